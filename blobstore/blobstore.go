@@ -0,0 +1,377 @@
+// Package blobstore keeps uploaded assets in S3 by the SHA-256 of their
+// content, so an asset uploaded many times, under any name, is stored
+// once. It counts the docs referring to each asset, so that assets no doc
+// refers to any more can be collected.
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"gopkg.in/yaml.v2"
+)
+
+// RefsDocName is the doc holding the references to every stored asset.
+const RefsDocName = "_asset-refs"
+
+// namePrefix starts the names of stored assets, which are their hash with
+// the extension they were uploaded with, like "sha256-9f86d0...08.png".
+const namePrefix = "sha256-"
+
+var (
+	validName  = regexp.MustCompile(`^sha256-([0-9a-f]{64})(\.[a-z0-9]+)?$`)
+	refPattern = regexp.MustCompile(`/assets/sha256-([0-9a-f]{64})`)
+)
+
+// Hash returns the hex SHA-256 of body, which an asset is stored under.
+func Hash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Name returns the name an asset with hash is served under, with ext, like
+// ".png", telling its type.
+func Name(hash, ext string) string {
+	return namePrefix + hash + strings.ToLower(ext)
+}
+
+// ParseName returns the hash of the stored asset name, or false if name
+// isn't one.
+func ParseName(name string) (string, bool) {
+	m := validName.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// Referenced returns the hashes of the stored assets body links to, in
+// order and without repeats.
+func Referenced(body []byte) []string {
+	seen := map[string]bool{}
+	var hashes []string
+	for _, m := range refPattern.FindAllSubmatch(body, -1) {
+		h := string(m[1])
+		if !seen[h] {
+			seen[h] = true
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// Ref is who refers to a stored asset: the docs whose latest revisions
+// link to it, or when it was last referred to if none do.
+type Ref struct {
+	Docs         []string  `yaml:"docs,omitempty"`
+	Unreferenced time.Time `yaml:"unreferenced,omitempty"`
+}
+
+// Refs are the references to stored assets, by hash.
+type Refs map[string]*Ref
+
+// ParseRefs parses the body of RefsDocName.
+func ParseRefs(b []byte) (Refs, error) {
+	refs := Refs{}
+	err := yaml.Unmarshal(b, &refs)
+	return refs, err
+}
+
+// Marshal formats refs as the body of RefsDocName.
+func (refs Refs) Marshal() ([]byte, error) {
+	return yaml.Marshal(refs)
+}
+
+// Count returns how many docs refer to the asset with hash.
+func (refs Refs) Count(hash string) int {
+	if r := refs[hash]; r != nil {
+		return len(r.Docs)
+	}
+	return 0
+}
+
+// Stored notes an asset just stored, which no doc may refer to yet,
+// reporting whether refs changed.
+func (refs Refs) Stored(hash string, now time.Time) bool {
+	if refs[hash] != nil {
+		return false
+	}
+	refs[hash] = &Ref{Unreferenced: now.UTC()}
+	return true
+}
+
+// Update sets the assets docId refers to to hashes, reporting whether refs
+// changed. Assets docId no longer refers to are left unreferenced at now
+// if no other doc does either.
+func (refs Refs) Update(docId string, hashes []string, now time.Time) bool {
+	want := map[string]bool{}
+	for _, h := range hashes {
+		want[h] = true
+	}
+
+	changed := false
+	for h, r := range refs {
+		i := sort.SearchStrings(r.Docs, docId)
+		has := i < len(r.Docs) && r.Docs[i] == docId
+		switch {
+		case has && !want[h]:
+			r.Docs = append(r.Docs[:i], r.Docs[i+1:]...)
+			if len(r.Docs) == 0 {
+				r.Unreferenced = now.UTC()
+			}
+			changed = true
+		case !has && want[h]:
+			r.Docs = append(r.Docs, "")
+			copy(r.Docs[i+1:], r.Docs[i:])
+			r.Docs[i] = docId
+			r.Unreferenced = time.Time{}
+			changed = true
+		}
+		delete(want, h)
+	}
+
+	// Links to assets that aren't stored are counted too, in case they are
+	// uploaded later.
+	for h := range want {
+		refs[h] = &Ref{Docs: []string{docId}}
+		changed = true
+	}
+	return changed
+}
+
+// Store keeps assets in an S3 bucket, under blobs/{hash}.
+type Store struct {
+	client s3iface.S3API
+	bucket string
+}
+
+// New returns a Store keeping assets in bucket.
+func New(client s3iface.S3API, bucket string) *Store {
+	return &Store{client, bucket}
+}
+
+func key(hash string) string {
+	return "blobs/" + hash
+}
+
+// Put stores body, reporting whether it was stored already.
+func (s *Store) Put(ctx context.Context, body []byte, contentType string) (hash string, existed bool, err error) {
+	hash = Hash(body)
+	_, err = s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key(hash)),
+	})
+	if err == nil {
+		return hash, true, nil
+	}
+	if !IsNotFound(err) {
+		return hash, false, err
+	}
+
+	_, err = s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key(hash)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return hash, false, err
+}
+
+// Get returns the asset stored under hash, or an error for which
+// IsNotFound is true if there isn't one.
+func (s *Store) Get(ctx context.Context, hash string) ([]byte, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key(hash)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+// Exists reports whether an asset is stored under hash.
+func (s *Store) Exists(ctx context.Context, hash string) (bool, error) {
+	_, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key(hash)),
+	})
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func uploadKey(id string) string {
+	return "uploads/" + id
+}
+
+// PresignUpload returns a URL, valid for ttl, that size bytes of
+// contentType can be PUT to, bypassing whatever would otherwise have to
+// carry them. The upload is held under id until Promote stores it.
+func (s *Store) PresignUpload(id, contentType string, size int64, ttl time.Duration) (string, error) {
+	req, _ := s.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(uploadKey(id)),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	})
+	return req.Presign(ttl)
+}
+
+// GetUpload returns the upload held under id, or an error for which
+// IsNotFound is true if nothing was uploaded.
+func (s *Store) GetUpload(ctx context.Context, id string) ([]byte, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(uploadKey(id)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+// Promote stores the upload held under id, whose content is body, by its
+// hash, reporting whether it was stored already, and discards the upload.
+// The upload is copied within the bucket rather than sent again.
+func (s *Store) Promote(ctx context.Context, id string, body []byte) (hash string, existed bool, err error) {
+	hash = Hash(body)
+	_, err = s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key(hash)),
+	})
+	existed = err == nil
+	if err != nil && !IsNotFound(err) {
+		return hash, false, err
+	}
+
+	if !existed {
+		_, err = s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(key(hash)),
+			CopySource: aws.String(s.bucket + "/" + uploadKey(id)),
+		})
+		if err != nil {
+			return hash, false, err
+		}
+	}
+
+	err = s.Discard(ctx, id)
+	return hash, existed, err
+}
+
+// Discard deletes the upload held under id.
+func (s *Store) Discard(ctx context.Context, id string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(uploadKey(id)),
+	})
+	return err
+}
+
+// ExpireUploads deletes the uploads made before t that were never
+// promoted, returning how many there were.
+func (s *Store) ExpireUploads(ctx context.Context, t time.Time) (int, error) {
+	n := 0
+	var expireErr error
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(uploadKey("")),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			if !aws.TimeValue(obj.LastModified).Before(t) {
+				continue
+			}
+			_, expireErr = s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    obj.Key,
+			})
+			if expireErr != nil {
+				return false
+			}
+			n++
+		}
+		return true
+	})
+	if err == nil {
+		err = expireErr
+	}
+	return n, err
+}
+
+// IsNotFound reports whether err is for an asset that isn't stored.
+func IsNotFound(err error) bool {
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return true
+	}
+	return false
+}
+
+// Collect deletes the stored assets no doc has referred to for grace,
+// including those stored but never referred to, and removes them from
+// refs, along with links to assets never stored that nothing has made
+// for grace. It returns the hashes of the assets deleted.
+func (s *Store) Collect(ctx context.Context, refs Refs, grace time.Duration, now time.Time) ([]string, error) {
+	var deleted []string
+	var collectErr error
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(key("")),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			hash := strings.TrimPrefix(aws.StringValue(obj.Key), key(""))
+			since := aws.TimeValue(obj.LastModified)
+			if r := refs[hash]; r != nil {
+				if len(r.Docs) > 0 {
+					continue
+				}
+				since = r.Unreferenced
+			}
+			if now.Sub(since) < grace {
+				continue
+			}
+
+			_, collectErr = s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    obj.Key,
+			})
+			if collectErr != nil {
+				return false
+			}
+			delete(refs, hash)
+			deleted = append(deleted, hash)
+		}
+		return true
+	})
+	if err == nil {
+		err = collectErr
+	}
+	if err != nil {
+		return deleted, err
+	}
+
+	for hash, r := range refs {
+		if len(r.Docs) == 0 && now.Sub(r.Unreferenced) >= grace {
+			delete(refs, hash)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,202 @@
+// Package cachestore wraps a docstore.DocStore with a read-through cache
+// shared between instances, like an ElastiCache memcached cluster, so hot
+// docs are served without a trip to the store.
+//
+// Revisions never change, so they are cached until evicted. Which revision
+// is a doc's latest is cached for a short TTL, since writers that don't go
+// through the CacheStore can't invalidate it. A revision written through
+// the CacheStore is cached as the latest as soon as it is written.
+package cachestore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/drocamor/docstore"
+)
+
+// Cache is a shared key value cache.
+type Cache interface {
+	// Get returns the value stored at key, with ok false on a miss.
+	Get(key string) (value []byte, ok bool, err error)
+	// Set stores value at key for ttl, or until evicted if ttl is 0.
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+type CacheStore struct {
+	ds        docstore.DocStore
+	cache     Cache
+	latestTTL time.Duration
+	onError   func(error)
+}
+
+type CacheStoreOption func(*CacheStore)
+
+// WithLatestTTL sets how long a doc's latest revision is cached, which
+// bounds how stale a doc written elsewhere can be. The default is five
+// seconds.
+func WithLatestTTL(d time.Duration) CacheStoreOption {
+	return func(c *CacheStore) {
+		c.latestTTL = d
+	}
+}
+
+// WithErrorHandler calls fn with cache errors. Calls fall through to the
+// store when the cache fails, so the errors are otherwise only seen as
+// latency.
+func WithErrorHandler(fn func(error)) CacheStoreOption {
+	return func(c *CacheStore) {
+		c.onError = fn
+	}
+}
+
+func New(ds docstore.DocStore, cache Cache, opts ...CacheStoreOption) *CacheStore {
+	c := &CacheStore{
+		ds:        ds,
+		cache:     cache,
+		latestTTL: 5 * time.Second,
+		onError:   func(error) {},
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+func latestKey(docId string) string {
+	return "latest:" + docId
+}
+
+func revisionKey(docId string, revisionId int) string {
+	return "rev:" + docId + ":" + strconv.Itoa(revisionId)
+}
+
+// cachedRevision is a revision read from or written to the cache.
+type cachedRevision struct {
+	meta docstore.RevisionMetadata
+	*bytes.Reader
+}
+
+func (r *cachedRevision) Metadata() docstore.RevisionMetadata {
+	return r.meta
+}
+
+// encode packs a revision's timestamp and body into a cache value.
+func encode(meta docstore.RevisionMetadata, body []byte) []byte {
+	ts := strconv.FormatInt(meta.Timestamp.UnixNano(), 10)
+	return append([]byte(ts+"\n"), body...)
+}
+
+func decode(docId string, revisionId int, value []byte) (*cachedRevision, error) {
+	i := bytes.IndexByte(value, '\n')
+	if i < 0 {
+		return nil, errors.New("cachestore: malformed value")
+	}
+	ns, err := strconv.ParseInt(string(value[:i]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cachestore: malformed value: %w", err)
+	}
+
+	meta := docstore.RevisionMetadata{DocId: docId, Id: revisionId, Timestamp: time.Unix(0, ns).UTC()}
+	return &cachedRevision{meta, bytes.NewReader(value[i+1:])}, nil
+}
+
+// store reads rev fully and caches it, returning a revision that can still
+// be read.
+func (c *CacheStore) store(rev docstore.Revision) (docstore.Revision, error) {
+	body, err := ioutil.ReadAll(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := rev.Metadata()
+	err = c.cache.Set(revisionKey(meta.DocId, meta.Id), encode(meta, body), 0)
+	if err != nil {
+		c.onError(err)
+	}
+	return &cachedRevision{meta, bytes.NewReader(body)}, nil
+}
+
+func (c *CacheStore) GetDoc(docId string) (rev docstore.Revision, err error) {
+	v, ok, err := c.cache.Get(latestKey(docId))
+	if err != nil {
+		c.onError(err)
+	}
+	if ok {
+		if n, err := strconv.Atoi(string(v)); err == nil {
+			return c.GetRevision(docId, n)
+		}
+	}
+
+	rev, err = c.ds.GetDoc(docId)
+	if err != nil {
+		return
+	}
+
+	rev, err = c.store(rev)
+	if err != nil {
+		return
+	}
+	err = c.cache.Set(latestKey(docId), []byte(strconv.Itoa(rev.Metadata().Id)), c.latestTTL)
+	if err != nil {
+		c.onError(err)
+	}
+	return rev, nil
+}
+
+func (c *CacheStore) GetRevision(docId string, revisionId int) (rev docstore.Revision, err error) {
+	v, ok, err := c.cache.Get(revisionKey(docId, revisionId))
+	if err != nil {
+		c.onError(err)
+	}
+	if ok {
+		cached, err := decode(docId, revisionId, v)
+		if err == nil {
+			return cached, nil
+		}
+		c.onError(err)
+	}
+
+	rev, err = c.ds.GetRevision(docId, revisionId)
+	if err != nil {
+		return
+	}
+	return c.store(rev)
+}
+
+// PutRevision writes through to the store and caches the revision written
+// as the latest of docId, so this instance and others sharing the cache
+// see the write at once. Dropping the cached latest revision instead would
+// let a read racing the write cache the one before it from the store.
+func (c *CacheStore) PutRevision(docId string, body io.Reader) (rev docstore.Revision, err error) {
+	rev, err = c.ds.PutRevision(docId, body)
+	if err != nil {
+		return
+	}
+
+	rev, err = c.store(rev)
+	if err != nil {
+		return
+	}
+	err = c.cache.Set(latestKey(docId), []byte(strconv.Itoa(rev.Metadata().Id)), c.latestTTL)
+	if err != nil {
+		c.onError(err)
+	}
+	return rev, nil
+}
+
+func (c *CacheStore) ListDocs(token string) (page docstore.DocPage, err error) {
+	return c.ds.ListDocs(token)
+}
+
+func (c *CacheStore) ListRevisions(docId string, token string) (page docstore.RevisionPage, err error) {
+	return c.ds.ListRevisions(docId, token)
+}
@@ -0,0 +1,164 @@
+package cachestore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxItemSize is memcached's default limit on the size of a value. Larger
+// values aren't cached.
+const maxItemSize = 1024 * 1024
+
+// Memcached is a Cache speaking the memcached text protocol, as
+// ElastiCache for Memcached does. It keeps one connection, redialing after
+// errors.
+type Memcached struct {
+	addr    string
+	timeout time.Duration
+	prefix  string
+
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// NewMemcached returns a Cache using the server at addr, host:port. Every
+// key is prefixed with prefix so stores can share a cluster, and every
+// call is bounded by timeout.
+func NewMemcached(addr, prefix string, timeout time.Duration) *Memcached {
+	return &Memcached{addr: addr, prefix: prefix, timeout: timeout}
+}
+
+// do runs fn on the connection, dialing it if needed and dropping it if fn
+// fails.
+func (m *Memcached) do(fn func(rw *bufio.ReadWriter) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn == nil {
+		conn, err := net.DialTimeout("tcp", m.addr, m.timeout)
+		if err != nil {
+			return err
+		}
+		m.conn, m.rw = conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	}
+
+	m.conn.SetDeadline(time.Now().Add(m.timeout))
+	err := fn(m.rw)
+	if err == nil {
+		err = m.rw.Flush()
+	}
+	if err != nil {
+		m.conn.Close()
+		m.conn, m.rw = nil, nil
+	}
+	return err
+}
+
+func (m *Memcached) key(key string) (string, error) {
+	key = m.prefix + key
+	if len(key) > 250 || strings.ContainsAny(key, " \t\r\n") {
+		return "", fmt.Errorf("memcached: bad key %q", key)
+	}
+	return key, nil
+}
+
+// reply reads a one line reply.
+func reply(rw *bufio.ReadWriter) (string, error) {
+	if err := rw.Flush(); err != nil {
+		return "", err
+	}
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "ERROR") || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", errors.New("memcached: " + line)
+	}
+	return line, nil
+}
+
+func (m *Memcached) Get(key string) (value []byte, ok bool, err error) {
+	key, err = m.key(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = m.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "get %s\r\n", key)
+		line, err := reply(rw)
+		if err != nil || line == "END" {
+			return err
+		}
+
+		// VALUE <key> <flags> <bytes>
+		f := strings.Fields(line)
+		if len(f) != 4 || f[0] != "VALUE" {
+			return fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		n, err := strconv.Atoi(f[3])
+		if err != nil {
+			return fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		value = make([]byte, n+2)
+		if _, err := io.ReadFull(rw, value); err != nil {
+			return err
+		}
+		value, ok = value[:n], true
+
+		line, err = reply(rw)
+		if err == nil && line != "END" {
+			err = fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		return err
+	})
+	return value, ok, err
+}
+
+func (m *Memcached) Set(key string, value []byte, ttl time.Duration) error {
+	key, err := m.key(key)
+	if err != nil || len(value) > maxItemSize {
+		return err
+	}
+
+	// Expiry is in whole seconds, and 0 means never.
+	exptime := int(ttl.Seconds())
+	if ttl > 0 && exptime == 0 {
+		exptime = 1
+	}
+
+	return m.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "set %s 0 %d %d\r\n", key, exptime, len(value))
+		rw.Write(value)
+		rw.WriteString("\r\n")
+		line, err := reply(rw)
+		if err == nil && line != "STORED" {
+			err = fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		return err
+	})
+}
+
+func (m *Memcached) Delete(key string) error {
+	key, err := m.key(key)
+	if err != nil {
+		return err
+	}
+
+	return m.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "delete %s\r\n", key)
+		line, err := reply(rw)
+		if err == nil && line != "DELETED" && line != "NOT_FOUND" {
+			err = fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		return err
+	})
+}
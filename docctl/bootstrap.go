@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// starterTemplate, starterStyle and starterHome are the theme and home
+// page a new site starts with. The nav lists the pinned docs.
+const (
+	starterTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+{{if .Robots}}<meta name="robots" content="{{.Robots}}">
+{{end}}{{with .Canonical}}{{.}}
+{{end}}<link rel="stylesheet" href="/style.css">
+{{.JSONLD}}
+</head>
+<body>
+<nav><a href="/">Home</a> {{range pinnedDocs}}<a href="/{{.DocId}}">{{.Title}}</a> {{end}}</nav>
+<main>
+{{.DocBody}}
+</main>
+<footer>Version {{.Version}}, updated {{.Timestamp}}</footer>
+</body>
+</html>
+`
+
+	starterStyle = `body {
+  font-family: sans-serif;
+  line-height: 1.5;
+  max-width: 40em;
+  margin: 0 auto;
+  padding: 0 1em;
+}
+
+nav a {
+  margin-right: 1em;
+}
+
+footer {
+  color: #666;
+  font-size: small;
+}
+`
+
+	starterHome = `Welcome
+
+This site was just set up. Edit this page by writing a new revision of
+the "index" doc, and the site's look with "doc-template.html" and
+"style.css".
+`
+)
+
+// bootstrap sets up a new store: it creates the docs and revisions tables
+// and the asset bucket, or checks the ones that exist, and writes a
+// config, theme and home page for the docs missing from the store. It
+// prints the site's address, from -url or the ServiceEndpoint output of
+// the deployed stack, and the admin API's.
+func bootstrap(args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	docsTable := fs.String("docs-table", envDefault("DOCS_TABLE", "docs"), "the docs table")
+	revisionsTable := fs.String("revisions-table", envDefault("REVISIONS_TABLE", "revisions"), "the revisions table")
+	assetBucket := fs.String("asset-bucket", os.Getenv("ASSET_BUCKET"), "the bucket for uploaded assets, if any")
+	name := fs.String("name", "Docs", "the site's name")
+	url := fs.String("url", "", "the site's public address; the stack's endpoint if unset")
+	stack := fs.String("stack", "n22t-docstore-dev", "the deployed CloudFormation stack, for its endpoint")
+	dryRun := fs.Bool("dry-run", false, "report what would be created without creating it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl bootstrap [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	sess := session.Must(session.NewSession())
+	db := dynamodb.New(sess)
+	err := ensureTable(db, *dryRun, &dynamodb.CreateTableInput{
+		TableName: aws.String(*docsTable),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("Id"), AttributeType: aws.String("S")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("Id"), KeyType: aws.String("HASH")},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = ensureTable(db, *dryRun, &dynamodb.CreateTableInput{
+		TableName: aws.String(*revisionsTable),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("DocId"), AttributeType: aws.String("S")},
+			{AttributeName: aws.String("Id"), AttributeType: aws.String("N")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("DocId"), KeyType: aws.String("HASH")},
+			{AttributeName: aws.String("Id"), KeyType: aws.String("RANGE")},
+		},
+		// The invalidate function and saved searches read the revisions
+		// stream for the docIds of new revisions.
+		StreamSpecification: &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(dynamodb.StreamViewTypeKeysOnly),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if *assetBucket != "" {
+		err = ensureBucket(s3.New(sess), *assetBucket, *dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	site := *url
+	if site == "" {
+		site, err = stackEndpoint(cloudformation.New(sess), *stack)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "docctl: no -url, and the endpoint of %s: %v\n", *stack, err)
+		}
+	}
+	site = strings.TrimSuffix(site, "/")
+
+	config := fmt.Sprintf("name: %q\n", *name)
+	if site != "" {
+		config += fmt.Sprintf("baseURL: %q\n", site)
+	}
+	docs := map[string]string{
+		"_config":           config,
+		"doc-template.html": starterTemplate,
+		"style.css":         starterStyle,
+		"index":             starterHome,
+	}
+	var docIds []string
+	for docId := range docs {
+		docIds = append(docIds, docId)
+	}
+	sort.Strings(docIds)
+
+	ds := awsdocstore.New(awsdocstore.WithDocTable(*docsTable), awsdocstore.WithRevisionTable(*revisionsTable))
+	for _, docId := range docIds {
+		_, err := ds.GetDoc(docId)
+		if err == nil {
+			fmt.Printf("%s: exists\n", docId)
+			continue
+		}
+		if !errors.Is(docerr.FromStore("GetDoc", err), docerr.ErrNotFound) && !*dryRun {
+			return fmt.Errorf("%s: %v", docId, err)
+		}
+		fmt.Printf("%s: writing\n", docId)
+		if *dryRun {
+			continue
+		}
+		_, err = ds.PutRevision(docId, bytes.NewReader([]byte(docs[docId])))
+		if err != nil {
+			return fmt.Errorf("%s: %v", docId, err)
+		}
+	}
+
+	if site == "" {
+		fmt.Println("the store is ready; deploy the functions, then run this again with -url to see the site's address")
+		return nil
+	}
+	fmt.Printf("site:      %s/\n", site)
+	fmt.Printf("admin API: %s/api/v1/admin/ (with the ADMIN_API_KEY in X-Api-Key)\n", site)
+	return nil
+}
+
+// ensureTable creates the table input describes, waiting for it to be
+// active, or checks that the table that exists has the same key.
+func ensureTable(db *dynamodb.DynamoDB, dryRun bool, input *dynamodb.CreateTableInput) error {
+	name := aws.StringValue(input.TableName)
+	out, err := db.DescribeTable(&dynamodb.DescribeTableInput{TableName: input.TableName})
+	if err == nil {
+		if !sameKey(out.Table.KeySchema, input.KeySchema) {
+			return fmt.Errorf("table %s exists with a different key", name)
+		}
+		fmt.Printf("table %s: exists\n", name)
+		return nil
+	}
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeResourceNotFoundException {
+		return fmt.Errorf("table %s: %v", name, err)
+	}
+
+	fmt.Printf("table %s: creating\n", name)
+	if dryRun {
+		return nil
+	}
+	input.SetBillingMode(dynamodb.BillingModePayPerRequest)
+	_, err = db.CreateTable(input)
+	if err != nil {
+		return fmt.Errorf("table %s: %v", name, err)
+	}
+	return db.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: input.TableName})
+}
+
+func sameKey(a, b []*dynamodb.KeySchemaElement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if aws.StringValue(a[i].AttributeName) != aws.StringValue(b[i].AttributeName) ||
+			aws.StringValue(a[i].KeyType) != aws.StringValue(b[i].KeyType) {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureBucket creates bucket, blocking public access to it, unless it
+// exists and can be reached.
+func ensureBucket(svc *s3.S3, bucket string, dryRun bool) error {
+	_, err := svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		fmt.Printf("bucket %s: exists\n", bucket)
+		return nil
+	}
+	if aerr, ok := err.(awserr.RequestFailure); !ok || aerr.StatusCode() != 404 {
+		return fmt.Errorf("bucket %s: %v", bucket, err)
+	}
+
+	fmt.Printf("bucket %s: creating\n", bucket)
+	if dryRun {
+		return nil
+	}
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	if r := aws.StringValue(svc.Config.Region); r != "" && r != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(r)}
+	}
+	_, err = svc.CreateBucket(input)
+	if err != nil {
+		return fmt.Errorf("bucket %s: %v", bucket, err)
+	}
+	_, err = svc.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucket),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("bucket %s: %v", bucket, err)
+	}
+	return nil
+}
+
+// stackEndpoint returns the ServiceEndpoint output serverless adds to the
+// stack it deploys: the address of its API Gateway stage.
+func stackEndpoint(cf *cloudformation.CloudFormation, stack string) (string, error) {
+	out, err := cf.DescribeStacks(&cloudformation.DescribeStacksInput{StackName: aws.String(stack)})
+	if err != nil {
+		return "", err
+	}
+	for _, s := range out.Stacks {
+		for _, o := range s.Outputs {
+			if aws.StringValue(o.OutputKey) == "ServiceEndpoint" {
+				return aws.StringValue(o.OutputValue), nil
+			}
+		}
+	}
+	return "", errors.New("it has no ServiceEndpoint output")
+}
+
+func envDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+)
+
+// bundle packs the latest revisions of the site's templates and
+// stylesheets into a gzipped tar in the snapshot layout, for the docs
+// function to load with THEME_BUNDLE instead of fetching each from the
+// store on a cold start. Without docIds every .html and .css doc is
+// bundled. The bundle is written to a file, which "make build" adds to the
+// docs function's package, or to s3://bucket/key.
+//
+// With -site the site's configuration is bundled instead: its system docs,
+// like the config, variables, defaults and schemas, along with its
+// templates and stylesheets. Restoring the bundle in another environment
+// with "docctl restore -overwrite" promotes the configuration there, say
+// from staging to prod.
+func bundle(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	out := fs.String("out", "theme.tar.gz", "file or s3://bucket/key to write the bundle to")
+	site := fs.Bool("site", false, "bundle the site's configuration and theme, to promote to another environment")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl bundle [flags] [docId...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ds := awsdocstore.New()
+
+	docIds := fs.Args()
+	if len(docIds) == 0 {
+		var err error
+		if *site {
+			docIds, err = siteDocIds(ds)
+		} else {
+			docIds, err = themeDocIds(ds)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	sort.Strings(docIds)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	add := func(name string, t time.Time, body []byte) error {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), ModTime: t})
+		if err == nil {
+			_, err = tw.Write(body)
+		}
+		return err
+	}
+
+	m := manifest{Exported: time.Now().UTC()}
+	for _, docId := range docIds {
+		rev, err := ds.GetDoc(docId)
+		if err != nil {
+			return fmt.Errorf("%s: %v", docId, err)
+		}
+		body, err := ioutil.ReadAll(rev)
+		if err != nil {
+			return fmt.Errorf("%s: %v", docId, err)
+		}
+
+		meta := rev.Metadata()
+		sum := sha256.Sum256(body)
+		entry := manifestEntry{
+			DocId:     docId,
+			Revision:  meta.Id,
+			Timestamp: meta.Timestamp.UTC(),
+			SHA256:    hex.EncodeToString(sum[:]),
+			Path:      docId + "/" + strconv.Itoa(meta.Id),
+		}
+		err = add(entry.Path, entry.Timestamp, body)
+		if err != nil {
+			return err
+		}
+		m.Docs = append(m.Docs, entry)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = add("manifest.json", m.Exported, b)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(*out, "s3://") {
+		err = uploadBundle(*out, buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(*out, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("bundled %d docs into %s\n", len(m.Docs), *out)
+	return nil
+}
+
+// themeDocIds lists the templates and stylesheets in the store.
+func themeDocIds(ds docstore.DocStore) ([]string, error) {
+	docs, err := listDocs(ds)
+	if err != nil {
+		return nil, err
+	}
+	var docIds []string
+	for _, d := range docs {
+		switch path.Ext(d.Id) {
+		case ".html", ".css":
+			docIds = append(docIds, d.Id)
+		}
+	}
+	return docIds, nil
+}
+
+// siteStateDocs are the system docs that hold an environment's state
+// rather than its configuration, and so aren't promoted with it: labels
+// and holds name its revisions, and the rest are its drafts, status, job
+// records and the last flush of its render cache.
+var siteStateDocs = []string{"_drafts", "_draft.", "_holds", "_labels", "_renders", "_status", "_import.", "_smoketest.", "_suggestion.", "_consistency."}
+
+// siteDocIds lists the system docs holding the site's configuration, and
+// its templates and stylesheets.
+func siteDocIds(ds docstore.DocStore) ([]string, error) {
+	docs, err := listDocs(ds)
+	if err != nil {
+		return nil, err
+	}
+	var docIds []string
+	for _, d := range docs {
+		if isStateDoc(d.Id) {
+			continue
+		}
+		if strings.HasPrefix(d.Id, "_") || path.Ext(d.Id) == ".html" || path.Ext(d.Id) == ".css" {
+			docIds = append(docIds, d.Id)
+		}
+	}
+	return docIds, nil
+}
+
+func isStateDoc(docId string) bool {
+	for _, s := range siteStateDocs {
+		if docId == s || (strings.HasSuffix(s, ".") && strings.HasPrefix(docId, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func uploadBundle(url string, b []byte) error {
+	parts := strings.SplitN(strings.TrimPrefix(url, "s3://"), "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("%s: want s3://bucket/key", url)
+	}
+
+	uploader := s3manager.NewUploader(session.Must(session.NewSession()))
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(parts[0]),
+		Key:         aws.String(parts[1]),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/gzip"),
+	})
+	return err
+}
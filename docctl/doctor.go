@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/docstore/awsdocstore"
+	"gopkg.in/yaml.v2"
+)
+
+// finding is one result of a doctor check: ok, a warning or a failure,
+// with what to do about it.
+type finding struct {
+	level string
+	check string
+	msg   string
+	fix   string
+}
+
+// findings collects the results of doctor's checks.
+type findings []finding
+
+func (f *findings) ok(check, msg string) {
+	*f = append(*f, finding{"ok", check, msg, ""})
+}
+
+func (f *findings) warn(check, msg, fix string) {
+	*f = append(*f, finding{"warn", check, msg, fix})
+}
+
+func (f *findings) fail(check, msg, fix string) {
+	*f = append(*f, finding{"FAIL", check, msg, fix})
+}
+
+// storeActions are the actions the docs function takes on the docs and
+// revisions tables, and bucketActions those on its buckets, to check its
+// role allows them. Each feature's table is used with some of
+// tableActions, so a role allowing none of them on one is missing its
+// statement.
+var (
+	storeActions  = []string{"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:Query", "dynamodb:Scan"}
+	tableActions  = []string{"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:Query"}
+	bucketActions = []string{"s3:GetObject", "s3:PutObject"}
+)
+
+// doctor diagnoses a deployment: it reads the docs function's
+// configuration, checks that its role may use the tables and buckets it
+// is configured with and that they exist, that the config doc and the
+// page template parse, that the revisions stream and the semantic search
+// index keep up, and that the API answers and finds the site healthy.
+// Every problem is printed with what to do about it; doctor fails if any
+// check does.
+func doctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	function := fs.String("function", "n22t-docstore-dev-docs", "the deployed docs function")
+	url := fs.String("url", "", "the site's public address; the stack's endpoint if unset")
+	stack := fs.String("stack", "n22t-docstore-dev", "the deployed CloudFormation stack, for its endpoint")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl doctor [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	sess := session.Must(session.NewSession())
+	var f findings
+
+	env := map[string]string{}
+	role := ""
+	cfg, err := lambda.New(sess).GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: function})
+	if err != nil {
+		f.fail("function", fmt.Sprintf("%s: %v", *function, err),
+			"deploy with \"make deploy\", or name the deployed docs function with -function; checking the local environment instead")
+		for _, kv := range os.Environ() {
+			if i := strings.Index(kv, "="); i > 0 {
+				env[kv[:i]] = kv[i+1:]
+			}
+		}
+	} else {
+		f.ok("function", fmt.Sprintf("%s runs %s, last modified %s", *function, aws.StringValue(cfg.Runtime), aws.StringValue(cfg.LastModified)))
+		role = aws.StringValue(cfg.Role)
+		if cfg.Environment != nil {
+			env = aws.StringValueMap(cfg.Environment.Variables)
+		}
+	}
+
+	docsTable := envDefaultIn(env, "DOCS_TABLE", "docs")
+	revisionsTable := envDefaultIn(env, "REVISIONS_TABLE", "revisions")
+	tables := map[string]string{docsTable: "store", revisionsTable: "store"}
+	buckets := map[string]string{}
+	for k, v := range env {
+		switch {
+		case v == "":
+		case strings.HasSuffix(k, "_TABLE") && tables[v] == "":
+			tables[v] = "table"
+		case strings.HasSuffix(k, "_BUCKET"):
+			buckets[v] = "bucket"
+		}
+	}
+
+	db := dynamodb.New(sess)
+	region := aws.StringValue(sess.Config.Region)
+	counts := map[string]int64{}
+	for _, name := range sortedKeys(tables) {
+		out, err := db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(name)})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			f.fail("tables", name+" doesn't exist", "run \"docctl bootstrap\" for the docs and revisions tables, or \"make deploy\" for the others")
+			continue
+		}
+		if err != nil {
+			f.fail("tables", fmt.Sprintf("%s: %v", name, err), "check that your credentials may describe the table")
+			continue
+		}
+		f.ok("tables", fmt.Sprintf("%s is %s", name, strings.ToLower(aws.StringValue(out.Table.TableStatus))))
+		counts[name] = aws.Int64Value(out.Table.ItemCount)
+
+		if name == revisionsTable {
+			if s := out.Table.StreamSpecification; s == nil || !aws.BoolValue(s.StreamEnabled) {
+				f.fail("tables", revisionsTable+" has no stream", "enable a stream on it; CDN invalidation, saved searches and semantic search read it")
+			}
+		}
+	}
+
+	svc := s3.New(sess)
+	for _, name := range sortedKeys(buckets) {
+		_, err := svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(name)})
+		if err != nil {
+			f.fail("buckets", fmt.Sprintf("%s: %v", name, err), "create the bucket, or fix the variable naming it in the function's environment")
+			continue
+		}
+		f.ok("buckets", name+" exists")
+	}
+
+	if role != "" {
+		checkPermissions(&f, iam.New(sess), role, region, tables, buckets)
+	}
+
+	ds := awsdocstore.New(awsdocstore.WithDocTable(docsTable), awsdocstore.WithRevisionTable(revisionsTable))
+	configDoc := "_config"
+	if profile := env["PROFILE"]; profile != "" {
+		if _, err := ds.GetDoc(configDoc + "." + profile); err == nil {
+			configDoc += "." + profile
+		}
+	}
+	if body, err := readDoc(ds, configDoc); err != nil {
+		f.warn("config", fmt.Sprintf("%s: %v", configDoc, err), "write a config doc, as \"docctl bootstrap\" does, to name the site and set its base URL")
+	} else if err := yaml.Unmarshal(body, &map[string]interface{}{}); err != nil {
+		f.fail("config", fmt.Sprintf("%s doesn't parse: %v", configDoc, err), "fix the YAML; the function keeps the last config that parsed until then")
+	} else {
+		f.ok("config", configDoc+" parses")
+	}
+
+	if body, err := readDoc(ds, "doc-template.html"); err != nil {
+		f.fail("template", fmt.Sprintf("doc-template.html: %v", err), "write a page template; every page fails to render without one")
+	} else if _, err := template.New("docPage").Funcs(testFuncs).Parse(string(body)); err != nil {
+		f.fail("template", fmt.Sprintf("doc-template.html doesn't parse: %v", err), "fix it, testing with \"docctl template test\" before writing it")
+	} else {
+		f.ok("template", "doc-template.html parses")
+	}
+
+	if t := env["EMBEDDINGS_TABLE"]; t != "" {
+		docs, embedded := counts[docsTable], counts[t]
+		switch {
+		case docs > 0 && embedded == 0:
+			f.warn("index", fmt.Sprintf("%s is empty but there are %d docs", t, docs),
+				"check the docs function's stream errors and EMBEDDING_PROVIDER; docs are embedded as they change")
+		case embedded < docs*9/10:
+			f.warn("index", fmt.Sprintf("%d of %d docs are embedded in %s", embedded, docs, t),
+				"docs written before semantic search was enabled are embedded the next time they change")
+		default:
+			f.ok("index", fmt.Sprintf("%d of %d docs are embedded (counts are updated every few hours)", embedded, docs))
+		}
+	}
+
+	site := *url
+	if site == "" {
+		site, err = stackEndpoint(cloudformation.New(sess), *stack)
+		if err != nil {
+			f.fail("api", fmt.Sprintf("no -url, and the endpoint of %s: %v", *stack, err), "pass the site's address with -url")
+		}
+	}
+	if site != "" {
+		checkHealth(&f, strings.TrimSuffix(site, "/"))
+	}
+
+	failed := 0
+	for _, x := range f {
+		fmt.Printf("%-4s  %-11s %s\n", x.level, x.check, x.msg)
+		if x.fix != "" {
+			fmt.Printf("      %-11s -> %s\n", "", x.fix)
+		}
+		if x.level == "FAIL" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// checkPermissions simulates the policies of the function's role for the
+// actions it takes on the tables and buckets, reporting those it is
+// denied.
+func checkPermissions(f *findings, svc *iam.IAM, role, region string, tables, buckets map[string]string) {
+	account := ""
+	if parts := strings.Split(role, ":"); len(parts) > 4 {
+		account = parts[4]
+	}
+
+	denied := 0
+	simulate := func(arn string, actions []string, anyOf bool) {
+		out, err := svc.SimulatePrincipalPolicy(&iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(role),
+			ActionNames:     aws.StringSlice(actions),
+			ResourceArns:    aws.StringSlice([]string{arn}),
+		})
+		if err != nil {
+			f.warn("permissions", fmt.Sprintf("simulating %s: %v", arn, err), "doctor needs iam:SimulatePrincipalPolicy to check the function's role")
+			return
+		}
+		var refused []string
+		for _, r := range out.EvaluationResults {
+			if aws.StringValue(r.EvalDecision) != iam.PolicyEvaluationDecisionTypeAllowed {
+				refused = append(refused, aws.StringValue(r.EvalActionName))
+			}
+		}
+		if len(refused) == 0 || (anyOf && len(refused) < len(actions)) {
+			return
+		}
+		denied++
+		f.fail("permissions", fmt.Sprintf("%s may not %s on %s", role, strings.Join(refused, ", "), arn),
+			"add a statement for it to the iamRoleStatements in serverless.yml and deploy")
+	}
+	for _, name := range sortedKeys(tables) {
+		arn := fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/%s", region, account, name)
+		if tables[name] == "store" {
+			simulate(arn, storeActions, false)
+		} else {
+			simulate(arn, tableActions, true)
+		}
+	}
+	for _, name := range sortedKeys(buckets) {
+		simulate(fmt.Sprintf("arn:aws:s3:::%s/*", name), bucketActions, false)
+	}
+	if denied == 0 {
+		f.ok("permissions", fmt.Sprintf("the function's role may use its %d tables and %d buckets", len(tables), len(buckets)))
+	}
+}
+
+// checkHealth asks the site's health endpoint, which the function answers
+// with the problems it found validating the site.
+func checkHealth(f *findings, site string) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	start := time.Now()
+	resp, err := client.Get(site + "/api/v1/health")
+	if err != nil {
+		f.fail("api", fmt.Sprintf("%s: %v", site, err), "check the address, and that the API Gateway stage is deployed")
+		return
+	}
+	defer resp.Body.Close()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	var health struct {
+		Status   string   `json:"status"`
+		Problems []string `json:"problems"`
+	}
+	if err := json.Unmarshal(b, &health); err != nil {
+		f.fail("api", fmt.Sprintf("%s/api/v1/health answered %s, not the health report", site, resp.Status),
+			"check that /api/{proxy+} routes to the docs function")
+		return
+	}
+	f.ok("api", fmt.Sprintf("%s answered in %v", site, elapsed))
+	checkSchema(f, resp.Header)
+	for _, p := range health.Problems {
+		f.fail("site", p, "fix the doc named; the function checks the site again on its next cold start")
+	}
+	if len(health.Problems) == 0 {
+		f.ok("site", "the function found no problems with the config and templates")
+	}
+}
+
+// writeSchema is the write payload schema of this release, which its API
+// clients write with. Keep it in step with the docs handler's.
+const writeSchema = 1
+
+// checkSchema checks the site accepts writes made with this release's
+// write schema, going by the range an API response gave, so clients of it
+// won't be turned away with 426 Upgrade Required.
+func checkSchema(f *findings, h http.Header) {
+	min, errMin := strconv.Atoi(h.Get("X-Docstore-Schema-Min"))
+	max, errMax := strconv.Atoi(h.Get("X-Docstore-Schema-Max"))
+	switch {
+	case errMin != nil || errMax != nil:
+		f.warn("schema", "the site doesn't say which write schemas it accepts", "deploy the current docs function")
+	case writeSchema < min:
+		f.fail("schema", fmt.Sprintf("the site accepts write schemas %d to %d, so clients writing this release's %d are refused", min, max, writeSchema),
+			"upgrade docctl and the clients, or lower MIN_WRITE_SCHEMA")
+	case writeSchema > max:
+		f.fail("schema", fmt.Sprintf("the site accepts write schemas %d to %d, older than this release's %d", min, max, writeSchema),
+			"deploy the current docs function")
+	default:
+		f.ok("schema", fmt.Sprintf("the site accepts write schemas %d to %d", min, max))
+	}
+}
+
+// readDoc returns the body of the latest revision of docId.
+func readDoc(ds *awsdocstore.AwsDocStore, docId string) ([]byte, error) {
+	rev, err := ds.GetDoc(docId)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(rev)
+}
+
+func envDefaultIn(env map[string]string, name, def string) string {
+	if v := env[name]; v != "" {
+		return v
+	}
+	return def
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+)
+
+// manifest lists what an export holds, so it can be shown later that the
+// content is exactly what was published and when.
+type manifest struct {
+	Exported time.Time       `json:"exported"`
+	Docs     []manifestEntry `json:"docs"`
+}
+
+type manifestEntry struct {
+	DocId     string    `json:"docId"`
+	Revision  int       `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+	SHA256    string    `json:"sha256"`
+	Path      string    `json:"path"`
+}
+
+// export writes docs to a directory along with manifest.json and, given a
+// key, an Ed25519 signature of the manifest in manifest.json.sig. Anyone
+// with the public key in manifest.json.pub can check the manifest, and the
+// manifest's hashes check the content.
+//
+// Docs are exported by a pool of workers. With -incremental, revisions
+// already in the directory's manifest, and still matching their hashes,
+// are kept rather than fetched again; revisions never change, so only
+// docs changed since the last export cost a read.
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "export", "directory to export to")
+	all := fs.Bool("all", false, "export every revision, not just the latest")
+	keyFile := fs.String("key", "", "file holding a base64 Ed25519 private key seed to sign the manifest with")
+	workers := fs.Int("workers", 8, "number of docs to export at once")
+	incremental := fs.Bool("incremental", false, "keep revisions exported to -out before instead of fetching them again")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl export [flags] [docId...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var key ed25519.PrivateKey
+	if *keyFile != "" {
+		b, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("%s: want a base64 %d byte Ed25519 seed", *keyFile, ed25519.SeedSize)
+		}
+		key = ed25519.NewKeyFromSeed(seed)
+	}
+
+	ds := awsdocstore.New()
+
+	// latest holds each doc's latest revision when the store listed it,
+	// which saves a read to find it.
+	latest := map[string]int{}
+	docIds := fs.Args()
+	if len(docIds) == 0 {
+		docs, err := listDocs(ds)
+		if err != nil {
+			return err
+		}
+		for _, d := range docs {
+			docIds = append(docIds, d.Id)
+			latest[d.Id] = d.LatestRevision
+		}
+	}
+	sort.Strings(docIds)
+
+	var prev map[string]manifestEntry
+	if *incremental {
+		prev = previousExport(*out)
+	}
+
+	if *workers < 1 {
+		*workers = 1
+	}
+	jobs := make(chan string)
+	results := make(chan exportResult)
+	for i := 0; i < *workers; i++ {
+		go func() {
+			for docId := range jobs {
+				r := exportResult{docId: docId}
+				r.entries, r.fetched, r.err = exportDoc(ds, *out, docId, latest[docId], *all, prev)
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		for _, docId := range docIds {
+			jobs <- docId
+		}
+		close(jobs)
+	}()
+
+	byDoc := map[string][]manifestEntry{}
+	var failed []string
+	fetched := 0
+	for i := range docIds {
+		r := <-results
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "\n%s: %v\n", r.docId, r.err)
+			failed = append(failed, r.docId)
+		}
+		byDoc[r.docId] = r.entries
+		fetched += r.fetched
+		fmt.Fprintf(os.Stderr, "\r%d/%d docs, %d revisions fetched", i+1, len(docIds), fetched)
+	}
+	fmt.Fprintln(os.Stderr)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("couldn't export %s", strings.Join(failed, ", "))
+	}
+
+	m := manifest{Exported: time.Now().UTC()}
+	for _, docId := range docIds {
+		m.Docs = append(m.Docs, byDoc[docId]...)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(*out, "manifest.json"), b, 0644)
+	if err != nil {
+		return err
+	}
+
+	if key != nil {
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, b))
+		pub := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+		err = ioutil.WriteFile(filepath.Join(*out, "manifest.json.sig"), []byte(sig+"\n"), 0644)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(*out, "manifest.json.pub"), []byte(pub+"\n"), 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("exported %d revisions of %d docs to %s, fetching %d\n", len(m.Docs), len(docIds), *out, fetched)
+	return nil
+}
+
+type exportResult struct {
+	docId   string
+	entries []manifestEntry
+	fetched int
+	err     error
+}
+
+// listDocs pages through every doc in the store.
+func listDocs(ds docstore.DocStore) ([]docstore.Doc, error) {
+	var docs []docstore.Doc
+	token := ""
+	for {
+		page, err := ds.ListDocs(token)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, page.Docs...)
+		if !page.More || page.NextToken == "" {
+			return docs, nil
+		}
+		token = page.NextToken
+	}
+}
+
+// previousExport returns the revisions in out's manifest that are still
+// there as they were exported, keyed by their paths.
+func previousExport(out string) map[string]manifestEntry {
+	prev := map[string]manifestEntry{}
+	b, err := ioutil.ReadFile(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		return prev
+	}
+	var m manifest
+	if json.Unmarshal(b, &m) != nil {
+		return prev
+	}
+
+	for _, e := range m.Docs {
+		body, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(e.Path)))
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) == e.SHA256 {
+			prev[e.Path] = e
+		}
+	}
+	return prev
+}
+
+// exportDoc exports the revisions of docId, reusing those in prev, and
+// reports how many it fetched. latest is docId's latest revision, or 0 if
+// it isn't known yet.
+func exportDoc(ds docstore.DocStore, out, docId string, latest int, all bool, prev map[string]manifestEntry) ([]manifestEntry, int, error) {
+	revs, err := exportRevisions(ds, docId, latest, all)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []manifestEntry
+	fetched := 0
+	for _, n := range revs {
+		if e, ok := prev[docId+"/"+strconv.Itoa(n)]; ok {
+			entries = append(entries, e)
+			continue
+		}
+		e, err := exportRevision(ds, out, docId, n)
+		if err != nil {
+			return nil, fetched, fmt.Errorf("revision %d: %v", n, err)
+		}
+		entries = append(entries, e)
+		fetched++
+	}
+	return entries, fetched, nil
+}
+
+// exportRevisions lists the revisions of docId to export.
+func exportRevisions(ds docstore.DocStore, docId string, latest int, all bool) ([]int, error) {
+	if !all {
+		if latest > 0 {
+			return []int{latest}, nil
+		}
+		rev, err := ds.GetDoc(docId)
+		if err != nil {
+			return nil, err
+		}
+		return []int{rev.Metadata().Id}, nil
+	}
+
+	var revs []int
+	token := ""
+	for {
+		page, err := ds.ListRevisions(docId, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Revisions {
+			revs = append(revs, r.Id)
+		}
+		if !page.More || page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	sort.Ints(revs)
+	if len(revs) == 0 {
+		return nil, errors.New("no revisions")
+	}
+	return revs, nil
+}
+
+// exportRevision writes revision n of docId to out/{docId}/{n}.
+func exportRevision(ds docstore.DocStore, out, docId string, n int) (manifestEntry, error) {
+	rev, err := ds.GetRevision(docId, n)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	body, err := ioutil.ReadAll(rev)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	path := filepath.Join(docId, strconv.Itoa(n))
+	err = os.MkdirAll(filepath.Join(out, docId), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(out, path), body, 0644)
+	}
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	sum := sha256.Sum256(body)
+	return manifestEntry{
+		DocId:     docId,
+		Revision:  n,
+		Timestamp: rev.Metadata().Timestamp.UTC(),
+		SHA256:    hex.EncodeToString(sum[:]),
+		Path:      filepath.ToSlash(path),
+	}, nil
+}
@@ -0,0 +1,65 @@
+// Command docctl is a command line tool for working with a doc store site.
+//
+//	docctl template test [flags] template.html [docId...]
+//	docctl template lint [flags] template.html...
+//	docctl export [flags] [docId...]
+//	docctl restore [flags] dir|snapshot.tar.gz|s3://bucket/key [docId...]
+//	docctl bundle [flags] [docId...]
+//	docctl theme install [flags] name git-url|theme.zip|dir
+//	docctl bootstrap [flags]
+//	docctl doctor [flags]
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: docctl <command> [arguments]
+
+commands:
+  template test   render a local template against sample or live docs
+  template lint   check local templates only use fields pages have
+  export          export docs with a signed manifest of their hashes
+  restore         restore docs from an export or a snapshot
+  bundle          bundle the site's theme for cold starts, or its configuration
+                  to promote to another environment
+  theme install   install a shared theme from a Git repository or zip archive
+  bootstrap       set up the tables, config, theme and home page of a new store
+  doctor          diagnose a deployment, printing what to fix
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd := os.Args[1:]; {
+	case len(cmd) >= 2 && cmd[0] == "template" && cmd[1] == "test":
+		err = templateTest(cmd[2:])
+	case len(cmd) >= 2 && cmd[0] == "template" && cmd[1] == "lint":
+		err = templateLint(cmd[2:])
+	case len(cmd) >= 2 && cmd[0] == "theme" && cmd[1] == "install":
+		err = themeInstall(cmd[2:])
+	case cmd[0] == "export":
+		err = export(cmd[1:])
+	case cmd[0] == "restore":
+		err = restore(cmd[1:])
+	case cmd[0] == "bundle":
+		err = bundle(cmd[1:])
+	case cmd[0] == "bootstrap":
+		err = bootstrap(cmd[1:])
+	case cmd[0] == "doctor":
+		err = doctor(cmd[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "docctl: %v\n", err)
+		os.Exit(1)
+	}
+}
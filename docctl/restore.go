@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// restore writes docs back to the store from an export directory, a
+// snapshot file written by the snapshot function, or a snapshot in S3.
+// Every revision is checked against the manifest first. Docs missing from
+// the store are restored with their whole history; docs that exist are
+// left alone unless -overwrite is given, when the snapshot's latest
+// revision is written over them. The store numbers and dates restored
+// revisions afresh.
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	overwrite := fs.Bool("overwrite", false, "write the snapshot's latest revision over docs that exist")
+	dryRun := fs.Bool("dry-run", false, "report what would be restored without writing")
+	tier := fs.String("tier", "Standard", "retrieval tier for snapshots in S3 archive storage: Expedited, Standard or Bulk")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl restore [flags] dir|snapshot.tar.gz|s3://bucket/key [docId...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	src, only := fs.Arg(0), fs.Args()[1:]
+
+	var files map[string][]byte
+	var err error
+	switch {
+	case strings.HasPrefix(src, "s3://"):
+		var b []byte
+		b, err = fetchSnapshot(src, *tier)
+		if err == nil {
+			files, err = readTar(bytes.NewReader(b))
+		}
+	case strings.HasSuffix(src, ".tar.gz") || strings.HasSuffix(src, ".tgz"):
+		var f *os.File
+		f, err = os.Open(src)
+		if err == nil {
+			files, err = readTar(f)
+			f.Close()
+		}
+	default:
+		files, err = readDir(src)
+	}
+	if err != nil {
+		return err
+	}
+
+	m, err := checkManifest(files)
+	if err != nil {
+		return err
+	}
+
+	byDoc := map[string][]manifestEntry{}
+	for _, e := range m.Docs {
+		byDoc[e.DocId] = append(byDoc[e.DocId], e)
+	}
+	docIds := only
+	if len(docIds) == 0 {
+		for docId := range byDoc {
+			docIds = append(docIds, docId)
+		}
+	}
+	sort.Strings(docIds)
+
+	ds := awsdocstore.New()
+	restored, skipped := 0, 0
+	for _, docId := range docIds {
+		entries := byDoc[docId]
+		if len(entries) == 0 {
+			return fmt.Errorf("%s: not in the snapshot", docId)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Revision < entries[j].Revision })
+
+		latest, err := ds.GetDoc(docId)
+		switch {
+		case err == nil && !*overwrite:
+			skipped++
+			continue
+		case err == nil:
+			body, err := ioutil.ReadAll(latest)
+			if err != nil {
+				return fmt.Errorf("%s: %v", docId, err)
+			}
+			last := entries[len(entries)-1]
+			if bytes.Equal(body, files[last.Path]) {
+				skipped++
+				continue
+			}
+			entries = []manifestEntry{last}
+		case !errors.Is(docerr.FromStore("GetDoc", err), docerr.ErrNotFound):
+			return fmt.Errorf("%s: %v", docId, err)
+		}
+
+		fmt.Printf("%s: %d revisions\n", docId, len(entries))
+		restored++
+		if *dryRun {
+			continue
+		}
+		for _, e := range entries {
+			_, err := ds.PutRevision(docId, bytes.NewReader(files[e.Path]))
+			if err != nil {
+				return fmt.Errorf("%s@%d: %v", docId, e.Revision, err)
+			}
+		}
+	}
+
+	verb := "restored"
+	if *dryRun {
+		verb = "would restore"
+	}
+	fmt.Printf("%s %d docs from the snapshot of %s, skipped %d\n", verb, restored, m.Exported.Format("2006-01-02 15:04"), skipped)
+	return nil
+}
+
+// checkManifest parses the manifest among files and checks every revision
+// it lists against its hash.
+func checkManifest(files map[string][]byte) (manifest, error) {
+	var m manifest
+	b, ok := files["manifest.json"]
+	if !ok {
+		return m, errors.New("no manifest.json")
+	}
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return m, fmt.Errorf("manifest.json: %v", err)
+	}
+
+	for _, e := range m.Docs {
+		body, ok := files[e.Path]
+		if !ok {
+			return m, fmt.Errorf("%s is missing", e.Path)
+		}
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != e.SHA256 {
+			return m, fmt.Errorf("%s doesn't match its hash", e.Path)
+		}
+	}
+	return m, nil
+}
+
+// readDir reads the files of an export directory.
+func readDir(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		files[filepath.ToSlash(rel)] = b
+		return err
+	})
+	return files, err
+}
+
+// readTar reads the files of a gzipped tar snapshot.
+func readTar(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = b
+	}
+}
+
+// fetchSnapshot downloads a snapshot from S3. Snapshots in archive storage
+// have to be restored before they can be read, which takes hours, so
+// fetchSnapshot starts the restore and asks to be run again.
+func fetchSnapshot(url, tier string) ([]byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(url, "s3://"), "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%s: want s3://bucket/key", url)
+	}
+	bucket, key := parts[0], parts[1]
+	svc := s3.New(session.Must(session.NewSession()))
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	archived := aws.StringValue(head.StorageClass) == s3.StorageClassGlacier ||
+		aws.StringValue(head.StorageClass) == s3.StorageClassDeepArchive
+	restoring := aws.StringValue(head.Restore)
+	switch {
+	case archived && restoring == "":
+		_, err = svc.RestoreObject(&s3.RestoreObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			RestoreRequest: &s3.RestoreRequest{
+				Days:                 aws.Int64(7),
+				GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s is in archive storage; its restore has started, run this again once it's done", url)
+	case archived && strings.Contains(restoring, `ongoing-request="true"`):
+		return nil, fmt.Errorf("%s is still being restored from archive storage", url)
+	}
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	return ioutil.ReadAll(obj.Body)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/tmpllint"
+	"github.com/gomarkdown/markdown"
+)
+
+// pageData mirrors the fields the docs handler gives page templates. Keep
+// it in step with docMetadata there.
+type pageData struct {
+	Title, DocBody, Timestamp string
+	Version                   int
+	TimestampISO              string
+	UpdatedAgo                string
+	OldRevision               *revisionBanner
+	Mirror                    *mirrorBanner
+	Source                    *sourceBanner
+	AsOf                      *asOfBanner
+	Robots                    string
+	JSONLD                    string
+	OEmbed                    string
+	Canonical                 string
+	Permalink                 string
+	Revision                  revisionMeta
+	Summary                   string
+	Audio                     string
+	Tags                      []string
+	Date                      string
+	Params                    map[string]interface{}
+	TOC                       []tocEntry
+	Footnotes                 []footnote
+	Docs                      []docSummary
+	RecentlyViewed            []docSummary
+	Prev, Next                *docSummary
+	Pager                     *docPager
+	Render                    *renderContext
+}
+
+// revisionBanner mirrors the data for a template's "banner" definition.
+type revisionBanner struct {
+	DocId     string
+	Version   int
+	Latest    int
+	LatestURL string
+	DiffURL   string
+	Versions  string
+}
+
+// mirrorBanner mirrors the data for a template's "mirror" definition.
+type mirrorBanner struct {
+	Source string
+	AsOf   string
+}
+
+// sourceBanner mirrors the data for a template's "source" definition.
+type sourceBanner struct {
+	System, URL, Synced string
+}
+
+// asOfBanner mirrors the data for a template's "asOf" definition.
+type asOfBanner struct {
+	AsOf      string
+	LatestURL string
+}
+
+// revisionMeta mirrors the metadata of the revision a page shows.
+type revisionMeta struct {
+	DocId, ContentType, SHA256, Author, Message string
+	Version, Size                               int
+	Timestamp                                   time.Time
+}
+
+// tocEntry mirrors a heading in the table of contents.
+type tocEntry struct {
+	Level            int
+	ID, Text, Number string
+}
+
+// footnote mirrors a footnote of the doc.
+type footnote struct {
+	Number                int
+	ID, RefID, Text, HTML string
+}
+
+// renderContext mirrors the render context of the request a page is
+// rendered for.
+type renderContext struct {
+	Path, Variant string
+	Started       time.Time
+	features      map[string]bool
+	stages        []renderStage
+}
+
+// renderStage mirrors how long a stage of rendering took.
+type renderStage struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Feature mirrors the render context's feature flag check.
+func (rc *renderContext) Feature(name string) bool {
+	return rc != nil && rc.features[name]
+}
+
+// Stages mirrors the render context's stage timings.
+func (rc *renderContext) Stages() []renderStage {
+	if rc == nil {
+		return nil
+	}
+	return rc.stages
+}
+
+// docPager mirrors the pager of a doc split into pages.
+type docPager struct {
+	Page, Pages int
+	Prev, Next  string
+	Links       []pageLink
+}
+
+// pageLink mirrors a page in a pager.
+type pageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+// docSummary mirrors the docs the listing functions return.
+type docSummary struct {
+	DocId     string
+	Title     string
+	Version   int
+	Timestamp time.Time
+
+	Pinned, Featured bool
+	Canonical        string
+}
+
+// sampleDoc is rendered when no docs are given.
+const sampleDoc = `Sample Doc
+
+Some *emphasis*, some **strong text** and a [link](/index).
+
+    $ make deploy
+`
+
+// testFuncs stand in for the handler's template functions with sample
+// results, so templates can be tested without a docstore.
+var testFuncs = template.FuncMap{
+	"allDocs":         sampleListing,
+	"pinnedDocs":      sampleListing,
+	"featuredDocs":    sampleListing,
+	"recentlyUpdated": func(n int) []docSummary { return sampleListing() },
+	"asset":           func(docId string) string { return "/assets/" + docId + "?v=1" },
+	"beaconScript":    func() string { return "<script></script>" },
+	"offlineTags":     func() string { return "<link rel=\"manifest\" href=\"/manifest.webmanifest\">" },
+	"var":             func(key string) string { return key },
+}
+
+func sampleListing() []docSummary {
+	return []docSummary{{DocId: "index", Title: "Sample Doc", Version: 1, Timestamp: time.Now()}}
+}
+
+// templateTest renders a local template against docs and reports missing
+// fields, execution errors and output sizes. It fails if any doc can't be
+// rendered or the template uses fields pages don't have.
+func templateTest(args []string) error {
+	fs := flag.NewFlagSet("template test", flag.ExitOnError)
+	dir := fs.String("docs", "", "directory of sample docs, one file per doc")
+	live := fs.Bool("live", false, "read the named docs from the live docstore, read-only")
+	maxSize := fs.Int("max-size", 0, "report pages larger than this many bytes as problems")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl template test [flags] template.html [docId...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	tmplFile, docIds := fs.Arg(0), fs.Args()[1:]
+	src, err := ioutil.ReadFile(tmplFile)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("docPage").Funcs(testFuncs).Parse(string(src))
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, u := range lintPageTemplate(tmpl).Undefined {
+		fmt.Printf("%s: %s\n", tmplFile, u)
+		failed = true
+	}
+
+	docs, err := loadDocs(*dir, *live, docIds)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range docs {
+		out, err := renderSample(tmpl, d.body, d.old)
+		if err != nil {
+			fmt.Printf("%s: %v\n", d.name, err)
+			failed = true
+			continue
+		}
+
+		status := "ok"
+		if *maxSize > 0 && len(out) > *maxSize {
+			status = fmt.Sprintf("over %d bytes", *maxSize)
+			failed = true
+		}
+		fmt.Printf("%s: %d bytes %s\n", d.name, len(out), status)
+	}
+
+	if failed {
+		return errors.New("template test failed")
+	}
+	return nil
+}
+
+// templateLint checks local templates against the data pages give them,
+// without rendering anything, as the docs handler does when a template is
+// saved. It reports references to fields, methods and templates that don't
+// exist, which fail the lint, and the page fields each template leaves
+// unused, which only fail it with -strict.
+func templateLint(args []string) error {
+	fs := flag.NewFlagSet("template lint", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "fail on unused page fields too")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl template lint [flags] template.html...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, tmplFile := range fs.Args() {
+		src, err := ioutil.ReadFile(tmplFile)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New("docPage").Funcs(testFuncs).Parse(string(src))
+		if err != nil {
+			fmt.Printf("%s: %v\n", tmplFile, err)
+			failed = true
+			continue
+		}
+
+		r := lintPageTemplate(tmpl)
+		for _, u := range r.Undefined {
+			fmt.Printf("%s: %s\n", tmplFile, u)
+			failed = true
+		}
+		for _, u := range r.Unused {
+			fmt.Printf("%s: unused field %s\n", tmplFile, u)
+			failed = failed || *strict
+		}
+	}
+
+	if failed {
+		return errors.New("template lint failed")
+	}
+	return nil
+}
+
+// lintPageTemplate checks a page template, and its banner definitions,
+// against the data they are given.
+func lintPageTemplate(tmpl *template.Template) tmpllint.Report {
+	return tmpllint.Check(tmpl, map[string]reflect.Type{
+		"docPage": reflect.TypeOf(pageData{}),
+		"banner":  reflect.TypeOf(revisionBanner{}),
+		"asOf":    reflect.TypeOf(asOfBanner{}),
+		"mirror":  reflect.TypeOf(mirrorBanner{}),
+		"source":  reflect.TypeOf(sourceBanner{}),
+	}, testFuncs)
+}
+
+type testDoc struct {
+	name string
+	body []byte
+	old  bool
+}
+
+// loadDocs reads the docs to render: the named docs from the live store,
+// the files in dir, or the built-in sample. Each is also rendered as an old
+// revision so the banner is exercised.
+func loadDocs(dir string, live bool, docIds []string) (docs []testDoc, err error) {
+	switch {
+	case live:
+		ds := awsdocstore.New()
+		for _, docId := range docIds {
+			rev, err := ds.GetDoc(docId)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", docId, err)
+			}
+			body, err := ioutil.ReadAll(rev)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", docId, err)
+			}
+			docs = append(docs, testDoc{name: docId, body: body})
+		}
+
+	case dir != "":
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			// Raw docs and system docs aren't rendered with the template.
+			if f.IsDir() || strings.Contains(f.Name(), ".") || strings.HasPrefix(f.Name(), "_") {
+				continue
+			}
+			body, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, testDoc{name: f.Name(), body: body})
+		}
+
+	default:
+		docs = append(docs, testDoc{name: "sample", body: []byte(sampleDoc)})
+	}
+
+	if len(docs) == 0 {
+		return nil, errors.New("no docs to render")
+	}
+
+	docs = append(docs, testDoc{name: docs[0].name + " (old revision)", body: docs[0].body, old: true})
+	return docs, nil
+}
+
+// renderSample renders doc with tmpl the way the handler does, minus the
+// store lookups.
+func renderSample(tmpl *template.Template, doc []byte, old bool) ([]byte, error) {
+	doc = stripFrontMatter(doc)
+	now := time.Now().UTC()
+
+	data := pageData{
+		Title:        firstLine(doc),
+		DocBody:      string(markdown.ToHTML(doc, nil, nil)),
+		Timestamp:    now.Format(time.RFC850),
+		TimestampISO: now.Format(time.RFC3339),
+		UpdatedAgo:   "just now",
+		Version:      2,
+		Tags:         []string{"sample"},
+		Date:         now.Format(time.RFC850),
+		Footnotes:    []footnote{{Number: 1, ID: "fn:1", RefID: "fnref:1", Text: "A sample footnote.", HTML: "<p>A sample footnote.</p>"}},
+		Render: &renderContext{Path: "/sample", Variant: "stable", Started: now, stages: []renderStage{
+			{"fetch", 4 * time.Millisecond},
+			{"markdown", 2 * time.Millisecond},
+		}},
+	}
+	sample := sampleListing()[0]
+	data.Prev, data.Next = &sample, &sample
+	data.Pager = &docPager{Page: 1, Pages: 2, Next: "/docs/sample/page/2", Links: []pageLink{
+		{Number: 1, URL: "/sample", Current: true},
+		{Number: 2, URL: "/docs/sample/page/2"},
+	}}
+	if old {
+		data.OldRevision = &revisionBanner{DocId: "sample", Version: 1, Latest: 2, LatestURL: "/sample", DiffURL: "/sample?diff=1", Versions: "/docs/sample/versions"}
+		if banner := tmpl.Lookup("banner"); banner != nil {
+			var b bytes.Buffer
+			if err := banner.Execute(&b, data.OldRevision); err != nil {
+				return nil, err
+			}
+			data.DocBody = b.String() + data.DocBody
+		}
+	}
+
+	var b bytes.Buffer
+	err := tmpl.Execute(&b, data)
+	return b.Bytes(), err
+}
+
+func firstLine(b []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Scan()
+	return scanner.Text()
+}
+
+// stripFrontMatter drops a leading block between "---" lines.
+func stripFrontMatter(doc []byte) []byte {
+	lines := bytes.SplitAfter(doc, []byte("\n"))
+	if len(lines) == 0 || strings.TrimSpace(string(lines[0])) != "---" {
+		return doc
+	}
+	for i := 1; i < len(lines); i++ {
+		if l := strings.TrimSpace(string(lines[i])); l == "---" || l == "..." {
+			return bytes.Join(lines[i+1:], nil)
+		}
+	}
+	return doc
+}
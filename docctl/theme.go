@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// themePrefix and themeRequired mirror the docs handler's: an installed
+// theme's docs are named like "_themes.paper.style.css", and every theme
+// has a page template.
+const themePrefix = "_themes."
+
+var themeRequired = []string{"doc-template.html"}
+
+// themeInstall installs a theme shared as a Git repository, a zip archive
+// or a directory as the docs under themePrefix, as "POST
+// /api/v1/admin/themes/{name}" does for archives: its templates,
+// stylesheets and scripts at the top of the repository, archive or
+// directory, or under the one directory everything is in. Every template
+// is parsed and linted first, and the theme is only written if all pass.
+// With -activate its docs are written over the site's own too.
+func themeInstall(args []string) error {
+	fs := flag.NewFlagSet("theme install", flag.ExitOnError)
+	ref := fs.String("ref", "", "the branch or tag of a Git repository to install")
+	activate := fs.Bool("activate", false, "make the theme the site's by writing its docs over the site's own")
+	dryRun := fs.Bool("dry-run", false, "check the theme and report what would be written without writing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: docctl theme install [flags] name git-url|theme.zip|dir")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name, src := fs.Arg(0), fs.Arg(1)
+	if !validThemeName(name) {
+		return fmt.Errorf("%s: a theme name is lowercase letters, digits and dashes", name)
+	}
+
+	var files map[string][]byte
+	var err error
+	switch {
+	case strings.HasSuffix(src, ".zip"):
+		var b []byte
+		if strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://") {
+			b, err = fetchArchive(src)
+		} else {
+			b, err = ioutil.ReadFile(src)
+		}
+		if err == nil {
+			files, err = readZip(b)
+		}
+	case isGitURL(src):
+		files, err = cloneRepo(src, *ref)
+	default:
+		files, err = readDir(src)
+	}
+	if err != nil {
+		return err
+	}
+
+	theme := pickThemeFiles(files)
+	failed := false
+	for _, t := range themeRequired {
+		if _, ok := theme[t]; !ok {
+			fmt.Printf("%s: missing\n", t)
+			failed = true
+		}
+	}
+	for _, file := range sortedFiles(theme) {
+		if !strings.HasSuffix(file, "-template.html") {
+			continue
+		}
+		tmpl, err := template.New("docPage").Funcs(testFuncs).Parse(string(theme[file]))
+		if err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			failed = true
+			continue
+		}
+		for _, u := range lintPageTemplate(tmpl).Undefined {
+			fmt.Printf("%s: %s\n", file, u)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("theme %s failed its checks; nothing was written", name)
+	}
+
+	ds := awsdocstore.New()
+	written := 0
+	for _, file := range sortedFiles(theme) {
+		docIds := []string{themePrefix + name + "." + file}
+		if *activate {
+			docIds = append(docIds, file)
+		}
+		for _, docId := range docIds {
+			changed, err := themeDocChanged(ds, docId, theme[file])
+			if err != nil {
+				return fmt.Errorf("%s: %v", docId, err)
+			}
+			if !changed {
+				continue
+			}
+			fmt.Println(docId)
+			written++
+			if *dryRun {
+				continue
+			}
+			if _, err := ds.PutRevision(docId, bytes.NewReader(theme[file])); err != nil {
+				return fmt.Errorf("%s: %v", docId, err)
+			}
+		}
+	}
+
+	verb := "wrote"
+	if *dryRun {
+		verb = "would write"
+	}
+	fmt.Printf("theme %s: %s %d docs\n", name, verb, written)
+	return nil
+}
+
+func validThemeName(name string) bool {
+	if name == "" || name[0] == '-' {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// isGitURL reports whether src names a Git repository rather than a
+// local directory.
+func isGitURL(src string) bool {
+	return strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://") ||
+		strings.HasPrefix(src, "git://") || strings.HasPrefix(src, "ssh://") ||
+		strings.HasPrefix(src, "git@") || strings.HasSuffix(src, ".git")
+}
+
+// cloneRepo reads the files of a shallow clone of the repository at url,
+// at ref if it is set.
+func cloneRepo(url, ref string) (map[string][]byte, error) {
+	dir, err := ioutil.TempDir("", "docctl-theme")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	cmd := exec.Command("git", append(args, url, dir)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git clone %s: %v", url, err)
+	}
+	return readDir(dir)
+}
+
+// fetchArchive downloads the archive at url.
+func fetchArchive(url string) ([]byte, error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// readZip reads the files of a zip archive.
+func readZip(b []byte) (map[string][]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		files[f.Name], err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	return files, nil
+}
+
+// pickThemeFiles picks the files of a theme out of files, as the docs
+// handler's themeFiles does: the templates, stylesheets and scripts at the
+// top, or under the one directory everything is in.
+func pickThemeFiles(files map[string][]byte) map[string][]byte {
+	dir := ""
+	for p := range files {
+		dir = strings.SplitN(path.Clean("/" + p)[1:], "/", 2)[0] + "/"
+		break
+	}
+	for p := range files {
+		if !strings.HasPrefix(path.Clean("/" + p)[1:], dir) {
+			dir = ""
+			break
+		}
+	}
+
+	theme := map[string][]byte{}
+	for p, body := range files {
+		name := strings.TrimPrefix(path.Clean("/" + p)[1:], dir)
+		ext := path.Ext(name)
+		if strings.Contains(name, "/") || strings.HasPrefix(name, ".") || docstore.ValidateDocId(name) != nil ||
+			(ext != ".html" && ext != ".css" && ext != ".js") ||
+			(ext == ".html" && !strings.HasSuffix(name, "-template.html")) {
+			continue
+		}
+		theme[name] = body
+	}
+	return theme
+}
+
+func sortedFiles(files map[string][]byte) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// themeDocChanged reports whether docId is missing or its latest revision
+// isn't body.
+func themeDocChanged(ds docstore.DocStore, docId string, body []byte) (bool, error) {
+	rev, err := ds.GetDoc(docId)
+	if errors.Is(docerr.FromStore("GetDoc", err), docerr.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	b, err := ioutil.ReadAll(rev)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(b, body), nil
+}
@@ -0,0 +1,172 @@
+// Package docerr defines the kinds of errors the doc service can return and
+// maps them centrally to HTTP status codes, public messages and metric
+// names.
+package docerr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel kinds. Test for them with errors.Is, which also matches the
+// kinds of wrapped errors, or compare them with Kind.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrForbidden        = errors.New("forbidden")
+	ErrConflict         = errors.New("conflict")
+	ErrBackend          = errors.New("backend error")
+	ErrTemplate         = errors.New("template error")
+	ErrTimeout          = errors.New("timed out")
+	ErrBadRequest       = errors.New("bad request")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrTooLarge         = errors.New("too large")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrTooManyAttempts  = errors.New("too many attempts")
+	ErrUpgradeRequired  = errors.New("upgrade required")
+	ErrLocked           = errors.New("locked")
+)
+
+// Error is an error of a particular kind raised by an operation.
+type Error struct {
+	Op   string // The operation that failed, like "GetDoc index"
+	Kind error  // One of the sentinel kinds
+	Err  error  // The underlying error, if any
+
+	// Details are extra facts for API clients, like which field was
+	// invalid.
+	Details map[string]interface{}
+}
+
+// E returns an error of kind raised by op, wrapping err.
+func E(op string, kind error, err error) error {
+	return &Error{Op: op, Kind: kind, Err: err}
+}
+
+// WithDetails is like E but attaches details for API clients.
+func WithDetails(op string, kind error, err error, details map[string]interface{}) error {
+	return &Error{Op: op, Kind: kind, Err: err, Details: details}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %v", e.Op, e.Kind)
+	}
+	return fmt.Sprintf("%s: %v: %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// FromStore classifies an error returned by a docstore.DocStore. The
+// docstore only reports missing docs and revisions by message, so anything
+// else is treated as a backend failure.
+func FromStore(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	switch err.Error() {
+	case "Doc not found.", "Revision not found.":
+		return E(op, ErrNotFound, err)
+	}
+
+	return E(op, ErrBackend, err)
+}
+
+type kindInfo struct {
+	kind    error
+	status  int
+	message string
+	metric  string
+	code    string
+}
+
+var kinds = []kindInfo{
+	{ErrNotFound, http.StatusNotFound, "Not found", "NotFound", "not_found"},
+	{ErrForbidden, http.StatusForbidden, "Forbidden", "Forbidden", "forbidden"},
+	{ErrConflict, http.StatusConflict, "Conflict", "Conflict", "conflict"},
+	{ErrBackend, http.StatusServiceUnavailable, "The document store is unavailable", "Backend", "backend_unavailable"},
+	{ErrTemplate, http.StatusInternalServerError, "The page could not be rendered", "Template", "template_error"},
+	{ErrTimeout, http.StatusGatewayTimeout, "The request timed out", "Timeout", "timeout"},
+	{ErrBadRequest, http.StatusBadRequest, "Bad request", "BadRequest", "bad_request"},
+	{ErrUnauthorized, http.StatusUnauthorized, "Authentication required", "Unauthorized", "unauthorized"},
+	{ErrMethodNotAllowed, http.StatusMethodNotAllowed, "Method not allowed", "MethodNotAllowed", "method_not_allowed"},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge, "Too large", "TooLarge", "too_large"},
+	{ErrQuotaExceeded, http.StatusTooManyRequests, "Quota exceeded", "QuotaExceeded", "quota_exceeded"},
+	{ErrTooManyAttempts, http.StatusTooManyRequests, "Too many failed attempts; try again later", "TooManyAttempts", "too_many_attempts"},
+	{ErrUpgradeRequired, http.StatusUpgradeRequired, "This client is too old; upgrade it", "UpgradeRequired", "upgrade_required"},
+	{ErrLocked, http.StatusLocked, "Docs are frozen; try again later", "Locked", "locked"},
+}
+
+var unknown = kindInfo{nil, http.StatusInternalServerError, "Internal server error", "Internal", "internal"}
+
+// Kind returns the kind of err, or nil if it has none. That is the kind
+// of the outermost *Error in its chain, so an operation that wraps an
+// error of another kind, like a template that wasn't found, decides what
+// it is. An error wrapping a sentinel without an *Error has that sentinel.
+func Kind(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	for _, k := range kinds {
+		if errors.Is(err, k.kind) {
+			return k.kind
+		}
+	}
+	return nil
+}
+
+func info(err error) kindInfo {
+	kind := Kind(err)
+	for _, k := range kinds {
+		if kind == k.kind {
+			return k
+		}
+	}
+	return unknown
+}
+
+// Status returns the HTTP status code for err.
+func Status(err error) int {
+	return info(err).status
+}
+
+// Message returns a message for err that is safe to show to readers.
+func Message(err error) string {
+	return info(err).message
+}
+
+// Metric returns the name err is counted under in error metrics.
+func Metric(err error) string {
+	return info(err).metric
+}
+
+// Code returns a stable machine readable code for err, like "not_found".
+func Code(err error) string {
+	return info(err).code
+}
+
+// Details returns the details attached to err, if any.
+func Details(err error) map[string]interface{} {
+	var e *Error
+	for errors.As(err, &e) {
+		if e.Details != nil {
+			return e.Details
+		}
+		err = e.Err
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package docerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestKind(t *testing.T) {
+	notFound := E("GetDoc doc-template.html", ErrNotFound, errors.New("Doc not found."))
+
+	tests := []struct {
+		name   string
+		err    error
+		kind   error
+		status int
+		metric string
+	}{
+		{"kind", E("GetDoc x", ErrNotFound, nil), ErrNotFound, http.StatusNotFound, "NotFound"},
+		{"outer kind wins", E("template doc-template.html", ErrTemplate, notFound), ErrTemplate, http.StatusInternalServerError, "Template"},
+		{"wrapped by fmt", fmt.Errorf("render: %w", E("template x", ErrTemplate, notFound)), ErrTemplate, http.StatusInternalServerError, "Template"},
+		{"bare sentinel", fmt.Errorf("lookup: %w", ErrConflict), ErrConflict, http.StatusConflict, "Conflict"},
+		{"from store", FromStore("GetDoc x", errors.New("Revision not found.")), ErrNotFound, http.StatusNotFound, "NotFound"},
+		{"store failure", FromStore("GetDoc x", context.DeadlineExceeded), ErrBackend, http.StatusServiceUnavailable, "Backend"},
+		{"unknown", errors.New("boom"), nil, http.StatusInternalServerError, "Internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Kind(tt.err); got != tt.kind {
+				t.Errorf("Kind = %v, want %v", got, tt.kind)
+			}
+			if got := Status(tt.err); got != tt.status {
+				t.Errorf("Status = %d, want %d", got, tt.status)
+			}
+			if got := Metric(tt.err); got != tt.metric {
+				t.Errorf("Metric = %q, want %q", got, tt.metric)
+			}
+		})
+	}
+
+	// errors.Is still finds the kinds of the errors wrapped.
+	if err := E("template x", ErrTemplate, notFound); !errors.Is(err, ErrNotFound) {
+		t.Errorf("errors.Is(%v, ErrNotFound) = false", err)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	inner := WithDetails("write x", ErrBadRequest, nil, map[string]interface{}{"field": "title"})
+	if got := Details(E("bulk write", ErrBadRequest, inner)); got["field"] != "title" {
+		t.Errorf("Details = %v, want the wrapped error's", got)
+	}
+	if got := Details(errors.New("boom")); got != nil {
+		t.Errorf("Details of a plain error = %v, want nil", got)
+	}
+}
@@ -0,0 +1,205 @@
+// The doc API for internal services, served by the server command over
+// Connect, gRPC and gRPC-Web at /docstore.v1.DocService/<Method>, alongside
+// the REST API it calls. Regenerate the Go code with "make proto".
+
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: docstore.proto
+
+package docstorev1connect
+
+import (
+	connect "connectrpc.com/connect"
+	context "context"
+	errors "errors"
+	docstorev1 "github.com/drocamor/n22t.docstore/docstorev1"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect.IsAtLeastVersion1_13_0
+
+const (
+	// DocServiceName is the fully-qualified name of the DocService service.
+	DocServiceName = "docstore.v1.DocService"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// DocServiceGetDocProcedure is the fully-qualified name of the DocService's GetDoc RPC.
+	DocServiceGetDocProcedure = "/docstore.v1.DocService/GetDoc"
+	// DocServicePutDocProcedure is the fully-qualified name of the DocService's PutDoc RPC.
+	DocServicePutDocProcedure = "/docstore.v1.DocService/PutDoc"
+	// DocServiceListRevisionsProcedure is the fully-qualified name of the DocService's ListRevisions
+	// RPC.
+	DocServiceListRevisionsProcedure = "/docstore.v1.DocService/ListRevisions"
+	// DocServiceSearchProcedure is the fully-qualified name of the DocService's Search RPC.
+	DocServiceSearchProcedure = "/docstore.v1.DocService/Search"
+)
+
+// DocServiceClient is a client for the docstore.v1.DocService service.
+type DocServiceClient interface {
+	// GetDoc returns the latest revision of a page.
+	GetDoc(context.Context, *connect.Request[docstorev1.GetDocRequest]) (*connect.Response[docstorev1.GetDocResponse], error)
+	// PutDoc writes a new revision of a doc, as PUT /api/v1/docs/{docId}.
+	PutDoc(context.Context, *connect.Request[docstorev1.PutDocRequest]) (*connect.Response[docstorev1.PutDocResponse], error)
+	// ListRevisions lists the revisions of a doc, newest first.
+	ListRevisions(context.Context, *connect.Request[docstorev1.ListRevisionsRequest]) (*connect.Response[docstorev1.ListRevisionsResponse], error)
+	// Search searches the docs, as GET /api/v1/search.
+	Search(context.Context, *connect.Request[docstorev1.SearchRequest]) (*connect.Response[docstorev1.SearchResponse], error)
+}
+
+// NewDocServiceClient constructs a client for the docstore.v1.DocService service. By default, it
+// uses the Connect protocol with the binary Protobuf Codec, asks for gzipped responses, and sends
+// uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the connect.WithGRPC() or
+// connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewDocServiceClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) DocServiceClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	docServiceMethods := docstorev1.File_docstore_proto.Services().ByName("DocService").Methods()
+	return &docServiceClient{
+		getDoc: connect.NewClient[docstorev1.GetDocRequest, docstorev1.GetDocResponse](
+			httpClient,
+			baseURL+DocServiceGetDocProcedure,
+			connect.WithSchema(docServiceMethods.ByName("GetDoc")),
+			connect.WithClientOptions(opts...),
+		),
+		putDoc: connect.NewClient[docstorev1.PutDocRequest, docstorev1.PutDocResponse](
+			httpClient,
+			baseURL+DocServicePutDocProcedure,
+			connect.WithSchema(docServiceMethods.ByName("PutDoc")),
+			connect.WithClientOptions(opts...),
+		),
+		listRevisions: connect.NewClient[docstorev1.ListRevisionsRequest, docstorev1.ListRevisionsResponse](
+			httpClient,
+			baseURL+DocServiceListRevisionsProcedure,
+			connect.WithSchema(docServiceMethods.ByName("ListRevisions")),
+			connect.WithClientOptions(opts...),
+		),
+		search: connect.NewClient[docstorev1.SearchRequest, docstorev1.SearchResponse](
+			httpClient,
+			baseURL+DocServiceSearchProcedure,
+			connect.WithSchema(docServiceMethods.ByName("Search")),
+			connect.WithClientOptions(opts...),
+		),
+	}
+}
+
+// docServiceClient implements DocServiceClient.
+type docServiceClient struct {
+	getDoc        *connect.Client[docstorev1.GetDocRequest, docstorev1.GetDocResponse]
+	putDoc        *connect.Client[docstorev1.PutDocRequest, docstorev1.PutDocResponse]
+	listRevisions *connect.Client[docstorev1.ListRevisionsRequest, docstorev1.ListRevisionsResponse]
+	search        *connect.Client[docstorev1.SearchRequest, docstorev1.SearchResponse]
+}
+
+// GetDoc calls docstore.v1.DocService.GetDoc.
+func (c *docServiceClient) GetDoc(ctx context.Context, req *connect.Request[docstorev1.GetDocRequest]) (*connect.Response[docstorev1.GetDocResponse], error) {
+	return c.getDoc.CallUnary(ctx, req)
+}
+
+// PutDoc calls docstore.v1.DocService.PutDoc.
+func (c *docServiceClient) PutDoc(ctx context.Context, req *connect.Request[docstorev1.PutDocRequest]) (*connect.Response[docstorev1.PutDocResponse], error) {
+	return c.putDoc.CallUnary(ctx, req)
+}
+
+// ListRevisions calls docstore.v1.DocService.ListRevisions.
+func (c *docServiceClient) ListRevisions(ctx context.Context, req *connect.Request[docstorev1.ListRevisionsRequest]) (*connect.Response[docstorev1.ListRevisionsResponse], error) {
+	return c.listRevisions.CallUnary(ctx, req)
+}
+
+// Search calls docstore.v1.DocService.Search.
+func (c *docServiceClient) Search(ctx context.Context, req *connect.Request[docstorev1.SearchRequest]) (*connect.Response[docstorev1.SearchResponse], error) {
+	return c.search.CallUnary(ctx, req)
+}
+
+// DocServiceHandler is an implementation of the docstore.v1.DocService service.
+type DocServiceHandler interface {
+	// GetDoc returns the latest revision of a page.
+	GetDoc(context.Context, *connect.Request[docstorev1.GetDocRequest]) (*connect.Response[docstorev1.GetDocResponse], error)
+	// PutDoc writes a new revision of a doc, as PUT /api/v1/docs/{docId}.
+	PutDoc(context.Context, *connect.Request[docstorev1.PutDocRequest]) (*connect.Response[docstorev1.PutDocResponse], error)
+	// ListRevisions lists the revisions of a doc, newest first.
+	ListRevisions(context.Context, *connect.Request[docstorev1.ListRevisionsRequest]) (*connect.Response[docstorev1.ListRevisionsResponse], error)
+	// Search searches the docs, as GET /api/v1/search.
+	Search(context.Context, *connect.Request[docstorev1.SearchRequest]) (*connect.Response[docstorev1.SearchResponse], error)
+}
+
+// NewDocServiceHandler builds an HTTP handler from the service implementation. It returns the path
+// on which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewDocServiceHandler(svc DocServiceHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	docServiceMethods := docstorev1.File_docstore_proto.Services().ByName("DocService").Methods()
+	docServiceGetDocHandler := connect.NewUnaryHandler(
+		DocServiceGetDocProcedure,
+		svc.GetDoc,
+		connect.WithSchema(docServiceMethods.ByName("GetDoc")),
+		connect.WithHandlerOptions(opts...),
+	)
+	docServicePutDocHandler := connect.NewUnaryHandler(
+		DocServicePutDocProcedure,
+		svc.PutDoc,
+		connect.WithSchema(docServiceMethods.ByName("PutDoc")),
+		connect.WithHandlerOptions(opts...),
+	)
+	docServiceListRevisionsHandler := connect.NewUnaryHandler(
+		DocServiceListRevisionsProcedure,
+		svc.ListRevisions,
+		connect.WithSchema(docServiceMethods.ByName("ListRevisions")),
+		connect.WithHandlerOptions(opts...),
+	)
+	docServiceSearchHandler := connect.NewUnaryHandler(
+		DocServiceSearchProcedure,
+		svc.Search,
+		connect.WithSchema(docServiceMethods.ByName("Search")),
+		connect.WithHandlerOptions(opts...),
+	)
+	return "/docstore.v1.DocService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case DocServiceGetDocProcedure:
+			docServiceGetDocHandler.ServeHTTP(w, r)
+		case DocServicePutDocProcedure:
+			docServicePutDocHandler.ServeHTTP(w, r)
+		case DocServiceListRevisionsProcedure:
+			docServiceListRevisionsHandler.ServeHTTP(w, r)
+		case DocServiceSearchProcedure:
+			docServiceSearchHandler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// UnimplementedDocServiceHandler returns CodeUnimplemented from all methods.
+type UnimplementedDocServiceHandler struct{}
+
+func (UnimplementedDocServiceHandler) GetDoc(context.Context, *connect.Request[docstorev1.GetDocRequest]) (*connect.Response[docstorev1.GetDocResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("docstore.v1.DocService.GetDoc is not implemented"))
+}
+
+func (UnimplementedDocServiceHandler) PutDoc(context.Context, *connect.Request[docstorev1.PutDocRequest]) (*connect.Response[docstorev1.PutDocResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("docstore.v1.DocService.PutDoc is not implemented"))
+}
+
+func (UnimplementedDocServiceHandler) ListRevisions(context.Context, *connect.Request[docstorev1.ListRevisionsRequest]) (*connect.Response[docstorev1.ListRevisionsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("docstore.v1.DocService.ListRevisions is not implemented"))
+}
+
+func (UnimplementedDocServiceHandler) Search(context.Context, *connect.Request[docstorev1.SearchRequest]) (*connect.Response[docstorev1.SearchResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("docstore.v1.DocService.Search is not implemented"))
+}
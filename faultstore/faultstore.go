@@ -0,0 +1,178 @@
+// Package faultstore wraps a docstore.DocStore and injects latency, errors
+// and truncated bodies so that resilience features can be exercised
+// outside of production.
+package faultstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/drocamor/docstore"
+)
+
+// ErrInjected is returned by calls that the FaultStore chose to fail.
+var ErrInjected = errors.New("faultstore: injected error")
+
+type FaultStore struct {
+	ds           docstore.DocStore
+	latency      time.Duration
+	errorRate    float64
+	truncateRate float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+type FaultStoreOption func(*FaultStore)
+
+// WithLatency delays every call by d.
+func WithLatency(d time.Duration) FaultStoreOption {
+	return func(f *FaultStore) {
+		f.latency = d
+	}
+}
+
+// WithErrorRate fails the given fraction of calls with ErrInjected.
+func WithErrorRate(r float64) FaultStoreOption {
+	return func(f *FaultStore) {
+		f.errorRate = r
+	}
+}
+
+// WithTruncateRate cuts the body of the given fraction of revisions read
+// through GetDoc and GetRevision in half.
+func WithTruncateRate(r float64) FaultStoreOption {
+	return func(f *FaultStore) {
+		f.truncateRate = r
+	}
+}
+
+// WithSeed makes the injected faults reproducible.
+func WithSeed(seed int64) FaultStoreOption {
+	return func(f *FaultStore) {
+		f.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+func New(ds docstore.DocStore, opts ...FaultStoreOption) *FaultStore {
+	f := &FaultStore{
+		ds:   ds,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, o := range opts {
+		o(f)
+	}
+
+	return f
+}
+
+func (f *FaultStore) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < rate
+}
+
+// inject sleeps for the configured latency and then decides whether the
+// call should fail.
+func (f *FaultStore) inject() error {
+	if f.latency > 0 {
+		time.Sleep(f.latency)
+	}
+
+	if f.roll(f.errorRate) {
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// truncatedRevision serves only the first half of a revision's body.
+type truncatedRevision struct {
+	docstore.Revision
+	r io.Reader
+}
+
+func (t *truncatedRevision) Read(p []byte) (int, error) {
+	return t.r.Read(p)
+}
+
+func (f *FaultStore) maybeTruncate(rev docstore.Revision) (docstore.Revision, error) {
+	if !f.roll(f.truncateRate) {
+		return rev, nil
+	}
+
+	b, err := ioutil.ReadAll(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return &truncatedRevision{
+		Revision: rev,
+		r:        bytes.NewReader(b[:len(b)/2]),
+	}, nil
+}
+
+func (f *FaultStore) GetDoc(docId string) (rev docstore.Revision, err error) {
+	err = f.inject()
+	if err != nil {
+		return
+	}
+
+	rev, err = f.ds.GetDoc(docId)
+	if err != nil {
+		return
+	}
+
+	return f.maybeTruncate(rev)
+}
+
+func (f *FaultStore) GetRevision(docId string, revisionId int) (rev docstore.Revision, err error) {
+	err = f.inject()
+	if err != nil {
+		return
+	}
+
+	rev, err = f.ds.GetRevision(docId, revisionId)
+	if err != nil {
+		return
+	}
+
+	return f.maybeTruncate(rev)
+}
+
+func (f *FaultStore) PutRevision(docId string, body io.Reader) (rev docstore.Revision, err error) {
+	err = f.inject()
+	if err != nil {
+		return
+	}
+
+	return f.ds.PutRevision(docId, body)
+}
+
+func (f *FaultStore) ListDocs(token string) (page docstore.DocPage, err error) {
+	err = f.inject()
+	if err != nil {
+		return
+	}
+
+	return f.ds.ListDocs(token)
+}
+
+func (f *FaultStore) ListRevisions(docId string, token string) (page docstore.RevisionPage, err error) {
+	err = f.inject()
+	if err != nil {
+		return
+	}
+
+	return f.ds.ListRevisions(docId, token)
+}
@@ -0,0 +1,157 @@
+// Package freshstore wraps a docstore.DocStore so that reads after a write
+// made through it see the write.
+//
+// The docstore.DocStore interface promises nothing about reads after
+// writes, and the DynamoDB store reads eventually consistently: for a
+// moment after PutRevision returns, GetDoc can still return the revision
+// before it, and GetRevision can miss the new one. A FreshStore remembers
+// the revisions written through it for a window and answers with them
+// whenever the store returns something older, so that after a successful
+// PutRevision, GetDoc returns the new revision or a newer one.
+//
+// The guarantee holds for reads through the same FreshStore. Writes made
+// elsewhere, by other instances or by clients like docctl, are seen when
+// the store and any caches in front of it catch up. ListDocs and
+// ListRevisions pass through unchanged.
+package freshstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/drocamor/docstore"
+)
+
+type FreshStore struct {
+	ds     docstore.DocStore
+	window time.Duration
+
+	mu      sync.Mutex
+	written map[string]written
+}
+
+// written is a revision written through the FreshStore, kept until the
+// store can be relied on to return it.
+type written struct {
+	meta docstore.RevisionMetadata
+	body []byte
+	at   time.Time
+}
+
+type FreshStoreOption func(*FreshStore)
+
+// WithWindow sets how long a revision written is remembered, which should
+// cover how long the store and any caches in it take to return it. The
+// default is ten seconds.
+func WithWindow(d time.Duration) FreshStoreOption {
+	return func(f *FreshStore) {
+		f.window = d
+	}
+}
+
+func New(ds docstore.DocStore, opts ...FreshStoreOption) *FreshStore {
+	f := &FreshStore{
+		ds:      ds,
+		window:  10 * time.Second,
+		written: map[string]written{},
+	}
+
+	for _, o := range opts {
+		o(f)
+	}
+
+	return f
+}
+
+// freshRevision is a remembered revision, read from memory.
+type freshRevision struct {
+	meta docstore.RevisionMetadata
+	*bytes.Reader
+}
+
+func (r *freshRevision) Metadata() docstore.RevisionMetadata {
+	return r.meta
+}
+
+func (w written) revision() docstore.Revision {
+	return &freshRevision{w.meta, bytes.NewReader(w.body)}
+}
+
+// recent returns the last revision of docId written within the window.
+func (f *FreshStore) recent(docId string) (written, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w, ok := f.written[docId]
+	if ok && time.Since(w.at) > f.window {
+		delete(f.written, docId)
+		return written{}, false
+	}
+	return w, ok
+}
+
+// remember keeps w unless a newer revision of its doc is remembered, and
+// forgets the revisions that have outlived the window.
+func (f *FreshStore) remember(w written) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for docId, old := range f.written {
+		if time.Since(old.at) > f.window {
+			delete(f.written, docId)
+		}
+	}
+	if old, ok := f.written[w.meta.DocId]; ok && old.meta.Id > w.meta.Id {
+		return
+	}
+	f.written[w.meta.DocId] = w
+}
+
+// GetDoc returns the latest revision of docId, or the one last written
+// through f if the store returns an older one or fails to find it.
+func (f *FreshStore) GetDoc(docId string) (rev docstore.Revision, err error) {
+	w, ok := f.recent(docId)
+	rev, err = f.ds.GetDoc(docId)
+	if !ok {
+		return
+	}
+	if err != nil || rev.Metadata().Id < w.meta.Id {
+		return w.revision(), nil
+	}
+	return rev, nil
+}
+
+func (f *FreshStore) GetRevision(docId string, revisionId int) (rev docstore.Revision, err error) {
+	// Revisions never change, so the one just written needn't be read.
+	if w, ok := f.recent(docId); ok && w.meta.Id == revisionId {
+		return w.revision(), nil
+	}
+	return f.ds.GetRevision(docId, revisionId)
+}
+
+// PutRevision writes through to the store and remembers the revision
+// written, for reads of docId until the window passes.
+func (f *FreshStore) PutRevision(docId string, body io.Reader) (rev docstore.Revision, err error) {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err = f.ds.PutRevision(docId, bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+
+	w := written{meta: rev.Metadata(), body: b, at: time.Now()}
+	f.remember(w)
+	return w.revision(), nil
+}
+
+func (f *FreshStore) ListDocs(token string) (page docstore.DocPage, err error) {
+	return f.ds.ListDocs(token)
+}
+
+func (f *FreshStore) ListRevisions(docId string, token string) (page docstore.RevisionPage, err error) {
+	return f.ds.ListRevisions(docId, token)
+}
@@ -0,0 +1,270 @@
+// Package fsstore is a docstore.DocStore backed by a directory, for
+// previewing a site locally. Each file in the directory is a doc with a
+// single revision, so a site can be edited in place. A doc with history is
+// a subdirectory instead, holding its revisions as files named 1, 2, 3 and
+// so on. Files and directories starting with "." are ignored.
+package fsstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drocamor/docstore"
+)
+
+// The docstore's errors are only told apart by message.
+var (
+	errDocNotFound      = fmt.Errorf("Doc not found.")
+	errRevisionNotFound = fmt.Errorf("Revision not found.")
+)
+
+type FsStore struct {
+	dir string
+
+	// mu serializes writes so revision numbers aren't handed out twice.
+	mu sync.Mutex
+}
+
+func New(dir string) *FsStore {
+	return &FsStore{dir: dir}
+}
+
+type revision struct {
+	meta docstore.RevisionMetadata
+	r    *bytes.Reader
+}
+
+func (r *revision) Metadata() docstore.RevisionMetadata {
+	return r.meta
+}
+
+func (r *revision) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (f *FsStore) path(docId string) (string, error) {
+	if docId == "" || strings.HasPrefix(docId, ".") || docstore.ValidateDocId(docId) != nil {
+		return "", errDocNotFound
+	}
+	return filepath.Join(f.dir, docId), nil
+}
+
+// revisions returns the revision numbers of the doc in directory p, in
+// order.
+func revisions(p string) ([]int, error) {
+	entries, err := ioutil.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, e := range entries {
+		if n, err := strconv.Atoi(e.Name()); err == nil && n > 0 && !e.IsDir() {
+			ids = append(ids, n)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func readRevision(docId string, id int, file string) (docstore.Revision, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision{
+		meta: docstore.RevisionMetadata{DocId: docId, Id: id, Timestamp: info.ModTime().UTC()},
+		r:    bytes.NewReader(b),
+	}, nil
+}
+
+func (f *FsStore) GetDoc(docId string) (docstore.Revision, error) {
+	p, err := f.path(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, errDocNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readRevision(docId, 1, p)
+	}
+
+	ids, err := revisions(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, errDocNotFound
+	}
+	latest := ids[len(ids)-1]
+	return readRevision(docId, latest, filepath.Join(p, strconv.Itoa(latest)))
+}
+
+func (f *FsStore) GetRevision(docId string, revisionId int) (docstore.Revision, error) {
+	p, err := f.path(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, errRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if revisionId != 1 {
+			return nil, errRevisionNotFound
+		}
+		return readRevision(docId, 1, p)
+	}
+
+	rev, err := readRevision(docId, revisionId, filepath.Join(p, strconv.Itoa(revisionId)))
+	if os.IsNotExist(err) {
+		return nil, errRevisionNotFound
+	}
+	return rev, err
+}
+
+// PutRevision writes a new doc as a plain file, and turns a plain file into
+// a directory of revisions when it is next written.
+func (f *FsStore) PutRevision(docId string, body io.Reader) (docstore.Revision, error) {
+	p, err := f.path(docId)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(p)
+	switch {
+	case os.IsNotExist(err):
+		err = ioutil.WriteFile(p, b, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return readRevision(docId, 1, p)
+	case err != nil:
+		return nil, err
+	case !info.IsDir():
+		err = f.toDir(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ids, err := revisions(p)
+	if err != nil {
+		return nil, err
+	}
+	next := 1
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+
+	file := filepath.Join(p, strconv.Itoa(next))
+	err = ioutil.WriteFile(file, b, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return readRevision(docId, next, file)
+}
+
+// toDir moves the plain file doc at p into a directory as revision 1.
+func (f *FsStore) toDir(p string) error {
+	tmp := filepath.Join(f.dir, fmt.Sprintf(".%s.%d", filepath.Base(p), time.Now().UnixNano()))
+	err := os.Rename(p, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(p, 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(p, "1"))
+}
+
+// ListDocs lists every doc in one page.
+func (f *FsStore) ListDocs(token string) (page docstore.DocPage, err error) {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || docstore.ValidateDocId(e.Name()) != nil {
+			continue
+		}
+
+		latest := 1
+		if e.IsDir() {
+			ids, err := revisions(filepath.Join(f.dir, e.Name()))
+			if err != nil || len(ids) == 0 {
+				continue
+			}
+			latest = ids[len(ids)-1]
+		}
+		page.Docs = append(page.Docs, docstore.Doc{Id: e.Name(), LatestRevision: latest})
+	}
+	return
+}
+
+// ListRevisions lists every revision of docId in one page.
+func (f *FsStore) ListRevisions(docId string, token string) (page docstore.RevisionPage, err error) {
+	p, err := f.path(docId)
+	if err != nil {
+		return
+	}
+
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return page, errDocNotFound
+	}
+	if err != nil {
+		return
+	}
+
+	if !info.IsDir() {
+		page.Revisions = []docstore.RevisionMetadata{{DocId: docId, Id: 1, Timestamp: info.ModTime().UTC()}}
+		return
+	}
+
+	ids, err := revisions(p)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		info, err := os.Stat(filepath.Join(p, strconv.Itoa(id)))
+		if err != nil {
+			return page, err
+		}
+		page.Revisions = append(page.Revisions, docstore.RevisionMetadata{DocId: docId, Id: id, Timestamp: info.ModTime().UTC()})
+	}
+	return
+}
@@ -1,11 +1,17 @@
 module github.com/drocamor/n22t.docstore
 
-go 1.15
+go 1.24.0
 
 require github.com/aws/aws-lambda-go v1.6.0
 
 require (
-	github.com/aws/aws-sdk-go v1.34.27 // indirect
+	connectrpc.com/connect v1.19.2
+	github.com/aws/aws-sdk-go v1.34.27
 	github.com/drocamor/docstore v0.0.1
 	github.com/gomarkdown/markdown v0.0.0-20200824053859-8c8b3816f167
+	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208
+	google.golang.org/protobuf v1.36.11
+	gopkg.in/yaml.v2 v2.4.0
 )
+
+require github.com/jmespath/go-jmespath v0.3.0 // indirect
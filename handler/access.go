@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// reader is a signed in reader of private docs.
+type reader struct {
+	Name   string
+	Groups []string
+
+	// session is the reader's session and issued when they signed in to
+	// it. See sessions.go.
+	session string
+	issued  time.Time
+}
+
+// requestReader returns the reader signed in on request, or nil for an
+// anonymous one. A Cognito or Lambda authorizer in front of the function
+// has already checked the reader; otherwise a bearer token is verified
+// against jwksURL.
+func requestReader(ctx context.Context, request events.APIGatewayProxyRequest) (*reader, error) {
+	auth := request.RequestContext.Authorizer
+	if _, ok := auth["claims"]; ok || auth["principalId"] != nil {
+		r := &reader{Name: principalName(request), Groups: principalGroups(request)}
+		if claims, ok := auth["claims"].(map[string]interface{}); ok {
+			r.session, r.issued = sessionClaims(claims)
+		}
+		return r, checkSession(ctx, request, r)
+	}
+
+	token := header(request, "Authorization")
+	if jwksURL == "" || !strings.HasPrefix(token, "Bearer ") {
+		return nil, nil
+	}
+	claims, err := verifyJWT(ctx, strings.TrimPrefix(token, "Bearer "))
+	if err != nil {
+		noteLogin(ctx, "bearer", bearerUser(strings.TrimPrefix(token, "Bearer ")), err)
+		return nil, err
+	}
+
+	r := &reader{}
+	for _, k := range []string{"email", "cognito:username", "username", "sub"} {
+		if v, ok := claims[k].(string); ok && v != "" {
+			r.Name = v
+			break
+		}
+	}
+	for _, k := range []string{"cognito:groups", "groups"} {
+		if gs, ok := claims[k].([]interface{}); ok {
+			for _, g := range gs {
+				if g, ok := g.(string); ok {
+					r.Groups = append(r.Groups, g)
+				}
+			}
+		}
+	}
+	r.session, r.issued = sessionClaims(claims)
+	err = checkSession(ctx, request, r)
+	noteLogin(ctx, "bearer", r.Name, err)
+	return r, err
+}
+
+// access returns who may read docId: whether it is private, and the
+// groups it is limited to, if any. The doc's front matter and the site
+// config's prefixes both count.
+func (c *siteConfig) access(docId string, fm frontMatter) (private bool, groups []string) {
+	private, groups = fm.Private, fm.Access
+	for _, p := range c.matchingPrefixes("/" + docId) {
+		private = private || p.Private
+		groups = append(groups, p.Access...)
+	}
+	return private || len(groups) > 0, groups
+}
+
+// checkAccess checks that the reader of request may read docId, going by
+// its latest revision, and reports whether it is private. Docs that don't
+// exist are left for the caller to report. The returned context carries
+// the fetched revision so serving the doc doesn't fetch it again.
+func checkAccess(ctx context.Context, request events.APIGatewayProxyRequest, docId string) (context.Context, bool, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	doc, err := fetchDoc(fetchCtx, docId)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return ctx, false, nil
+	}
+	if err != nil {
+		return ctx, false, err
+	}
+	ctx = withFetched(ctx, docId, doc)
+
+	fm, _ := splitFrontMatter(docId, doc.body)
+	private, groups := getConfig(ctx).access(docId, fm)
+	if !private {
+		return ctx, false, nil
+	}
+
+	op := "read " + docId
+	r, err := requestReader(ctx, request)
+	if err != nil {
+		return ctx, true, err
+	}
+	if r == nil {
+		return ctx, true, docerr.E(op, docerr.ErrUnauthorized, nil)
+	}
+	if len(groups) == 0 {
+		return ctx, true, nil
+	}
+	for _, g := range r.Groups {
+		for _, allowed := range groups {
+			if g == allowed {
+				return ctx, true, nil
+			}
+		}
+	}
+	return ctx, true, docerr.E(op, docerr.ErrForbidden, nil)
+}
+
+// privateHeaders keep shared caches from storing a private doc.
+var privateHeaders = map[string]string{
+	"Cache-Control": "private, no-cache",
+	"Vary":          "Authorization",
+}
+
+type fetchedKey struct{ docId string }
+
+// withFetched returns ctx carrying the latest revision of docId, which
+// fetchDoc returns for the rest of the request.
+func withFetched(ctx context.Context, docId string, doc fetchedDoc) context.Context {
+	return context.WithValue(ctx, fetchedKey{docId}, doc)
+}
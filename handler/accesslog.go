@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var (
+	// accessLogStream is a Kinesis Firehose delivery stream to send access
+	// log records to.
+	accessLogStream = os.Getenv("ACCESS_LOG_STREAM")
+
+	// accessLogBucket is an S3 bucket to write access log records to
+	// directly, one object per request under hourly partitions, when there
+	// is no delivery stream.
+	accessLogBucket = os.Getenv("ACCESS_LOG_BUCKET")
+
+	// accessLogTimeout bounds how long writing the record can delay the
+	// response.
+	accessLogTimeout = envDuration("ACCESS_LOG_TIMEOUT", time.Second)
+)
+
+// accessRecord is one access log record, written as a line of JSON for
+// querying with Athena.
+type accessRecord struct {
+	Time       string `json:"time"`
+	RequestId  string `json:"requestId"`
+	TraceId    string `json:"traceId,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Bytes      int    `json:"bytes"`
+	DurationMs int64  `json:"durationMs"`
+	Stale      bool   `json:"stale,omitempty"`
+	Referer    string `json:"referer,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	SourceIP   string `json:"sourceIp,omitempty"`
+}
+
+// withAccessLog writes an access log record for every request when a
+// stream or bucket is configured.
+func withAccessLog(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		if accessLogStream == "" && accessLogBucket == "" {
+			return next(ctx, request)
+		}
+
+		start := time.Now()
+		resp, err := next(ctx, request)
+
+		rec := accessRecord{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			RequestId:  request.RequestContext.RequestID,
+			Method:     request.HTTPMethod,
+			Path:       request.Path,
+			Status:     resp.StatusCode,
+			Bytes:      len(resp.Body),
+			DurationMs: time.Since(start).Milliseconds(),
+			Stale:      resp.Headers[staleHeader] != "",
+			Referer:    header(request, "Referer"),
+			UserAgent:  header(request, "User-Agent"),
+			SourceIP:   request.RequestContext.Identity.SourceIP,
+		}
+		if tc, ok := traceFrom(ctx); ok {
+			rec.TraceId = tc.traceId
+		}
+
+		writeAccessRecord(ctx, start, rec)
+		return resp, err
+	}
+}
+
+// writeAccessRecord sends rec to the delivery stream, or else to the
+// bucket.
+func writeAccessRecord(ctx context.Context, t time.Time, rec accessRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("access log error: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	ctx, cancel := context.WithTimeout(ctx, accessLogTimeout)
+	defer cancel()
+
+	if accessLogStream != "" {
+		_, err = firehose.New(awsSession()).PutRecordWithContext(ctx, &firehose.PutRecordInput{
+			DeliveryStreamName: aws.String(accessLogStream),
+			Record:             &firehose.Record{Data: b},
+		})
+	} else {
+		_, err = s3.New(awsSession()).PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(accessLogBucket),
+			Key:         aws.String(accessLogKey(t, rec.RequestId)),
+			Body:        bytes.NewReader(b),
+			ContentType: aws.String("application/json"),
+		})
+	}
+	if err != nil {
+		log.Printf("access log error: %v", err)
+	}
+}
+
+// accessLogKey places a record in Hive style hourly partitions, which
+// Athena can prune by time.
+func accessLogKey(t time.Time, requestId string) string {
+	t = t.UTC()
+	if requestId == "" {
+		requestId = randomHex(16)
+	}
+	return fmt.Sprintf("access/year=%04d/month=%02d/day=%02d/hour=%02d/%s.json",
+		t.Year(), t.Month(), t.Day(), t.Hour(), requestId)
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// annotationsTable is the DynamoDB table holding reviewers' comments
+	// on docs, keyed by DocId and Id. Annotations are off when it is
+	// unset.
+	annotationsTable = os.Getenv("ANNOTATIONS_TABLE")
+)
+
+// reviewersGroup is the authorizer group whose members may annotate docs,
+// along with holders of the write and admin API keys.
+const reviewersGroup = "reviewers"
+
+// annotation is a reviewer's comment on the text from byte Start to End of
+// a revision's source. Quote is that text, so a client can check it is
+// anchored where it meant.
+type annotation struct {
+	DocId   string    `json:"docId"`
+	Id      string    `json:"id"`
+	Version int       `json:"version"`
+	Start   int       `json:"start"`
+	End     int       `json:"end"`
+	Quote   string    `json:"quote"`
+	Comment string    `json:"comment"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+}
+
+// requireReviewer checks that request comes from a reviewer, returning a
+// name to attribute their comments to.
+func requireReviewer(ctx context.Context, request events.APIGatewayProxyRequest) (string, error) {
+	for _, g := range principalGroups(request) {
+		if g == reviewersGroup {
+			return principalName(request), nil
+		}
+	}
+
+	role, err := requireWriter(ctx, request)
+	if err != nil {
+		return "", docerr.E("review "+request.Path, docerr.ErrUnauthorized, nil)
+	}
+	if arn := iamPrincipal(ctx); arn != "" {
+		return arn, nil
+	}
+	return role, nil
+}
+
+// principalName returns the name of the principal an API Gateway
+// authorizer authenticated, from its claims or the Lambda authorizer's
+// principal id.
+func principalName(request events.APIGatewayProxyRequest) string {
+	auth := request.RequestContext.Authorizer
+	if claims, ok := auth["claims"].(map[string]interface{}); ok {
+		for _, k := range []string{"email", "cognito:username", "sub"} {
+			if v, ok := claims[k].(string); ok && v != "" {
+				return v
+			}
+		}
+	}
+	if v, ok := auth["principalId"].(string); ok && v != "" {
+		return v
+	}
+	return reviewersGroup
+}
+
+// getAnnotations returns the annotations on revision version of docId, in
+// the order they appear in it.
+func getAnnotations(ctx context.Context, docId string, version int) ([]annotation, error) {
+	var all []annotation
+	var scanErr error
+	err := dynamo().QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(annotationsTable),
+		KeyConditionExpression:    aws.String("DocId = :d"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":d": {S: aws.String(docId)}},
+	}, func(out *dynamodb.QueryOutput, last bool) bool {
+		var page []annotation
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &page)
+		all = append(all, page...)
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return nil, docerr.E("annotations "+docId, docerr.ErrBackend, err)
+	}
+
+	notes := []annotation{}
+	for _, a := range all {
+		if a.Version == version {
+			notes = append(notes, a)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].Start < notes[j].Start })
+	return notes, nil
+}
+
+// annotationVersion reads the version query parameter.
+func annotationVersion(request events.APIGatewayProxyRequest) (int, error) {
+	n, err := strconv.Atoi(request.QueryStringParameters["version"])
+	if err != nil {
+		return 0, docerr.WithDetails("annotations", docerr.ErrBadRequest, err,
+			map[string]interface{}{"version": "a revision number is required"})
+	}
+	return n, nil
+}
+
+// listAnnotations reports the annotations on the revision of the docId
+// path parameter given by the version query parameter.
+func listAnnotations(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if annotationsTable == "" {
+		return Response{}, docerr.E("annotations", docerr.ErrNotFound, nil)
+	}
+	_, err := requireReviewer(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	version, err := annotationVersion(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	notes, err := getAnnotations(ctx, request.PathParameters["docId"], version)
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, struct {
+		Annotations []annotation `json:"annotations"`
+	}{notes}), nil
+}
+
+// addAnnotation stores a comment on a range of a revision of the docId
+// path parameter: {"version": 3, "start": 120, "end": 164, "comment": "..."}.
+func addAnnotation(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if annotationsTable == "" {
+		return Response{}, docerr.E("annotations", docerr.ErrNotFound, nil)
+	}
+	author, err := requireReviewer(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	docId := request.PathParameters["docId"]
+	op := "annotate " + docId
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	var a annotation
+	err = json.Unmarshal(body, &a)
+	if err != nil || strings.TrimSpace(a.Comment) == "" {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"comment": "a comment is required"})
+	}
+
+	rev, err := fetchRevision(ctx, docId, a.Version)
+	if err != nil {
+		return Response{}, err
+	}
+	if a.Start < 0 || a.End <= a.Start || a.End > len(rev.body) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"start": a.Start, "end": a.End, "length": len(rev.body)})
+	}
+	quote := string(rev.body[a.Start:a.End])
+	if a.Quote != "" && a.Quote != quote {
+		return Response{}, docerr.WithDetails(op, docerr.ErrConflict, nil,
+			map[string]interface{}{"quote": quote})
+	}
+
+	a.DocId, a.Id, a.Quote = docId, randomHex(8), quote
+	a.Author, a.Created = author, time.Now().UTC()
+
+	item, err := dynamodbattribute.MarshalMap(a)
+	if err != nil {
+		return Response{}, err
+	}
+	_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(annotationsTable),
+		Item:      item,
+	})
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	return jsonResponse(201, a), nil
+}
+
+// deleteAnnotation removes the annotation with the id path parameter.
+func deleteAnnotation(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if annotationsTable == "" {
+		return Response{}, docerr.E("annotations", docerr.ErrNotFound, nil)
+	}
+	_, err := requireReviewer(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	key, err := dynamodbattribute.MarshalMap(struct{ DocId, Id string }{
+		request.PathParameters["docId"], request.PathParameters["id"],
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	_, err = dynamo().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(annotationsTable),
+		Key:       key,
+	})
+	if err != nil {
+		return Response{}, docerr.E("delete annotation", docerr.ErrBackend, err)
+	}
+	return Response{StatusCode: 204}, nil
+}
+
+// annotate marks each note's range in a revision's source and appends the
+// notes, for the review view. Ranges inside the front matter are skipped,
+// and ranges spanning lines get a reference mark at their end rather than
+// a highlight, which would break the markdown blocks they cross.
+func annotate(body []byte, notes []annotation) []byte {
+	_, rest, _ := frontMatterBlock(body)
+	skip := len(body) - len(rest)
+
+	var b strings.Builder
+	pos := 0
+	for i, a := range notes {
+		if a.Start < skip || a.Start < pos || a.End > len(body) {
+			continue
+		}
+		ref := fmt.Sprintf(`<sup class="annotation-ref"><a href="#note-%d">%d</a></sup>`, i+1, i+1)
+		text := string(body[a.Start:a.End])
+
+		b.Write(body[pos:a.Start])
+		if strings.Contains(text, "\n") {
+			b.WriteString(text + ref)
+		} else {
+			fmt.Fprintf(&b, `<mark class="annotation" id="mark-%d">%s</mark>%s`, i+1, text, ref)
+		}
+		pos = a.End
+	}
+	b.Write(body[pos:])
+
+	if len(notes) > 0 {
+		b.WriteString("\n\n<aside class=\"annotations\"><ol>\n")
+		for i, a := range notes {
+			fmt.Fprintf(&b, "<li id=\"note-%d\"><blockquote>%s</blockquote><p>%s</p><footer>%s, %s</footer></li>\n",
+				i+1, html.EscapeString(a.Quote), html.EscapeString(a.Comment),
+				html.EscapeString(a.Author), a.Created.Format(time.RFC3339))
+		}
+		b.WriteString("</ol></aside>\n")
+	}
+	return []byte(b.String())
+}
+
+// serveReview renders revision n of docId with its annotations as margin
+// notes, for reviewers.
+func serveReview(ctx context.Context, request events.APIGatewayProxyRequest, docId string, n int) (Response, error) {
+	if annotationsTable == "" {
+		return Response{}, docerr.E("review "+docId, docerr.ErrNotFound, nil)
+	}
+	_, err := requireReviewer(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	_, rev, err := fetchLatestAnd(ctx, docId, n)
+	if err != nil {
+		return Response{}, err
+	}
+	notes, err := getAnnotations(ctx, docId, n)
+	if err != nil {
+		return Response{}, err
+	}
+	rev.body = annotate(rev.body, notes)
+
+	resp, err := renderWith(ctx, docId, rev, tmplDocName, nil)
+	if err != nil {
+		return resp, err
+	}
+	return withHeaders(resp, map[string]string{
+		"Cache-Control": "private, no-store",
+		"X-Robots-Tag":  "noindex",
+	}), nil
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// apiV1 is the prefix of version 1 of the JSON API.
+	apiV1 = apiPrefix + "v1/"
+)
+
+// apiRoute maps a method and path pattern to a handler. Pattern segments
+// like {docId} match any single segment and are passed to the handler in
+// request.PathParameters.
+type apiRoute struct {
+	method  string
+	pattern string
+	admin   bool // Requires the admin API key, or a prefix admin for delegatedRoutes
+	handler handlerFunc
+}
+
+var apiRoutes = []apiRoute{
+	{"GET", apiV1 + "health", false, healthStatus},
+	{"GET", apiV1 + "status", false, statusJSON},
+	{"GET", apiV1 + "search", false, searchJSON},
+	{"GET", apiV1 + "suggest", false, suggestJSON},
+	{"POST", apiV1 + "ask", false, askQuestion},
+	{"GET", apiV1 + "sessions", false, listSessions},
+	{"DELETE", apiV1 + "sessions", false, deleteSessions},
+	{"DELETE", apiV1 + "sessions/{id}", false, deleteSession},
+	{"GET", apiV1 + "searches", false, listSavedSearches},
+	{"PUT", apiV1 + "searches/{name}", false, putSavedSearch},
+	{"DELETE", apiV1 + "searches/{name}", false, deleteSavedSearch},
+	{"GET", apiV1 + "searches/{name}/results", false, savedSearchResults},
+	{"POST", apiV1 + "beacon", false, sectionBeacon},
+	{"POST", apiV1 + "suggestions/{docId}", false, suggestEdit},
+	{"GET", apiV1 + "annotations/{docId}", false, listAnnotations},
+	{"POST", apiV1 + "annotations/{docId}", false, addAnnotation},
+	{"DELETE", apiV1 + "annotations/{docId}/{id}", false, deleteAnnotation},
+	{"GET", apiV1 + "admin/stats/sections", true, sectionStats},
+	{"GET", apiV1 + "admin/stats/missing", true, missingStats},
+	{"GET", apiV1 + "admin/stats/redirects", true, redirectStats},
+	{"GET", apiV1 + "admin/stats/duplicates", true, duplicateStats},
+	{"GET", apiV1 + "admin/stats/quality", true, qualityStats},
+	{"GET", apiV1 + "admin/stats/tenants", true, tenantStats},
+	{"GET", apiV1 + "admin/holds", true, listHolds},
+	{"GET", apiV1 + "admin/holds/{docId}", true, getHold},
+	{"PUT", apiV1 + "admin/holds/{docId}", true, placeHold},
+	{"DELETE", apiV1 + "admin/holds/{docId}", true, releaseHold},
+	{"GET", apiV1 + "labels/{docId}", false, listLabels},
+	{"GET", apiV1 + "docs/{docId}/revisions", false, listDocRevisions},
+	{"GET", apiV1 + "docs/{docId}/nav", false, getDocNav},
+	{"GET", apiV1 + "admin/sessions/{user}", true, listUserSessions},
+	{"DELETE", apiV1 + "admin/sessions/{user}", true, deleteUserSessions},
+	{"DELETE", apiV1 + "admin/sessions/{user}/{id}", true, deleteUserSession},
+	{"GET", apiV1 + "admin/pins", true, listPins},
+	{"POST", apiV1 + "admin/pins/{docId}/promote", true, promotePin},
+	{"PUT", apiV1 + "admin/labels/{docId}/{label}", true, putLabel},
+	{"DELETE", apiV1 + "admin/labels/{docId}/{label}", true, deleteLabel},
+	{"GET", apiV1 + "admin/suggestions", true, listSuggestions},
+	{"GET", apiV1 + "admin/suggestions/{id}", true, reviewSuggestion},
+	{"POST", apiV1 + "admin/suggestions/{id}/accept", true, idempotent(acceptSuggestion)},
+	{"POST", apiV1 + "admin/suggestions/{id}/reject", true, rejectSuggestion},
+	{"POST", apiV1 + "admin/compare/{docId}", true, compareDoc},
+	{"POST", apiV1 + "admin/smoketest", true, startSmokeTest},
+	{"GET", apiV1 + "admin/smoketest/{id}", true, smokeTestReport},
+	{"POST", apiV1 + "admin/consistency", true, startConsistencyCheck},
+	{"GET", apiV1 + "admin/consistency/{id}", true, consistencyReport},
+	{"GET", apiV1 + "admin/policies", true, policyReport},
+	{"POST", apiV1 + "admin/imports", true, startImport},
+	{"GET", apiV1 + "admin/imports/{id}", true, importReport},
+	{"POST", apiV1 + "admin/themes/{name}", true, installTheme},
+	{"POST", apiV1 + "admin/cache/flush", true, flushRenders},
+	{"GET", apiV1 + "admin/settings/{docId}", true, getSettings},
+	{"GET", apiV1 + "admin/template-context", true, templateContextReference},
+	{"PUT", apiV1 + "admin/settings/{docId}", true, idempotent(putSettings)},
+	{"PUT", apiV1 + "docs/{docId}", true, idempotent(putDoc)},
+	{"PATCH", apiV1 + "docs/{docId}", true, idempotent(patchDoc)},
+	{"POST", apiV1 + "docs/{docId}/entries", true, idempotent(appendLogEntry)},
+	{"GET", apiV1 + "docs/{docId}/drafts", true, listDrafts},
+	{"GET", apiV1 + "docs/{docId}/drafts/{name}", true, getDraft},
+	{"PUT", apiV1 + "docs/{docId}/drafts/{name}", true, idempotent(putDraft)},
+	{"DELETE", apiV1 + "docs/{docId}/drafts/{name}", true, discardDraft},
+	{"POST", apiV1 + "docs/{docId}/drafts/{name}/publish", true, idempotent(publishDraft)},
+	{"POST", apiV1 + "docs", true, idempotent(bulkPutDocs)},
+	{"POST", apiV1 + "assets", true, uploadAsset},
+	{"POST", apiV1 + "assets/uploads", true, startUpload},
+	{"POST", apiV1 + "assets/uploads/{id}/complete", true, completeUpload},
+}
+
+// match reports whether path fits pattern, returning the values of its
+// parameters.
+func match(pattern, path string) (params map[string]string, ok bool) {
+	ps, ss := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(ps) != len(ss) {
+		return nil, false
+	}
+
+	params = map[string]string{}
+	for i, p := range ps {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if ss[i] == "" {
+				return nil, false
+			}
+			params[p[1:len(p)-1]] = ss[i]
+		} else if p != ss[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// routeAPI dispatches requests under apiPrefix to the JSON API handlers.
+func routeAPI(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	pathMatched := false
+	for _, r := range apiRoutes {
+		params, ok := match(r.pattern, request.Path)
+		if !ok {
+			continue
+		}
+		pathMatched = true
+		if r.method != request.HTTPMethod {
+			continue
+		}
+		if writeRefused(r.method, r.pattern) {
+			return Response{}, docerr.E(request.HTTPMethod+" "+request.Path, docerr.ErrMethodNotAllowed, errMirrorReadOnly)
+		}
+		if immutableRefused(r.method, r.pattern) {
+			return Response{}, docerr.E(request.HTTPMethod+" "+request.Path, docerr.ErrForbidden, errImmutable)
+		}
+
+		if r.admin {
+			var err error
+			ctx, err = authorizeAdmin(ctx, request, r.pattern)
+			if err != nil {
+				return Response{}, err
+			}
+			if docId, ok := params["docId"]; ok {
+				err = requireAdministers(ctx, request.HTTPMethod+" "+request.Path, docId)
+				if err != nil {
+					return Response{}, err
+				}
+			}
+		}
+
+		request.PathParameters = params
+		return r.handler(ctx, request)
+	}
+
+	if pathMatched {
+		return Response{}, docerr.E(request.HTTPMethod+" "+request.Path, docerr.ErrMethodNotAllowed, nil)
+	}
+	return Response{}, docerr.E("route "+request.Path, docerr.ErrNotFound, nil)
+}
+
+// requestBody returns the body of request, decoding it if API Gateway
+// passed it base64 encoded.
+func requestBody(request events.APIGatewayProxyRequest) ([]byte, error) {
+	if !request.IsBase64Encoded {
+		return []byte(request.Body), nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(request.Body)
+	if err != nil {
+		return nil, docerr.E("decode body", docerr.ErrBadRequest, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// askSources is how many docs an answer may draw on, and
+	// askSourceText how much of each the model is given.
+	askSources    = 5
+	askSourceText = 4000
+
+	maxQuestion = 500
+)
+
+var (
+	// askProvider is the language model provider, "bedrock" or "openai",
+	// answering questions at /api/v1/ask with the ASK_MODEL model. Asking
+	// is off when it is unset.
+	askProvider = os.Getenv("ASK_PROVIDER")
+
+	askOnce  sync.Once
+	askModel languageModel
+
+	// citation matches a reference to a numbered source in an answer.
+	citation = regexp.MustCompile(`\[(\d+)\]`)
+
+	// questionWords are too common in questions to help find the docs
+	// answering them.
+	questionWords = map[string]bool{
+		"a": true, "an": true, "and": true, "are": true, "can": true, "do": true,
+		"does": true, "for": true, "how": true, "i": true, "in": true, "is": true,
+		"it": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+		"we": true, "what": true, "when": true, "where": true, "which": true,
+		"who": true, "why": true, "with": true,
+	}
+)
+
+// getAskModel returns the model answering questions, or nil if asking is
+// off.
+func getAskModel() languageModel {
+	askOnce.Do(func() {
+		if askProvider == "" {
+			return
+		}
+		lm, err := newLanguageModel(askProvider, os.Getenv("ASK_MODEL"))
+		if err != nil {
+			log.Printf("ignoring ASK_PROVIDER: %v", err)
+			return
+		}
+		askModel = lm
+	})
+	return askModel
+}
+
+// askSource is a doc an answer may cite, numbered as the model was given
+// it.
+type askSource struct {
+	N     int    `json:"n"`
+	DocId string `json:"docId"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Cited bool   `json:"cited"`
+
+	passage string
+}
+
+// askQuestion answers {"question": "..."} from the docs most relevant to
+// it, citing them. Only the catalog's docs, which are public and listed,
+// are drawn on.
+func askQuestion(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	op := "ask"
+	lm := getAskModel()
+	if lm == nil {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	var q struct {
+		Question string `json:"question"`
+	}
+	err = json.Unmarshal(body, &q)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+	q.Question = strings.TrimSpace(q.Question)
+	if q.Question == "" || len(q.Question) > maxQuestion {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"question": fmt.Sprintf("a question of at most %d bytes", maxQuestion)})
+	}
+
+	docs, err := getCatalog(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	sources := askSourcesFor(getConfig(ctx), docs, q.Question)
+
+	answer := "None of the docs seem to answer that."
+	if len(sources) > 0 {
+		answer, err = lm.Complete(ctx, askPrompt(q.Question, sources), 800)
+		if err != nil {
+			return Response{}, docerr.E(op, docerr.ErrBackend, err)
+		}
+		for _, m := range citation.FindAllStringSubmatch(answer, -1) {
+			if n, _ := strconv.Atoi(m[1]); n >= 1 && n <= len(sources) {
+				sources[n-1].Cited = true
+			}
+		}
+	}
+	if sources == nil {
+		sources = []askSource{}
+	}
+
+	resp := jsonResponse(200, struct {
+		Question string      `json:"question"`
+		Answer   string      `json:"answer"`
+		Sources  []askSource `json:"sources"`
+	}{q.Question, answer, sources})
+	return withHeaders(resp, map[string]string{"Cache-Control": "no-store"}), nil
+}
+
+// askSourcesFor picks the docs most likely to answer question: those
+// matching the most of its words, then those matching them most often,
+// counting matches in the title double.
+func askSourcesFor(cfg *siteConfig, docs catalog, question string) []askSource {
+	var terms []string
+	for _, t := range searchTerms(question) {
+		if !questionWords[t] && len(t) > 1 {
+			terms = append(terms, t)
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	re := termPattern(terms)
+
+	type ranked struct {
+		d             docSummary
+		spans         [][2]int
+		distinct, hit int
+	}
+	var matched []ranked
+	for _, d := range docs {
+		titleSpans := findTerms(re, d.Title)
+		spans := findTerms(re, d.text)
+		seen := map[string]bool{}
+		for _, s := range titleSpans {
+			seen[strings.ToLower(d.Title[s[0]:s[1]])] = true
+		}
+		for _, s := range spans {
+			seen[strings.ToLower(d.text[s[0]:s[1]])] = true
+		}
+		if len(seen) == 0 {
+			continue
+		}
+		matched = append(matched, ranked{d, spans, len(seen), 2*len(titleSpans) + len(spans)})
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].distinct != matched[j].distinct {
+			return matched[i].distinct > matched[j].distinct
+		}
+		return matched[i].hit > matched[j].hit
+	})
+	if len(matched) > askSources {
+		matched = matched[:askSources]
+	}
+
+	base := strings.TrimSuffix(cfg.BaseURL, "/")
+	sources := make([]askSource, len(matched))
+	for i, m := range matched {
+		title := m.d.Title
+		if title == "" {
+			title = m.d.DocId
+		}
+		sources[i] = askSource{
+			N:       i + 1,
+			DocId:   m.d.DocId,
+			Title:   title,
+			URL:     base + "/" + m.d.DocId,
+			passage: passage(m.d.text, m.spans, askSourceText),
+		}
+	}
+	return sources
+}
+
+// passage returns at most n bytes of text for a model to read: all of it
+// if it fits, or else the text around the first matches in spans.
+func passage(text string, spans [][2]int, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	const window = 400
+	var b strings.Builder
+	end := 0
+	for _, s := range spans {
+		if b.Len() >= n {
+			break
+		}
+		start := s[0] - window/2
+		if start < end {
+			start = end
+		}
+		if start < 0 {
+			start = 0
+		}
+		stop := start + window
+		if stop > len(text) {
+			stop = len(text)
+		}
+		if stop <= start {
+			continue
+		}
+		if b.Len() > 0 && start > end {
+			b.WriteString(" … ")
+		}
+		b.WriteString(strings.ToValidUTF8(text[start:stop], ""))
+		end = stop
+	}
+	if b.Len() == 0 {
+		return excerpt(text, n)
+	}
+	return b.String()
+}
+
+// askPrompt asks a model to answer question from sources alone, citing
+// them by number.
+func askPrompt(question string, sources []askSource) string {
+	var b strings.Builder
+	b.WriteString("Answer the question using only the numbered documents below. ")
+	b.WriteString("Cite the documents each part of the answer comes from like [1]. ")
+	b.WriteString("If they don't answer the question, say so rather than guessing.\n\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "[%d] %s\n%s\n\n", s.N, s.Title, s.passage)
+	}
+	fmt.Fprintf(&b, "Question: %s\n", question)
+	return b.String()
+}
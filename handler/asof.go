@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// maxAsOfCatalogs is how many catalogs of the site as it was a warm
+// container keeps. The past doesn't change, so they are kept until there
+// are too many.
+const maxAsOfCatalogs = 8
+
+var (
+	asOfCatalogs = &asOfCatalogCache{entries: map[time.Time]catalog{}}
+
+	// siteLink matches the links of a rendered page to other pages of the
+	// site, which carry a reader browsing the past along with them.
+	siteLink = regexp.MustCompile(`href="(/[a-z0-9_-]*)(\?[^"#]*)?(#[^"]*)?"`)
+)
+
+type asOfKey struct{}
+
+// withAsOf returns ctx resolving docs, templates and listings to the latest
+// revision at or before t, for browsing the site as it was then. The site
+// config and theme bundle are always current.
+func withAsOf(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, asOfKey{}, t)
+}
+
+// asOfFrom returns the instant ctx browses the site at, if any.
+func asOfFrom(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(asOfKey{}).(time.Time)
+	return t, ok
+}
+
+// parseAsOf parses the asOf query parameter: an RFC 3339 time, or a date
+// meaning its start in UTC.
+func parseAsOf(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", v)
+	}
+	if err != nil {
+		return time.Time{}, docerr.WithDetails("asOf "+v, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"asOf": "an RFC 3339 time, like 2023-06-01T00:00:00Z"})
+	}
+	return t.UTC(), nil
+}
+
+// fetchDocAt fetches the latest revision of docId made at or before t. A
+// doc made after t is not found.
+func fetchDocAt(ctx context.Context, docId string, t time.Time) (fetchedDoc, error) {
+	revs, err := listRevisions(ctx, docId)
+	if err != nil {
+		return fetchedDoc{}, err
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Id > revs[j].Id })
+	for _, rev := range revs {
+		if !rev.Timestamp.After(t) {
+			return fetchRevision(ctx, docId, rev.Id)
+		}
+	}
+	return fetchedDoc{}, docerr.E(fmt.Sprintf("%s as of %s", docId, t.Format(time.RFC3339)), docerr.ErrNotFound, nil)
+}
+
+type asOfCatalogCache struct {
+	sync.Mutex
+	entries map[time.Time]catalog
+}
+
+// catalogAt returns the catalog of the site as it was at t.
+func catalogAt(ctx context.Context, t time.Time) (catalog, error) {
+	asOfCatalogs.Lock()
+	c, ok := asOfCatalogs.entries[t]
+	asOfCatalogs.Unlock()
+	// A catalog of the future would miss docs yet to be written.
+	if ok && t.Before(time.Now()) {
+		return c, nil
+	}
+
+	summaries, err := buildCatalog(withAsOf(ctx, t), nil)
+	if err != nil {
+		return nil, err
+	}
+	c = assembleCatalog(ctx, summaries)
+
+	asOfCatalogs.Lock()
+	defer asOfCatalogs.Unlock()
+	if len(asOfCatalogs.entries) >= maxAsOfCatalogs {
+		for k := range asOfCatalogs.entries {
+			delete(asOfCatalogs.entries, k)
+			break
+		}
+	}
+	asOfCatalogs.entries[t] = c
+	return c, nil
+}
+
+// asOfFuncs are the template functions listing docs, listing them as they
+// were at t instead.
+func asOfFuncs(t time.Time) template.FuncMap {
+	docs := func(sel func(catalog) []docSummary) []docSummary {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		c, err := catalogAt(ctx, t)
+		if err != nil {
+			log.Printf("catalog as of %s: %v", t.Format(time.RFC3339), err)
+			return nil
+		}
+		return sel(c)
+	}
+	return template.FuncMap{
+		"allDocs":      func() []docSummary { return docs(func(c catalog) []docSummary { return c }) },
+		"pinnedDocs":   func() []docSummary { return docs(catalog.pinned) },
+		"featuredDocs": func() []docSummary { return docs(catalog.featured) },
+		"recentlyUpdated": func(n int) []docSummary {
+			return docs(func(c catalog) []docSummary { return c.recentlyUpdated(n) })
+		},
+	}
+}
+
+// asOfBanner describes a page showing the site as it was at a past
+// instant.
+type asOfBanner struct {
+	AsOf      string
+	LatestURL string
+}
+
+// defaultAsOfBanner is used unless the page template defines its own with
+// {{define "asOf"}}...{{end}}. Its link is quoted with single quotes, which
+// keepAsOf leaves alone, so that it leads back to the present.
+var defaultAsOfBanner = template.Must(template.New("asOf").Parse(
+	`<div class="as-of">You are viewing this site as it was on {{.AsOf}}. ` +
+		`<a href='{{.LatestURL}}'>View it as it is now</a>.</div>
+`))
+
+// pageAsOfBanner returns the banner for docId's page if ctx browses the
+// past, or nil.
+func pageAsOfBanner(ctx context.Context, docId string) *asOfBanner {
+	tf := renderFrom(ctx).tf
+	t, ok := asOfFrom(ctx)
+	if !ok {
+		return nil
+	}
+	b := &asOfBanner{AsOf: tf.format(t), LatestURL: "/" + docId}
+	if docId == indexDocName {
+		b.LatestURL = "/"
+	}
+	return b
+}
+
+// asOfBannerHTML renders the banner with the page template's "asOf"
+// definition if it has one.
+func asOfBannerHTML(tmpl *template.Template, b *asOfBanner) (string, error) {
+	t := tmpl.Lookup("asOf")
+	if t == nil {
+		t = defaultAsOfBanner
+	}
+
+	var s strings.Builder
+	err := t.Execute(&s, b)
+	return s.String(), err
+}
+
+// keepAsOf adds the asOf parameter for t to the links in page to other
+// pages of the site, so that following them stays in the past. Only links
+// quoted with double quotes, as rendered markdown's are, are changed.
+func keepAsOf(page string, t time.Time) string {
+	param := "asOf=" + url.QueryEscape(t.Format(time.RFC3339))
+	return siteLink.ReplaceAllStringFunc(page, func(link string) string {
+		m := siteLink.FindStringSubmatch(link)
+		query := "?" + param
+		if m[2] != "" {
+			query = m[2] + "&amp;" + param
+		}
+		return `href="` + m[1] + query + m[3] + `"`
+	})
+}
+
+// templateAt parses the template stored as name as it was at t, with its
+// listings of docs as they were then too. A template made since t is used
+// as it is now.
+func templateAt(ctx context.Context, name string, t time.Time) (*template.Template, error) {
+	tmplDoc, err := fetchDoc(ctx, name)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return getTemplate(context.WithValue(ctx, asOfKey{}, nil), name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New("docPage").Funcs(templateFuncs).Funcs(asOfFuncs(t)).Parse(string(tmplDoc.body))
+	if err != nil {
+		return nil, docerr.E("parse "+name, docerr.ErrTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// serveAsOf renders docId as it was at the instant ctx browses the site at.
+// Renders of the past aren't kept in the render cache, which is for the
+// latest revisions.
+func serveAsOf(ctx context.Context, docId string) (Response, error) {
+	doc, err := fetchDoc(ctx, docId)
+	if errors.Is(err, docerr.ErrNotFound) && docId == indexDocName {
+		return generatedIndex(ctx)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+	return renderRevision(ctx, docId, doc)
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/blobstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// assetsPrefix is where store assets are served from.
+	assetsPrefix = "/assets/"
+
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+var (
+	// assetTag matches link and script elements, and assetAttr the
+	// attribute in them that references another resource.
+	assetTag  = regexp.MustCompile(`(?i)<(?:link|script)\b[^>]*>`)
+	assetAttr = regexp.MustCompile(`(?i)\b(href|src)="(/(?:assets/)?[.a-z0-9_-]+\.(?:css|js))"`)
+
+	assets = &assetCache{entries: map[string]assetInfo{}}
+)
+
+// assetInfo is what a page needs to reference a store asset safely.
+type assetInfo struct {
+	revision  int
+	integrity string
+	fetched   time.Time
+}
+
+type assetCache struct {
+	sync.Mutex
+	entries map[string]assetInfo
+}
+
+// getAsset returns the latest revision and integrity hash of the asset
+// docId, caching them for configTTL.
+func getAsset(ctx context.Context, docId string) (assetInfo, error) {
+	key := docId + variantSuffix(ctx)
+	assets.Lock()
+	cached, ok := assets.entries[key]
+	assets.Unlock()
+	if ok && time.Since(cached.fetched) < configTTL {
+		return cached, nil
+	}
+
+	doc, err := themeDoc(ctx, docId)
+	if err != nil {
+		return assetInfo{}, err
+	}
+
+	sum := sha512.Sum384(doc.body)
+	info := assetInfo{
+		revision:  doc.meta.Id,
+		integrity: "sha384-" + base64.StdEncoding.EncodeToString(sum[:]),
+		fetched:   time.Now(),
+	}
+
+	assets.Lock()
+	assets.entries[key] = info
+	assets.Unlock()
+	return info, nil
+}
+
+// assetURL is the revision stamped URL of a store asset, which changes
+// whenever the asset does so it can be cached forever.
+func assetURL(docId string, revision int) string {
+	return fmt.Sprintf("%s%s?v=%d", assetsPrefix, docId, revision)
+}
+
+// stampAssets adds subresource integrity hashes to the link and script
+// elements in page that reference CSS and JS in the store, and points them
+// at revision stamped URLs. References to assets that can't be fetched are
+// left alone.
+func stampAssets(ctx context.Context, page string) string {
+	return assetTag.ReplaceAllStringFunc(page, func(tag string) string {
+		if strings.Contains(strings.ToLower(tag), "integrity=") {
+			return tag
+		}
+
+		m := assetAttr.FindStringSubmatchIndex(tag)
+		if m == nil {
+			return tag
+		}
+		attr, path := tag[m[2]:m[3]], tag[m[4]:m[5]]
+		docId := path[strings.LastIndex(path, "/")+1:]
+		// Stored assets never change, so need no stamping.
+		if _, ok := blobstore.ParseName(docId); ok {
+			return tag
+		}
+
+		info, err := getAsset(ctx, docId)
+		if err != nil {
+			log.Printf("not stamping %s: %v", path, err)
+			return tag
+		}
+
+		stamped := fmt.Sprintf(`%s="%s" integrity="%s"`, attr, assetURL(docId, info.revision), info.integrity)
+		return tag[:m[0]] + stamped + tag[m[1]:]
+	})
+}
+
+// serveAsset serves a store asset. With a v query parameter it serves
+// exactly that revision, which never changes, with a long lived immutable
+// Cache-Control; otherwise it serves the latest revision and asks caches
+// to revalidate. Paths naming a directory of assets, like /assets/ or
+// /assets/icons-, list the assets in it.
+func serveAsset(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := strings.TrimPrefix(request.Path, assetsPrefix)
+	if hash, ok := blobstore.ParseName(docId); ok {
+		return serveBlob(ctx, docId, hash)
+	}
+	if isListingDir(docId) && docstore.ValidateDocId(docId) == nil {
+		return serveListing(ctx, request.Path, docId, true)
+	}
+	err := docstore.ValidateDocId(docId)
+	if err != nil || isPage(docId) || strings.HasPrefix(docId, "_") {
+		return Response{}, docerr.E("asset "+docId, docerr.ErrNotFound, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	var doc fetchedDoc
+	cacheControl := "no-cache"
+	if v, ok := request.QueryStringParameters["v"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Response{}, docerr.E("asset "+docId, docerr.ErrBadRequest, err)
+		}
+		doc, err = fetchRevision(ctx, docId, n)
+		if err != nil {
+			return Response{}, err
+		}
+		cacheControl = immutableCacheControl
+	} else {
+		doc, err = themeDoc(ctx, docId)
+		if err != nil {
+			return Response{}, err
+		}
+	}
+
+	resp := rawResponse(docId, doc.body)
+	resp.Headers["Cache-Control"] = cacheControl
+	return withHeaders(resp, validators(doc.meta.Id, doc.meta.Timestamp, resp.Body)), nil
+}
+
+// rawContentType returns the content type of a raw doc from its extension,
+// or else by sniffing body.
+func rawContentType(docId string, body []byte) string {
+	if ct := mime.TypeByExtension(path.Ext(docId)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(body)
+}
+
+// isText reports whether contentType is text, which API Gateway can pass
+// through as it is, rather than binary, which it must be sent base64
+// encoded.
+func isText(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/json" || mediaType == "application/xml" ||
+		mediaType == "application/javascript"
+}
+
+// rawResponse serves a doc with an extension, like an image or stylesheet,
+// as it was stored.
+func rawResponse(docId string, body []byte) Response {
+	contentType := rawContentType(docId, body)
+	resp := Response{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": contentType,
+		},
+	}
+	if isText(contentType) {
+		resp.Body = string(body)
+	} else {
+		resp.Body, resp.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	}
+	return resp
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Docs can hold content for some readers only:
+//
+//	:::only audience=internal
+//	Page the on-call engineer in #ops.
+//	:::
+//
+//	:::except audience=internal
+//	Contact support.
+//	:::
+//
+// A reader's audiences are those the site config gives every reader of the
+// deployment, plus the groups of the principal the API Gateway authorizer
+// authenticated, if any.
+var audienceDirective = regexp.MustCompile(`^:::\s*(only|except)\s+audience\s*=\s*(\S+)\s*$`)
+
+// audiences is the set of audiences a page is rendered for.
+type audiences map[string]bool
+
+// key identifies the set in cache and coalescing keys, since pages rendered
+// for different audiences differ.
+func (a audiences) key() string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// any reports whether a includes any of the comma separated audiences in
+// list.
+func (a audiences) any(list string) bool {
+	for _, name := range strings.Split(list, ",") {
+		if a[strings.TrimSpace(name)] {
+			return true
+		}
+	}
+	return false
+}
+
+type audienceKey struct{}
+
+// withAudiences returns ctx carrying the reader's audiences.
+func withAudiences(ctx context.Context, a audiences) context.Context {
+	return context.WithValue(ctx, audienceKey{}, a)
+}
+
+func audiencesFrom(ctx context.Context) audiences {
+	a, _ := ctx.Value(audienceKey{}).(audiences)
+	return a
+}
+
+// detach returns a context for work that outlives the request, carrying the
+// request's values that affect rendering but none of its deadline.
+func detach(ctx context.Context) context.Context {
+	d := withAudiences(context.Background(), audiencesFrom(ctx))
+	d = withVariant(d, variantFrom(ctx))
+	d = withRender(d, renderFrom(ctx))
+	if dbg := debugFrom(ctx); dbg != nil {
+		d = context.WithValue(d, debugKey{}, dbg)
+	}
+	return withRecentlyViewed(d, recentlyViewedFrom(ctx))
+}
+
+// requestAudiences works out the audiences of the reader of request.
+func requestAudiences(cfg *siteConfig, request events.APIGatewayProxyRequest) audiences {
+	a := audiences{}
+	for _, name := range cfg.Audiences {
+		a[name] = true
+	}
+
+	for _, g := range principalGroups(request) {
+		a[g] = true
+	}
+	return a
+}
+
+// principalGroups returns the groups of the principal an API Gateway
+// authorizer authenticated: the "cognito:groups" claim from a Cognito
+// authorizer, or a "groups" value from a Lambda authorizer. Either may be
+// a comma or space separated list, optionally in brackets.
+func principalGroups(request events.APIGatewayProxyRequest) []string {
+	auth := request.RequestContext.Authorizer
+	if auth == nil {
+		return nil
+	}
+
+	var raw string
+	if claims, ok := auth["claims"].(map[string]interface{}); ok {
+		raw, _ = claims["cognito:groups"].(string)
+	}
+	if raw == "" {
+		raw, _ = auth["groups"].(string)
+	}
+
+	raw = strings.Trim(raw, "[]")
+	return strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// filterAudience drops the blocks of doc that aren't for a, reporting
+// whether doc had any audience blocks. Fenced code is left alone so docs
+// can show the syntax.
+func filterAudience(doc []byte, a audiences) ([]byte, bool) {
+	if !strings.Contains(string(doc), ":::") {
+		return doc, false
+	}
+
+	var b strings.Builder
+	fenced, found := false, false
+	skipping, inBlock := false, false
+	for _, line := range strings.SplitAfter(string(doc), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+
+		if !fenced {
+			if m := audienceDirective.FindStringSubmatch(trimmed); m != nil && !inBlock {
+				inBlock, found = true, true
+				skipping = a.any(m[2]) != (m[1] == "only")
+				continue
+			}
+			if trimmed == ":::" && inBlock {
+				inBlock, skipping = false, false
+				continue
+			}
+		}
+
+		if !skipping {
+			b.WriteString(line)
+		}
+	}
+	return []byte(b.String()), found
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// audioSuffix follows /docs/{docId} for the doc read aloud.
+	audioSuffix = "/audio"
+
+	// pollyChunk is how much text goes in each request to Polly, which
+	// takes at most 3000 characters at a time, and maxAudioText how much
+	// of a doc is read at all.
+	pollyChunk   = 2800
+	maxAudioText = 100000
+
+	// audioURLTTL is how long a link to a recording lasts.
+	audioURLTTL = 15 * time.Minute
+)
+
+var (
+	// audioBucket is the S3 bucket recordings of docs are kept in, one per
+	// revision. Audio is off when it is unset.
+	audioBucket = os.Getenv("AUDIO_BUCKET")
+
+	// audioVoice and audioEngine are the Polly voice docs are read in and
+	// the engine, "standard" or "neural", reading them.
+	audioVoice  = envString("AUDIO_VOICE", "Joanna")
+	audioEngine = envString("AUDIO_ENGINE", "standard")
+)
+
+// audioURL is where the recording of docId is served, for templates' audio
+// players, or "" if audio is off.
+func audioURL(docId string) string {
+	if audioBucket == "" {
+		return ""
+	}
+	return docsPrefix + docId + audioSuffix
+}
+
+// serveAudio answers /docs/{docId}/audio with a redirect to an MP3 of the
+// latest revision of docId read aloud. The first request for a revision
+// has Polly record it, which takes a few seconds for a long doc; later
+// ones reuse the recording.
+func serveAudio(ctx context.Context, docId string) (Response, error) {
+	op := "audio " + docId
+	if audioBucket == "" || !isPage(docId) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	doc, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	_, body := splitFrontMatter(docId, doc.body)
+	body = substituteVariables(ctx, docId, body)
+	body, personalized := filterAudience(body, audiencesFrom(ctx))
+	text := docText(body)
+	if len(text) > maxAudioText {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"length": len(text), "max": maxAudioText})
+	}
+
+	// Pages differing by audience get a recording per audience.
+	key := fmt.Sprintf("audio/%s/%d.mp3", docId, doc.meta.Id)
+	if personalized {
+		sum := sha256.Sum256([]byte(audiencesFrom(ctx).key()))
+		key = fmt.Sprintf("audio/%s/%d-%s.mp3", docId, doc.meta.Id, hex.EncodeToString(sum[:8]))
+	}
+
+	client := s3.New(awsSession())
+	ch := flights.DoChan("audio:"+key, func() (interface{}, error) {
+		ctx := context.Background()
+		_, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(audioBucket),
+			Key:    aws.String(key),
+		})
+		var aerr awserr.RequestFailure
+		if err == nil || !errors.As(err, &aerr) || aerr.StatusCode() != 404 {
+			return nil, err
+		}
+
+		mp3, err := synthesize(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		_, err = client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(audioBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(mp3),
+			ContentType: aws.String("audio/mpeg"),
+		})
+		return nil, err
+	})
+	_, err = await(ctx, op, ch)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	req, _ := client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(audioBucket),
+		Key:    aws.String(key),
+	})
+	link, err := req.Presign(audioURLTTL)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	return Response{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"Location":      link,
+			"Cache-Control": fmt.Sprintf("private, max-age=%d", int(audioURLTTL.Seconds())/2),
+		},
+	}, nil
+}
+
+// synthesize reads text aloud with Polly, a chunk at a time, and joins the
+// chunks' MP3 frames into one recording.
+func synthesize(ctx context.Context, text string) ([]byte, error) {
+	chunks := audioChunks(text, pollyChunk)
+	parts := make([][]byte, len(chunks))
+	errs := make([]error, len(chunks))
+
+	client := polly.New(awsSession())
+	sem := make(chan struct{}, 4)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, chunk string) {
+			defer func() { <-sem; wg.Done() }()
+			out, err := client.SynthesizeSpeechWithContext(ctx, &polly.SynthesizeSpeechInput{
+				Engine:       aws.String(audioEngine),
+				OutputFormat: aws.String(polly.OutputFormatMp3),
+				Text:         aws.String(chunk),
+				VoiceId:      aws.String(audioVoice),
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer out.AudioStream.Close()
+			parts[i], errs[i] = ioutil.ReadAll(out.AudioStream)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bytes.Join(parts, nil), nil
+}
+
+// audioChunks splits text into pieces of at most n bytes, between
+// sentences where it can and between words otherwise.
+func audioChunks(text string, n int) []string {
+	var chunks []string
+	var cur strings.Builder
+	for _, word := range strings.Fields(text) {
+		if cur.Len() > 0 && cur.Len()+1+len(word) > n {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+		}
+		if cur.Len() > 0 {
+			cur.WriteByte(' ')
+		}
+		cur.WriteString(word)
+
+		// Past two thirds of a chunk, end it at a sentence.
+		if cur.Len() > n*2/3 && strings.ContainsAny(word[len(word)-1:], ".!?") {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+		}
+	}
+	if cur.Len() > 0 {
+		chunks = append(chunks, cur.String())
+	}
+	return chunks
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// auditRecord is logged as JSON for every administrative action, so the
+// trail can be searched with CloudWatch Logs Insights, e.g.
+//
+//	filter audit | sort time desc
+type auditRecord struct {
+	Audit     bool                   `json:"audit"`
+	Time      time.Time              `json:"time"`
+	Action    string                 `json:"action"`
+	DocId     string                 `json:"docId,omitempty"`
+	RequestId string                 `json:"requestId"`
+	SourceIP  string                 `json:"sourceIp,omitempty"`
+	Principal string                 `json:"principal,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// audit records that request performed action on docId.
+func audit(request events.APIGatewayProxyRequest, action, docId string, details map[string]interface{}) {
+	b, err := json.Marshal(auditRecord{
+		Audit:     true,
+		Time:      time.Now().UTC(),
+		Action:    action,
+		DocId:     docId,
+		RequestId: request.RequestContext.RequestID,
+		SourceIP:  request.RequestContext.Identity.SourceIP,
+		Principal: request.RequestContext.Identity.UserArn,
+		Details:   details,
+	})
+	if err != nil {
+		log.Printf("audit error: %v", err)
+		return
+	}
+	log.Print(string(b))
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// adminAPIKey must be sent in the X-Api-Key header to use the admin
+	// API. The admin API is disabled when it is unset. It may be a secret
+	// reference.
+	adminAPIKey = envSecret("ADMIN_API_KEY")
+
+	// writeAPIKey lets clients write docs at /docs/{docId} without the
+	// admin API's other powers. It may be a secret reference.
+	writeAPIKey = envSecret("WRITE_API_KEY")
+)
+
+// requireAdmin checks that request carries the admin API key.
+func requireAdmin(request events.APIGatewayProxyRequest) error {
+	key := header(request, "X-Api-Key")
+	if adminAPIKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminAPIKey)) != 1 {
+		return docerr.E("admin "+request.Path, docerr.ErrUnauthorized, nil)
+	}
+	return nil
+}
+
+// requireWriter checks that request carries the write API key or the
+// admin API key, or was signed by an IAM principal granted a role,
+// returning the role the key grants.
+func requireWriter(ctx context.Context, request events.APIGatewayProxyRequest) (string, error) {
+	if role, signed, err := iamRole(ctx, "write "+request.Path); signed {
+		return role, err
+	}
+
+	key := header(request, "X-Api-Key")
+	if writeAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(writeAPIKey)) == 1 {
+		noteLogin(ctx, "api-key", "", nil)
+		return roleWriter, nil
+	}
+	err := requireAdmin(request)
+	if key != "" {
+		noteLogin(ctx, "api-key", "", err)
+	}
+	return roleAdmin, err
+}
+
+// Roles of API clients, which quotas are set for.
+const (
+	roleAdmin     = "admin"
+	roleWriter    = "writer"
+	roleAnonymous = "anonymous"
+)
+
+type roleKey struct{}
+
+// withRole returns ctx carrying the client's role.
+func withRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// roleFrom returns the client's role carried by ctx, if any.
+func roleFrom(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey{}).(string)
+	return role
+}
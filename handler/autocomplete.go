@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	defaultSuggestLimit = 8
+	maxSuggestLimit     = 25
+)
+
+// titleSuggestion is a doc offered as a reader types its title.
+type titleSuggestion struct {
+	DocId string `json:"docId"`
+	Title string `json:"title"`
+
+	rank int
+}
+
+// suggestTitles returns the listed docs whose titles or docIds match what
+// a reader has typed so far, q, best first: those starting with q, then
+// those with a word starting with each word of q, then those a typo or so
+// away from that. The last word of q may be unfinished.
+func suggestTitles(ctx context.Context, q string, limit int) ([]titleSuggestion, error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	c, err := getCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []titleSuggestion
+	for _, d := range c {
+		words := append(searchTerms(d.Title), searchTerms(d.DocId)...)
+		rank := -1
+		switch {
+		case strings.HasPrefix(strings.ToLower(d.Title), q) || strings.HasPrefix(d.DocId, q):
+			rank = 0
+		case prefixWords(terms, words, 0):
+			rank = 1
+		case prefixWords(terms, words, 1):
+			rank = 2
+		}
+		if rank >= 0 {
+			found = append(found, titleSuggestion{d.DocId, d.Title, rank})
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].rank != found[j].rank {
+			return found[i].rank < found[j].rank
+		}
+		if len(found[i].Title) != len(found[j].Title) {
+			return len(found[i].Title) < len(found[j].Title)
+		}
+		return found[i].DocId < found[j].DocId
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// prefixWords reports whether each of terms begins one of words. With
+// typos, a term may be off from a word's beginning by up to its typo
+// allowance, and at most typos edits.
+func prefixWords(terms, words []string, typos int) bool {
+	for _, t := range terms {
+		allowed := typoAllowance(t)
+		if allowed > typos {
+			allowed = typos
+		}
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+			if allowed > 0 {
+				rw, n := []rune(w), len([]rune(t))
+				if n > len(rw) {
+					n = len(rw)
+				}
+				if editDistance(t, string(rw[:n])) <= allowed {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// suggestJSON answers /api/v1/suggest?q=, for type-ahead in search boxes
+// and link pickers. limit caps the suggestions, 8 by default.
+func suggestJSON(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	q := request.QueryStringParameters["q"]
+	limit, err := strconv.Atoi(request.QueryStringParameters["limit"])
+	switch {
+	case err != nil || limit <= 0:
+		limit = defaultSuggestLimit
+	case limit > maxSuggestLimit:
+		limit = maxSuggestLimit
+	}
+
+	found, err := suggestTitles(ctx, q, limit)
+	if err != nil {
+		return Response{}, docerr.FromStore("suggest", err)
+	}
+	if found == nil {
+		found = []titleSuggestion{}
+	}
+	resp := jsonResponse(200, struct {
+		Query       string            `json:"query"`
+		Suggestions []titleSuggestion `json:"suggestions"`
+	}{q, found})
+	// Each keystroke asks again, so let browsers and the CDN answer
+	// repeats for a little while.
+	return withHeaders(resp, map[string]string{"Cache-Control": "public, max-age=60"}), nil
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	awsOnce sync.Once
+	awsSess *session.Session
+)
+
+// awsSession returns the shared AWS session, created on first use.
+func awsSession() *session.Session {
+	awsOnce.Do(func() {
+		awsSess = session.Must(session.NewSession())
+		awsSess.Handlers.Build.PushBack(propagateTrace)
+	})
+	return awsSess
+}
+
+func dynamo() *dynamodb.DynamoDB {
+	return dynamodb.New(awsSession())
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const badgePrefix = "/badge/"
+
+var (
+	// Docs updated within freshDays are fresh, and those not updated for
+	// staleDays are stale.
+	freshDays = envFloat("BADGE_FRESH_DAYS", 30)
+	staleDays = envFloat("BADGE_STALE_DAYS", 180)
+)
+
+// badge is a label and message, in the shape of a shields.io endpoint
+// response: https://shields.io/endpoint
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// docBadge describes field of a doc: its front matter "status" or "owner",
+// or its "freshness", how long since it was updated.
+func docBadge(field string, fm frontMatter, updated, now time.Time) (badge, bool) {
+	b := badge{SchemaVersion: 1, Label: field, Color: "blue"}
+
+	switch field {
+	case "status":
+		b.Message = fm.Status
+	case "owner":
+		b.Message = fm.Owner
+	case "freshness":
+		days := now.Sub(updated).Hours() / 24
+		b.Label, b.Message, b.Color = "updated", ago(updated, now), "green"
+		switch {
+		case days >= staleDays:
+			b.Color = "red"
+		case days >= freshDays:
+			b.Color = "yellow"
+		}
+	default:
+		return b, false
+	}
+
+	if b.Message == "" {
+		b.Message, b.Color = "unknown", "lightgrey"
+	}
+	return b, true
+}
+
+// badgeColors maps shields.io color names to their hex values.
+var badgeColors = map[string]string{
+	"blue":      "#007ec6",
+	"green":     "#4c1",
+	"yellow":    "#dfb317",
+	"red":       "#e05d44",
+	"lightgrey": "#9f9f9f",
+}
+
+// svg draws b as a flat badge. Text widths are estimated, which is close
+// enough for short labels.
+func (b badge) svg() string {
+	lw, mw := 6*len(b.Label)+10, 6*len(b.Message)+10
+	label, message := html.EscapeString(b.Label), html.EscapeString(b.Message)
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<title>%[4]s: %[5]s</title>`+
+		`<rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="14">%[4]s</text><text x="%[8]d" y="14">%[5]s</text></g></svg>`,
+		lw+mw, lw, mw, label, message, badgeColors[b.Color], lw/2, lw+mw/2)
+}
+
+// serveBadge answers /badge/{docId}?field=status with an SVG badge, or
+// with the shields.io endpoint JSON for format=json.
+func serveBadge(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := strings.TrimPrefix(request.Path, badgePrefix)
+	op := "badge " + docId
+	if !isPage(docId) || docstore.ValidateDocId(docId) != nil {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	doc, err := fetchDoc(ctx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	fm, _ := splitFrontMatter(docId, doc.body)
+	if private, _ := getConfig(ctx).access(docId, fm); !fm.listed() || private {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	field := request.QueryStringParameters["field"]
+	if field == "" {
+		field = "freshness"
+	}
+	b, ok := docBadge(field, fm, doc.meta.Timestamp, time.Now())
+	if !ok {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"field": field})
+	}
+
+	// Badges are embedded in other sites' pages and should follow changes
+	// to the doc soon.
+	headers := map[string]string{"Cache-Control": "public, max-age=300"}
+	if request.QueryStringParameters["format"] == "json" {
+		return withHeaders(jsonResponse(200, b), headers), nil
+	}
+
+	headers["Content-Type"] = "image/svg+xml"
+	return Response{StatusCode: 200, Body: b.svg(), Headers: headers}, nil
+}
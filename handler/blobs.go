@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/n22t.docstore/blobstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// assetBucket is an S3 bucket that uploads to POST /api/v1/assets are
+	// kept in by content hash, so identical images are stored once.
+	// Uploading is off when it is unset.
+	assetBucket = os.Getenv("ASSET_BUCKET")
+
+	validAssetExt = regexp.MustCompile(`^\.[a-z0-9]+$`)
+)
+
+func blobs() *blobstore.Store {
+	return blobstore.New(s3.New(awsSession()), assetBucket)
+}
+
+// getAssetRefs returns the references to stored assets.
+func getAssetRefs(ctx context.Context) (blobstore.Refs, error) {
+	flights.Forget("doc:" + blobstore.RefsDocName)
+	doc, err := fetchDoc(ctx, blobstore.RefsDocName)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return blobstore.Refs{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := blobstore.ParseRefs(doc.body)
+	if err != nil {
+		return nil, docerr.E("parse "+blobstore.RefsDocName, docerr.ErrBackend, err)
+	}
+	return refs, nil
+}
+
+func putAssetRefs(refs blobstore.Refs) error {
+	b, err := refs.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(blobstore.RefsDocName, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+blobstore.RefsDocName, err)
+	}
+	return nil
+}
+
+// noteAssetRefs counts the stored assets the revision of docId just
+// written links to. Counting is best effort: a write isn't failed for it,
+// and the maintenance job only collects assets unreferenced for a while.
+func noteAssetRefs(ctx context.Context, docId string, body []byte) {
+	if assetBucket == "" || docId == blobstore.RefsDocName {
+		return
+	}
+
+	refs, err := getAssetRefs(ctx)
+	if err == nil && refs.Update(docId, blobstore.Referenced(body), time.Now()) {
+		err = putAssetRefs(refs)
+	}
+	if err != nil {
+		log.Printf("counting asset references from %s: %v", docId, err)
+	}
+}
+
+// uploadAsset stores the request body by its content hash, answering with
+// the URL it is served at. The name query parameter, like logo.png, gives
+// its type. Uploading what is already stored stores nothing more.
+func uploadAsset(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	name := request.QueryStringParameters["name"]
+	op := "upload " + name
+	if assetBucket == "" {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if !validAssetExt.MatchString(ext) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"name": "a file name with an extension, like logo.png"})
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(body) == 0 {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"body": "the asset is required"})
+	}
+	err = checkMalware(ctx, name, body)
+	if err != nil {
+		return Response{}, err
+	}
+
+	hash, existed, err := blobs().Put(ctx, body, rawContentType(name, body))
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+	return storedAsset(ctx, hash, ext, len(body), existed)
+}
+
+// storedAsset notes the asset just stored with hash, answering with the
+// URL it is served at and how many docs link to it already.
+func storedAsset(ctx context.Context, hash, ext string, size int, existed bool) (Response, error) {
+	refs, err := getAssetRefs(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	if refs.Stored(hash, time.Now()) {
+		err = putAssetRefs(refs)
+		if err != nil {
+			return Response{}, err
+		}
+	}
+
+	status := 201
+	if existed {
+		status = 200
+	}
+	return jsonResponse(status, struct {
+		URL        string `json:"url"`
+		Hash       string `json:"hash"`
+		Size       int    `json:"size"`
+		Existed    bool   `json:"existed"`
+		References int    `json:"references"`
+	}{assetsPrefix + blobstore.Name(hash, ext), hash, size, existed, refs.Count(hash)}), nil
+}
+
+// serveBlob serves the stored asset with hash. Its content never changes,
+// so caches may keep it forever.
+func serveBlob(ctx context.Context, name, hash string) (Response, error) {
+	op := "asset " + name
+	if assetBucket == "" {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	body, err := blobs().Get(ctx, hash)
+	if blobstore.IsNotFound(err) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, err)
+	}
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	resp := rawResponse(name, body)
+	resp.Headers["Cache-Control"] = immutableCacheControl
+	return withHeaders(resp, map[string]string{"ETag": `"` + hash + `"`}), nil
+}
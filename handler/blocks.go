@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"text/template"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// blocksDocPrefix starts the name of a doc's companion doc overriding
+// blocks of its template, like "_blocks-launch" for "launch":
+//
+//	{{define "hero"}}
+//	<section class="hero"><h1>{{.Title}}</h1></section>
+//	{{end}}
+//
+// Smaller overrides can go in the doc's front matter instead:
+//
+//	---
+//	blocks:
+//	  hero: <section class="hero"><h1>{{.Title}}</h1></section>
+//	---
+//
+// Only blocks the template defines, with {{block}} or {{define}}, are
+// overridden, so landing pages can change parts of the site's template
+// without a template of their own. The companion doc takes precedence
+// over the front matter.
+const blocksDocPrefix = "_blocks-"
+
+// docBlocks returns the template text of docId's block overrides, from
+// its front matter and then its companion doc.
+func docBlocks(ctx context.Context, docId string, fm frontMatter) []string {
+	var texts []string
+	if len(fm.Blocks) > 0 {
+		var names []string
+		for name := range fm.Blocks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		text := ""
+		for _, name := range names {
+			text += fmt.Sprintf("{{define %q}}%s{{end}}", name, fm.Blocks[name])
+		}
+		texts = append(texts, text)
+	}
+
+	doc, err := fetchDoc(ctx, blocksDocPrefix+docId)
+	switch {
+	case err == nil:
+		texts = append(texts, string(doc.body))
+	case !errors.Is(err, docerr.ErrNotFound):
+		log.Printf("blocks of %s: %v", docId, err)
+	}
+	return texts
+}
+
+// parseBlocks parses block overrides, each text replacing the blocks the
+// ones before it define.
+func parseBlocks(texts []string) (*template.Template, error) {
+	blocks := template.New("blocks").Funcs(templateFuncs)
+	for _, text := range texts {
+		if _, err := blocks.Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// withBlocks returns a copy of tmpl with the blocks it defines that texts
+// override replaced. tmpl is returned as it is if the overrides don't
+// parse, which lintDoc stops them doing when saved.
+func withBlocks(tmplName string, tmpl *template.Template, texts []string) *template.Template {
+	blocks, err := parseBlocks(texts)
+	if err != nil {
+		log.Printf("blocks for %s: %v", tmplName, err)
+		return tmpl
+	}
+	merged, err := tmpl.Clone()
+	if err != nil {
+		log.Printf("blocks for %s: %v", tmplName, err)
+		return tmpl
+	}
+	for _, b := range blocks.Templates() {
+		if b == blocks || b.Tree == nil || tmpl.Lookup(b.Name()) == nil {
+			continue
+		}
+		if _, err := merged.AddParseTree(b.Name(), b.Tree); err != nil {
+			log.Printf("block %s for %s: %v", b.Name(), tmplName, err)
+			return tmpl
+		}
+	}
+	return merged
+}
+
+// lintBlocks checks a doc's front matter blocks parse.
+func lintBlocks(fm frontMatter) []string {
+	var problems []string
+	for name, text := range fm.Blocks {
+		if _, err := template.New(name).Funcs(templateFuncs).Parse(text); err != nil {
+			problems = append(problems, "front matter: blocks: "+err.Error())
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
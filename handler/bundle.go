@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/docstore"
+)
+
+var (
+	// themeBundleURL is a theme bundle written by "docctl bundle": a file
+	// shipped in the deployment package, or an s3://bucket/key. Templates
+	// and stylesheets in it are read from it instead of the store, which
+	// saves a round trip per doc on a cold start. Changes to those docs in
+	// the store take effect once the bundle is rebuilt and redeployed.
+	themeBundleURL = os.Getenv("THEME_BUNDLE")
+
+	// themeBundle holds the bundled docs by docId. It is loaded once, in
+	// init, and only read after that.
+	themeBundle map[string]fetchedDoc
+)
+
+// bundleManifest lists the docs in a theme bundle, in the layout of
+// docctl export's manifest.
+type bundleManifest struct {
+	Exported time.Time `json:"exported"`
+	Docs     []struct {
+		DocId     string    `json:"docId"`
+		Revision  int       `json:"revision"`
+		Timestamp time.Time `json:"timestamp"`
+		SHA256    string    `json:"sha256"`
+		Path      string    `json:"path"`
+	} `json:"docs"`
+}
+
+// loadThemeBundle loads themeBundleURL. A bundle that can't be loaded is
+// logged and the store is used instead.
+func loadThemeBundle() {
+	if themeBundleURL == "" {
+		return
+	}
+
+	start := time.Now()
+	docs, err := readThemeBundle(themeBundleURL)
+	if err != nil {
+		log.Printf("not using theme bundle %s: %v", themeBundleURL, err)
+		return
+	}
+	themeBundle = docs
+	log.Printf("loaded %d docs from theme bundle %s in %v", len(docs), themeBundleURL, time.Since(start))
+}
+
+func readThemeBundle(url string) (map[string]fetchedDoc, error) {
+	revs, err := readArchive(url)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := map[string]fetchedDoc{}
+	for _, rev := range revs {
+		if cur, ok := docs[rev.meta.DocId]; !ok || cur.meta.Id < rev.meta.Id {
+			docs[rev.meta.DocId] = rev
+		}
+	}
+	return docs, nil
+}
+
+// readArchive reads every revision in an archive in the snapshot layout,
+// a file or an s3://bucket/key, checking each against the manifest.
+func readArchive(url string) ([]fetchedDoc, error) {
+	var b []byte
+	var err error
+	if strings.HasPrefix(url, "s3://") {
+		b, err = fetchThemeBundle(url)
+	} else {
+		b, err = ioutil.ReadFile(url)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name], err = ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var m bundleManifest
+	err = json.Unmarshal(files["manifest.json"], &m)
+	if err != nil {
+		return nil, fmt.Errorf("manifest.json: %v", err)
+	}
+
+	var revs []fetchedDoc
+	for _, e := range m.Docs {
+		body, ok := files[e.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing", e.Path)
+		}
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != e.SHA256 {
+			return nil, fmt.Errorf("%s doesn't match its hash", e.Path)
+		}
+		revs = append(revs, fetchedDoc{
+			meta: docstore.RevisionMetadata{DocId: e.DocId, Id: e.Revision, Timestamp: e.Timestamp},
+			body: body,
+		})
+	}
+	return revs, nil
+}
+
+func fetchThemeBundle(url string) ([]byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(url, "s3://"), "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("want s3://bucket/key")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	obj, err := s3.New(awsSession()).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(parts[0]),
+		Key:    aws.String(parts[1]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	return ioutil.ReadAll(obj.Body)
+}
+
+// themeDoc returns the revision served of a template or stylesheet: from
+// the theme bundle if it is bundled, and otherwise from the store, at the
+// revision it is pinned to or else its latest.
+func themeDoc(ctx context.Context, docId string) (fetchedDoc, error) {
+	if doc, ok := themeBundle[docId]; ok {
+		return doc, nil
+	}
+	n, ok, err := pinnedVersion(ctx, docId)
+	if err != nil {
+		return fetchedDoc{}, err
+	}
+	if ok {
+		return fetchRevision(ctx, docId, n)
+	}
+	return fetchDoc(ctx, docId)
+}
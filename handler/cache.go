@@ -0,0 +1,388 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// staleHeader is set on responses served from the render cache because
+	// the docstore was too slow or failing.
+	staleHeader = "X-Docstore-Stale"
+
+	// rendererVersion is bumped by changes to rendering that change the
+	// pages rendered, and is part of every render's key.
+	rendererVersion = 1
+
+	// rendersDocName records when an admin last flushed the render cache,
+	// so that every warm container stops serving what it rendered before.
+	rendersDocName = "_renders"
+)
+
+var (
+	// swrTimeout is how long a request waits on the docstore before
+	// falling back to the last good render of the doc.
+	swrTimeout = envDuration("SWR_TIMEOUT", 3*time.Second)
+
+	// renderTTL is how long a render is served without rendering the doc
+	// again while the revisions it was rendered from stay the latest. It
+	// bounds how long what a render's key doesn't cover, like the "updated
+	// ago" of the page, goes unrefreshed. Renders are only served when the
+	// docstore fails if it is 0.
+	renderTTL = envDuration("RENDER_TTL", time.Minute)
+
+	// renderFlushTTL is how long a warm container goes by what it read of
+	// the last flush of the render cache.
+	renderFlushTTL = envDuration("RENDER_FLUSH_TTL", 10*time.Second)
+
+	renders = &renderCache{entries: map[string]renderEntry{}}
+	flushes = &flushCache{}
+)
+
+// renderCache holds the last successful render of each doc, for each time
+// format and audience it was rendered for, for the life of the warm Lambda
+// container.
+type renderCache struct {
+	sync.Mutex
+	entries map[string]renderEntry
+}
+
+// renderEntry is a render and what it was rendered from. key composes the
+// renderer version with the revisions of the doc, the templates and the
+// config, and the generation of the catalog, that it used.
+type renderEntry struct {
+	resp     Response
+	inputs   []renderInput
+	key      string
+	rendered time.Time
+}
+
+func (c *renderCache) get(key string) (e renderEntry, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok = c.entries[key]
+	return
+}
+
+func (c *renderCache) put(key string, e renderEntry) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = e
+}
+
+func (c *renderCache) flush() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = map[string]renderEntry{}
+}
+
+// renderInput is a versioned input of a render: a doc, template or config
+// at the revision used, with 0 for a doc that wasn't found, or the catalog
+// at the generation used.
+type renderInput struct {
+	use, docId string
+	version    int
+}
+
+// renderInputs collects the inputs of a render. A render that failed to
+// fetch a doc is untracked, and never served as fresh.
+type renderInputs struct {
+	sync.Mutex
+	inputs    map[string]renderInput
+	untracked bool
+}
+
+// renderedPage is a render with its inputs, as shared by coalesced
+// callers.
+type renderedPage struct {
+	resp   Response
+	inputs *renderInputs
+}
+
+type renderInputsKey struct{}
+
+// withRenderInputs returns ctx collecting the inputs of what is rendered
+// with it.
+func withRenderInputs(ctx context.Context) (context.Context, *renderInputs) {
+	in := &renderInputs{inputs: map[string]renderInput{}}
+	return context.WithValue(ctx, renderInputsKey{}, in), in
+}
+
+// noteInput records that what is rendered with ctx used the version of
+// docId as its use, like "template".
+func noteInput(ctx context.Context, use, docId string, version int) {
+	if in, ok := ctx.Value(renderInputsKey{}).(*renderInputs); ok {
+		in.Lock()
+		in.inputs[use+" "+docId] = renderInput{use, docId, version}
+		in.Unlock()
+	}
+}
+
+// noteFetch records the outcome of fetching docId as an input.
+func noteFetch(ctx context.Context, docId string, doc fetchedDoc, err error) {
+	switch {
+	case err == nil:
+		noteInput(ctx, "doc", docId, doc.meta.Id)
+	case errors.Is(err, docerr.ErrNotFound):
+		noteInput(ctx, "doc", docId, 0)
+	default:
+		if in, ok := ctx.Value(renderInputsKey{}).(*renderInputs); ok {
+			in.Lock()
+			in.untracked = true
+			in.Unlock()
+		}
+	}
+}
+
+// noteInputs records from's inputs as those of what is rendered with ctx.
+func noteInputs(ctx context.Context, from *renderInputs) {
+	in, ok := ctx.Value(renderInputsKey{}).(*renderInputs)
+	if !ok || in == from {
+		return
+	}
+	from.Lock()
+	defer from.Unlock()
+	in.Lock()
+	defer in.Unlock()
+	for k, v := range from.inputs {
+		in.inputs[k] = v
+	}
+	in.untracked = in.untracked || from.untracked
+}
+
+// list returns the inputs in a stable order, or false if they are
+// untracked.
+func (in *renderInputs) list() ([]renderInput, bool) {
+	in.Lock()
+	defer in.Unlock()
+	if in.untracked {
+		return nil, false
+	}
+	inputs := make([]renderInput, 0, len(in.inputs))
+	for _, v := range in.inputs {
+		inputs = append(inputs, v)
+	}
+	sort.Slice(inputs, func(i, j int) bool {
+		if inputs[i].use != inputs[j].use {
+			return inputs[i].use < inputs[j].use
+		}
+		return inputs[i].docId < inputs[j].docId
+	})
+	return inputs, true
+}
+
+// renderKey composes the renderer version and the versions of inputs.
+func renderKey(inputs []renderInput) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "renderer@%d", rendererVersion)
+	for _, in := range inputs {
+		fmt.Fprintf(&b, "|%s %s@%d", in.use, in.docId, in.version)
+	}
+	return b.String()
+}
+
+// currentVersion returns the version of in that a render would use now.
+func currentVersion(ctx context.Context, in renderInput) (int, error) {
+	pctx, probe := withRenderInputs(ctx)
+	var err error
+	switch in.use {
+	case "doc":
+		_, err = fetchDoc(pctx, in.docId)
+		if errors.Is(err, docerr.ErrNotFound) {
+			return 0, nil
+		}
+	case "template":
+		_, err = getTemplate(pctx, in.docId)
+	case "config":
+		getConfig(pctx)
+	case "catalog":
+		_, err = getCatalog(pctx)
+	default:
+		return 0, fmt.Errorf("unknown render input %q", in.use)
+	}
+	if err != nil {
+		return 0, err
+	}
+	probe.Lock()
+	defer probe.Unlock()
+	v, ok := probe.inputs[in.use+" "+in.docId]
+	if !ok {
+		return 0, fmt.Errorf("no version of %s %s", in.use, in.docId)
+	}
+	return v.version, nil
+}
+
+// fresh reports whether e can be served without rendering its doc again:
+// it is younger than renderTTL and the last flush, and its key is the one
+// a render would have now.
+func (e renderEntry) fresh(ctx context.Context) bool {
+	if e.key == "" || time.Since(e.rendered) >= renderTTL || !e.rendered.After(flushedAt(ctx)) {
+		return false
+	}
+	current := make([]renderInput, len(e.inputs))
+	for i, in := range e.inputs {
+		v, err := currentVersion(ctx, in)
+		if err != nil {
+			return false
+		}
+		current[i] = renderInput{in.use, in.docId, v}
+	}
+	return renderKey(current) == e.key
+}
+
+// flushCache holds when the render cache was last flushed, as read from
+// rendersDocName.
+type flushCache struct {
+	sync.Mutex
+	flushed time.Time
+	checked time.Time
+}
+
+// renderFlush is the content of rendersDocName.
+type renderFlush struct {
+	Flushed time.Time `yaml:"flushed" json:"flushed"`
+}
+
+// flushedAt returns when the render cache was last flushed, or the zero
+// time if it never was.
+func flushedAt(ctx context.Context) time.Time {
+	flushes.Lock()
+	defer flushes.Unlock()
+	if time.Since(flushes.checked) < renderFlushTTL {
+		return flushes.flushed
+	}
+
+	flights.Forget("doc:" + rendersDocName)
+	doc, err := fetchDoc(ctx, rendersDocName)
+	if errors.Is(err, docerr.ErrNotFound) {
+		flushes.checked = time.Now()
+		return flushes.flushed
+	}
+	var f renderFlush
+	if err == nil {
+		err = yaml.Unmarshal(doc.body, &f)
+	}
+	if err != nil {
+		log.Printf("reading %s: %v", rendersDocName, err)
+		return flushes.flushed
+	}
+	flushes.flushed, flushes.checked = f.Flushed, time.Now()
+	return flushes.flushed
+}
+
+// flushRenders empties the render cache of every warm container: this
+// one at once, along with its templates and config, and the rest once
+// they read rendersDocName. Writes make renders stale on their own; this
+// is for changes the renders' keys don't cover.
+func flushRenders(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	f := renderFlush{Flushed: time.Now().UTC()}
+	b, err := yaml.Marshal(f)
+	if err != nil {
+		return Response{}, err
+	}
+	_, err = ds.PutRevision(rendersDocName, bytes.NewReader(b))
+	if err != nil {
+		return Response{}, docerr.FromStore("PutRevision "+rendersDocName, err)
+	}
+
+	renders.flush()
+	templates.Lock()
+	templates.entries = map[string]cachedTemplate{}
+	templates.Unlock()
+	configs.Lock()
+	configs.fetched = time.Time{}
+	configs.Unlock()
+	flushes.Lock()
+	flushes.flushed, flushes.checked = f.Flushed, time.Now()
+	flushes.Unlock()
+
+	audit(request, "renders.flush", "", nil)
+	return jsonResponse(200, f), nil
+}
+
+type renderResult struct {
+	resp Response
+	err  error
+}
+
+// stale returns a copy of resp flagged as stale.
+func stale(resp Response) Response {
+	return withHeaders(resp, map[string]string{staleHeader: "1"})
+}
+
+// serveDoc renders docId, serving the last render instead while it is
+// fresh, or when the docstore errors or takes longer than swrTimeout.
+// The render runs detached from ctx, bounded only by its phase timeouts,
+// so a slow render keeps going and refreshes the cache when it finishes,
+// either in the background or when the container is next thawed.
+func serveDoc(ctx context.Context, docId string) (Response, error) {
+	// A home page listing the docs its reader viewed last is only theirs,
+	// from a cookie they could make up, so it isn't kept.
+	if len(recentlyViewedFrom(ctx)) > 0 {
+		return renderDoc(ctx, docId)
+	}
+
+	key := docId + varies(ctx)
+
+	cached, ok := renders.get(key)
+	if ok {
+		checkCtx, cancel := context.WithTimeout(ctx, swrTimeout)
+		fresh := cached.fresh(checkCtx)
+		cancel()
+		if fresh {
+			noteCache(ctx, "render", "hit")
+			return cached.resp, nil
+		}
+	}
+
+	done := make(chan renderResult, 1)
+	go func() {
+		rctx, in := withRenderInputs(detach(ctx))
+		resp, err := renderDoc(rctx, docId)
+		if err == nil && resp.StatusCode == 200 {
+			e := renderEntry{resp: resp, rendered: time.Now()}
+			if inputs, ok := in.list(); ok {
+				e.inputs, e.key = inputs, renderKey(inputs)
+			}
+			renders.put(key, e)
+		}
+		done <- renderResult{resp, err}
+	}()
+
+	if !ok {
+		noteCache(ctx, "render", "miss")
+		select {
+		case r := <-done:
+			return r.resp, r.err
+		case <-ctx.Done():
+			return Response{}, docerr.E("render "+docId, docerr.ErrTimeout, ctx.Err())
+		}
+	}
+
+	select {
+	case r := <-done:
+		if r.err == nil && r.resp.StatusCode == 200 {
+			noteCache(ctx, "render", "refreshed")
+			return r.resp, nil
+		}
+		log.Printf("serving stale %s: status %d, err %v", docId, r.resp.StatusCode, r.err)
+	case <-time.After(swrTimeout):
+		log.Printf("serving stale %s: docstore slower than %v", docId, swrTimeout)
+	case <-ctx.Done():
+		log.Printf("serving stale %s: %v", docId, ctx.Err())
+	}
+
+	noteCache(ctx, "render", "stale")
+	return stale(cached.resp), nil
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+const (
+	// canaryCookie keeps a reader on the variant they were first served,
+	// for canaryCookieAge seconds, so pages don't flip between them.
+	canaryCookie    = "canary"
+	canaryCookieAge = 24 * 60 * 60
+
+	variantStable = "stable"
+	variantCanary = "canary"
+)
+
+type variantKey struct{}
+
+// withVariant returns ctx serving pinned docs as variant: the promoted
+// revisions for "stable", the latest for "canary".
+func withVariant(ctx context.Context, variant string) context.Context {
+	return context.WithValue(ctx, variantKey{}, variant)
+}
+
+// variantFrom returns the variant ctx serves, or "" when no canary is
+// running.
+func variantFrom(ctx context.Context) string {
+	v, _ := ctx.Value(variantKey{}).(string)
+	return v
+}
+
+// servesCanary reports whether ctx serves pinned templates and theme docs
+// at their latest revisions rather than their promoted ones.
+func servesCanary(ctx context.Context) bool {
+	return variantFrom(ctx) == variantCanary
+}
+
+// variantSuffix distinguishes the canary's entries in caches shared with
+// stable readers.
+func variantSuffix(ctx context.Context) string {
+	if servesCanary(ctx) {
+		return "@" + variantCanary
+	}
+	return ""
+}
+
+// withCanary splits page requests between the stable and canary variants
+// while pins.canary, a percentage, is set. A reader keeps their variant
+// from canaryCookie; new readers are assigned at random. Each variant's
+// requests and errors are counted separately, as VariantRequests and
+// VariantErrors, so the canary can be compared with stable before it is
+// promoted. Pages differ by variant, so caches mustn't share them.
+func withCanary(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		percent := getConfig(ctx).Pins.Canary
+		if percent <= 0 || strings.HasPrefix(request.Path, apiPrefix) {
+			return next(ctx, request)
+		}
+
+		variant, assigned := cookie(request, canaryCookie), false
+		if variant != variantStable && variant != variantCanary {
+			variant, assigned = variantStable, true
+			if rand.Float64()*100 < float64(percent) {
+				variant = variantCanary
+			}
+		}
+
+		resp, err := next(withVariant(ctx, variant), request)
+		if err != nil {
+			return resp, err
+		}
+
+		dims := map[string]string{"Variant": variant}
+		metrics.Incr("VariantRequests", dims)
+		if resp.StatusCode >= 500 || resp.Headers[degradedHeader] != "" {
+			metrics.Incr("VariantErrors", dims)
+		}
+
+		resp = withDefaultHeaders(resp, map[string]string{"Cache-Control": "private"})
+		if assigned {
+			resp = withHeaders(resp, map[string]string{
+				"Set-Cookie": fmt.Sprintf("%s=%s; Path=/; Max-Age=%d; SameSite=Lax", canaryCookie, variant, canaryCookieAge),
+			})
+		}
+		return resp, nil
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// canonicalURL returns the URL search engines should index docId at: the
+// canonical URL its front matter names, for a doc mirrored from another
+// site that is authoritative for it, or else its own under the site's
+// base URL. It is "" if the site has no base URL and the doc names none.
+func canonicalURL(cfg *siteConfig, docId string, fm frontMatter) string {
+	if fm.Canonical != "" {
+		return fm.Canonical
+	}
+	if cfg.BaseURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(cfg.BaseURL, "/") + "/" + docId
+}
+
+// canonicalLink returns a link element for the head naming u as the page's
+// canonical URL, or "" if there is none.
+func canonicalLink(u string) string {
+	if u == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<link rel="canonical" href="%s">`, html.EscapeString(u))
+}
+
+// lintCanonical checks that a doc's canonical URL is an absolute http or
+// https URL, which is all search engines follow.
+func lintCanonical(fm frontMatter) []string {
+	if fm.Canonical == "" {
+		return nil
+	}
+	u, err := url.Parse(fm.Canonical)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		strings.ContainsAny(fm.Canonical, "<>\"\r\n") {
+		return []string{fmt.Sprintf("front matter: canonical: %q isn't an absolute http or https URL", fm.Canonical)}
+	}
+	return nil
+}
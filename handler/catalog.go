@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/storelist"
+)
+
+var (
+	// catalogTTL is how long a warm container reuses the catalog before
+	// listing the store again. Only docs whose latest revision has changed
+	// since are fetched.
+	catalogTTL = envDuration("CATALOG_TTL", 5*time.Minute)
+
+	// catalogRebuildInterval is how often the catalog is instead rebuilt
+	// from every doc, reconciling anything the incremental updates missed.
+	catalogRebuildInterval = envDuration("CATALOG_REBUILD_INTERVAL", time.Hour)
+
+	// catalogWorkers bounds how many docs are fetched at once while
+	// building the catalog.
+	catalogWorkers = 8
+
+	catalogs = &catalogCache{}
+)
+
+// docSummary describes a doc for listings.
+type docSummary struct {
+	DocId     string
+	Title     string
+	Version   int
+	Timestamp time.Time
+
+	Pinned, Featured bool
+
+	// Canonical is the authoritative URL of a doc mirrored from another
+	// site, for listings like feeds and sitemaps to link to instead, or
+	// "" for the site's own docs.
+	Canonical string
+
+	fm frontMatter
+
+	// text is the doc's text without markup, for search.
+	text string
+}
+
+// catalog summarises every listed doc, pinned docs first and then the most
+// recently updated.
+type catalog []docSummary
+
+func (c catalog) pinned() (docs []docSummary) {
+	for _, d := range c {
+		if d.Pinned {
+			docs = append(docs, d)
+		}
+	}
+	return
+}
+
+func (c catalog) featured() (docs []docSummary) {
+	for _, d := range c {
+		if d.Featured {
+			docs = append(docs, d)
+		}
+	}
+	return
+}
+
+type catalogCache struct {
+	sync.Mutex
+	docs catalog
+
+	// summaries holds every page by docId, listed or not, so that the
+	// catalog can be updated from the pages that changed.
+	summaries map[string]docSummary
+
+	// changed is set when a write has updated summaries since docs was
+	// built.
+	changed bool
+
+	// generation counts the catalogs built, for the renders listing docs
+	// to tell when theirs is out of date.
+	generation int
+
+	fetched, rebuilt time.Time
+}
+
+// getCatalog returns the catalog, or that of the site as it was if ctx
+// browses the past, updating it when the cached copy is
+// older than catalogTTL and rebuilding it every catalogRebuildInterval.
+// If it can't be updated the last good one is kept.
+func getCatalog(ctx context.Context) (catalog, error) {
+	if t, ok := asOfFrom(ctx); ok {
+		return catalogAt(ctx, t)
+	}
+
+	catalogs.Lock()
+	defer catalogs.Unlock()
+	defer func() { noteInput(ctx, "catalog", "", catalogs.generation) }()
+
+	if catalogs.docs != nil && time.Since(catalogs.fetched) < catalogTTL {
+		if catalogs.changed {
+			catalogs.docs, catalogs.changed = assembleCatalog(ctx, catalogs.summaries), false
+			catalogs.generation++
+		}
+		return catalogs.docs, nil
+	}
+
+	var prev map[string]docSummary
+	rebuild := catalogs.summaries == nil || time.Since(catalogs.rebuilt) >= catalogRebuildInterval
+	if !rebuild {
+		prev = catalogs.summaries
+	}
+
+	summaries, err := buildCatalog(ctx, prev)
+	if err != nil {
+		if catalogs.docs != nil {
+			log.Printf("catalog error, using last good catalog: %v", err)
+			return catalogs.docs, nil
+		}
+		return nil, err
+	}
+
+	catalogs.summaries, catalogs.fetched = summaries, time.Now()
+	if rebuild {
+		catalogs.rebuilt = catalogs.fetched
+	}
+	catalogs.docs, catalogs.changed = assembleCatalog(ctx, summaries), false
+	catalogs.generation++
+	return catalogs.docs, nil
+}
+
+// allPages returns the summaries of every page, listed or not, for
+// reports on the whole store.
+func allPages(ctx context.Context) ([]docSummary, error) {
+	_, err := getCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogs.Lock()
+	defer catalogs.Unlock()
+	pages := make([]docSummary, 0, len(catalogs.summaries))
+	for _, s := range catalogs.summaries {
+		pages = append(pages, s)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].DocId < pages[j].DocId })
+	return pages, nil
+}
+
+// noteWrite updates the catalog with a revision this container wrote, so
+// its listings show the change without waiting for catalogTTL.
+func noteWrite(docId string, doc fetchedDoc) {
+	if isDefaultsDoc(docId) {
+		forgetDefaults(docId)
+		return
+	}
+	if isSchemaDoc(docId) || isRedirectsDoc(docId) {
+		forgetSystemDoc(docId)
+		return
+	}
+	if !isPage(docId) {
+		return
+	}
+
+	catalogs.Lock()
+	defer catalogs.Unlock()
+	if catalogs.summaries != nil {
+		catalogs.summaries[docId] = summarize(docId, doc)
+		catalogs.changed = true
+	}
+}
+
+// listAllDocs pages through every doc in the store.
+func listAllDocs(ctx context.Context) ([]docstore.Doc, error) {
+	return storelist.Docs(ctx, ds)
+}
+
+// isPage reports whether docId is a rendered page, rather than a raw
+// asset or a system doc.
+func isPage(docId string) bool {
+	return !strings.Contains(docId, ".") && !strings.HasPrefix(docId, "_")
+}
+
+// buildCatalog summarises every page. Pages in prev at their latest
+// revision are reused rather than fetched again.
+func buildCatalog(ctx context.Context, prev map[string]docSummary) (map[string]docSummary, error) {
+	docs, err := listAllDocs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := map[string]docSummary{}
+	var changed []string
+	for _, d := range docs {
+		if !isPage(d.Id) {
+			continue
+		}
+		if s, ok := prev[d.Id]; ok && d.LatestRevision > 0 && s.Version >= d.LatestRevision {
+			summaries[d.Id] = s
+			continue
+		}
+		changed = append(changed, d.Id)
+	}
+
+	ids := make(chan string)
+	fetched := make(chan docSummary)
+
+	var wg sync.WaitGroup
+	for i := 0; i < catalogWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for docId := range ids {
+				doc, err := fetchDoc(ctx, docId)
+				if err != nil {
+					log.Printf("catalog: skipping %s: %v", docId, err)
+					continue
+				}
+				fetched <- summarize(docId, doc)
+			}
+		}()
+	}
+
+	go func() {
+		for _, docId := range changed {
+			ids <- docId
+		}
+		close(ids)
+		wg.Wait()
+		close(fetched)
+	}()
+
+	for s := range fetched {
+		summaries[s.DocId] = s
+	}
+	// A page that couldn't be fetched keeps its last summary.
+	for _, docId := range changed {
+		if _, ok := summaries[docId]; !ok {
+			if s, ok := prev[docId]; ok {
+				summaries[docId] = s
+			}
+		}
+	}
+	return summaries, ctx.Err()
+}
+
+// assembleCatalog orders the listed pages among summaries into a catalog.
+func assembleCatalog(ctx context.Context, summaries map[string]docSummary) catalog {
+	cfg := getConfig(ctx)
+	c := catalog{}
+	for _, s := range summaries {
+		if private, _ := cfg.access(s.DocId, s.fm); s.fm.listed() && !private {
+			c = append(c, s)
+		}
+	}
+
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].Pinned != c[j].Pinned {
+			return c[i].Pinned
+		}
+		if !c[i].Timestamp.Equal(c[j].Timestamp) {
+			return c[i].Timestamp.After(c[j].Timestamp)
+		}
+		return c[i].DocId < c[j].DocId
+	})
+	return c
+}
+
+func summarize(docId string, doc fetchedDoc) docSummary {
+	fm, body := splitFrontMatter(docId, doc.body)
+	// The text is shared by every reader, so it has only what a reader in
+	// no audience sees.
+	public, _ := filterAudience(body, audiences{})
+	return docSummary{
+		DocId:     docId,
+		Title:     fm.title(body),
+		Version:   doc.meta.Id,
+		Timestamp: doc.meta.Timestamp,
+		Pinned:    fm.Pinned,
+		Featured:  fm.Featured,
+		Canonical: fm.Canonical,
+		fm:        fm,
+		text:      docText(public),
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// searchMatch tells a reader that a new or updated doc matches one of
+// their subscribed searches.
+type searchMatch struct {
+	Type    string `json:"type"`
+	User    string `json:"user"`
+	Search  string `json:"search"`
+	DocId   string `json:"docId"`
+	Version int    `json:"version"`
+	Title   string `json:"title"`
+	Message string `json:"message,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Invoke is the docs function's entry point. It takes API Gateway
+// requests, which go to Handler, batches from the revisions table's
+// stream, which go to handleChanges and embedChanges, and scheduled
+// events, which start a policy run.
+func Invoke(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var probe struct {
+		Source  string `json:"source"`
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		}
+	}
+	json.Unmarshal(payload, &probe)
+	if probe.Source == "aws.events" {
+		return nil, runPolicies(ctx)
+	}
+	if len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:dynamodb" {
+		var event events.DynamoDBEvent
+		err := json.Unmarshal(payload, &event)
+		if err != nil {
+			return nil, err
+		}
+		err = handleChanges(ctx, event)
+		if err == nil {
+			err = embedChanges(ctx, event)
+		}
+		return nil, err
+	}
+
+	var request events.APIGatewayProxyRequest
+	err := json.Unmarshal(payload, &request)
+	if err != nil {
+		return nil, err
+	}
+	return Handler(ctx, request)
+}
+
+// changedPages returns the pages with revisions inserted in event.
+func changedPages(event events.DynamoDBEvent) []string {
+	seen := map[string]bool{}
+	var docIds []string
+	for _, record := range event.Records {
+		if record.EventName != "INSERT" {
+			continue
+		}
+		docId, ok := record.Change.Keys["DocId"]
+		if !ok || !isPage(docId.String()) || seen[docId.String()] {
+			continue
+		}
+		seen[docId.String()] = true
+		docIds = append(docIds, docId.String())
+	}
+	sort.Strings(docIds)
+	return docIds
+}
+
+// handleChanges matches the listed, public pages changed in event against
+// the subscribed saved searches and notifies their readers. A search
+// matches a doc when the doc passes its filters and contains every word
+// of its query, as on the search page.
+func handleChanges(ctx context.Context, event events.DynamoDBEvent) error {
+	notifier := getNotifier()
+	if savedSearchesTable == "" || notifier == nil {
+		return nil
+	}
+	docIds := changedPages(event)
+	if len(docIds) == 0 {
+		return nil
+	}
+
+	cfg := getConfig(ctx)
+	var docs []docSummary
+	messages := map[string]string{}
+	for _, docId := range docIds {
+		doc, err := fetchDoc(ctx, docId)
+		if err != nil {
+			return err
+		}
+		d := summarize(docId, doc)
+		if private, _ := cfg.access(docId, d.fm); d.fm.listed() && !private {
+			docs = append(docs, d)
+			messages[docId] = docRevisionMeta(ctx, doc).Message
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	saved, err := subscribedSearches(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range saved {
+		sq, err := s.parse()
+		if err != nil {
+			log.Printf("saved search %s of %s: %v", s.Name, s.User, err)
+			continue
+		}
+		terms := searchTerms(sq.Q)
+		var re *regexp.Regexp
+		if len(terms) > 0 {
+			re = termPattern(terms)
+		}
+
+		for _, d := range docs {
+			if _, ok := matchDoc(sq, terms, re, d); !ok {
+				continue
+			}
+			m := searchMatch{
+				Type:    "search.match",
+				User:    s.User,
+				Search:  s.Name,
+				DocId:   d.DocId,
+				Version: d.Version,
+				Title:   d.Title,
+				Message: messages[d.DocId],
+			}
+			if cfg.BaseURL != "" {
+				m.URL = strings.TrimSuffix(cfg.BaseURL, "/") + "/" + d.DocId
+			}
+			err = notifier.Notify(ctx, notification{
+				Type:    m.Type,
+				User:    s.User,
+				Subject: fmt.Sprintf("New match for %s: %s", s.Name, d.Title),
+				Text:    strings.TrimSpace(strings.Join([]string{m.Message, m.URL}, "\n")),
+				Message: m,
+			})
+			if err != nil {
+				return fmt.Errorf("notify %s of %s: %w", s.User, d.DocId, err)
+			}
+		}
+	}
+	return nil
+}
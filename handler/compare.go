@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/textdiff"
+)
+
+var (
+	// compareTimeout bounds fetching the source to compare a doc with.
+	compareTimeout = envDuration("COMPARE_TIMEOUT", 10*time.Second)
+
+	compareClient = &http.Client{}
+)
+
+// compareMaxBytes is the most of a fetched source that is compared.
+const compareMaxBytes = 5 << 20
+
+type comparison struct {
+	DocId     string `json:"docId"`
+	Version   int    `json:"version"`
+	Source    string `json:"source"`
+	Identical bool   `json:"identical"`
+	Deleted   int    `json:"deleted"`
+	Inserted  int    `json:"inserted"`
+	Diff      string `json:"diff,omitempty"`
+}
+
+// fetchSource gets the text at rawURL to compare with.
+func fetchSource(ctx context.Context, rawURL string) ([]byte, error) {
+	body, err := fetchURL(ctx, "compare source "+rawURL, rawURL, compareMaxBytes)
+	if int64(len(body)) > compareMaxBytes {
+		body = body[:compareMaxBytes]
+	}
+	return body, err
+}
+
+// fetchURL gets rawURL for op, reading no more than max and a byte of it
+// so that callers can tell a body over max from one that fits.
+func fetchURL(ctx context.Context, op, rawURL string, max int64) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"url": "an http or https URL is required"})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, compareTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+	resp, err := compareClient.Do(req)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrBackend, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"url": rawURL, "status": resp.StatusCode})
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+}
+
+// compareDoc diffs the docId path parameter with the request body, or with
+// the text fetched from the url query parameter, to check that a mirror
+// matches its source. The latest revision is compared unless the version
+// query parameter picks another. Only site admins may use url, since it
+// fetches arbitrary URLs.
+func compareDoc(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+
+	c := comparison{DocId: docId, Source: "body"}
+	var other []byte
+	var err error
+	if u := request.QueryStringParameters["url"]; u != "" {
+		if roleFrom(ctx) == rolePrefixAdmin {
+			return Response{}, docerr.WithDetails("compare "+docId, docerr.ErrForbidden, nil,
+				map[string]interface{}{"url": "only site admins may compare with a URL; send the text as the body"})
+		}
+		c.Source = u
+		other, err = fetchSource(ctx, u)
+	} else {
+		other, err = requestBody(request)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	var doc fetchedDoc
+	if v, ok := request.QueryStringParameters["version"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Response{}, docerr.E("version "+v, docerr.ErrBadRequest, err)
+		}
+		doc, err = fetchRevision(fetchCtx, docId, n)
+	} else {
+		doc, err = fetchDoc(fetchCtx, docId)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+
+	c.Version = doc.meta.Id
+	diff := textdiff.Lines(string(doc.body), string(other))
+	c.Deleted, c.Inserted = textdiff.Stats(diff)
+	c.Identical = c.Deleted == 0 && c.Inserted == 0
+	if !c.Identical {
+		c.Diff = diffText(diff)
+	}
+
+	return jsonResponse(200, c), nil
+}
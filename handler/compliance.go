@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	// immutableStore is compliance mode, for regulated environments: every
+	// change is append-only and audited. Nothing recorded can be deleted,
+	// holds can't be released, as no revision is ever pruned, and every
+	// revision written, not only admin actions, gets an audit record. It is
+	// set on the deployment, with IMMUTABLE_STORE, rather than in the site
+	// config, so that writing a doc can't turn it off.
+	immutableStore = envBool("IMMUTABLE_STORE")
+
+	// deletingRoutes are the API routes that remove something recorded.
+	deletingRoutes = map[string]bool{
+		apiV1 + "annotations/{docId}/{id}":     true,
+		apiV1 + "admin/holds/{docId}":          true,
+		apiV1 + "admin/labels/{docId}/{label}": true,
+	}
+
+	errImmutable = errors.New("the store is immutable")
+)
+
+// immutableRefused reports whether a request with method to the API route
+// pattern would delete something, and so isn't allowed in compliance mode.
+func immutableRefused(method, pattern string) bool {
+	return immutableStore && method == "DELETE" && deletingRoutes[pattern]
+}
+
+type auditRequestKey struct{}
+
+// withAuditRequest keeps the request in the context, so that code without
+// it at hand, like writeDoc, can audit what it did on its behalf.
+func withAuditRequest(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		return next(context.WithValue(ctx, auditRequestKey{}, request), request)
+	}
+}
+
+// auditRequestFrom returns the request carried by ctx, or an empty one.
+func auditRequestFrom(ctx context.Context) events.APIGatewayProxyRequest {
+	request, _ := ctx.Value(auditRequestKey{}).(events.APIGatewayProxyRequest)
+	return request
+}
+
+// auditWrite records a revision written in compliance mode.
+func auditWrite(ctx context.Context, res writeResult) {
+	if !immutableStore {
+		return
+	}
+	audit(auditRequestFrom(ctx), "doc.write", res.DocId, map[string]interface{}{
+		"action":   res.Action,
+		"version":  res.Version,
+		"base":     res.BaseVersion,
+		"deleted":  res.Deleted,
+		"inserted": res.Inserted,
+		"role":     roleFrom(ctx),
+	})
+}
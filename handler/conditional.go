@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// validators returns the ETag and Last-Modified headers for a response
+// showing revision rev, modified at t, with body. The ETag includes a hash
+// of the body as well as the revision, since the same revision renders
+// differently once the template or site config changes.
+func validators(rev int, t time.Time, body string) map[string]string {
+	h := fnv.New64a()
+	h.Write([]byte(body))
+	return map[string]string{
+		"ETag":          fmt.Sprintf(`W/"%d-%x"`, rev, h.Sum64()),
+		"Last-Modified": t.UTC().Format(http.TimeFormat),
+	}
+}
+
+// notModified reports whether a client holding the response with etag and
+// lastModified can reuse it, going by the request's If-None-Match or, if
+// that isn't sent, If-Modified-Since.
+func notModified(request events.APIGatewayProxyRequest, etag, lastModified string) bool {
+	if inm := header(request, "If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims := header(request, "If-Modified-Since")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(since)
+}
+
+// withConditional answers conditional GETs for responses that haven't
+// changed with an empty 304.
+func withConditional(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		resp, err := next(ctx, request)
+		if err != nil || resp.StatusCode != http.StatusOK ||
+			(request.HTTPMethod != "GET" && request.HTTPMethod != "HEAD" && request.HTTPMethod != "") {
+			return resp, err
+		}
+
+		etag := resp.Headers[http.CanonicalHeaderKey("ETag")]
+		lastModified := resp.Headers["Last-Modified"]
+		if !notModified(request, etag, lastModified) {
+			return resp, err
+		}
+
+		// A 304 carries the headers that would have described the body.
+		headers := map[string]string{}
+		for _, k := range []string{"ETag", "Last-Modified", "Cache-Control", "Vary", "Expires"} {
+			k = http.CanonicalHeaderKey(k)
+			if v, ok := resp.Headers[k]; ok {
+				headers[k] = v
+			}
+		}
+		return Response{StatusCode: http.StatusNotModified, Headers: headers}, nil
+	}
+}
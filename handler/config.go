@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// configDocName is the doc holding the YAML site config.
+	configDocName = "_config"
+)
+
+var (
+	// configTTL is how long a warm container uses the site config before
+	// fetching it again.
+	configTTL = envDuration("CONFIG_TTL", time.Minute)
+
+	configs = &configCache{}
+)
+
+// siteConfig is the site config, for example:
+//
+//	headers:
+//	  X-Frame-Options: DENY
+//	prefixes:
+//	  /internal-:
+//	    headers:
+//	      Cache-Control: private, no-store
+type siteConfig struct {
+	// Name is the site's name and BaseURL its public address, like
+	// "https://docs.example.com".
+	Name    string `yaml:"name"`
+	BaseURL string `yaml:"baseURL"`
+
+	// Headers are added to every response that doesn't set them itself,
+	// for instance from a doc's front matter.
+	Headers map[string]string `yaml:"headers"`
+
+	// Prefixes holds settings for request paths starting with each key.
+	Prefixes map[string]prefixConfig `yaml:"prefixes"`
+
+	CORS corsConfig `yaml:"cors"`
+
+	Embed embedConfig `yaml:"embed"`
+
+	Time timeConfig `yaml:"time"`
+
+	Robots robotsConfig `yaml:"robots"`
+
+	// Minify strips comments and excess whitespace from rendered pages.
+	Minify bool `yaml:"minify"`
+
+	// Audiences every reader of this deployment belongs to, for docs'
+	// ":::only audience=" blocks, like [internal] for an intranet site.
+	Audiences []string `yaml:"audiences"`
+
+	PII piiConfig `yaml:"pii"`
+
+	FrontMatterSchema schemaConfig `yaml:"frontMatterSchema"`
+
+	Quotas quotaConfig `yaml:"quotas"`
+
+	Normalize normalizeConfig `yaml:"normalize"`
+
+	Pins pinConfig `yaml:"pins"`
+
+	// Tenants share the deployment, each owning the docs under a prefix.
+	Tenants map[string]tenantConfig `yaml:"tenants"`
+
+	IAM iamConfig `yaml:"iam"`
+
+	RevisionMessages revisionMessagesConfig `yaml:"revisionMessages"`
+
+	Offline offlineConfig `yaml:"offline"`
+
+	Markdown markdownConfig `yaml:"markdown"`
+
+	// Features are flags templates can check with .Render.Feature, to try
+	// out changes before making them for good.
+	Features map[string]bool `yaml:"features"`
+
+	// Freezes are times docs aren't changed in, like release weekends.
+	Freezes []freezeWindow `yaml:"freezes"`
+
+	// source is the config doc read, and version its revision.
+	source  string
+	version int
+}
+
+type prefixConfig struct {
+	// Headers are added to responses for paths under the prefix, taking
+	// precedence over the site wide headers.
+	Headers map[string]string `yaml:"headers"`
+
+	// Robots set to "disallow" keeps crawlers out of the prefix.
+	Robots string `yaml:"robots"`
+
+	// Private and Access make the docs under the prefix private, as the
+	// front matter fields of the same names do.
+	Private bool     `yaml:"private"`
+	Access  []string `yaml:"access"`
+
+	// Listing lists the docs under the prefix, like a web server's
+	// directory index, at the prefix's own path when no doc is there.
+	Listing bool `yaml:"listing"`
+
+	// Admins are groups that administer the docs under the prefix without
+	// the admin API key. See rolePrefixAdmin.
+	Admins []string `yaml:"admins"`
+
+	// RawHTML is "allow", "strip" or "block": what is done with raw HTML
+	// in the markdown of docs under the prefix. See rawhtml.go.
+	RawHTML string `yaml:"rawHTML"`
+}
+
+// matchingPrefixes returns the prefix settings that apply to path, from the
+// shortest prefix to the longest.
+func (c *siteConfig) matchingPrefixes(path string) (matches []prefixConfig) {
+	var keys []string
+	for prefix := range c.Prefixes {
+		if strings.HasPrefix(path, prefix) {
+			keys = append(keys, prefix)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) < len(keys[j]) })
+
+	for _, k := range keys {
+		matches = append(matches, c.Prefixes[k])
+	}
+	return
+}
+
+type configCache struct {
+	sync.Mutex
+	cfg     *siteConfig
+	fetched time.Time
+}
+
+// getConfig returns the site config, fetching it when the cached copy is
+// older than configTTL. A missing config doc is an empty config; if the
+// config can't be fetched or parsed the last good one is kept.
+func getConfig(ctx context.Context) *siteConfig {
+	configs.Lock()
+	defer configs.Unlock()
+
+	if configs.cfg != nil && time.Since(configs.fetched) < configTTL {
+		noteConfig(ctx, configs.cfg, "hit")
+		return configs.cfg
+	}
+
+	cfg, err := fetchConfig(ctx)
+	if err != nil {
+		log.Printf("config error: %v", err)
+		if configs.cfg != nil {
+			return configs.cfg
+		}
+		return &siteConfig{}
+	}
+
+	configs.cfg, configs.fetched = cfg, time.Now()
+	noteConfig(ctx, cfg, "miss")
+	return cfg
+}
+
+// noteConfig records the config a request used for debugging, and as an
+// input of what it renders.
+func noteConfig(ctx context.Context, cfg *siteConfig, outcome string) {
+	noteCache(ctx, "config", outcome)
+	noteInput(ctx, "config", cfg.source, cfg.version)
+	if cfg.source != "" {
+		noteRevision(ctx, "config", cfg.source, cfg.version)
+	}
+}
+
+// fetchConfig reads the first of the profile's config docs that exists.
+func fetchConfig(ctx context.Context) (*siteConfig, error) {
+	cfg := &siteConfig{}
+
+	for _, name := range configDocNames() {
+		doc, err := fetchDoc(ctx, name)
+		if errors.Is(err, docerr.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = yaml.Unmarshal(doc.body, cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.source, cfg.version = name, doc.meta.Id
+		break
+	}
+
+	return cfg, nil
+}
+
+// withConfigHeaders adds the response headers set in the site config.
+func withConfigHeaders(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		resp, err := next(ctx, request)
+		if err != nil {
+			return resp, err
+		}
+
+		cfg := getConfig(ctx)
+		headers := map[string]string{}
+		for k, v := range cfg.Headers {
+			headers[http.CanonicalHeaderKey(k)] = v
+		}
+		for _, p := range cfg.matchingPrefixes(request.Path) {
+			for k, v := range p.Headers {
+				headers[http.CanonicalHeaderKey(k)] = v
+			}
+		}
+
+		return withDefaultHeaders(resp, headers), nil
+	}
+}
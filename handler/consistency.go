@@ -0,0 +1,401 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/blobstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// consistencyPrefix starts the ids of the docs holding consistency check
+// reports, one per run, like "_consistency.3f9a0c12d4e5b6a7".
+const consistencyPrefix = "_consistency."
+
+// consistencyCheck is a run checking that the store agrees with itself,
+// and the repair plan for where it doesn't. It checks that:
+//
+//   - index: every doc listed has the latest revision it is listed with,
+//     and every doc the labels, holds, drafts and asset references name
+//     exists
+//   - revisions: every doc's revisions run from 1 to its latest without
+//     gaps
+//   - redirects: every redirect within the site leads to a doc
+//   - assets: every asset the latest revision of a page links to, in the
+//     store or the asset bucket, exists
+type consistencyCheck struct {
+	Id       string               `json:"id"`
+	Started  time.Time            `json:"started"`
+	Finished *time.Time           `json:"finished,omitempty"`
+	Status   string               `json:"status"` // running, consistent, inconsistent or failed
+	Error    string               `json:"error,omitempty"`
+	Docs     int                  `json:"docs"`
+	Problems []consistencyProblem `json:"problems"`
+}
+
+// consistencyProblem is an invariant that doesn't hold, and how to repair
+// it.
+type consistencyProblem struct {
+	Check   string `json:"check"`
+	DocId   string `json:"docId,omitempty"`
+	Problem string `json:"problem"`
+	Repair  string `json:"repair"`
+}
+
+func getConsistencyCheck(ctx context.Context, id string) (consistencyCheck, error) {
+	var c consistencyCheck
+	op := "consistency check " + id
+	if id == "" || docstore.ValidateDocId(id) != nil || strings.Contains(id, ".") {
+		return c, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	flights.Forget("doc:" + consistencyPrefix + id)
+	doc, err := fetchDoc(ctx, consistencyPrefix+id)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(doc.body, &c)
+	if err != nil {
+		return c, docerr.E("parse "+op, docerr.ErrBackend, err)
+	}
+	return c, nil
+}
+
+func putConsistencyCheck(c consistencyCheck) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(consistencyPrefix+c.Id, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+consistencyPrefix+c.Id, err)
+	}
+	return nil
+}
+
+// startConsistencyCheck starts checking the store's invariants. It answers
+// at once with the run's id; GET admin/consistency/{id} reports the
+// problems found, with their repairs, when it is done. Nothing is
+// repaired. A run that outlives the invocation finishes when the
+// container is next thawed.
+func startConsistencyCheck(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	c := consistencyCheck{
+		Id:       randomHex(8),
+		Started:  time.Now().UTC(),
+		Status:   "running",
+		Problems: []consistencyProblem{},
+	}
+	err := putConsistencyCheck(c)
+	if err != nil {
+		return Response{}, err
+	}
+
+	go runConsistencyCheck(detach(ctx), c)
+
+	return jsonResponse(202, struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}{c.Id, c.Status}), nil
+}
+
+// runConsistencyCheck checks the store for c and stores the report.
+func runConsistencyCheck(ctx context.Context, c consistencyCheck) {
+	docs, problems, err := checkConsistency(ctx)
+	c.Docs = len(docs)
+	c.Problems = append(c.Problems, problems...)
+	c.Status = "consistent"
+	switch {
+	case err != nil:
+		c.Status, c.Error = "failed", err.Error()
+	case len(c.Problems) > 0:
+		c.Status = "inconsistent"
+	}
+	finished := time.Now().UTC()
+	c.Finished = &finished
+
+	err = putConsistencyCheck(c)
+	if err != nil {
+		log.Printf("consistency check %s: %v", c.Id, err)
+		return
+	}
+	log.Printf("consistency check %s %s: %d problems in %d docs", c.Id, c.Status, len(c.Problems), c.Docs)
+}
+
+// checkConsistency checks every doc in the store, returning them and the
+// problems found, ordered by check and doc.
+func checkConsistency(ctx context.Context) ([]docstore.Doc, []consistencyProblem, error) {
+	docs, err := listAllDocs(ctx)
+	if err != nil {
+		return nil, nil, docerr.FromStore("ListDocs", err)
+	}
+	latest := map[string]int{}
+	for _, d := range docs {
+		latest[d.Id] = d.LatestRevision
+	}
+
+	var mu sync.Mutex
+	var problems []consistencyProblem
+	report := func(p ...consistencyProblem) {
+		mu.Lock()
+		problems = append(problems, p...)
+		mu.Unlock()
+	}
+
+	sem := make(chan struct{}, catalogWorkers)
+	var wg sync.WaitGroup
+	for _, d := range docs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d docstore.Doc) {
+			defer func() { <-sem; wg.Done() }()
+			report(checkDocConsistency(ctx, d, latest)...)
+		}(d)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return docs, problems, err
+	}
+
+	more, err := checkIndexes(ctx, latest)
+	report(more...)
+	report(checkRedirectTargets(ctx, latest)...)
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Check != problems[j].Check {
+			return problems[i].Check < problems[j].Check
+		}
+		return problems[i].DocId < problems[j].DocId
+	})
+	return docs, problems, err
+}
+
+// checkDocConsistency checks d's revisions and, for a page, the assets
+// its latest revision links to. latest has the latest revision of every
+// doc.
+func checkDocConsistency(ctx context.Context, d docstore.Doc, latest map[string]int) []consistencyProblem {
+	var problems []consistencyProblem
+	problem := func(check, repair, format string, args ...interface{}) {
+		problems = append(problems, consistencyProblem{check, d.Id, fmt.Sprintf(format, args...), repair})
+	}
+
+	revs, err := listRevisions(ctx, d.Id)
+	if err != nil {
+		problem("revisions", "check the revisions table is readable and run the check again", "listing revisions: %v", err)
+		return problems
+	}
+	have, newest := map[int]bool{}, 0
+	for _, r := range revs {
+		have[r.Id] = true
+		if r.Id > newest {
+			newest = r.Id
+		}
+	}
+	var missing []string
+	for n := 1; n <= d.LatestRevision; n++ {
+		if !have[n] {
+			missing = append(missing, fmt.Sprint(n))
+		}
+	}
+	if len(missing) > 0 {
+		problem("revisions", "restore the missing revisions from a snapshot or backup",
+			"revisions %s of %d are missing", strings.Join(missing, ", "), d.LatestRevision)
+	}
+	if newest > d.LatestRevision {
+		problem("index", fmt.Sprintf("set the doc's latest revision to %d in the docs table", newest),
+			"revision %d is newer than the latest listed, %d", newest, d.LatestRevision)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	rev, err := ds.GetRevision(d.Id, d.LatestRevision)
+	if err != nil {
+		problem("index", "restore the revision from a snapshot, or point the docs table at the newest revision there is",
+			"the latest revision listed, %d, can't be read: %v", d.LatestRevision, err)
+		return problems
+	}
+	if !isPage(d.Id) {
+		return problems
+	}
+	doc, err := readRevision(rev)
+	if err != nil {
+		problem("index", "restore the revision from a snapshot", "reading revision %d: %v", d.LatestRevision, err)
+		return problems
+	}
+
+	for _, target := range storeAssetLinks(doc.body) {
+		if latest[target] == 0 {
+			problem("assets", fmt.Sprintf("upload %s, or remove the link from %s", target, d.Id),
+				"links to %s, which isn't in the store", target)
+		}
+	}
+	if assetBucket != "" {
+		for _, hash := range blobstore.Referenced(doc.body) {
+			ok, err := blobs().Exists(fetchCtx, hash)
+			switch {
+			case err != nil:
+				problem("assets", "check the asset bucket is readable and run the check again",
+					"checking stored asset %s: %v", hash, err)
+			case !ok:
+				problem("assets", fmt.Sprintf("upload the asset again, or remove the link from %s", d.Id),
+					"links to stored asset %s, which isn't in the bucket", hash)
+			}
+		}
+	}
+	return problems
+}
+
+// readRevision reads rev fully.
+func readRevision(rev docstore.Revision) (fetchedDoc, error) {
+	var b bytes.Buffer
+	_, err := b.ReadFrom(rev)
+	return fetchedDoc{meta: rev.Metadata(), body: b.Bytes()}, err
+}
+
+// storeAssetLinks returns the docs with an extension, like images, that
+// the links and images of page body point to within the site.
+func storeAssetLinks(body []byte) []string {
+	_, md, ok := frontMatterBlock(body)
+	if !ok {
+		md = body
+	}
+	root := markdown.Parse(md, parser.NewWithExtensions(mdExtensions))
+	seen := map[string]bool{}
+	var targets []string
+	ast.WalkFunc(root, func(node ast.Node, entering bool) ast.WalkStatus {
+		var dest []byte
+		switch n := node.(type) {
+		case *ast.Link:
+			dest = n.Destination
+		case *ast.Image:
+			dest = n.Destination
+		}
+		target := string(dest)
+		if i := strings.IndexAny(target, "?#"); i >= 0 {
+			target = target[:i]
+		}
+		target = strings.TrimPrefix(target, "/")
+		if entering && target != "" && !seen[target] && !strings.ContainsAny(target, ":/") &&
+			strings.Contains(target, ".") && docstore.ValidateDocId(target) == nil {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+		return ast.GoToNext
+	})
+	return targets
+}
+
+// checkIndexes checks the docs named by the labels, holds, drafts and
+// asset references exist, and the revisions labels name too.
+func checkIndexes(ctx context.Context, latest map[string]int) ([]consistencyProblem, error) {
+	exists := func(docId string) bool { return latest[docId] > 0 }
+	var problems []consistencyProblem
+	problem := func(docId, problem, repair string) {
+		problems = append(problems, consistencyProblem{"index", docId, problem, repair})
+	}
+
+	labels, err := getLabels(ctx)
+	if err != nil {
+		return problems, err
+	}
+	for docId, ls := range labels {
+		if !exists(docId) {
+			problem(docId, fmt.Sprintf("labeled in %s, but doesn't exist", labelsDocName), "delete its labels")
+			continue
+		}
+		for label, version := range ls {
+			if version < 1 || version > latest[docId] {
+				problem(docId, fmt.Sprintf("label %s names revision %d, which doesn't exist", label, version),
+					"delete the label or point it at a revision that exists")
+			}
+		}
+	}
+
+	holds, err := getHolds(ctx)
+	if err != nil {
+		return problems, err
+	}
+	for docId := range holds {
+		if !exists(docId) {
+			problem(docId, fmt.Sprintf("held in %s, but doesn't exist", holdsDocName), "release the hold if it was placed by mistake")
+		}
+	}
+
+	drafts, err := getDrafts(ctx)
+	if err != nil {
+		return problems, err
+	}
+	for docId, named := range drafts {
+		for name, d := range named {
+			if !exists(draftDocId(docId, name)) {
+				problem(docId, fmt.Sprintf("draft %s is open, but its doc doesn't exist", name), "discard the draft")
+			}
+			if d.Base > 0 && !exists(docId) {
+				problem(docId, fmt.Sprintf("draft %s branched from revision %d, but the doc doesn't exist", name, d.Base), "discard the draft, or publish it as a new doc")
+			}
+		}
+	}
+
+	refs, err := getAssetRefs(ctx)
+	if err != nil {
+		return problems, err
+	}
+	for hash, r := range refs {
+		for _, docId := range r.Docs {
+			if !exists(docId) {
+				problem(docId, fmt.Sprintf("counted in %s as linking to %s, but doesn't exist", blobstore.RefsDocName, hash),
+					"remove it from the asset's docs, so the asset can be collected once unreferenced")
+			}
+		}
+	}
+	return problems, nil
+}
+
+// checkRedirectTargets checks the redirects within the site lead to docs.
+// Redirects elsewhere, and to paths that aren't a doc's, like mounted
+// pages, aren't checked.
+func checkRedirectTargets(ctx context.Context, latest map[string]int) []consistencyProblem {
+	var problems []consistencyProblem
+	for _, r := range getRedirects(ctx) {
+		target := r.Target
+		if i := strings.IndexAny(target, "?#"); i >= 0 {
+			target = target[:i]
+		}
+		if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+			continue
+		}
+		docId := strings.TrimPrefix(target, "/")
+		if docId == "" || strings.Contains(docId, "/") || latest[docId] > 0 {
+			continue
+		}
+		repair := fmt.Sprintf("remove %s from %s, or point it at the doc's new name", r.Path, r.Source)
+		if !isRedirectsDoc(r.Source) {
+			repair = fmt.Sprintf("remove the alias %s from %s", r.Path, r.Source)
+		}
+		problems = append(problems, consistencyProblem{"redirects", r.Source,
+			fmt.Sprintf("%s redirects to %s, which doesn't exist", r.Path, r.Target), repair})
+	}
+	return problems
+}
+
+// consistencyReport reports the consistency check with the id path
+// parameter.
+func consistencyReport(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	c, err := getConsistencyCheck(ctx, request.PathParameters["id"])
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, c), nil
+}
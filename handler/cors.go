@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// corsConfig controls cross-origin access to the API routes, for example:
+//
+//	cors:
+//	  allowedOrigins: [https://editor.example.com]
+//	  allowedMethods: [GET, PUT, POST]
+//	  allowedHeaders: [Authorization, Content-Type]
+//	  maxAge: 600
+//
+// Cross-origin requests are refused unless allowedOrigins is set. Use "*"
+// to allow any origin.
+type corsConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	AllowedMethods []string `yaml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	MaxAge         int      `yaml:"maxAge"`
+
+	// Paths are the request path prefixes CORS applies to. Defaults to
+	// the API routes.
+	Paths []string `yaml:"paths"`
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "PUT", "POST", "DELETE"}
+
+// applies reports whether CORS handling covers path.
+func (c corsConfig) applies(path string) bool {
+	paths := c.Paths
+	if len(paths) == 0 {
+		paths = []string{apiPrefix}
+	}
+	for _, p := range paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it is not allowed.
+func (c corsConfig) allowOrigin(origin string) string {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (c corsConfig) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+// withCORS answers preflight requests to the API routes and adds CORS
+// headers to their responses.
+func withCORS(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		origin := header(request, "Origin")
+		cors := getConfig(ctx).CORS
+		if origin == "" || !cors.applies(request.Path) {
+			return next(ctx, request)
+		}
+
+		allowed := cors.allowOrigin(origin)
+		headers := map[string]string{"Vary": "Origin"}
+		if allowed != "" {
+			headers["Access-Control-Allow-Origin"] = allowed
+		}
+
+		if request.HTTPMethod == http.MethodOptions && header(request, "Access-Control-Request-Method") != "" {
+			if allowed != "" {
+				headers["Access-Control-Allow-Methods"] = strings.Join(cors.methods(), ", ")
+				if len(cors.AllowedHeaders) > 0 {
+					headers["Access-Control-Allow-Headers"] = strings.Join(cors.AllowedHeaders, ", ")
+				} else if h := header(request, "Access-Control-Request-Headers"); h != "" {
+					headers["Access-Control-Allow-Headers"] = h
+				}
+				if cors.MaxAge > 0 {
+					headers["Access-Control-Max-Age"] = strconv.Itoa(cors.MaxAge)
+				}
+			}
+			return withHeaders(Response{StatusCode: http.StatusNoContent}, headers), nil
+		}
+
+		resp, err := next(ctx, request)
+		if err != nil {
+			return resp, err
+		}
+
+		return withHeaders(resp, headers), nil
+	}
+}
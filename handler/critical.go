@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// criticalCSSDocName holds the CSS needed for first paint. It is
+	// inlined into the head of every page while the full stylesheet loads
+	// as usual.
+	criticalCSSDocName = "_critical.css"
+)
+
+var systemDocs = &systemDocCache{entries: map[string]systemDoc{}}
+
+type systemDoc struct {
+	body    []byte
+	found   bool
+	fetched time.Time
+}
+
+// systemDocCache keeps small system docs for configTTL so they don't cost
+// a docstore read on every render.
+type systemDocCache struct {
+	sync.Mutex
+	entries map[string]systemDoc
+}
+
+// getSystemDoc returns the body of the system doc docId and whether it
+// exists. If it can't be fetched the last good copy is used.
+func getSystemDoc(ctx context.Context, docId string) ([]byte, bool) {
+	systemDocs.Lock()
+	defer systemDocs.Unlock()
+
+	cached, ok := systemDocs.entries[docId]
+	if ok && time.Since(cached.fetched) < configTTL {
+		return cached.body, cached.found
+	}
+
+	// System docs are shared by every reader, so always stable.
+	doc, err := themeDoc(withVariant(ctx, ""), docId)
+	switch {
+	case errors.Is(err, docerr.ErrNotFound):
+		cached = systemDoc{fetched: time.Now()}
+	case err != nil:
+		log.Printf("error fetching %s: %v", docId, err)
+		return cached.body, cached.found
+	default:
+		cached = systemDoc{body: doc.body, found: true, fetched: time.Now()}
+	}
+
+	systemDocs.entries[docId] = cached
+	return cached.body, cached.found
+}
+
+// forgetSystemDoc drops the cached copy of a system doc this container
+// wrote.
+func forgetSystemDoc(docId string) {
+	systemDocs.Lock()
+	defer systemDocs.Unlock()
+	delete(systemDocs.entries, docId)
+}
+
+// inlineCriticalCSS adds the critical CSS to the head of page.
+func inlineCriticalCSS(ctx context.Context, page string) string {
+	css, ok := getSystemDoc(ctx, criticalCSSDocName)
+	if !ok || len(css) == 0 {
+		return page
+	}
+
+	if strings.Contains(strings.ToLower(string(css)), "</style") {
+		log.Printf("not inlining %s: it contains </style", criticalCSSDocName)
+		return page
+	}
+
+	i := indexFold(page, "</head>")
+	if i < 0 {
+		return page
+	}
+
+	return page[:i] + "<style>" + string(css) + "</style>\n" + page[i:]
+}
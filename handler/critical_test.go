@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInlineCriticalCSS(t *testing.T) {
+	store := newMemStore()
+	store.put(criticalCSSDocName, "p{}")
+	useStore(store)
+
+	tests := []struct {
+		name, page, want string
+	}{
+		{"head", "<html><head><title>t</title></head>", "<html><head><title>t</title><style>p{}</style>\n</head>"},
+		{"head case", "<HEAD></HEAD>", "<HEAD><style>p{}</style>\n</HEAD>"},
+		{"no head", "<p>x</p>", "<p>x</p>"},
+		{"case changes length", "<head><title>İstanbul İzmir</title></head>",
+			"<head><title>İstanbul İzmir</title><style>p{}</style>\n</head>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inlineCriticalCSS(context.Background(), tt.page); got != tt.want {
+				t.Errorf("inlineCriticalCSS(%q) = %q, want %q", tt.page, got, tt.want)
+			}
+		})
+	}
+}
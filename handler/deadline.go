@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// requestTimeout keeps each request inside API Gateway's 29 second
+	// integration limit, leaving time to send an error response.
+	requestTimeout = envDuration("REQUEST_TIMEOUT", 28*time.Second)
+
+	// fetchTimeout and templateTimeout bound the docstore phases of a
+	// render.
+	fetchTimeout    = envDuration("FETCH_TIMEOUT", 10*time.Second)
+	templateTimeout = envDuration("TEMPLATE_TIMEOUT", 5*time.Second)
+)
+
+// await waits for a coalesced call to finish or for ctx to be done,
+// whichever comes first.
+func await(ctx context.Context, op string, ch <-chan singleflight.Result) (interface{}, error) {
+	select {
+	case r := <-ch:
+		return r.Val, r.Err
+	case <-ctx.Done():
+		return nil, docerr.E(op, docerr.ErrTimeout, ctx.Err())
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// debugHeader, set to 1 on a request carrying the admin API key, asks for
+// debug headers on the response:
+//
+//	X-Docstore-Debug-Cache: config=hit, render=miss, template=hit
+//	X-Docstore-Debug-Revisions: config=_config@4, doc=guide@12, template=doc-template.html@3
+//	Server-Timing: fetch;dur=18.2, markdown;dur=1.4, template;dur=0.1, execute;dur=0.6, total;dur=21.0
+//
+// It is ignored on requests without the key, so readers can't see how the
+// site is put together.
+const debugHeader = "X-Docstore-Debug"
+
+// debugInfo collects what a request used, as it is served.
+type debugInfo struct {
+	sync.Mutex
+	cache     map[string]string
+	revisions map[string]string
+	phases    map[string]time.Duration
+	order     []string
+}
+
+type debugKey struct{}
+
+// debugFrom returns the debug info ctx collects, or nil if its request
+// didn't ask for it.
+func debugFrom(ctx context.Context) *debugInfo {
+	d, _ := ctx.Value(debugKey{}).(*debugInfo)
+	return d
+}
+
+// noteCache records whether a cache layer, like "render", had what the
+// request wanted the first time the request looked.
+func noteCache(ctx context.Context, layer, outcome string) {
+	if d := debugFrom(ctx); d != nil {
+		d.Lock()
+		if _, ok := d.cache[layer]; !ok {
+			d.cache[layer] = outcome
+		}
+		d.Unlock()
+	}
+}
+
+// noteRevision records the revision of docId the request used as its use,
+// like "template".
+func noteRevision(ctx context.Context, use, docId string, version int) {
+	noteInput(ctx, use, docId, version)
+	if d := debugFrom(ctx); d != nil {
+		d.Lock()
+		d.revisions[use] = fmt.Sprintf("%s@%d", docId, version)
+		d.Unlock()
+	}
+}
+
+// timePhase starts timing a phase of the request, returning the func
+// that stops it: defer timePhase(ctx, "fetch")(). A phase run more than
+// once is the sum of its runs. Phases are stages of the request's render
+// context too.
+func timePhase(ctx context.Context, phase string) func() {
+	rc, _ := ctx.Value(renderCtxKey{}).(*renderContext)
+	d := debugFrom(ctx)
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		if rc != nil {
+			rc.noteStage(phase, elapsed)
+		}
+		if d == nil {
+			return
+		}
+		d.Lock()
+		defer d.Unlock()
+		if _, ok := d.phases[phase]; !ok {
+			d.order = append(d.order, phase)
+		}
+		d.phases[phase] += elapsed
+	}
+}
+
+// headers formats the info as response headers.
+func (d *debugInfo) headers(total time.Duration) map[string]string {
+	d.Lock()
+	defer d.Unlock()
+
+	timings := make([]string, 0, len(d.order)+1)
+	for _, phase := range d.order {
+		timings = append(timings, serverTiming(phase, d.phases[phase]))
+	}
+	timings = append(timings, serverTiming("total", total))
+
+	return map[string]string{
+		debugHeader + "-Cache":     joinPairs(d.cache),
+		debugHeader + "-Revisions": joinPairs(d.revisions),
+		"Server-Timing":            strings.Join(timings, ", "),
+		"Cache-Control":            "no-store",
+	}
+}
+
+func serverTiming(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.1f", name, float64(d)/float64(time.Millisecond))
+}
+
+// joinPairs formats m as "k=v, k=v", sorted by key.
+func joinPairs(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// withDebug collects debug info for requests asking for it with
+// debugHeader and the admin API key, and adds it to their responses. The
+// responses are marked no-store, so that no cache hands them to anyone
+// else.
+func withDebug(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		if header(request, debugHeader) != "1" || requireAdmin(request) != nil {
+			return next(ctx, request)
+		}
+
+		d := &debugInfo{
+			cache:     map[string]string{},
+			revisions: map[string]string{},
+			phases:    map[string]time.Duration{},
+		}
+		start := time.Now()
+		resp, err := next(context.WithValue(ctx, debugKey{}, d), request)
+		if err != nil {
+			return resp, err
+		}
+		return withHeaders(resp, d.headers(time.Since(start))), nil
+	}
+}
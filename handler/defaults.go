@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultsDocName holds default front matter for every page, as YAML:
+//
+//	owner: docs-team
+//	template: wide
+//
+// A defaults doc for a prefix, like "_defaults.ops-" for the pages whose
+// docIds start "ops-", applies over it to those pages. Prefixes end at a
+// "-", the separator of docIds' words. A page's own front matter applies
+// over all of them, a field at a time, so "tags: []" clears default tags.
+const defaultsDocName = "_defaults"
+
+// isDefaultsDoc reports whether docId holds default front matter.
+func isDefaultsDoc(docId string) bool {
+	return docId == defaultsDocName || strings.HasPrefix(docId, defaultsDocName+".")
+}
+
+// prefixDocs returns the system docs named name that may apply to docId,
+// from the site wide one to that of the longest prefix.
+func prefixDocs(name, docId string) []string {
+	names := []string{name}
+	for i, r := range docId {
+		if r == '-' && i > 0 {
+			names = append(names, name+"."+docId[:i+1])
+		}
+	}
+	return names
+}
+
+// frontMatterDefaults returns the default front matter of the page docId,
+// merged from its defaults docs. Defaults docs are kept for configTTL like
+// other system docs, so pages don't wait on them often.
+func frontMatterDefaults(docId string) yaml.MapSlice {
+	if !isPage(docId) {
+		return nil
+	}
+	var merged yaml.MapSlice
+	for _, name := range prefixDocs(defaultsDocName, docId) {
+		body, ok := getSystemDoc(context.Background(), name)
+		if !ok {
+			continue
+		}
+		var defaults yaml.MapSlice
+		err := yaml.Unmarshal(body, &defaults)
+		if err != nil {
+			log.Printf("%s error: %v", name, err)
+			continue
+		}
+		merged = mergeMapSlice(merged, defaults)
+	}
+	return merged
+}
+
+// mergeMapSlice returns base with the fields of over set over it.
+func mergeMapSlice(base, over yaml.MapSlice) yaml.MapSlice {
+	merged := append(yaml.MapSlice{}, base...)
+	for _, item := range over {
+		replaced := false
+		for i := range merged {
+			if merged[i].Key == item.Key {
+				merged[i].Value, replaced = item.Value, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// applyDefaults returns the front matter block of docId with its defaults
+// merged under it.
+func applyDefaults(docId string, block []byte) ([]byte, error) {
+	defaults := frontMatterDefaults(docId)
+	if len(defaults) == 0 {
+		return block, nil
+	}
+	var own yaml.MapSlice
+	err := yaml.Unmarshal(block, &own)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(mergeMapSlice(defaults, own))
+}
+
+// lintDefaults checks the body of a defaults doc as front matter.
+func lintDefaults(body []byte) []string {
+	var fm frontMatter
+	if err := yaml.UnmarshalStrict(body, &fm); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// forgetDefaults drops the cached copy of a defaults doc that this
+// container wrote, and has the catalog rebuilt, so that pages pick up the
+// change without waiting for configTTL and the catalog rebuild interval.
+func forgetDefaults(docId string) {
+	forgetSystemDoc(docId)
+
+	catalogs.Lock()
+	catalogs.fetched, catalogs.rebuilt = time.Time{}, time.Time{}
+	catalogs.Unlock()
+}
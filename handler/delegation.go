@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// rolePrefixAdmin is the role of a signed in reader in one of the admins
+// groups of a prefix in the site config, like
+//
+//	prefixes:
+//	  /eng-:
+//	    admins: [eng-leads]
+//
+// who may use the delegatedRoutes for the docs under the prefix without
+// the admin API key: write them, with their access lists, templates,
+// defaults, schemas and redirects, label and compare them, and import
+// archives into the prefix.
+const rolePrefixAdmin = "prefix-admin"
+
+// delegatedRoutes are the admin API routes a prefix admin may use. Their
+// docId path parameter, and every doc they write, must be under one of
+// the prefixes the admin administers.
+var delegatedRoutes = map[string]bool{
+	apiV1 + "docs":                               true,
+	apiV1 + "docs/{docId}":                       true,
+	apiV1 + "docs/{docId}/entries":               true,
+	apiV1 + "docs/{docId}/drafts":                true,
+	apiV1 + "docs/{docId}/drafts/{name}":         true,
+	apiV1 + "docs/{docId}/drafts/{name}/publish": true,
+	apiV1 + "admin/labels/{docId}/{label}":       true,
+	apiV1 + "admin/pins/{docId}/promote":         true,
+	apiV1 + "admin/compare/{docId}":              true,
+	apiV1 + "admin/settings/{docId}":             true,
+	apiV1 + "admin/imports":                      true,
+	apiV1 + "admin/imports/{id}":                 true,
+	apiV1 + "admin/template-context":             true,
+}
+
+type delegationKey struct{}
+
+// adminPrefixes returns the docId prefixes whose admins include one of
+// groups.
+func (c *siteConfig) adminPrefixes(groups []string) []string {
+	var prefixes []string
+	for path, p := range c.Prefixes {
+		if intersects(p.Admins, groups) {
+			prefixes = append(prefixes, strings.TrimPrefix(path, "/"))
+		}
+	}
+	return prefixes
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorizeAdmin checks that request may use the admin API route pattern:
+// that it carries the admin API key, or that its reader or IAM principal
+// administers a prefix and the route is delegated. The returned context
+// carries the role, and the prefixes of a prefix admin for administers to
+// check.
+func authorizeAdmin(ctx context.Context, request events.APIGatewayProxyRequest, pattern string) (context.Context, error) {
+	if role, signed, err := iamRole(ctx, "admin "+request.Path); signed {
+		if err == nil && role != roleAdmin && (role != rolePrefixAdmin || !delegatedRoutes[pattern]) {
+			err = docerr.E("admin "+request.Path, docerr.ErrForbidden, nil)
+		}
+		return withRole(ctx, role), err
+	}
+
+	err := requireAdmin(request)
+	if header(request, "X-Api-Key") != "" {
+		noteLogin(ctx, "api-key", "", err)
+	}
+	if err == nil || !delegatedRoutes[pattern] || header(request, "X-Api-Key") != "" {
+		return withRole(ctx, roleAdmin), err
+	}
+
+	r, rerr := requestReader(ctx, request)
+	if rerr != nil || r == nil {
+		return ctx, err
+	}
+	prefixes := getConfig(ctx).adminPrefixes(r.Groups)
+	if len(prefixes) == 0 {
+		return ctx, docerr.E("admin "+request.Path, docerr.ErrForbidden, nil)
+	}
+	ctx = withRole(ctx, rolePrefixAdmin)
+	return context.WithValue(ctx, delegationKey{}, prefixes), nil
+}
+
+// administers reports whether the client of ctx may administer docId: a
+// client with the admin or write API key may administer any doc, and a
+// prefix admin the docs under their prefixes. The defaults, schema and
+// redirects docs of a prefix, like "_defaults.eng-", count as under it;
+// other system docs are for site admins.
+func administers(ctx context.Context, docId string) bool {
+	prefixes, ok := ctx.Value(delegationKey{}).([]string)
+	if !ok {
+		return true
+	}
+
+	if isDefaultsDoc(docId) || isSchemaDoc(docId) || isRedirectsDoc(docId) {
+		i := strings.Index(docId, ".")
+		if i < 0 {
+			return false
+		}
+		docId = docId[i+1:]
+	}
+	if strings.HasPrefix(docId, "_") {
+		return false
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(docId, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdministers checks that the client of ctx administers docId.
+func requireAdministers(ctx context.Context, op, docId string) error {
+	if administers(ctx, docId) {
+		return nil
+	}
+	return docerr.WithDetails(op, docerr.ErrForbidden, nil,
+		map[string]interface{}{"docId": docId, "reason": "outside the prefixes you administer"})
+}
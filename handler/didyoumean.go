@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// maxDidYouMean is how many close docs a search with no results suggests.
+const maxDidYouMean = 3
+
+// didYouMean is a doc whose title or docId is close to a query that found
+// nothing. Distance is how many characters the query is off by.
+type didYouMean struct {
+	DocId    string `json:"docId"`
+	Title    string `json:"title"`
+	Distance int    `json:"distance"`
+}
+
+// suggestDocs returns the listed docs whose titles or docIds are within a
+// few typos of q, closest first. Each word of q must be close to a word of
+// the title or docId, or q as a whole close to the docId, so "deplyo
+// gide" finds "Deploy Guide" and "gettingstarted" finds getting-started.
+func suggestDocs(ctx context.Context, q string) ([]didYouMean, error) {
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	whole := strings.Join(terms, "")
+
+	c, err := getCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []didYouMean
+	for _, d := range c {
+		best := -1
+		if n := editDistance(whole, strings.Join(searchTerms(d.DocId), "")); n <= typoAllowance(whole) {
+			best = n
+		}
+		words := append(searchTerms(d.Title), searchTerms(d.DocId)...)
+		if n, ok := closeWords(terms, words); ok && (best < 0 || n < best) {
+			best = n
+		}
+		if best >= 0 {
+			found = append(found, didYouMean{d.DocId, d.Title, best})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Distance != found[j].Distance {
+			return found[i].Distance < found[j].Distance
+		}
+		return found[i].DocId < found[j].DocId
+	})
+	if len(found) > maxDidYouMean {
+		found = found[:maxDidYouMean]
+	}
+	return found, nil
+}
+
+// closeWords reports whether every one of terms is within its typo
+// allowance of one of words, and how far off they are in all.
+func closeWords(terms, words []string) (int, bool) {
+	total := 0
+	for _, t := range terms {
+		best := -1
+		for _, w := range words {
+			if n := editDistance(t, w); n <= typoAllowance(t) && (best < 0 || n < best) {
+				best = n
+			}
+		}
+		if best < 0 {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+// typoAllowance is how many edits a word of s's length may be off by and
+// still be taken for a typo.
+func typoAllowance(s string) int {
+	n := len([]rune(s))
+	switch {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	case n < 10:
+		return 2
+	}
+	return 3
+}
+
+// editDistance is the Levenshtein distance between a and b, counting
+// runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
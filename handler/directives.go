@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// docDirective is an HTML comment by which a doc opts in or out of parts
+// of the render pipeline without front matter, on a line of its own
+// outside fenced code:
+//
+//	<!-- docstore: toc=off, numbering=on, sanitize=strict -->
+//
+// toc=off leaves the table of contents out of the page, numbering=on or
+// off numbers headings, figures and tables or not, whatever the front
+// matter says, and sanitize=strict strips raw HTML even where the site
+// config allows it. A doc can't loosen the site's raw HTML policy. Later
+// directives override earlier ones.
+var docDirective = regexp.MustCompile(`^<!--\s*docstore:(.*?)-->$`)
+
+// docDirectives are the settings a doc's directives chose. Unset settings
+// are nil or empty.
+type docDirectives struct {
+	TOC       *bool
+	Numbering *bool
+	Sanitize  string
+}
+
+// directiveSwitches are the values of the on and off directives.
+var directiveSwitches = map[string]bool{"on": true, "off": false}
+
+// parseDirectives returns the settings of the directives in md, and
+// problems with those it doesn't understand.
+func parseDirectives(md []byte) (d docDirectives, problems []string) {
+	if !strings.Contains(string(md), "docstore:") {
+		return
+	}
+
+	fenced := false
+	for _, line := range strings.Split(string(md), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+		m := docDirective.FindStringSubmatch(trimmed)
+		if fenced || m == nil {
+			continue
+		}
+
+		for _, setting := range strings.Split(m[1], ",") {
+			setting = strings.TrimSpace(setting)
+			if setting == "" {
+				continue
+			}
+			i := strings.Index(setting, "=")
+			if i < 0 {
+				problems = append(problems, fmt.Sprintf("directive %q: want key=value", setting))
+				continue
+			}
+			key, value := strings.TrimSpace(setting[:i]), strings.ToLower(strings.TrimSpace(setting[i+1:]))
+			on, isSwitch := directiveSwitches[value]
+			switch {
+			case key == "toc" && isSwitch:
+				d.TOC = &on
+			case key == "numbering" && isSwitch:
+				d.Numbering = &on
+			case key == "sanitize" && (value == "strict" || value == "default"):
+				d.Sanitize = value
+			case key == "toc", key == "numbering", key == "sanitize":
+				problems = append(problems, fmt.Sprintf("directive %s: unknown value %q", key, value))
+			default:
+				problems = append(problems, fmt.Sprintf("directive: unknown key %q", key))
+			}
+		}
+	}
+	return
+}
+
+// numbered reports whether a doc with front matter fm is numbered.
+func (d docDirectives) numbered(fm frontMatter) bool {
+	if d.Numbering != nil {
+		return *d.Numbering
+	}
+	return fm.Numbered
+}
+
+// options returns opts as the directives change them.
+func (d docDirectives) options(fm frontMatter, opts markdownOptions) markdownOptions {
+	opts.Numbered = d.numbered(fm)
+	if d.Sanitize == "strict" {
+		opts.StripHTML = true
+	}
+	return opts
+}
+
+// showsTOC reports whether the page lists its table of contents.
+func (d docDirectives) showsTOC() bool {
+	return d.TOC == nil || *d.TOC
+}
+
+// directiveWarnings warns of the directives of page docId that aren't
+// understood, and so are ignored.
+func directiveWarnings(docId string, body []byte) []string {
+	if !isPage(docId) {
+		return nil
+	}
+	_, md := splitFrontMatter(docId, body)
+	_, problems := parseDirectives(md)
+	return problems
+}
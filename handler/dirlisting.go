@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// dirEntry is a doc in a directory listing.
+type dirEntry struct {
+	DocId    string
+	URL      string
+	Size     int
+	Modified time.Time
+}
+
+// isListingDir reports whether name, the part of a path after /assets/,
+// names a directory of assets rather than an asset: it is empty or ends
+// with a separator, like "icons-".
+func isListingDir(name string) bool {
+	return name == "" || strings.HasSuffix(name, "-") || strings.HasSuffix(name, "_") || strings.HasSuffix(name, ".")
+}
+
+// listing reports whether path is a configured prefix that lists its docs
+// when there is no doc at it.
+func (c *siteConfig) listing(path string) bool {
+	p, ok := c.Prefixes[path]
+	return ok && p.Listing
+}
+
+// dirEntries returns the docs starting with prefix, by name: the assets
+// if assets is set, or else the raw docs and the listed pages.
+func dirEntries(ctx context.Context, prefix string, assets bool) ([]dirEntry, error) {
+	docs, err := listAllDocs(ctx)
+	if err != nil {
+		return nil, docerr.FromStore("ListDocs", err)
+	}
+	listed := map[string]bool{}
+	if !assets {
+		c, err := getCatalog(ctx)
+		if err != nil {
+			return nil, docerr.FromStore("list docs", err)
+		}
+		for _, d := range c {
+			listed[d.DocId] = true
+		}
+	}
+
+	var docIds []string
+	for _, d := range docs {
+		switch {
+		case !strings.HasPrefix(d.Id, prefix) || strings.HasPrefix(d.Id, "_"):
+		case isPage(d.Id) && (assets || !listed[d.Id]):
+		default:
+			docIds = append(docIds, d.Id)
+		}
+	}
+	sort.Strings(docIds)
+
+	entries := make([]dirEntry, len(docIds))
+	errs := make([]error, len(docIds))
+	sem := make(chan struct{}, catalogWorkers)
+	var wg sync.WaitGroup
+	for i, docId := range docIds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, docId string) {
+			defer func() { <-sem; wg.Done() }()
+			doc, err := fetchDoc(ctx, docId)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			url := "/" + docId
+			if assets {
+				url = assetsPrefix + docId
+			}
+			entries[i] = dirEntry{docId, url, len(doc.body), doc.meta.Timestamp}
+		}(i, docId)
+	}
+	wg.Wait()
+
+	found := make([]dirEntry, 0, len(entries))
+	for i, e := range entries {
+		// A doc deleted since it was listed is left out.
+		if errors.Is(errs[i], docerr.ErrNotFound) {
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		found = append(found, e)
+	}
+	return found, nil
+}
+
+// formatSize formats n bytes for people, like "1.2 KB".
+func formatSize(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// serveListing lists the docs starting with prefix, with their sizes and
+// when they were modified, like a web server's directory index, at path.
+// There is nothing to list when no docs start with prefix.
+func serveListing(ctx context.Context, path, prefix string, assets bool) (Response, error) {
+	tf := renderFrom(ctx).tf
+	entries, err := dirEntries(ctx, prefix, assets)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(entries) == 0 {
+		return Response{}, docerr.E("listing "+path, docerr.ErrNotFound, nil)
+	}
+
+	var b strings.Builder
+	b.WriteString("<table class=\"listing\">\n<thead><tr><th>Name</th><th>Size</th><th>Modified</th></tr></thead>\n<tbody>\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td><time datetime=\"%s\">%s</time></td></tr>\n",
+			e.URL, html.EscapeString(e.DocId), formatSize(e.Size), tf.iso(e.Modified), tf.format(e.Modified))
+	}
+	b.WriteString("</tbody>\n</table>\n")
+
+	meta := docMetadata{
+		Title:   "Index of " + path,
+		DocBody: b.String(),
+	}
+	return renderPage(ctx, tmplDocName, meta)
+}
@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/textdiff"
+	"gopkg.in/yaml.v2"
+)
+
+// Drafts are named branches of a doc, like "rewrite", edited without
+// touching the published doc until they're published. A draft's revisions
+// are kept in "_draft.{docId}.{name}", and draftsDocName records each
+// open draft and the published revision it branched from. The store can't
+// delete docs, so a discarded or published draft's revisions are kept.
+const (
+	draftsDocName = "_drafts"
+	draftPrefix   = "_draft."
+)
+
+var validDraftName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+type draft struct {
+	// Base is the published revision the draft branched from, or 0 for a
+	// draft of a new doc.
+	Base    int       `yaml:"base" json:"base"`
+	Created time.Time `yaml:"created" json:"created"`
+}
+
+// draftStatus describes a draft. Inserted and Deleted count its changes
+// against the latest published revision, which is Latest.
+type draftStatus struct {
+	DocId     string    `json:"docId"`
+	Name      string    `json:"name"`
+	Base      int       `json:"base"`
+	Latest    int       `json:"latest"`
+	Version   int       `json:"version,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	Inserted  int       `json:"inserted"`
+	Deleted   int       `json:"deleted"`
+	Body      string    `json:"body,omitempty"`
+}
+
+func draftDocId(docId, name string) string {
+	return draftPrefix + docId + "." + name
+}
+
+// getDrafts returns the open drafts by docId and name.
+func getDrafts(ctx context.Context) (map[string]map[string]draft, error) {
+	drafts := map[string]map[string]draft{}
+
+	flights.Forget("doc:" + draftsDocName)
+	doc, err := fetchDoc(ctx, draftsDocName)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return drafts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(doc.body, &drafts)
+	if err != nil {
+		return nil, docerr.E("parse "+draftsDocName, docerr.ErrBackend, err)
+	}
+	return drafts, nil
+}
+
+func putDrafts(drafts map[string]map[string]draft) error {
+	b, err := yaml.Marshal(drafts)
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(draftsDocName, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+draftsDocName, err)
+	}
+	return nil
+}
+
+// openDraft returns the draft named by the name path parameter of the
+// docId path parameter, along with every open draft.
+func openDraft(ctx context.Context, request events.APIGatewayProxyRequest) (draft, map[string]map[string]draft, error) {
+	docId, name := request.PathParameters["docId"], request.PathParameters["name"]
+
+	drafts, err := getDrafts(ctx)
+	if err != nil {
+		return draft{}, nil, err
+	}
+	d, ok := drafts[docId][name]
+	if !ok {
+		return draft{}, nil, docerr.E("draft "+docId+" "+name, docerr.ErrNotFound, nil)
+	}
+	return d, drafts, nil
+}
+
+// latestBody returns the latest published revision of docId, or an empty
+// one if docId doesn't exist yet.
+func latestBody(ctx context.Context, docId string) (fetchedDoc, error) {
+	latest, err := fetchDoc(ctx, docId)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return fetchedDoc{}, nil
+	}
+	return latest, err
+}
+
+// listDrafts reports the open drafts of the docId path parameter.
+func listDrafts(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	drafts, err := getDrafts(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	open := drafts[request.PathParameters["docId"]]
+	if open == nil {
+		open = map[string]draft{}
+	}
+	return jsonResponse(200, struct {
+		Drafts map[string]draft `json:"drafts"`
+	}{open}), nil
+}
+
+// getDraft reports a draft and its text.
+func getDraft(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId, name := request.PathParameters["docId"], request.PathParameters["name"]
+	d, _, err := openDraft(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	flights.Forget("doc:" + draftDocId(docId, name))
+	doc, err := fetchDoc(ctx, draftDocId(docId, name))
+	if err != nil {
+		return Response{}, err
+	}
+	latest, err := latestBody(ctx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	s := draftStatus{
+		DocId:     docId,
+		Name:      name,
+		Base:      d.Base,
+		Latest:    latest.meta.Id,
+		Version:   doc.meta.Id,
+		Timestamp: doc.meta.Timestamp,
+		Body:      string(doc.body),
+	}
+	s.Deleted, s.Inserted = textdiff.Stats(textdiff.Lines(string(latest.body), s.Body))
+	return jsonResponse(200, s), nil
+}
+
+// putDraft writes the request body as a new revision of a draft, opening
+// the draft from the latest published revision if it isn't open. The body
+// is checked as a write of the doc itself would be.
+func putDraft(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId, name := request.PathParameters["docId"], request.PathParameters["name"]
+	op := "draft " + docId + " " + name
+	if !isPage(docId) || strings.HasPrefix(docId, "_") {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	if !validDraftName.MatchString(name) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"name": "lowercase letters, digits, '_' and '-'"})
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	res, err := planWrite(ctx, docId, body)
+	if err != nil {
+		return Response{}, err
+	}
+
+	drafts, err := getDrafts(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	d, ok := drafts[docId][name]
+	if !ok {
+		d = draft{Base: res.BaseVersion, Created: time.Now().UTC()}
+	}
+
+	s := draftStatus{
+		DocId:    docId,
+		Name:     name,
+		Base:     d.Base,
+		Latest:   res.BaseVersion,
+		Inserted: res.Inserted,
+		Deleted:  res.Deleted,
+	}
+	if len(res.Problems) > 0 {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"docId": docId, "problems": res.Problems})
+	}
+	if dryRun(request) {
+		return jsonResponse(200, s), nil
+	}
+
+	rev, err := ds.PutRevision(draftDocId(docId, name), bytes.NewReader(res.body))
+	if err != nil {
+		return Response{}, docerr.FromStore("PutRevision "+draftDocId(docId, name), err)
+	}
+	meta := rev.Metadata()
+	s.Version, s.Timestamp = meta.Id, meta.Timestamp
+
+	if !ok {
+		if drafts[docId] == nil {
+			drafts[docId] = map[string]draft{}
+		}
+		drafts[docId][name] = d
+		err = putDrafts(drafts)
+		if err != nil {
+			return Response{}, err
+		}
+		audit(request, "draft.open", docId, map[string]interface{}{"draft": name, "base": d.Base})
+	}
+
+	return jsonResponse(200, s), nil
+}
+
+// publishDraft writes a draft as the next revision of its doc and closes
+// it. Changes published since the draft branched are merged into it, and
+// if they touch the same lines as the draft the publish is refused with a
+// conflict, unless ?force=true publishes the draft as it is.
+func publishDraft(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId, name := request.PathParameters["docId"], request.PathParameters["name"]
+	op := "publish " + docId + " " + name
+	d, drafts, err := openDraft(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	flights.Forget("doc:" + draftDocId(docId, name))
+	doc, err := fetchDoc(ctx, draftDocId(docId, name))
+	if err != nil {
+		return Response{}, err
+	}
+	latest, err := latestBody(ctx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	body := doc.body
+	merged := latest.meta.Id != d.Base && request.QueryStringParameters["force"] != "true"
+	if merged {
+		var base fetchedDoc
+		if d.Base > 0 {
+			base, err = fetchRevision(ctx, docId, d.Base)
+			if err != nil {
+				return Response{}, err
+			}
+		}
+
+		text, conflicts := textdiff.Merge(string(base.body), string(doc.body), string(latest.body))
+		if conflicts > 0 {
+			return Response{}, docerr.WithDetails(op, docerr.ErrConflict, nil,
+				map[string]interface{}{"base": d.Base, "latest": latest.meta.Id, "conflicts": conflicts})
+		}
+		body = []byte(text)
+	}
+
+	res, err := writeDoc(ctx, docId, body, dryRun(request))
+	if err != nil {
+		return Response{}, err
+	}
+	if res.DryRun {
+		return jsonResponse(200, res), nil
+	}
+
+	delete(drafts[docId], name)
+	if len(drafts[docId]) == 0 {
+		delete(drafts, docId)
+	}
+	err = putDrafts(drafts)
+	if err != nil {
+		return Response{}, err
+	}
+
+	audit(request, "draft.publish", docId, map[string]interface{}{
+		"draft": name, "base": d.Base, "version": res.Version, "merged": merged,
+	})
+	return jsonResponse(200, res), nil
+}
+
+// discardDraft closes a draft without publishing it.
+func discardDraft(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId, name := request.PathParameters["docId"], request.PathParameters["name"]
+	_, drafts, err := openDraft(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	delete(drafts[docId], name)
+	if len(drafts[docId]) == 0 {
+		delete(drafts, docId)
+	}
+	err = putDrafts(drafts)
+	if err != nil {
+		return Response{}, err
+	}
+
+	audit(request, "draft.discard", docId, map[string]interface{}{"draft": name})
+	return Response{StatusCode: 204}, nil
+}
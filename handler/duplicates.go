@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// minhashSize is how many hashes a doc's signature has; similarities
+	// are estimated to within about 1/sqrt(minhashSize).
+	minhashSize = 64
+
+	// shingleWords is how many words each compared run of text has, and
+	// minDuplicateWords how many words a doc needs to be compared at all,
+	// as short docs match one another by chance.
+	shingleWords      = 5
+	minDuplicateWords = 40
+
+	maxDuplicateWarnings = 3
+)
+
+var (
+	// duplicateThreshold is the estimated share of their text two docs
+	// must have in common to be reported as duplicates.
+	duplicateThreshold = envFloat("DUPLICATE_THRESHOLD", 0.8)
+
+	signatures = &signatureCache{entries: map[string]docSignature{}}
+
+	// minhashSeeds vary the hash each place in a signature is taken with.
+	minhashSeeds = func() (seeds [minhashSize]uint64) {
+		x := uint64(0x9e3779b97f4a7c15)
+		for i := range seeds {
+			x = splitmix(x)
+			seeds[i] = x
+		}
+		return
+	}()
+)
+
+// minhash is a doc's MinHash signature: for each of minhashSize hashes,
+// the least hash of its shingles. The share of places two signatures
+// agree estimates the Jaccard similarity of the docs' shingles.
+type minhash [minhashSize]uint64
+
+// docSignature is the signature of a revision of a doc, or ok false if it
+// is too short to compare.
+type docSignature struct {
+	version int
+	sig     minhash
+	ok      bool
+}
+
+type signatureCache struct {
+	sync.Mutex
+	entries map[string]docSignature
+}
+
+// get returns the signature of d, working it out if the revision's isn't
+// cached.
+func (c *signatureCache) get(d docSummary) docSignature {
+	c.Lock()
+	s, ok := c.entries[d.DocId]
+	c.Unlock()
+	if ok && s.version == d.Version {
+		return s
+	}
+
+	sig, ok := signature(d.text)
+	s = docSignature{d.Version, sig, ok}
+	c.Lock()
+	c.entries[d.DocId] = s
+	c.Unlock()
+	return s
+}
+
+func splitmix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// signature returns the MinHash signature of text's runs of shingleWords
+// words, ignoring case and punctuation, or false if text is too short.
+func signature(text string) (minhash, bool) {
+	var sig minhash
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < minDuplicateWords {
+		return sig, false
+	}
+
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+	h := fnv.New64a()
+	for i := 0; i+shingleWords <= len(words); i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:i+shingleWords], " ")))
+		base := h.Sum64()
+		for j, seed := range minhashSeeds {
+			if v := splitmix(base ^ seed); v < sig[j] {
+				sig[j] = v
+			}
+		}
+	}
+	return sig, true
+}
+
+// similarity estimates the share of shingles a and b's docs have in
+// common.
+func (a minhash) similarity(b minhash) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / minhashSize
+}
+
+// duplicatePair is two pages whose text is mostly the same.
+type duplicatePair struct {
+	DocIds     [2]string `json:"docIds"`
+	Titles     [2]string `json:"titles"`
+	Similarity float64   `json:"similarity"`
+}
+
+// duplicateStats reports pairs of pages, listed or not, estimated to share
+// at least the min parameter, or else duplicateThreshold, of their text,
+// most similar first, along with the groups they form, for consolidating.
+func duplicateStats(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	threshold := duplicateThreshold
+	if v := request.QueryStringParameters["min"]; v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			return Response{}, docerr.WithDetails("duplicate stats", docerr.ErrBadRequest, err,
+				map[string]interface{}{"min": "a similarity between 0 and 1"})
+		}
+		threshold = f
+	}
+
+	pages, err := allPages(ctx)
+	if err != nil {
+		return Response{}, docerr.FromStore("duplicate stats", err)
+	}
+	sigs := make([]docSignature, len(pages))
+	for i, d := range pages {
+		sigs[i] = signatures.get(d)
+	}
+
+	pairs := []duplicatePair{}
+	// group maps each page in a pair to the first page of its group.
+	group := map[string]string{}
+	var find func(string) string
+	find = func(docId string) string {
+		if g, ok := group[docId]; ok && g != docId {
+			group[docId] = find(g)
+			return group[docId]
+		}
+		return docId
+	}
+	for i := range pages {
+		if !sigs[i].ok {
+			continue
+		}
+		for j := i + 1; j < len(pages); j++ {
+			if !sigs[j].ok {
+				continue
+			}
+			sim := sigs[i].sig.similarity(sigs[j].sig)
+			if sim < threshold {
+				continue
+			}
+			pairs = append(pairs, duplicatePair{
+				DocIds:     [2]string{pages[i].DocId, pages[j].DocId},
+				Titles:     [2]string{pages[i].Title, pages[j].Title},
+				Similarity: sim,
+			})
+			a, b := find(pages[i].DocId), find(pages[j].DocId)
+			group[a], group[b] = a, a
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Similarity > pairs[j].Similarity })
+
+	members := map[string][]string{}
+	for docId := range group {
+		g := find(docId)
+		members[g] = append(members[g], docId)
+	}
+	groups := [][]string{}
+	for _, m := range members {
+		sort.Strings(m)
+		groups = append(groups, m)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i]) != len(groups[j]) {
+			return len(groups[i]) > len(groups[j])
+		}
+		return groups[i][0] < groups[j][0]
+	})
+
+	return jsonResponse(200, struct {
+		Threshold float64         `json:"threshold"`
+		Pairs     []duplicatePair `json:"pairs"`
+		Groups    [][]string      `json:"groups"`
+	}{threshold, pairs, groups}), nil
+}
+
+// duplicateWarnings returns warnings naming the pages, besides docId
+// itself, whose text body mostly repeats. Like tag suggestions they are
+// best effort, and never hold up a write.
+func duplicateWarnings(ctx context.Context, docId string, body []byte) []string {
+	if !isPage(docId) {
+		return nil
+	}
+	_, md := splitFrontMatter(docId, body)
+	public, _ := filterAudience(md, audiences{})
+	sig, ok := signature(docText(public))
+	if !ok {
+		return nil
+	}
+
+	pages, err := allPages(ctx)
+	if err != nil {
+		log.Printf("duplicates of %s: %v", docId, err)
+		return nil
+	}
+	type similar struct {
+		docId string
+		sim   float64
+	}
+	var found []similar
+	for _, d := range pages {
+		if d.DocId == docId {
+			continue
+		}
+		if s := signatures.get(d); s.ok {
+			if sim := sig.similarity(s.sig); sim >= duplicateThreshold {
+				found = append(found, similar{d.DocId, sim})
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].sim > found[j].sim })
+	if len(found) > maxDuplicateWarnings {
+		found = found[:maxDuplicateWarnings]
+	}
+
+	var warnings []string
+	for _, f := range found {
+		warnings = append(warnings, fmt.Sprintf("about %.0f%% the same as %s; consider consolidating", 100*f.sim, f.docId))
+	}
+	return warnings
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// embedPrefix serves docs for other apps to embed.
+const embedPrefix = "/embed/"
+
+// embedConfig lets other apps embed docs, for example:
+//
+//	embed:
+//	  allowedOrigins: [https://portal.example.com]
+//
+// The origins may frame /embed/ pages and fetch them from scripts.
+// Embedding is off unless allowedOrigins is set.
+type embedConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+// allowOrigin returns origin if it may embed docs, or "" otherwise.
+func (c embedConfig) allowOrigin(origin string) string {
+	for _, o := range c.AllowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// serveEmbed serves /embed/{docId}: the latest revision of a page rendered
+// as an HTML fragment, without the page template, for another app to put
+// in its own page. Links to the site are made absolute when the site
+// config has a baseURL, so they still work there.
+func serveEmbed(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	cfg := getConfig(ctx)
+	docId := strings.TrimPrefix(request.Path, embedPrefix)
+	if len(cfg.Embed.AllowedOrigins) == 0 || docId == "" || strings.Contains(docId, "/") || !isPage(docId) {
+		return Response{}, docerr.E("embed "+request.Path, docerr.ErrNotFound, nil)
+	}
+
+	ctx = withAudiences(ctx, requestAudiences(cfg, request))
+	ctx, private, err := checkAccess(ctx, request, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	rev, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	meta, _, personalized := pageMeta(ctx, docId, rev, nil)
+	body := meta.DocBody
+	if base := strings.TrimSuffix(cfg.BaseURL, "/"); base != "" {
+		body = strings.NewReplacer(`href="/`, `href="`+base+`/`, `src="/`, `src="`+base+`/`).Replace(body)
+	}
+	fragment := fmt.Sprintf("<article class=\"docstore-embed\" data-doc-id=\"%s\" data-version=\"%d\">\n%s</article>\n",
+		html.EscapeString(docId), meta.Version, body)
+
+	// frame-ancestors supersedes any X-Frame-Options the site config sets.
+	headers := map[string]string{
+		"Content-Type":            "text/html; charset=utf-8",
+		"Content-Security-Policy": "frame-ancestors " + strings.Join(cfg.Embed.AllowedOrigins, " "),
+		"X-Robots-Tag":            "noindex",
+		"Vary":                    "Origin",
+	}
+	if allowed := cfg.Embed.allowOrigin(header(request, "Origin")); allowed != "" {
+		headers["Access-Control-Allow-Origin"] = allowed
+	}
+	for k, v := range validators(rev.meta.Id, rev.meta.Timestamp, fragment) {
+		headers[k] = v
+	}
+	if personalized {
+		headers["Cache-Control"] = "private"
+	}
+	if private {
+		headers["Cache-Control"] = privateHeaders["Cache-Control"]
+		headers["Vary"] = "Origin, Authorization"
+	}
+	return withHeaders(Response{StatusCode: 200, Body: fragment}, headers), nil
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envDuration reads a duration such as "2s" from the environment variable
+// key, returning def if it is unset or malformed.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s %q: %v", key, v, err)
+		return def
+	}
+
+	return d
+}
+
+// envBool reports whether the environment variable key is set to a true
+// value such as "1" or "true".
+func envBool(key string) bool {
+	b, err := strconv.ParseBool(os.Getenv(key))
+	return err == nil && b
+}
+
+// envFloat reads a number from the environment variable key, returning def
+// if it is unset or malformed.
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid %s %q: %v", key, v, err)
+		return def
+	}
+
+	return f
+}
+
+// envString reads the environment variable key, returning def if it is
+// unset.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
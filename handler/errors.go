@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+// errorEnvelope is the body of every API error response.
+type errorEnvelope struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	RequestId string                 `json:"requestId"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// errorResponse maps err to the response the client sees and counts it in
+// the error metrics. API routes get a JSON error envelope; pages get a
+// plain message.
+func errorResponse(request events.APIGatewayProxyRequest, err error) Response {
+	log.Printf("error: %v", err)
+	metrics.Incr("Errors", map[string]string{"Kind": docerr.Metric(err)})
+
+	if !strings.HasPrefix(request.Path, apiPrefix) {
+		return Response{
+			StatusCode: docerr.Status(err),
+			Body:       docerr.Message(err),
+			Headers: map[string]string{
+				"Content-Type": "text/plain; charset=utf-8",
+			},
+		}
+	}
+
+	return jsonResponse(docerr.Status(err), errorEnvelope{
+		Code:      docerr.Code(err),
+		Message:   docerr.Message(err),
+		RequestId: request.RequestContext.RequestID,
+		Details:   docerr.Details(err),
+	})
+}
+
+// errorPage is errorResponse, except that readers refused a private doc,
+// and writers turned away by a freeze, get a page in the site's template
+// saying so.
+func errorPage(ctx context.Context, request events.APIGatewayProxyRequest, err error) Response {
+	resp := errorResponse(request, err)
+	status := resp.StatusCode
+	if status == http.StatusLocked {
+		resp = withRetryAfter(resp, err)
+	}
+	if strings.HasPrefix(request.Path, apiPrefix) ||
+		(status != http.StatusUnauthorized && status != http.StatusForbidden && status != http.StatusLocked) {
+		return resp
+	}
+
+	message := docerr.Message(err)
+	if m, ok := docerr.Details(err)["message"].(string); ok && status == http.StatusLocked {
+		message = m
+	}
+	page, perr := renderPage(ctx, tmplDocName, docMetadata{
+		Title:   http.StatusText(status),
+		DocBody: "<p>" + html.EscapeString(message) + "</p>\n",
+		Robots:  "noindex",
+	})
+	if perr != nil {
+		log.Printf("error page: %v", perr)
+		page = resp
+		page.Body = message
+	}
+
+	headers := map[string]string{"Cache-Control": "no-store", "X-Robots-Tag": "noindex"}
+	if status == http.StatusUnauthorized {
+		headers["WWW-Authenticate"] = "Bearer"
+	}
+	if v := resp.Headers["Retry-After"]; v != "" {
+		headers["Retry-After"] = v
+	}
+	page.StatusCode = status
+	return withHeaders(page, headers)
+}
+
+// withRetryAfter tells clients turned away by a freeze when it ends.
+func withRetryAfter(resp Response, err error) Response {
+	ends, ok := docerr.Details(err)["ends"].(time.Time)
+	if !ok {
+		return resp
+	}
+	secs := int(time.Until(ends).Seconds()) + 1
+	if secs < 1 {
+		secs = 1
+	}
+	return withHeaders(resp, map[string]string{"Retry-After": strconv.Itoa(secs)})
+}
+
+// jsonResponse encodes v as the body of a response with status.
+func jsonResponse(status int, v interface{}) Response {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("JSON encoding error: %v", err)
+		return Response{StatusCode: 500}
+	}
+
+	return Response{
+		StatusCode: status,
+		Body:       string(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
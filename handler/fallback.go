@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"errors"
+	"text/template"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// degradedHeader is set on pages rendered with the fallback template.
+const degradedHeader = "X-Docstore-Degraded"
+
+// fallbackTemplate renders pages when the page template can't be fetched,
+// so readers still get the doc, if plainly.
+var fallbackTemplate = template.Must(template.New("docPage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{if .Robots}}<meta name="robots" content="{{.Robots}}">
+{{end}}</head>
+<body>
+<main>
+{{.DocBody}}
+</main>
+<footer>Version {{.Version}}, updated {{.Timestamp}}</footer>
+</body>
+</html>
+`))
+
+// unreachable reports whether err means the docstore couldn't be reached,
+// rather than that the template is missing or broken.
+func unreachable(err error) bool {
+	return errors.Is(err, docerr.ErrBackend) || errors.Is(err, docerr.ErrTimeout)
+}
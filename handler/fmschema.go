@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/drocamor/n22t.docstore/jsonschema"
+	"gopkg.in/yaml.v2"
+)
+
+// schemaDocName holds a JSON Schema that pages' front matter, with its
+// defaults, must meet, for example:
+//
+//	{
+//	  "type": "object",
+//	  "required": ["owner"],
+//	  "properties": {
+//	    "owner": {"type": "string"},
+//	    "status": {"enum": ["draft", "approved"]}
+//	  }
+//	}
+//
+// Like defaults docs, a schema doc for a prefix, like "_schema.ops-",
+// applies to the pages whose docIds start with the prefix, as well as the
+// site wide one. See jsonschema for the keywords understood.
+const schemaDocName = "_schema"
+
+// schemaConfig sets what failing a front matter schema does: "warn", the
+// default, reports it with the write, and "block" stops the write.
+type schemaConfig struct {
+	Policy string `yaml:"policy"`
+}
+
+// isSchemaDoc reports whether docId holds a front matter schema.
+func isSchemaDoc(docId string) bool {
+	return docId == schemaDocName || strings.HasPrefix(docId, schemaDocName+".")
+}
+
+// lintSchema checks that the body of a schema doc parses.
+func lintSchema(body []byte) []string {
+	if _, err := jsonschema.Parse(body); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// checkFrontMatterSchema returns the ways the front matter of body, with
+// the defaults for docId, fails the schemas that apply to docId.
+func checkFrontMatterSchema(ctx context.Context, docId string, body []byte) []string {
+	if !isPage(docId) {
+		return nil
+	}
+
+	block, _, _ := frontMatterBlock(body)
+	block, err := applyDefaults(docId, block)
+	if err != nil {
+		return []string{"front matter: " + err.Error()}
+	}
+	var fm interface{}
+	err = yaml.Unmarshal(block, &fm)
+	if err != nil {
+		return []string{"front matter: " + err.Error()}
+	}
+	if fm == nil {
+		fm = map[string]interface{}{}
+	}
+
+	var problems []string
+	for _, name := range prefixDocs(schemaDocName, docId) {
+		doc, ok := getSystemDoc(ctx, name)
+		if !ok {
+			continue
+		}
+		schema, err := jsonschema.Parse(doc)
+		if err != nil {
+			log.Printf("%s error: %v", name, err)
+			continue
+		}
+		for _, e := range schema.Validate(fm) {
+			problems = append(problems, fmt.Sprintf("front matter: %v (%s)", e, name))
+		}
+	}
+	return problems
+}
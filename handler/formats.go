@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Formats a page can be read in besides its rendered page: its markdown,
+// its text without markup, or JSON with both and its rendered body and
+// front matter. Readers ask for one with the Accept header or, since many
+// tools can't set headers, a suffix on the doc's path: /guide.md or
+// /docs/guide.json. A doc named with the suffix, like an asset, takes
+// precedence.
+const (
+	formatHTML     = "html"
+	formatMarkdown = "md"
+	formatJSON     = "json"
+	formatText     = "txt"
+)
+
+// formatTypes are the media types of the formats.
+var formatTypes = map[string]string{
+	formatHTML:     "text/html",
+	formatMarkdown: "text/markdown",
+	formatJSON:     "application/json",
+	formatText:     "text/plain",
+}
+
+// splitFormat splits a format suffix off docId, returning the page it
+// names and the format, or false if docId has no such suffix.
+func splitFormat(docId string) (string, string, bool) {
+	i := strings.LastIndex(docId, ".")
+	if i < 0 {
+		return docId, "", false
+	}
+	base, format := docId[:i], docId[i+1:]
+	if _, ok := formatTypes[format]; !ok || base == "" || !isPage(base) {
+		return docId, "", false
+	}
+	return base, format, true
+}
+
+// acceptFormat returns the format the Accept header of request prefers,
+// the rendered page unless it prefers another.
+func acceptFormat(request events.APIGatewayProxyRequest) string {
+	best, bestQ := formatHTML, 0.0
+	for _, part := range strings.Split(header(request, "Accept"), ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		for format, t := range formatTypes {
+			// Ties go to the rendered page.
+			if t == mediaType && (q > bestQ || q == bestQ && format == formatHTML) {
+				best, bestQ = format, q
+			}
+		}
+	}
+	return best
+}
+
+// serveFormat answers with the latest revision of docId in format, as the
+// reader of ctx may see it.
+func serveFormat(ctx context.Context, docId, format string) (Response, error) {
+	if format == formatHTML {
+		return serveDoc(ctx, docId)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	rev, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	noteRevision(ctx, "doc", docId, rev.meta.Id)
+
+	fm, md := splitFrontMatter(docId, rev.body)
+	directives, _ := parseDirectives(md)
+	md = substituteVariables(ctx, docId, md)
+	md, personalized := filterAudience(md, audiencesFrom(ctx))
+
+	var resp Response
+	switch format {
+	case formatMarkdown:
+		resp = Response{StatusCode: 200, Body: string(md)}
+	case formatText:
+		resp = Response{StatusCode: 200, Body: docText(md)}
+	case formatJSON:
+		html, toc, _ := renderMarkdown(md, docResolver(ctx), directives.options(fm, markdownOptions{
+			StripHTML: getConfig(ctx).stripsHTML(docId),
+			Engine:    getConfig(ctx).Markdown.Engine,
+		}))
+		if !directives.showsTOC() {
+			toc = nil
+		}
+		resp = jsonResponse(200, struct {
+			DocId     string     `json:"docId"`
+			Version   int        `json:"version"`
+			Timestamp time.Time  `json:"timestamp"`
+			Title     string     `json:"title"`
+			Tags      []string   `json:"tags,omitempty"`
+			Params    docParams  `json:"params,omitempty"`
+			TOC       []tocEntry `json:"toc,omitempty"`
+			Markdown  string     `json:"markdown"`
+			Text      string     `json:"text"`
+			HTML      string     `json:"html"`
+		}{docId, rev.meta.Id, rev.meta.Timestamp, fm.title(md), fm.Tags,
+			frontMatterParams(docId, rev.body), toc, string(md), docText(md), string(html)})
+	}
+
+	headers := validators(rev.meta.Id, rev.meta.Timestamp, resp.Body)
+	if format != formatJSON {
+		headers["Content-Type"] = formatTypes[format] + "; charset=utf-8"
+	}
+	if personalized {
+		headers["Cache-Control"] = "private"
+	}
+	return withHeaders(resp, headers), nil
+}
+
+// serveFormatSuffix answers a request for docId whose name ends with a
+// format suffix, and which isn't a doc, with the page it names in that
+// format.
+func serveFormatSuffix(ctx context.Context, request events.APIGatewayProxyRequest, docId string) (Response, bool, error) {
+	base, format, ok := splitFormat(docId)
+	if !ok || (request.Path != "/"+docId && request.Path != docsPrefix+docId) {
+		return Response{}, false, nil
+	}
+
+	ctx, private, err := checkAccess(ctx, request, base)
+	if err != nil {
+		return Response{}, true, err
+	}
+	resp, err := serveFormat(withAudiences(ctx, requestAudiences(getConfig(ctx), request)), base, format)
+	if err != nil {
+		return Response{}, true, err
+	}
+	if private {
+		resp = withHeaders(resp, privateHeaders)
+	}
+	return resp, true, nil
+}
+
+// withVary returns a copy of resp that varies by field as well as by what
+// it already varied by.
+func withVary(resp Response, field string) Response {
+	if v := resp.Headers["Vary"]; v != "" {
+		field = v + ", " + field
+	}
+	return withHeaders(resp, map[string]string{"Vary": field})
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// freezeWindow is a time docs aren't changed in, like a release weekend,
+// set in the site config:
+//
+//	freezes:
+//	- name: 2.0 release
+//	  start: 2020-10-02T17:00:00Z
+//	  end: 2020-10-05T09:00:00Z
+//	  message: The docs are frozen for the 2.0 release until Monday.
+//	  prefixes: [/releases/, /guide/]
+//
+// Writes to the docs it covers, all of them unless it lists prefixes, are
+// refused with 423 Locked while it lasts, except by admins, whose writes
+// are recorded in the audit log as overrides. Drafts and suggestions can
+// still be written, to be published once the window ends.
+type freezeWindow struct {
+	Name     string    `yaml:"name"`
+	Start    time.Time `yaml:"start"`
+	End      time.Time `yaml:"end"`
+	Message  string    `yaml:"message"`
+	Prefixes []string  `yaml:"prefixes"`
+}
+
+// covers reports whether w freezes docId at t.
+func (w freezeWindow) covers(docId string, t time.Time) bool {
+	if t.Before(w.Start) || !t.Before(w.End) {
+		return false
+	}
+	if len(w.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range w.Prefixes {
+		if strings.HasPrefix("/"+docId, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// message explains w to the writers it turns away.
+func (w freezeWindow) message(tf timeFormat) string {
+	if w.Message != "" {
+		return w.Message
+	}
+	if w.Name != "" {
+		return fmt.Sprintf("Docs are frozen for %s until %s.", w.Name, tf.format(w.End))
+	}
+	return fmt.Sprintf("Docs are frozen until %s.", tf.format(w.End))
+}
+
+// activeFreeze returns the window freezing docId now, the one ending last
+// if several overlap, or nil if none does.
+func activeFreeze(cfg *siteConfig, docId string) *freezeWindow {
+	now := time.Now()
+	var active *freezeWindow
+	for i, w := range cfg.Freezes {
+		if w.covers(docId, now) && (active == nil || w.End.After(active.End)) {
+			active = &cfg.Freezes[i]
+		}
+	}
+	return active
+}
+
+// checkFreeze refuses a write to docId while a window freezes it, unless
+// the client is an admin. It returns the window an admin's write
+// overrides, if any.
+func checkFreeze(ctx context.Context, docId string) (*freezeWindow, error) {
+	w := activeFreeze(getConfig(ctx), docId)
+	if w == nil || roleFrom(ctx) == roleAdmin {
+		return w, nil
+	}
+	return nil, docerr.WithDetails("write "+docId, docerr.ErrLocked, nil, map[string]interface{}{
+		"docId":   docId,
+		"freeze":  w.Name,
+		"ends":    w.End.UTC(),
+		"message": w.message(renderFrom(ctx).tf),
+	})
+}
+
+// auditFreezeOverride records an admin's write made during a freeze.
+func auditFreezeOverride(ctx context.Context, w *freezeWindow, res writeResult) {
+	audit(auditRequestFrom(ctx), "freeze.override", res.DocId, map[string]interface{}{
+		"freeze":  w.Name,
+		"ends":    w.End.UTC(),
+		"action":  res.Action,
+		"version": res.Version,
+		"role":    roleFrom(ctx),
+	})
+}
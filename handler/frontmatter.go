@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// frontMatter is the YAML block a doc may start with, between "---" lines:
+//
+//	---
+//	headers:
+//	  X-Robots-Tag: noindex
+//	---
+//	Title
+//
+//	Body...
+type frontMatter struct {
+	// Title replaces the doc's first line as its title.
+	Title string `yaml:"title"`
+
+	// Template names the doc's page template, like "wide" for
+	// "wide-template.html", instead of the default.
+	Template string `yaml:"template"`
+
+	// Tags and Date are given to the page template. Date is the date the
+	// doc was written, like 2020-09-01, rather than of its last revision.
+	Tags []string `yaml:"tags"`
+	Date string   `yaml:"date"`
+
+	// Headers are response headers for the doc. Only those in
+	// docHeaderAllowlist are used.
+	Headers map[string]string `yaml:"headers"`
+
+	// Draft and Archived docs are still served but ask search engines not
+	// to index them.
+	Draft    bool `yaml:"draft"`
+	Archived bool `yaml:"archived"`
+
+	// Unlisted docs are served to anyone with the URL but left out of
+	// every listing of docs: indexes, search, feeds, sitemaps and related
+	// pages.
+	Unlisted bool `yaml:"unlisted"`
+
+	// Private docs are only served to signed in readers, and only to
+	// those in one of the Access groups if any are given. They are left
+	// out of listings like unlisted docs. See access.go.
+	Private bool     `yaml:"private"`
+	Access  []string `yaml:"access"`
+
+	// Pinned docs are listed ahead of the rest regardless of when they
+	// were updated. Featured docs are offered to templates separately,
+	// for instance for a home page.
+	Pinned   bool `yaml:"pinned"`
+	Featured bool `yaml:"featured"`
+
+	// Description, Author and SchemaType feed the doc's structured data.
+	// SchemaType is a schema.org type and defaults to Article.
+	Description string `yaml:"description"`
+	Author      string `yaml:"author"`
+	SchemaType  string `yaml:"schemaType"`
+
+	// Status, like "draft" or "approved", and Owner describe the doc's
+	// upkeep, for badges.
+	Status string `yaml:"status"`
+	Owner  string `yaml:"owner"`
+
+	// Type "log" makes the doc a changelog of timestamped entries; see
+	// logdoc.go.
+	Type string `yaml:"type"`
+
+	// Numbered docs, like specifications, have their headings numbered
+	// 1.2.3 and their figures and tables numbered with captions; see
+	// numbering.go.
+	Numbered bool `yaml:"numbered"`
+
+	// Aliases are the doc's old names, which are redirected to it while
+	// no doc has them; see redirects.go.
+	Aliases []string `yaml:"aliases"`
+
+	// Blocks override blocks of the doc's template by name; see blocks.go.
+	Blocks map[string]string `yaml:"blocks"`
+
+	// Source is where a doc synced from another system comes from; see
+	// provenance.go.
+	Source *docSource `yaml:"source"`
+
+	// Canonical is the URL of the doc on the site that is authoritative
+	// for it, for docs mirrored from another site, so search engines
+	// index that rather than count this as a duplicate; see canonical.go.
+	Canonical string `yaml:"canonical"`
+}
+
+// title returns the doc's title: its front matter title, or else the
+// first line of body.
+func (fm frontMatter) title(body []byte) string {
+	if fm.Title != "" {
+		return fm.Title
+	}
+	return firstLine(body)
+}
+
+// templateName returns the doc holding the doc's page template, or def if
+// it doesn't choose one.
+func (fm frontMatter) templateName(def string) string {
+	switch {
+	case fm.Template == "":
+		return def
+	case strings.HasSuffix(fm.Template, ".html"):
+		return fm.Template
+	}
+	return fm.Template + "-template.html"
+}
+
+// date returns the front matter date in the time format, or as written if
+// it isn't a date the format understands.
+func (fm frontMatter) date(tf timeFormat) string {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, fm.Date); err == nil {
+			return tf.format(t)
+		}
+	}
+	return fm.Date
+}
+
+// listed reports whether the doc may appear in listings of docs.
+func (fm frontMatter) listed() bool {
+	return !fm.Unlisted && !fm.Private && len(fm.Access) == 0
+}
+
+// robots returns the robots directives for the doc, or "" for none.
+func (fm frontMatter) robots() string {
+	switch {
+	case fm.Draft:
+		return "noindex, nofollow"
+	case fm.Archived, fm.Unlisted:
+		return "noindex"
+	}
+	return ""
+}
+
+// docHeaderAllowlist is the set of response headers a doc may set.
+var docHeaderAllowlist = map[string]bool{
+	"Cache-Control":    true,
+	"Content-Language": true,
+	"Expires":          true,
+	"Referrer-Policy":  true,
+	"X-Robots-Tag":     true,
+}
+
+// splitFrontMatter separates a leading front matter block from the rest of
+// doc, with the defaults for docId merged under it; see defaults.go. Docs
+// without one are returned unchanged, with only the defaults. Malformed
+// front matter is logged and ignored.
+func splitFrontMatter(docId string, doc []byte) (fm frontMatter, body []byte) {
+	block, body, ok := frontMatterBlock(doc)
+	if !ok {
+		body = doc
+	}
+
+	block, err := applyDefaults(docId, block)
+	if err == nil {
+		err = yaml.Unmarshal(block, &fm)
+	}
+	if err != nil {
+		log.Printf("front matter error in %s: %v", docId, err)
+		fm = frontMatter{}
+	}
+	return fm, body
+}
+
+// frontMatterBlock returns the YAML between the fences of doc's front
+// matter and the body after it, if doc has front matter.
+func frontMatterBlock(doc []byte) (block, body []byte, ok bool) {
+	lines := bytes.SplitAfter(doc, []byte("\n"))
+	if len(lines) == 0 || !isFence(lines[0]) {
+		return nil, doc, false
+	}
+
+	for i := 1; i < len(lines); i++ {
+		if !isFence(lines[i]) && strings.TrimRight(string(lines[i]), "\r\n") != "..." {
+			continue
+		}
+
+		return bytes.Join(lines[1:i], nil), bytes.Join(lines[i+1:], nil), true
+	}
+
+	// No closing fence, so this is a thematic break rather than front
+	// matter.
+	return nil, doc, false
+}
+
+func isFence(line []byte) bool {
+	return strings.TrimRight(string(line), "\r\n") == "---"
+}
+
+// headers returns the doc's allowlisted response headers.
+func (fm frontMatter) headers(docId string) map[string]string {
+	h := map[string]string{}
+	for k, v := range fm.Headers {
+		k = http.CanonicalHeaderKey(k)
+		if !docHeaderAllowlist[k] || strings.ContainsAny(v, "\r\n") {
+			log.Printf("ignoring header %q in %s", k, docId)
+			continue
+		}
+		h[k] = v
+	}
+	return h
+}
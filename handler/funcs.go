@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"text/template"
+)
+
+// templateFuncs are available to every page template.
+var templateFuncs = template.FuncMap{
+	// allDocs lists every listed doc, pinned docs first.
+	"allDocs": func() []docSummary {
+		return catalogDocs(func(c catalog) []docSummary { return c })
+	},
+	"pinnedDocs": func() []docSummary {
+		return catalogDocs(catalog.pinned)
+	},
+	"featuredDocs": func() []docSummary {
+		return catalogDocs(catalog.featured)
+	},
+	// recentlyUpdated lists the n listed docs updated last.
+	"recentlyUpdated": func(n int) []docSummary {
+		return catalogDocs(func(c catalog) []docSummary { return c.recentlyUpdated(n) })
+	},
+
+	// asset returns the revision stamped URL of a store asset, for
+	// references stampAssets can't see, like images.
+	"asset": func(docId string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+
+		info, err := getAsset(ctx, docId)
+		if err != nil {
+			log.Printf("asset %s: %v", docId, err)
+			return "/" + docId
+		}
+		return assetURL(docId, info.revision)
+	},
+
+	// var returns a site variable, as {{var "key"}} does in docs.
+	"var": func(key string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+
+		return getVariables(ctx)[key]
+	},
+
+	// beaconScript reports which sections of the page readers visit.
+	"beaconScript": func() string {
+		return beaconScript
+	},
+
+	// recentScript remembers the page among the reader's recently viewed
+	// docs, which the home page gets as RecentlyViewed.
+	"recentScript": func() string {
+		return recentScript
+	},
+
+	// offlineTags links the web app manifest and registers the service
+	// worker when the site is readable offline.
+	"offlineTags": func() string {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+
+		return offlineTags(getConfig(ctx))
+	},
+}
+
+// catalogDocs selects docs from the catalog for a template. The catalog is
+// only built when a template asks for it, and a page still renders,
+// without the listing, if it can't be.
+func catalogDocs(sel func(catalog) []docSummary) []docSummary {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	c, err := getCatalog(ctx)
+	if err != nil {
+		log.Printf("catalog error: %v", err)
+		return nil
+	}
+	return sel(c)
+}
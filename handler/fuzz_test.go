@@ -0,0 +1,142 @@
+//go:build go1.18
+// +build go1.18
+
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// renderDeadline bounds how long a single fuzz input may take before it is
+// reported as a stall that would tie up a Lambda invocation.
+const renderDeadline = 10 * time.Second
+
+// addSeeds adds the golden corpus and some pathological inputs.
+func addSeeds(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join(goldenDocsDir, "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, name := range files {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Add([]byte(sampleDoc(3)))
+	f.Add([]byte(strings.Repeat("- ", 500) + "item"))
+	f.Add([]byte(strings.Repeat(">", 500) + " quote"))
+	f.Add([]byte(strings.Repeat("[", 500) + strings.Repeat("]", 500)))
+	f.Add([]byte(strings.Repeat("*_", 500)))
+	f.Add([]byte("```\nunterminated fence"))
+	f.Add([]byte("<div>\n<script>alert(1)</script>"))
+	f.Add([]byte{0xff, 0xfe, 0x00, '\r', '\n'})
+}
+
+// withinDeadline runs fn, failing the test if it panics or stalls.
+func withinDeadline(t *testing.T, fn func()) {
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() { done <- recover() }()
+		fn()
+	}()
+
+	select {
+	case p := <-done:
+		if p != nil {
+			t.Fatalf("panic: %v", p)
+		}
+	case <-time.After(renderDeadline):
+		t.Fatalf("render took longer than %v", renderDeadline)
+	}
+}
+
+func FuzzMarkdown(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		withinDeadline(t, func() {
+			renderMarkdown(doc, nil, markdownOptions{})
+			renderMarkdown(doc, nil, markdownOptions{Numbered: true, StripHTML: true})
+		})
+	})
+}
+
+func FuzzRender(f *testing.F) {
+	store := newMemStore()
+	store.put(tmplDocName, testTemplate)
+	fuzzRender(f, store)
+}
+
+// FuzzRenderMinified fuzzes the render pipeline with the passes the config
+// turns on, minifying and inlining critical CSS, after the template.
+func FuzzRenderMinified(f *testing.F) {
+	store := newMemStore()
+	store.put(tmplDocName, testTemplate)
+	store.put(configDocName, "minify: true\n")
+	store.put(criticalCSSDocName, "body{margin:0}")
+	fuzzRender(f, store)
+}
+
+// fuzzRender checks that every input renders against the docs in store.
+func fuzzRender(f *testing.F, store *memStore) {
+	addSeeds(f)
+	f.Add([]byte("# \u212a\u212a\u212a\u212a\n\n<pre>  x  </pre>"))
+	f.Add([]byte("---\ntitle: \u0130stanbul \u0130zmir\n---\nbody"))
+	f.Add([]byte("<!-- unterminated\n\nvoil\u00e0 \u00c5ngstr\u00f6m"))
+	useStore(store)
+
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		withinDeadline(t, func() {
+			resp, err := renderRevision(context.Background(), "fuzz", fetchedDoc{body: doc})
+			if err != nil || resp.StatusCode != 200 {
+				t.Errorf("status %d, err %v", resp.StatusCode, err)
+			}
+		})
+	})
+}
+
+func FuzzMinify(f *testing.F) {
+	addSeeds(f)
+	f.Add([]byte("<p>\u212a\u212a\u212a\u212a</p><pre> x </pre>"))
+	f.Add([]byte("<PRE>unterminated"))
+	f.Add([]byte("voilà <!-- unterminated"))
+	f.Fuzz(func(t *testing.T, page []byte) {
+		withinDeadline(t, func() {
+			out := minifyHTML(string(page))
+			if utf8.Valid(page) && !utf8.ValidString(out) {
+				t.Errorf("minifyHTML(%q) = %q, not valid UTF-8", page, out)
+			}
+		})
+	})
+}
+
+func FuzzFrontMatter(f *testing.F) {
+	addSeeds(f)
+	f.Add([]byte("---\ntitle: t\n---\nbody"))
+	f.Add([]byte("---\r\ntitle: t\r\n...\r\n"))
+	f.Add([]byte("---\n---"))
+	f.Add([]byte("---\nno closing fence"))
+	f.Add([]byte("---\n[unbalanced\n---\n"))
+	f.Add([]byte("---\na: &a [*a]\n---\n"))
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		withinDeadline(t, func() {
+			block, body, ok := frontMatterBlock(doc)
+			if !ok && !bytes.Equal(body, doc) {
+				t.Errorf("frontMatterBlock(%q) without front matter changed the body to %q", doc, body)
+			}
+			if ok && len(block)+len(body) > len(doc) {
+				t.Errorf("frontMatterBlock(%q) = %q, %q, longer than the doc", doc, block, body)
+			}
+			splitFrontMatter("fuzz", doc)
+		})
+	})
+}
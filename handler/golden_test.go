@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const (
+	goldenDocsDir = "testdata/docs"
+	goldenDir     = "testdata/golden"
+)
+
+// loadGoldenStore loads every file in testdata/docs into a memStore as a
+// doc named after the file.
+func loadGoldenStore(t *testing.T) (*memStore, []string) {
+	files, err := ioutil.ReadDir(goldenDocsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemStore()
+	var docIds []string
+	for _, f := range files {
+		body, err := ioutil.ReadFile(filepath.Join(goldenDocsDir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.put(f.Name(), string(body))
+		docIds = append(docIds, f.Name())
+	}
+
+	return store, docIds
+}
+
+// goldenTraceparent is sent with every golden request so the trace id on
+// the responses is stable.
+const goldenTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+var spanId = regexp.MustCompile(`-([0-9a-f]{32})-[0-9a-f]{16}-`)
+
+// dumpResponse formats the parts of a response the golden files pin down.
+func dumpResponse(resp Response) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Status: %d\n", resp.StatusCode)
+	var keys []string
+	for k := range resp.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := resp.Headers[k]
+		if k == "Traceparent" {
+			// The span id is new on every request.
+			v = spanId.ReplaceAllString(v, "-${1}-xxxxxxxxxxxxxxxx-")
+		}
+		fmt.Fprintf(&b, "%s: %s\n", k, v)
+	}
+	fmt.Fprintf(&b, "Base64: %t\n\n", resp.IsBase64Encoded)
+	b.WriteString(resp.Body)
+	return b.Bytes()
+}
+
+// TestGolden renders every doc in testdata/docs through the handler and
+// compares the response with testdata/golden/{docId}.golden. Run
+// "go test ./handler -update" to rewrite the golden files after an intended
+// change to rendering.
+func TestGolden(t *testing.T) {
+	store, docIds := loadGoldenStore(t)
+	useStore(store)
+
+	for _, docId := range docIds {
+		t.Run(docId, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{
+				Path:           "/" + docId,
+				PathParameters: map[string]string{"docId": docId},
+				Headers:        map[string]string{"traceparent": goldenTraceparent},
+			}
+			resp, err := Handler(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := dumpResponse(resp)
+
+			golden := filepath.Join(goldenDir, docId+".golden")
+			if *update {
+				err = ioutil.WriteFile(golden, got, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response for %s differs from %s:\ngot:\n%s\nwant:\n%s", docId, golden, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,763 @@
+// Package handler serves a doc store site: its pages, feeds and the JSON
+// API, from API Gateway events. It reaches docs only through a
+// docstore.DocStore, the DynamoDB tables unless UseStore picks another,
+// so the docs command runs it in Lambda and the local command against a
+// directory.
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/faultstore"
+	"github.com/drocamor/n22t.docstore/freshstore"
+	"github.com/drocamor/n22t.docstore/metrics"
+	"golang.org/x/sync/singleflight"
+)
+
+// Response is of type APIGatewayProxyResponse since we're leveraging the
+// AWS Lambda Proxy Request functionality (default behavior)
+//
+// https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
+type Response events.APIGatewayProxyResponse
+
+type docMetadata struct {
+	Title, DocBody, Timestamp string
+	Version                   int
+
+	// TimestampISO is the timestamp in ISO 8601 form, for machines.
+	TimestampISO string
+
+	// UpdatedAgo is how long ago the revision was made, like "3 days ago".
+	UpdatedAgo string
+
+	// OldRevision is set when the page shows a revision other than the
+	// latest.
+	OldRevision *revisionBanner
+
+	// Mirror is set when the site is a read-only mirror of another.
+	Mirror *mirrorBanner
+
+	// Source is set on pages synced from another system, like GitHub.
+	Source *sourceBanner
+
+	// AsOf is set when the reader is browsing the site as it was at a
+	// past instant.
+	AsOf *asOfBanner
+
+	// Robots holds directives for a robots meta tag, like "noindex", or
+	// is empty if crawlers may index the page.
+	Robots string
+
+	// JSONLD is a script element with schema.org structured data for the
+	// page, for templates to place in the head.
+	JSONLD string
+
+	// OEmbed is a link element for the head telling oEmbed consumers,
+	// like chat apps unfurling links, where to get a card for the page.
+	OEmbed string
+
+	// Canonical is a link element for the head giving the URL search
+	// engines should index the page at: its own, or the authoritative
+	// site's for a mirrored doc.
+	Canonical string
+
+	// Permalink is the permanent URL of the revision shown.
+	Permalink string
+
+	// Revision describes the revision shown: its content type, size and
+	// hash, and its author and message when they were recorded.
+	Revision revisionMeta
+
+	// Summary is an abstract of the doc, when summaries are on and one is
+	// at hand.
+	Summary string
+
+	// Audio is the URL of the doc read aloud, for an audio player, when
+	// audio is on.
+	Audio string
+
+	// Tags and Date come from the doc's front matter.
+	Tags []string
+	Date string
+
+	// Params holds every field of the doc's front matter, for templates to
+	// read typed values from. See docParams.
+	Params docParams
+
+	// TOC lists the doc's headings, for templates that show a table of
+	// contents.
+	TOC []tocEntry
+
+	// Footnotes lists the doc's footnotes, for themes showing them in
+	// popovers by their references.
+	Footnotes []footnote
+
+	// Docs lists every doc, most recently updated first, on the generated
+	// index page.
+	Docs []docSummary
+
+	// RecentlyViewed lists the listed docs the reader viewed last, from
+	// their recent cookie, on the home page.
+	RecentlyViewed []docSummary
+
+	// Render is the render context of the request; see renderctx.go.
+	Render *renderContext
+
+	// Pager is set on the pages of a doc split at page breaks, for pager
+	// controls. See pages.go.
+	Pager *docPager
+
+	// nav finds the docs for the Prev and Next methods.
+	nav *docNav
+
+	// blocks override blocks of the page's template; see blocks.go.
+	blocks []string
+}
+
+const (
+	tmplDocName = "doc-template.html"
+
+	// apiPrefix is where the JSON API routes live.
+	apiPrefix = "/api/"
+)
+
+var (
+	ds docstore.DocStore
+
+	// flights coalesces concurrent docstore fetches and renders within a
+	// warm container so a burst of requests for one page costs one backend
+	// call.
+	flights singleflight.Group
+
+	// freshWindow is how long a warm container answers reads of a doc it
+	// wrote with the revision it wrote, if the store returns an older one,
+	// while the store's eventually consistent reads catch up.
+	freshWindow = envDuration("FRESH_WINDOW", 10*time.Second)
+
+	// templateTTL is how long a warm container reuses a parsed template
+	// before fetching it again.
+	templateTTL = envDuration("TEMPLATE_TTL", time.Minute)
+
+	templates = &templateCache{entries: map[string]cachedTemplate{}}
+)
+
+// fetchedDoc is a revision read fully into memory so that it can be shared
+// between coalesced callers.
+type fetchedDoc struct {
+	meta docstore.RevisionMetadata
+	body []byte
+}
+
+func init() {
+	if mirrorArchive != "" {
+		ds = openMirrorArchive()
+	} else {
+		ds = withMounts(withStagingMirror(awsdocstore.New(storeOptions()...), openTables), openTables)
+	}
+
+	// Fault injection is for exercising resilience in dev and stage, never
+	// prod.
+	if envBool("FAULT_INJECTION") && os.Getenv("STAGE") != "prod" {
+		log.Printf("injecting docstore faults")
+		ds = faultstore.New(ds,
+			faultstore.WithLatency(envDuration("FAULT_LATENCY", 0)),
+			faultstore.WithErrorRate(envFloat("FAULT_ERROR_RATE", 0)),
+			faultstore.WithTruncateRate(envFloat("FAULT_TRUNCATE_RATE", 0)),
+		)
+	}
+
+	ds = withReadCache(ds)
+
+	// Editors read what they just wrote, whatever the store and the cache
+	// in front of it return for a moment after the write.
+	ds = freshstore.New(ds, freshstore.WithWindow(freshWindow))
+
+	loadThemeBundle()
+}
+
+// UseStore serves the site from store, with the storeMounts opened by
+// open, instead of from the DynamoDB tables.
+func UseStore(store docstore.DocStore, open func(where string) docstore.DocStore) {
+	ds = withMounts(store, open)
+}
+
+func firstLine(b []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Scan()
+	return scanner.Text()
+}
+
+// fetchDoc gets the latest revision of docId, sharing the result with any
+// concurrent fetch of the same doc. It gives up when ctx is done, leaving
+// the shared fetch to finish for anyone else waiting on it. A revision
+// already fetched for the request, by checkAccess, is reused.
+func fetchDoc(ctx context.Context, docId string) (doc fetchedDoc, err error) {
+	if t, ok := asOfFrom(ctx); ok {
+		return fetchDocAt(ctx, docId, t)
+	}
+	if doc, ok := ctx.Value(fetchedKey{docId}).(fetchedDoc); ok {
+		noteInput(ctx, "doc", docId, doc.meta.Id)
+		return doc, nil
+	}
+	defer func() { noteFetch(ctx, docId, doc, err) }()
+
+	ch := flights.DoChan("doc:"+docId, func() (interface{}, error) {
+		rev, err := ds.GetDoc(docId)
+		if err != nil {
+			return nil, docerr.FromStore("GetDoc "+docId, err)
+		}
+
+		body, err := readDoc("GetDoc "+docId, docId, rev)
+		if err != nil {
+			return nil, err
+		}
+
+		return fetchedDoc{meta: rev.Metadata(), body: body}, nil
+	})
+
+	v, err := await(ctx, "GetDoc "+docId, ch)
+	if err != nil {
+		return
+	}
+
+	doc = v.(fetchedDoc)
+	return
+}
+
+// errNoTemplate is wrapped by the template error of a template that
+// doesn't exist, for renders that fall back to another.
+var errNoTemplate = errors.New("no such template")
+
+// missingTemplate reports whether err is from rendering with a template
+// that doesn't exist.
+func missingTemplate(err error) bool {
+	return errors.Is(err, errNoTemplate)
+}
+
+// getTemplate fetches and parses the template stored as the doc name, or
+// bundled as it in the theme bundle. A warm container reuses the parsed
+// template for templateTTL.
+func getTemplate(ctx context.Context, name string) (tmpl *template.Template, err error) {
+	if t, ok := asOfFrom(ctx); ok {
+		return templateAt(ctx, name, t)
+	}
+	key := name + variantSuffix(ctx)
+	if c, ok := templates.get(key); ok {
+		noteCache(ctx, "template", "hit")
+		noteRevision(ctx, "template", name, c.version)
+		return c.tmpl, nil
+	}
+
+	ch := flights.DoChan("tmpl:"+key, func() (interface{}, error) {
+		tmplDoc, err := themeDoc(withVariant(context.Background(), variantFrom(ctx)), name)
+		if errors.Is(err, docerr.ErrNotFound) {
+			return nil, docerr.E("template "+name, docerr.ErrTemplate, errNoTemplate)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := template.New("docPage").Funcs(templateFuncs).Parse(string(tmplDoc.body))
+		if err != nil {
+			return nil, docerr.E("parse "+name, docerr.ErrTemplate, err)
+		}
+
+		c := cachedTemplate{tmpl, tmplDoc.meta.Id, time.Now()}
+		templates.put(key, c)
+		return c, nil
+	})
+
+	v, err := await(ctx, "template "+name, ch)
+	if err != nil {
+		return
+	}
+
+	c := v.(cachedTemplate)
+	noteCache(ctx, "template", "miss")
+	noteRevision(ctx, "template", name, c.version)
+	return c.tmpl, nil
+}
+
+type cachedTemplate struct {
+	tmpl    *template.Template
+	version int
+	fetched time.Time
+}
+
+// templateCache holds parsed templates by doc name.
+type templateCache struct {
+	sync.Mutex
+	entries map[string]cachedTemplate
+}
+
+func (c *templateCache) get(name string) (cachedTemplate, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[name]
+	if !ok || time.Since(e.fetched) >= templateTTL {
+		return cachedTemplate{}, false
+	}
+	return e, true
+}
+
+func (c *templateCache) put(name string, e cachedTemplate) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[name] = e
+}
+
+// renderDoc fetches the latest revision of docId and renders it into a
+// Response. Concurrent renders of the same revision are coalesced.
+func renderDoc(ctx context.Context, docId string) (Response, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	fetched := timePhase(ctx, "fetch")
+	doc, err := fetchDoc(fetchCtx, docId)
+	fetched()
+	if err != nil {
+		return Response{}, err
+	}
+
+	key := fmt.Sprintf("render:%s@%d%s", docId, doc.meta.Id, varies(ctx))
+	ch := flights.DoChan(key, func() (interface{}, error) {
+		// The inputs travel with the render, so every caller it is shared
+		// with learns them.
+		rctx, in := withRenderInputs(detach(ctx))
+		resp, err := renderRevision(rctx, docId, doc)
+		return renderedPage{resp, in}, err
+	})
+
+	v, err := await(ctx, "render "+docId, ch)
+	if err != nil {
+		return Response{}, err
+	}
+
+	page := v.(renderedPage)
+	noteInputs(ctx, page.inputs)
+	resp := page.resp
+	if resp.StatusCode == 200 {
+		maybeShadow(ctx, docId, doc, resp)
+	}
+
+	return resp, nil
+}
+
+// renderRevision renders a fetched revision of docId into a Response.
+func renderRevision(ctx context.Context, docId string, rev fetchedDoc) (Response, error) {
+	return renderWith(ctx, docId, rev, tmplDocName, nil)
+}
+
+// renderWith renders a fetched revision of docId into a Response using the
+// template stored as tmplName. If old is set the revision is not the latest
+// and the page carries a banner saying so.
+func renderWith(ctx context.Context, docId string, rev fetchedDoc, tmplName string, old *revisionBanner) (Response, error) {
+	noteRevision(ctx, "doc", docId, rev.meta.Id)
+
+	// Docs with an extension, like images and stylesheets, are served as
+	// they are.
+	if strings.Contains(docId, ".") {
+		resp := rawResponse(docId, rev.body)
+		return withHeaders(resp, validators(rev.meta.Id, rev.meta.Timestamp, resp.Body)), nil
+	}
+
+	converted := timePhase(ctx, "markdown")
+	meta, fm, personalized := pageMeta(ctx, docId, rev, old)
+	converted()
+
+	// A doc choosing a template that doesn't exist gets the default one.
+	resp, err := renderPage(ctx, fm.templateName(tmplName), meta)
+	if missingTemplate(err) && fm.Template != "" {
+		log.Printf("template %s for %s: %v", fm.templateName(tmplName), docId, err)
+		resp, err = renderPage(ctx, tmplName, meta)
+	}
+	if err != nil {
+		return resp, err
+	}
+
+	headers := fm.headers(docId)
+	for k, v := range validators(rev.meta.Id, rev.meta.Timestamp, resp.Body) {
+		headers[k] = v
+	}
+	if meta.Robots != "" {
+		headers["X-Robots-Tag"] = meta.Robots
+	}
+	// Crawlers honour the header too, whether or not the template has
+	// the link element.
+	if _, ok := headers["Link"]; fm.Canonical != "" && !ok {
+		headers["Link"] = fmt.Sprintf("<%s>; rel=\"canonical\"", fm.Canonical)
+	}
+
+	// Pages that differ by audience or reader mustn't be shared by caches.
+	if _, ok := headers["Cache-Control"]; personalized && !ok {
+		headers["Cache-Control"] = "private"
+	}
+
+	return withHeaders(resp, headers), nil
+}
+
+// pageMeta renders a fetched revision of docId's markdown into the data
+// its page template is executed with. personalized is set when the page
+// differs by the reader's audience or who they are.
+func pageMeta(ctx context.Context, docId string, rev fetchedDoc, old *revisionBanner) (meta docMetadata, fm frontMatter, personalized bool) {
+	tf := renderFrom(ctx).tf
+	fm, doc := splitFrontMatter(docId, rev.body)
+	directives, _ := parseDirectives(doc)
+	doc = substituteVariables(ctx, docId, doc)
+	doc, personalized = filterAudience(doc, audiencesFrom(ctx))
+	title := fm.title(doc)
+	doc, pager := paginate(docId, doc, pageFrom(ctx))
+
+	// Convert the doc's markdown to HTML
+	var entries []logEntry
+	if fm.Type == logDocType {
+		var intro string
+		intro, entries = splitLog(doc)
+		doc = []byte(intro)
+	}
+	opts := directives.options(fm, markdownOptions{
+		StripHTML: getConfig(ctx).stripsHTML(docId),
+		Engine:    getConfig(ctx).Markdown.Engine,
+	})
+	parsed, toc, notes := renderMarkdown(doc, docResolver(ctx), opts)
+	if entries != nil {
+		parsed = append(parsed, renderLog(entries, tf, opts.StripHTML)...)
+	}
+	if !directives.showsTOC() {
+		toc = nil
+	}
+
+	meta = docMetadata{
+		Title:        title,
+		DocBody:      string(parsed),
+		Timestamp:    tf.format(rev.meta.Timestamp),
+		TimestampISO: tf.iso(rev.meta.Timestamp),
+		UpdatedAgo:   ago(rev.meta.Timestamp, time.Now()),
+		Version:      rev.meta.Id,
+		OldRevision:  old,
+		Mirror:       pageMirrorBanner(tf),
+		Source:       pageSourceBanner(fm, rev, tf),
+		AsOf:         pageAsOfBanner(ctx, docId),
+		Robots:       fm.robots(),
+		Permalink:    permalinkURL(docId, rev.meta.Id),
+		Revision:     docRevisionMeta(ctx, rev),
+		Audio:        audioURL(docId),
+		Tags:         fm.Tags,
+		Date:         fm.date(tf),
+		Params:       frontMatterParams(docId, rev.body),
+		TOC:          toc,
+		Footnotes:    notes,
+		Pager:        pager,
+		nav:          newDocNav(ctx, docId),
+		blocks:       docBlocks(ctx, docId, fm),
+	}
+	meta.Canonical = canonicalLink(canonicalURL(getConfig(ctx), docId, fm))
+	meta.JSONLD = jsonLD(getConfig(ctx), docId, fm, meta)
+	if private, _ := getConfig(ctx).access(docId, fm); !private {
+		meta.OEmbed = oembedLink(getConfig(ctx), docId, meta.Title)
+	}
+	if getSummarizer() != nil {
+		meta.Summary = quickAbstract(ctx, docId, rev.meta.Id, meta.Title, summarize(docId, rev).text)
+	}
+	if docId == indexDocName {
+		meta.RecentlyViewed = viewedDocs(ctx)
+		personalized = personalized || len(meta.RecentlyViewed) > 0
+	}
+	return meta, fm, personalized
+}
+
+// renderPage executes the template stored as tmplName with meta.
+func renderPage(ctx context.Context, tmplName string, meta docMetadata) (resp Response, err error) {
+	defer func() { noteTemplateError(ctx, tmplName, err) }()
+
+	// Get the template from the docstore
+	tmplCtx, cancel := context.WithTimeout(ctx, templateTimeout)
+	defer cancel()
+
+	parsed := timePhase(ctx, "template")
+	tmpl, err := getTemplate(tmplCtx, tmplName)
+	parsed()
+	degraded := false
+	if unreachable(err) {
+		log.Printf("rendering with the fallback template: %v", err)
+		metrics.Incr("TemplateFallback", map[string]string{"Template": tmplName})
+		tmpl, err, degraded = fallbackTemplate, nil, true
+	}
+	if err != nil {
+		return Response{}, err
+	}
+	if len(meta.blocks) > 0 && !degraded {
+		tmpl = withBlocks(tmplName, tmpl, meta.blocks)
+	}
+	meta.Render = renderFrom(ctx)
+
+	if meta.OldRevision != nil {
+		banner, err := bannerHTML(tmpl, meta.OldRevision)
+		if err != nil {
+			return Response{}, docerr.E("banner "+tmplName, docerr.ErrTemplate, err)
+		}
+		meta.DocBody = banner + meta.DocBody
+	}
+	if meta.AsOf != nil {
+		banner, err := asOfBannerHTML(tmpl, meta.AsOf)
+		if err != nil {
+			return Response{}, docerr.E("as of banner "+tmplName, docerr.ErrTemplate, err)
+		}
+		meta.DocBody = banner + meta.DocBody
+	}
+	if meta.Mirror != nil {
+		banner, err := mirrorBannerHTML(tmpl, meta.Mirror)
+		if err != nil {
+			return Response{}, docerr.E("mirror banner "+tmplName, docerr.ErrTemplate, err)
+		}
+		meta.DocBody = banner + meta.DocBody
+	}
+	if meta.Source != nil {
+		banner, err := sourceBannerHTML(tmpl, meta.Source)
+		if err != nil {
+			return Response{}, docerr.E("source banner "+tmplName, docerr.ErrTemplate, err)
+		}
+		meta.DocBody = banner + meta.DocBody
+	}
+
+	var b bytes.Buffer
+
+	executed := timePhase(ctx, "execute")
+	start := time.Now()
+	err = tmpl.Execute(&b, meta)
+	noteTemplateDuration(ctx, tmplName, time.Since(start))
+	executed()
+
+	if err != nil {
+		return Response{}, docerr.E("execute "+tmplName, docerr.ErrTemplate, err)
+	}
+
+	body := stampAssets(ctx, inlineCriticalCSS(ctx, b.String()))
+	if t, ok := asOfFrom(ctx); ok {
+		body = keepAsOf(body, t)
+	}
+	if getConfig(ctx).Minify {
+		body = minifyHTML(body)
+	}
+
+	resp = Response{
+		StatusCode:      200,
+		IsBase64Encoded: false,
+		Body:            body,
+		Headers: map[string]string{
+			"Content-Type": "text/html",
+		},
+	}
+
+	// Keep caches from holding on to the plain page once the template is
+	// back.
+	if degraded {
+		resp.Headers[degradedHeader] = "template"
+		resp.Headers["Cache-Control"] = "no-store"
+	}
+
+	return resp, nil
+}
+
+// Handler is our lambda handler for API Gateway requests, which Invoke
+// passes on
+func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	return handle(ctx, request)
+}
+
+// route dispatches request to the handler for its path.
+func route(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if pprofEnabled && strings.HasPrefix(request.Path, pprofPrefix) {
+		return pprofHandler(ctx, request)
+	}
+
+	if strings.HasPrefix(request.Path, apiPrefix) {
+		return routeAPI(ctx, request)
+	}
+
+	if strings.HasPrefix(request.Path, docsPrefix) && request.HTTPMethod != "GET" {
+		if writeRefused(request.HTTPMethod, docsPrefix) {
+			return Response{}, docerr.E(request.HTTPMethod+" "+request.Path, docerr.ErrMethodNotAllowed, errMirrorReadOnly)
+		}
+		return serveWrite(ctx, request)
+	}
+
+	// API Gateway drops the trailing slash of /assets/.
+	if request.Path == strings.TrimSuffix(assetsPrefix, "/") {
+		request.Path = assetsPrefix
+	}
+	if strings.HasPrefix(request.Path, assetsPrefix) {
+		return serveAsset(ctx, request)
+	}
+
+	if strings.HasPrefix(request.Path, permalinkPrefix) {
+		return servePermalink(withAudiences(ctx, requestAudiences(getConfig(ctx), request)), request)
+	}
+
+	if strings.HasPrefix(request.Path, embedPrefix) {
+		return serveEmbed(ctx, request)
+	}
+
+	if strings.HasPrefix(request.Path, readerPrefix) {
+		return serveReader(ctx, request)
+	}
+
+	if strings.HasPrefix(request.Path, badgePrefix) {
+		return serveBadge(ctx, request)
+	}
+
+	if request.Path == "/robots.txt" {
+		return robotsTxt(getConfig(ctx)), nil
+	}
+
+	if request.Path == manifestPath {
+		return serveManifest(ctx)
+	}
+
+	if request.Path == serviceWorkerPath {
+		return serviceWorker(ctx, request)
+	}
+
+	if request.Path == indexJSONPath {
+		return serveIndexJSON(ctx)
+	}
+
+	if request.Path == searchPath {
+		return serveSearch(ctx, request)
+	}
+
+	if request.Path == oembedPath {
+		return serveOEmbed(ctx, request)
+	}
+
+	if _, ok := request.PathParameters["page"]; ok {
+		resp, err := serveMounted(request)
+		if docerr.Kind(err) == docerr.ErrNotFound {
+			if r, ok := serveRedirect(ctx, request); ok {
+				return r, nil
+			}
+		}
+		return resp, err
+	}
+
+	docId, ok := request.PathParameters["docId"]
+	if !ok {
+		docId = indexDocName
+	}
+
+	// Docs starting with "_", like the site config, are for the system
+	// rather than readers.
+	if strings.HasPrefix(docId, "_") {
+		return Response{}, docerr.E("route "+docId, docerr.ErrNotFound, nil)
+	}
+
+	ctx, private, err := checkAccess(ctx, request, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	resp, err := routeDoc(ctx, request, docId)
+	if docerr.Kind(err) == docerr.ErrNotFound {
+		if r, ok, err := serveFormatSuffix(ctx, request, docId); ok {
+			return r, err
+		}
+		if r, ok := serveRedirect(ctx, request); ok {
+			return r, nil
+		}
+	}
+	if docerr.Kind(err) == docerr.ErrNotFound && getConfig(ctx).listing(request.Path) {
+		resp, err = serveListing(ctx, request.Path, docId, false)
+	}
+	if err == nil && private {
+		// Keeping what the page already varies by, like Accept.
+		vary := resp.Headers["Vary"]
+		resp = withHeaders(resp, privateHeaders)
+		if vary != "" {
+			resp = withVary(resp, vary)
+		}
+	}
+	return resp, err
+}
+
+// routeDoc dispatches a request for docId, or one of its versions, to its
+// handler.
+func routeDoc(ctx context.Context, request events.APIGatewayProxyRequest, docId string) (Response, error) {
+	ctx = withAudiences(ctx, requestAudiences(getConfig(ctx), request))
+	if docId == indexDocName {
+		ctx = withRecentlyViewed(ctx, requestRecentlyViewed(request))
+	}
+
+	if request.Path == docsPrefix+docId+summarySuffix {
+		return serveSummary(ctx, docId)
+	}
+
+	if request.Path == docsPrefix+docId+audioSuffix {
+		return serveAudio(ctx, docId)
+	}
+
+	if strings.HasPrefix(request.Path, docsPrefix) {
+		return serveVersions(ctx, request)
+	}
+
+	if request.Path == "/status" {
+		return serveStatus(ctx)
+	}
+
+	if v, ok := request.QueryStringParameters["diff"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Response{}, docerr.E("diff "+v, docerr.ErrBadRequest, err)
+		}
+		return serveDiff(ctx, docId, n)
+	}
+
+	if lang, ok := request.QueryStringParameters["translate"]; ok {
+		return serveTranslation(ctx, docId, lang)
+	}
+
+	if v, ok := request.QueryStringParameters["asOf"]; ok {
+		t, err := parseAsOf(v)
+		if err != nil {
+			return Response{}, err
+		}
+		return serveAsOf(withAsOf(ctx, t), docId)
+	}
+
+	if v, ok := request.QueryStringParameters["rev"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Response{}, docerr.E("rev "+v, docerr.ErrBadRequest, err)
+		}
+		return serveRevision(ctx, docId, n)
+	}
+
+	if docId == indexDocName {
+		return serveIndex(ctx)
+	}
+
+	if !isPage(docId) {
+		return serveDoc(ctx, docId)
+	}
+	resp, err := serveFormat(ctx, docId, acceptFormat(request))
+	if err != nil {
+		return resp, err
+	}
+	return withVary(resp, "Accept"), nil
+}
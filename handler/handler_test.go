@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+)
+
+const testTemplate = `<!DOCTYPE html>
+<html>
+<head><title>{{.Title}}</title></head>
+<body>
+<main>{{.DocBody}}</main>
+<footer>Version {{.Version}}, updated {{.Timestamp}}</footer>
+</body>
+</html>
+`
+
+// memRevision is a docstore.Revision held in memory.
+type memRevision struct {
+	meta docstore.RevisionMetadata
+	*bytes.Reader
+}
+
+func (r *memRevision) Metadata() docstore.RevisionMetadata {
+	return r.meta
+}
+
+// memStore is an in-memory docstore.DocStore for tests.
+type memStore struct {
+	sync.Mutex
+	revs map[string][][]byte
+	now  time.Time
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		revs: map[string][][]byte{},
+		now:  time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func (m *memStore) put(docId, body string) {
+	_, err := m.PutRevision(docId, strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (m *memStore) revision(docId string, revisionId int) *memRevision {
+	return &memRevision{
+		meta: docstore.RevisionMetadata{
+			DocId:     docId,
+			Id:        revisionId,
+			Timestamp: m.now.Add(time.Duration(revisionId) * time.Hour),
+		},
+		Reader: bytes.NewReader(m.revs[docId][revisionId-1]),
+	}
+}
+
+func (m *memStore) GetDoc(docId string) (docstore.Revision, error) {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.revs[docId]) == 0 {
+		return nil, fmt.Errorf("Doc not found.")
+	}
+	return m.revision(docId, len(m.revs[docId])), nil
+}
+
+func (m *memStore) GetRevision(docId string, revisionId int) (docstore.Revision, error) {
+	m.Lock()
+	defer m.Unlock()
+	if revisionId < 1 || revisionId > len(m.revs[docId]) {
+		return nil, fmt.Errorf("Revision not found.")
+	}
+	return m.revision(docId, revisionId), nil
+}
+
+func (m *memStore) PutRevision(docId string, body io.Reader) (docstore.Revision, error) {
+	err := docstore.ValidateDocId(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.revs[docId] = append(m.revs[docId], b)
+	return m.revision(docId, len(m.revs[docId])), nil
+}
+
+func (m *memStore) ListDocs(token string) (page docstore.DocPage, err error) {
+	m.Lock()
+	defer m.Unlock()
+	for id, revs := range m.revs {
+		page.Docs = append(page.Docs, docstore.Doc{Id: id, LatestRevision: len(revs)})
+	}
+	return
+}
+
+func (m *memStore) ListRevisions(docId string, token string) (page docstore.RevisionPage, err error) {
+	m.Lock()
+	defer m.Unlock()
+	for i := range m.revs[docId] {
+		page.Revisions = append(page.Revisions, m.revision(docId, i+1).meta)
+	}
+	return
+}
+
+// sampleDoc generates markdown with a title and the given number of
+// sections of mixed prose, lists, code and links.
+func sampleDoc(sections int) string {
+	var b strings.Builder
+	b.WriteString("Sample Document\n\n")
+	for i := 1; i <= sections; i++ {
+		fmt.Fprintf(&b, "## Section %d\n\n", i)
+		b.WriteString("Lorem ipsum dolor sit amet, *consectetur* adipiscing elit, sed do ")
+		b.WriteString("eiusmod tempor incididunt ut **labore** et dolore magna aliqua. ")
+		fmt.Fprintf(&b, "See [section %d](#section-%d) and `inline code`.\n\n", i, i)
+		b.WriteString("- first item\n- second item\n- third item\n\n")
+		b.WriteString("```\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n```\n\n")
+	}
+	return b.String()
+}
+
+var benchSizes = []struct {
+	name     string
+	sections int
+}{
+	{"small", 2},
+	{"medium", 25},
+	{"large", 250},
+}
+
+// useStore points the handler at store and clears warm container state.
+func useStore(store docstore.DocStore) {
+	ds = store
+	renders = &renderCache{entries: map[string]renderEntry{}}
+	flushes = &flushCache{}
+	configs = &configCache{}
+	catalogs = &catalogCache{}
+	systemDocs = &systemDocCache{entries: map[string]systemDoc{}}
+	assets = &assetCache{entries: map[string]assetInfo{}}
+	templates = &templateCache{entries: map[string]cachedTemplate{}}
+}
+
+func BenchmarkMarkdown(b *testing.B) {
+	for _, size := range benchSizes {
+		doc := []byte(sampleDoc(size.sections))
+		b.Run(size.name, func(b *testing.B) {
+			b.SetBytes(int64(len(doc)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				renderMarkdown(doc, nil, markdownOptions{})
+			}
+		})
+	}
+}
+
+func BenchmarkTemplate(b *testing.B) {
+	tmpl := template.Must(template.New("docPage").Parse(testTemplate))
+	for _, size := range benchSizes {
+		body, _, _ := renderMarkdown([]byte(sampleDoc(size.sections)), nil, markdownOptions{})
+		meta := docMetadata{
+			Title:     "Sample Document",
+			DocBody:   string(body),
+			Timestamp: time.Now().Format(time.RFC850),
+			Version:   1,
+		}
+		b.Run(size.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := tmpl.Execute(ioutil.Discard, meta)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHandler(b *testing.B) {
+	store := newMemStore()
+	store.put(tmplDocName, testTemplate)
+	for _, size := range benchSizes {
+		store.put(size.name, sampleDoc(size.sections))
+	}
+	useStore(store)
+
+	for _, size := range benchSizes {
+		req := events.APIGatewayProxyRequest{
+			Path:           "/" + size.name,
+			PathParameters: map[string]string{"docId": size.name},
+		}
+		b.Run(size.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				resp, err := Handler(context.Background(), req)
+				if err != nil || resp.StatusCode != 200 {
+					b.Fatalf("status %d, err %v", resp.StatusCode, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkColdHandler renders with empty caches every time, as the first
+// request to a new container does.
+func BenchmarkColdHandler(b *testing.B) {
+	store := newMemStore()
+	store.put(tmplDocName, testTemplate)
+	for _, size := range benchSizes {
+		store.put(size.name, sampleDoc(size.sections))
+	}
+
+	for _, size := range benchSizes {
+		req := events.APIGatewayProxyRequest{
+			Path:           "/" + size.name,
+			PathParameters: map[string]string{"docId": size.name},
+		}
+		b.Run(size.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				useStore(store)
+				resp, err := Handler(context.Background(), req)
+				if err != nil || resp.StatusCode != 200 {
+					b.Fatalf("status %d, err %v", resp.StatusCode, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/storelist"
+	"github.com/drocamor/n22t.docstore/textdiff"
+)
+
+// revisionBanner describes a page showing an old revision of a doc.
+type revisionBanner struct {
+	DocId     string
+	Version   int
+	Latest    int
+	LatestURL string
+	DiffURL   string
+	Versions  string
+}
+
+// defaultBanner is used unless the page template defines its own with
+// {{define "banner"}}...{{end}}.
+var defaultBanner = template.Must(template.New("banner").Parse(
+	`<div class="old-revision">You are viewing version {{.Version}} of this page. ` +
+		`<a href="{{.LatestURL}}">View the latest version ({{.Latest}})</a> or ` +
+		`<a href="{{.DiffURL}}">see what changed</a>. ` +
+		`<a href="{{.Versions}}">All versions</a>.</div>
+`))
+
+// bannerHTML renders the old revision banner with the page template's
+// "banner" definition if it has one.
+func bannerHTML(tmpl *template.Template, b *revisionBanner) (string, error) {
+	t := tmpl.Lookup("banner")
+	if t == nil {
+		t = defaultBanner
+	}
+
+	var s strings.Builder
+	err := t.Execute(&s, b)
+	return s.String(), err
+}
+
+// fetchRevision gets revision n of docId, sharing the result with any
+// concurrent fetch of the same revision.
+func fetchRevision(ctx context.Context, docId string, n int) (doc fetchedDoc, err error) {
+	op := fmt.Sprintf("GetRevision %s@%d", docId, n)
+	ch := flights.DoChan("rev:"+docId+"@"+fmt.Sprint(n), func() (interface{}, error) {
+		rev, err := ds.GetRevision(docId, n)
+		if err != nil {
+			return nil, docerr.FromStore(op, err)
+		}
+
+		body, err := readDoc(op, docId, rev)
+		if err != nil {
+			return nil, err
+		}
+
+		return fetchedDoc{meta: rev.Metadata(), body: body}, nil
+	})
+
+	v, err := await(ctx, op, ch)
+	if err != nil {
+		return
+	}
+
+	doc = v.(fetchedDoc)
+	return
+}
+
+// fetchLatestAnd fetches the latest revision of docId along with revision n.
+func fetchLatestAnd(ctx context.Context, docId string, n int) (latest, rev fetchedDoc, err error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	latest, err = fetchDoc(ctx, docId)
+	if err != nil {
+		return
+	}
+
+	if n == latest.meta.Id {
+		rev = latest
+		return
+	}
+
+	rev, err = fetchRevision(ctx, docId, n)
+	return
+}
+
+// serveRevision renders revision n of docId, with a banner pointing readers
+// at the latest version.
+func serveRevision(ctx context.Context, docId string, n int) (Response, error) {
+	latest, rev, err := fetchLatestAnd(ctx, docId, n)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if rev.meta.Id == latest.meta.Id {
+		return renderRevision(ctx, docId, latest)
+	}
+
+	banner := &revisionBanner{
+		DocId:     docId,
+		Version:   n,
+		Latest:    latest.meta.Id,
+		LatestURL: "/" + docId,
+		DiffURL:   fmt.Sprintf("/%s?diff=%d", docId, n),
+		Versions:  versionsURL(docId),
+	}
+
+	return renderWith(ctx, docId, rev, tmplDocName, banner)
+}
+
+// diffHTML renders a diff as a preformatted block of deleted, inserted and
+// unchanged lines.
+func diffHTML(diff []textdiff.Line) string {
+	var b strings.Builder
+	b.WriteString(`<pre class="diff">`)
+	for _, l := range diff {
+		text := html.EscapeString(l.Text)
+		switch l.Op {
+		case textdiff.Delete:
+			fmt.Fprintf(&b, "<del>- %s</del>\n", text)
+		case textdiff.Insert:
+			fmt.Fprintf(&b, "<ins>+ %s</ins>\n", text)
+		default:
+			fmt.Fprintf(&b, "  %s\n", text)
+		}
+	}
+	b.WriteString("</pre>\n")
+	return b.String()
+}
+
+// diffText renders a diff as unified diff style lines.
+func diffText(diff []textdiff.Line) string {
+	var b strings.Builder
+	for _, l := range diff {
+		switch l.Op {
+		case textdiff.Delete:
+			b.WriteString("-")
+		case textdiff.Insert:
+			b.WriteString("+")
+		default:
+			b.WriteString(" ")
+		}
+		b.WriteString(l.Text + "\n")
+	}
+	return b.String()
+}
+
+// revisionMessagesHTML lists the messages recorded with the revisions of
+// docId after from up to to, newest first, or is empty if none were.
+func revisionMessagesHTML(ctx context.Context, docId string, from, to int) string {
+	metas, err := queryRevisionMeta(ctx, docId)
+	if err != nil {
+		log.Printf("messages of %s: %v", docId, err)
+		return ""
+	}
+
+	var b strings.Builder
+	for v := to; v > from; v-- {
+		m, ok := metas[v]
+		if !ok || m.Message == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "<li>Version %d: %s", v, html.EscapeString(m.Message))
+		if m.Author != "" {
+			fmt.Fprintf(&b, " <span class=\"author\">(%s)</span>", html.EscapeString(m.Author))
+		}
+		b.WriteString("</li>\n")
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "<ul class=\"messages\">\n" + b.String() + "</ul>\n"
+}
+
+// serveDiff renders the changes between revision n of docId and the latest.
+func serveDiff(ctx context.Context, docId string, n int) (Response, error) {
+	tf := renderFrom(ctx).tf
+	latest, rev, err := fetchLatestAnd(ctx, docId, n)
+	if err != nil {
+		return Response{}, err
+	}
+
+	diff := textdiff.Lines(string(rev.body), string(latest.body))
+	deleted, inserted := textdiff.Stats(diff)
+
+	body := fmt.Sprintf(
+		"<p>Changes from <a href=\"/%s?rev=%d\">version %d</a> to <a href=\"/%s\">version %d</a>: %d lines removed, %d added.</p>\n",
+		docId, n, n, docId, latest.meta.Id, deleted, inserted)
+	body += revisionMessagesHTML(ctx, docId, n, latest.meta.Id)
+
+	meta := docMetadata{
+		Title:        "Changes to " + firstLine(latest.body),
+		DocBody:      body + diffHTML(diff),
+		Timestamp:    tf.format(latest.meta.Timestamp),
+		TimestampISO: tf.iso(latest.meta.Timestamp),
+		UpdatedAgo:   ago(latest.meta.Timestamp, time.Now()),
+		Version:      latest.meta.Id,
+	}
+
+	return renderPage(ctx, tmplDocName, meta)
+}
+
+// listRevisions pages through the metadata of every revision of docId.
+func listRevisions(ctx context.Context, docId string) ([]docstore.RevisionMetadata, error) {
+	revs, err := storelist.Revisions(ctx, ds, docId)
+	if err != nil && ctx.Err() == nil {
+		return nil, docerr.FromStore("ListRevisions "+docId, err)
+	}
+	return revs, err
+}
+
+// versionsURL is where the revisions of docId are listed.
+func versionsURL(docId string) string {
+	return docsPrefix + docId + "/versions"
+}
+
+// serveVersions answers /docs/{docId}/versions, listing every revision of
+// docId, /docs/{docId}/versions/{n}, showing revision n, and
+// /docs/{docId}/versions/{n}/review, showing it with reviewers' notes. A
+// revision's label may stand in for n. It also answers
+// /docs/{docId}/page/{n}, showing page n of a paged doc.
+func serveVersions(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	parts := strings.Split(strings.TrimPrefix(request.Path, docsPrefix), "/")
+	if len(parts) == 3 && parts[0] != "" && parts[1] == "page" {
+		return servePage(ctx, parts[0], parts[2])
+	}
+	if len(parts) < 2 || len(parts) > 4 || parts[0] == "" || parts[1] != "versions" ||
+		(len(parts) == 4 && parts[3] != "review") {
+		return Response{}, docerr.E("route "+request.Path, docerr.ErrNotFound, nil)
+	}
+	docId := parts[0]
+
+	if len(parts) == 2 {
+		return serveVersionList(ctx, docId)
+	}
+
+	n, err := strconv.Atoi(parts[2])
+	if err != nil && isLabel(parts[2]) {
+		n, err = labeledVersion(ctx, docId, parts[2])
+	}
+	if err != nil {
+		return Response{}, docerr.E("version "+parts[2], docerr.ErrNotFound, err)
+	}
+	if len(parts) == 4 {
+		return serveReview(ctx, request, docId, n)
+	}
+	return serveRevision(ctx, docId, n)
+}
+
+// serveVersionList renders the revisions of docId, newest first.
+func serveVersionList(ctx context.Context, docId string) (Response, error) {
+	tf := renderFrom(ctx).tf
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	latest, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	revs, err := revisionHistory(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	labels, err := docLabels(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	byVersion := labelsByVersion(labels)
+
+	fm, body := splitFrontMatter(docId, latest.body)
+	title := fm.title(body)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h1>History of %s</h1>\n<ul class=\"versions\">\n", html.EscapeString(title))
+	for _, r := range revs {
+		url := fmt.Sprintf("%s/%d", versionsURL(docId), r.Version)
+		if r.Version == latest.meta.Id {
+			url = "/" + docId
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">Version %d</a> <time datetime=\"%s\">%s</time>",
+			url, r.Version, tf.iso(r.Timestamp), tf.format(r.Timestamp))
+		if r.Author != "" {
+			fmt.Fprintf(&b, " by <span class=\"author\">%s</span>", html.EscapeString(r.Author))
+		}
+		sort.Strings(byVersion[r.Version])
+		for _, label := range byVersion[r.Version] {
+			fmt.Fprintf(&b, " <a class=\"label\" href=\"%s/%s\">%s</a>", versionsURL(docId), label, label)
+		}
+		if r.Version == latest.meta.Id {
+			b.WriteString(" (latest)")
+		} else {
+			fmt.Fprintf(&b, " <a href=\"/%s?diff=%d\">changes since</a>", docId, r.Version)
+		}
+		if r.Message != "" {
+			fmt.Fprintf(&b, "<p class=\"message\">%s</p>", html.EscapeString(r.Message))
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n")
+
+	meta := docMetadata{
+		Title:        "History of " + title,
+		DocBody:      b.String(),
+		Timestamp:    tf.format(latest.meta.Timestamp),
+		TimestampISO: tf.iso(latest.meta.Timestamp),
+		UpdatedAgo:   ago(latest.meta.Timestamp, time.Now()),
+		Version:      latest.meta.Id,
+		Robots:       "noindex",
+	}
+
+	return renderPage(ctx, tmplDocName, meta)
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"gopkg.in/yaml.v2"
+)
+
+// holdsDocName lists the docs under retention hold. A held doc's revisions
+// must not be pruned and the doc must not be deleted or renamed until an
+// admin releases the hold. It is only changed through the hold endpoints,
+// which audit every change.
+const holdsDocName = "_holds"
+
+type hold struct {
+	Reason string    `yaml:"reason" json:"reason"`
+	Since  time.Time `yaml:"since" json:"since"`
+}
+
+// getHolds returns the holds by docId.
+func getHolds(ctx context.Context) (map[string]hold, error) {
+	holds := map[string]hold{}
+
+	flights.Forget("doc:" + holdsDocName)
+	doc, err := fetchDoc(ctx, holdsDocName)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return holds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(doc.body, &holds)
+	if err != nil {
+		return nil, docerr.E("parse "+holdsDocName, docerr.ErrBackend, err)
+	}
+	return holds, nil
+}
+
+// heldDoc returns the hold on docId, if there is one.
+func heldDoc(ctx context.Context, docId string) (hold, bool, error) {
+	holds, err := getHolds(ctx)
+	if err != nil {
+		return hold{}, false, err
+	}
+	h, ok := holds[docId]
+	return h, ok, nil
+}
+
+func putHolds(holds map[string]hold) error {
+	b, err := yaml.Marshal(holds)
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(holdsDocName, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+holdsDocName, err)
+	}
+	return nil
+}
+
+// listHolds reports every hold.
+func listHolds(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	holds, err := getHolds(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, struct {
+		Holds map[string]hold `json:"holds"`
+	}{holds}), nil
+}
+
+// getHold reports the hold on the docId path parameter.
+func getHold(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	h, ok, err := heldDoc(ctx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	if !ok {
+		return Response{}, docerr.E("hold "+docId, docerr.ErrNotFound, nil)
+	}
+	return jsonResponse(200, h), nil
+}
+
+// placeHold puts the docId path parameter under hold. The body gives the
+// reason: {"reason": "litigation 2020-14"}.
+func placeHold(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	op := "hold " + docId
+	if docstore.ValidateDocId(docId) != nil {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, nil)
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	var h hold
+	err = json.Unmarshal(body, &h)
+	if err != nil || h.Reason == "" {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"reason": "a reason is required"})
+	}
+	h.Since = time.Now().UTC()
+
+	holds, err := getHolds(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	if prev, ok := holds[docId]; ok {
+		return jsonResponse(200, prev), nil
+	}
+
+	holds[docId] = h
+	err = putHolds(holds)
+	if err != nil {
+		return Response{}, err
+	}
+
+	audit(request, "hold.place", docId, map[string]interface{}{"reason": h.Reason})
+	return jsonResponse(201, h), nil
+}
+
+// releaseHold lifts the hold on the docId path parameter.
+func releaseHold(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+
+	holds, err := getHolds(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	h, ok := holds[docId]
+	if !ok {
+		return Response{}, docerr.E("hold "+docId, docerr.ErrNotFound, nil)
+	}
+
+	delete(holds, docId)
+	err = putHolds(holds)
+	if err != nil {
+		return Response{}, err
+	}
+
+	audit(request, "hold.release", docId, map[string]interface{}{"reason": h.Reason, "since": h.Since})
+	return Response{StatusCode: 204}, nil
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// HTTPHandler serves plain HTTP requests with Handler, translating them
+// into the API Gateway events it takes, to run the site outside Lambda.
+func HTTPHandler() http.Handler {
+	return http.HandlerFunc(serveHTTP)
+}
+
+// Event builds the API Gateway event for r, filling in the docId path
+// parameter the way the deployed routes do, for Handler to answer.
+func Event(r *http.Request) (events.APIGatewayProxyRequest, error) {
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:            r.Method,
+		Path:                  r.URL.Path,
+		Headers:               map[string]string{},
+		QueryStringParameters: map[string]string{},
+		PathParameters:        map[string]string{},
+	}
+	for k, v := range r.Header {
+		request.Headers[k] = v[0]
+	}
+	for k, v := range r.URL.Query() {
+		request.QueryStringParameters[k] = v[0]
+	}
+	request.RequestContext.Identity.SourceIP = r.RemoteAddr
+	request.RequestContext.RequestID = randomHex(16)
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] != "":
+		request.PathParameters["docId"] = segments[0]
+	case len(segments) >= 2 && "/"+segments[0]+"/" == docsPrefix:
+		request.PathParameters["docId"] = segments[1]
+	case len(segments) == 2:
+		request.PathParameters["docId"] = segments[0]
+		request.PathParameters["page"] = segments[1]
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return request, err
+	}
+	if utf8.Valid(body) {
+		request.Body = string(body)
+	} else {
+		request.Body, request.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	}
+	return request, nil
+}
+
+func serveHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := Event(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := Handler(r.Context(), request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		body, err = base64.StdEncoding.DecodeString(resp.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
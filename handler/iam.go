@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// iamPrefix is where internal services call the JSON API and the write
+// endpoint with their AWS identities rather than API keys, at
+// /iam/api/v1/... and /iam/docs/{docId}. API Gateway checks the SigV4
+// signature of requests to these routes and passes the caller's ARN in
+// the request context; the ARN is only trusted under iamPrefix.
+const iamPrefix = "/iam"
+
+// iamConfig grants IAM principals the roles API keys would, for example:
+//
+//	iam:
+//	  principals:
+//	    arn:aws:iam::123456789012:role/docs-publisher:
+//	      role: writer
+//	    arn:aws:sts::123456789012:assumed-role/docs-sync/*:
+//	      role: prefix-admin
+//	      prefixes: [eng-]
+//
+// The keys are ARNs, or patterns of them as path.Match takes, and the
+// longest matching one applies. Roles assumed by a service are matched by
+// their session ARNs, like the second. A principal that matches none may
+// not call the API.
+type iamConfig struct {
+	Principals map[string]iamGrant `yaml:"principals"`
+}
+
+// iamGrant is the role of an IAM principal: admin, writer or
+// prefix-admin, with the docId prefixes a prefix admin administers.
+type iamGrant struct {
+	Role     string   `yaml:"role"`
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// iamCaller is the IAM principal that signed a request, and its grant.
+type iamCaller struct {
+	arn     string
+	grant   iamGrant
+	granted bool
+}
+
+type iamKey struct{}
+
+// grantFor returns the grant of the longest principal pattern matching
+// arn.
+func (c *iamConfig) grantFor(arn string) (g iamGrant, ok bool) {
+	best := ""
+	for pattern, grant := range c.Principals {
+		if m, _ := path.Match(pattern, arn); m && (!ok || len(pattern) > len(best)) {
+			best, g, ok = pattern, grant, true
+		}
+	}
+	return
+}
+
+// iamRouted reports whether path is one of the routes under iamPrefix.
+func iamRouted(path string) bool {
+	return strings.HasPrefix(path, iamPrefix+apiPrefix) || strings.HasPrefix(path, iamPrefix+docsPrefix)
+}
+
+// withIAM serves requests under iamPrefix as the routes they mirror,
+// carrying the IAM principal that signed them for requireWriter and
+// authorizeAdmin to check in place of an API key.
+func withIAM(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		if !iamRouted(request.Path) {
+			return next(ctx, request)
+		}
+		request.Path = strings.TrimPrefix(request.Path, iamPrefix)
+
+		arn := request.RequestContext.Identity.UserArn
+		if arn == "" {
+			// The route isn't behind IAM auth, as when previewing locally.
+			return errorResponse(request, docerr.E("iam "+request.Path, docerr.ErrUnauthorized, nil)), nil
+		}
+		c := &iamCaller{arn: arn}
+		c.grant, c.granted = getConfig(ctx).IAM.grantFor(arn)
+		ctx = context.WithValue(ctx, iamKey{}, c)
+		if c.granted && c.grant.Role == rolePrefixAdmin {
+			ctx = context.WithValue(ctx, delegationKey{}, c.grant.Prefixes)
+		}
+		return next(ctx, request)
+	}
+}
+
+// iamRole returns the role granted the IAM principal that signed ctx's
+// request, and whether one did. A principal without a grant is forbidden.
+func iamRole(ctx context.Context, op string) (role string, signed bool, err error) {
+	c, ok := ctx.Value(iamKey{}).(*iamCaller)
+	if !ok {
+		return "", false, nil
+	}
+	noteLogin(ctx, "iam", c.arn, nil)
+	if !c.granted {
+		return "", true, docerr.WithDetails(op, docerr.ErrForbidden, nil,
+			map[string]interface{}{"principal": c.arn, "reason": "no role is granted to the principal"})
+	}
+	return c.grant.Role, true, nil
+}
+
+// iamPrincipal returns the ARN of the IAM principal that signed ctx's
+// request, if any.
+func iamPrincipal(ctx context.Context) string {
+	if c, ok := ctx.Value(iamKey{}).(*iamCaller); ok {
+		return c.arn
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+
+	// replayedHeader marks a response repeated from an earlier request
+	// with the same idempotency key.
+	replayedHeader = "Idempotent-Replayed"
+)
+
+var (
+	// idempotencyTable is the DynamoDB table remembering write responses
+	// by idempotency key, with a TTL on the Expires attribute. Keys are
+	// ignored when it is unset.
+	idempotencyTable = os.Getenv("IDEMPOTENCY_TABLE")
+
+	// idempotencyTTL is how long a key is remembered, long enough to
+	// cover client retries after API Gateway's 29 second timeout.
+	idempotencyTTL = envDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+
+	// idempotencyLease is how long a key is held for the first request
+	// using it before it gets a response. A request killed before then,
+	// by the function timing out or running out of memory, leaves its
+	// key for a retry to claim once the lease is up.
+	idempotencyLease = envDuration("IDEMPOTENCY_LEASE", requestTimeout+5*time.Second)
+)
+
+// idempotencyRecord is the dedupe record for one key. Status is 0 while
+// the first request is still in progress, until the lease in Expires is
+// up.
+type idempotencyRecord struct {
+	Key         string
+	RequestHash string
+	Expires     int64
+	Status      int               `dynamodbav:",omitempty"`
+	Body        string            `dynamodbav:",omitempty"`
+	Headers     map[string]string `dynamodbav:",omitempty"`
+}
+
+// idempotent makes h safe to retry: a request carrying an Idempotency-Key
+// it has seen before gets the first request's response instead of being
+// run again.
+func idempotent(h handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		key := header(request, idempotencyHeader)
+		if key == "" || idempotencyTable == "" {
+			return h(ctx, request)
+		}
+		if len(key) > 255 {
+			return Response{}, docerr.E("idempotency key", docerr.ErrBadRequest, nil)
+		}
+
+		// The same key may not be reused for a different request.
+		sum := sha256.Sum256([]byte(request.HTTPMethod + " " + request.Path + "?" + request.QueryStringParameters["dryRun"] + "\n" + request.Body))
+		rec := idempotencyRecord{
+			Key:         request.HTTPMethod + " " + request.Path + " " + key,
+			RequestHash: hex.EncodeToString(sum[:]),
+			Expires:     time.Now().Add(idempotencyLease).Unix(),
+		}
+
+		prev, claimed, err := claimIdempotencyKey(ctx, rec)
+		if err != nil {
+			return Response{}, err
+		}
+		if !claimed {
+			return replay(prev, rec)
+		}
+
+		resp, err := h(ctx, request)
+		if err != nil {
+			// Let the client retry with the same key.
+			releaseIdempotencyKey(rec.Key)
+			return resp, err
+		}
+
+		rec.Status, rec.Body, rec.Headers = resp.StatusCode, resp.Body, resp.Headers
+		rec.Expires = time.Now().Add(idempotencyTTL).Unix()
+		saveIdempotencyRecord(rec)
+		return resp, nil
+	}
+}
+
+// replay answers a repeated request from the record of the first.
+func replay(prev, rec idempotencyRecord) (Response, error) {
+	op := "idempotency key " + rec.Key
+	if prev.RequestHash != rec.RequestHash {
+		return Response{}, docerr.WithDetails(op, docerr.ErrConflict, nil,
+			map[string]interface{}{"reason": "key was used for a different request"})
+	}
+	if prev.Status == 0 {
+		return Response{}, docerr.WithDetails(op, docerr.ErrConflict, nil,
+			map[string]interface{}{"reason": "first request with this key is still in progress"})
+	}
+
+	resp := Response{StatusCode: prev.Status, Body: prev.Body, Headers: prev.Headers}
+	return withHeaders(resp, map[string]string{replayedHeader: "true"}), nil
+}
+
+// claimIdempotencyKey records rec unless its key is already known, in
+// which case the existing record is returned. A key whose record has
+// expired, whether a response or a lapsed lease, is claimed again.
+func claimIdempotencyKey(ctx context.Context, rec idempotencyRecord) (prev idempotencyRecord, claimed bool, err error) {
+	item, err := dynamodbattribute.MarshalMap(rec)
+	if err != nil {
+		return
+	}
+
+	_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(idempotencyTable),
+		Item:      item,
+		// Expired records may linger until DynamoDB's TTL sweep gets to
+		// them.
+		ConditionExpression: aws.String("attribute_not_exists(#k) OR Expires < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#k": aws.String("Key"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+	})
+	if err == nil {
+		return prev, true, nil
+	}
+
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return prev, false, docerr.E("idempotency key", docerr.ErrBackend, err)
+	}
+
+	key, _ := dynamodbattribute.MarshalMap(struct{ Key string }{rec.Key})
+	out, err := dynamo().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(idempotencyTable),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return prev, false, docerr.E("idempotency key", docerr.ErrBackend, err)
+	}
+
+	err = dynamodbattribute.UnmarshalMap(out.Item, &prev)
+	return prev, false, err
+}
+
+// saveIdempotencyRecord stores the response for rec's key, to be kept
+// until rec.Expires. The write has already happened, so failing to record
+// it is only logged.
+func saveIdempotencyRecord(rec idempotencyRecord) {
+	item, err := dynamodbattribute.MarshalMap(rec)
+	if err == nil {
+		_, err = dynamo().PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(idempotencyTable),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		log.Printf("error saving idempotency record %s: %v", rec.Key, err)
+	}
+}
+
+func releaseIdempotencyKey(key string) {
+	k, err := dynamodbattribute.MarshalMap(struct{ Key string }{key})
+	if err == nil {
+		_, err = dynamo().DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(idempotencyTable),
+			Key:       k,
+		})
+	}
+	if err != nil {
+		log.Printf("error releasing idempotency key %s: %v", key, err)
+	}
+}
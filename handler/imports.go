@@ -0,0 +1,389 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/blobstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// importPrefix starts the ids of the docs holding import reports, one per
+// import, like "_import.3f9a0c12d4e5b6a7".
+const importPrefix = "_import."
+
+var (
+	// importMaxBytes and importMaxFiles limit what an archive may expand
+	// to, which is held in memory while it is imported.
+	importMaxBytes = int64(envFloat("IMPORT_MAX_BYTES", 256<<20))
+	importMaxFiles = int(envFloat("IMPORT_MAX_FILES", 2000))
+
+	validImportPrefix = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	invalidDocIdChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+	relativeLink      = regexp.MustCompile(`(\]\(|\]: )([^)\s]+)`)
+)
+
+// archiveFile is a file expanded from an imported archive.
+type archiveFile struct {
+	path string
+	body []byte
+}
+
+// importJob is an import of an archive of markdown and assets, and what
+// came of each file in it.
+type importJob struct {
+	Id       string         `json:"id"`
+	Prefix   string         `json:"prefix"`
+	Upload   string         `json:"upload,omitempty"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Status   string         `json:"status"` // running, done or failed
+	Summary  map[string]int `json:"summary"`
+	Files    []importResult `json:"files"`
+}
+
+// importResult is what came of one file in an import. Action is a
+// writeResult's, or "skipped" or "failed" with the reason in Error.
+type importResult struct {
+	Path    string `json:"path"`
+	DocId   string `json:"docId,omitempty"`
+	Action  string `json:"action"`
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func getImport(ctx context.Context, id string) (importJob, error) {
+	var job importJob
+	op := "import " + id
+	if id == "" || docstore.ValidateDocId(id) != nil || strings.Contains(id, ".") {
+		return job, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	flights.Forget("doc:" + importPrefix + id)
+	doc, err := fetchDoc(ctx, importPrefix+id)
+	if err != nil {
+		return job, err
+	}
+
+	err = json.Unmarshal(doc.body, &job)
+	if err != nil {
+		return job, docerr.E("parse "+op, docerr.ErrBackend, err)
+	}
+	return job, nil
+}
+
+func putImport(job importJob) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(importPrefix+job.Id, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+importPrefix+job.Id, err)
+	}
+	return nil
+}
+
+// expandArchive returns the files in a zip, tar or gzipped tar archive,
+// leaving out directories and hidden files.
+func expandArchive(b []byte) ([]archiveFile, error) {
+	var files []archiveFile
+	var total int64
+	add := func(name string, r io.Reader) error {
+		if len(files) == importMaxFiles {
+			return fmt.Errorf("more than %d files", importMaxFiles)
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r, importMaxBytes-total+1))
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		total += int64(len(body))
+		if total > importMaxBytes {
+			return fmt.Errorf("expands to more than %s", formatSize(int(importMaxBytes)))
+		}
+		files = append(files, archiveFile{name, body})
+		return nil
+	}
+
+	switch {
+	case bytes.HasPrefix(b, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || hiddenPath(f.Name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.Name, err)
+			}
+			err = add(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+
+	case bytes.HasPrefix(b, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		b, err = ioutil.ReadAll(io.LimitReader(gz, importMaxBytes*2))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a zip or tar archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || hiddenPath(hdr.Name) {
+			continue
+		}
+		err = add(hdr.Name, tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// hiddenPath reports whether an archive path is a hidden file or in a
+// hidden directory, like the __MACOSX folder that Finder adds to zips.
+func hiddenPath(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if (strings.HasPrefix(part, ".") && part != "." && part != "..") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// isMarkdownPath reports whether an archive path is a page.
+func isMarkdownPath(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// importDocId returns the docId a file at an archive path is imported as
+// under prefix: the path with its directories joined by dashes, like
+// "eng-guide-intro" for "guide/intro.md", keeping the extension of
+// anything but markdown, like "eng-guide-diagram.png". It returns "" for
+// a path that leaves nothing to name a doc by.
+func importDocId(prefix, p string) string {
+	p = strings.ToLower(path.Clean("/" + p))[1:]
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	if isMarkdownPath(p) {
+		ext = ""
+	} else if !validAssetExt.MatchString(ext) {
+		base, ext = p, ""
+	}
+
+	// Docs starting with an underscore are the site's own.
+	name := strings.Trim(invalidDocIdChars.ReplaceAllString(base, "-"), "-_")
+	if name == "" {
+		return ""
+	}
+	return prefix + name + ext
+}
+
+// rewriteLinks points the relative links in the markdown file at p to the
+// other files in the archive at where they are imported, so that the
+// import reads as it did before it was expanded.
+func rewriteLinks(p string, body []byte, docIds map[string]string) []byte {
+	dir := path.Dir(path.Clean("/" + p))
+	return relativeLink.ReplaceAllFunc(body, func(m []byte) []byte {
+		sub := relativeLink.FindSubmatch(m)
+		target := string(sub[2])
+		if strings.Contains(target, ":") || strings.HasPrefix(target, "/") || strings.HasPrefix(target, "#") {
+			return m
+		}
+		frag := ""
+		if i := strings.IndexAny(target, "#?"); i >= 0 {
+			target, frag = target[:i], target[i:]
+		}
+		docId, ok := docIds[path.Join(dir, target)]
+		if !ok {
+			return m
+		}
+		url := "/" + docId
+		if !isPage(docId) {
+			url = assetsPrefix + docId
+		}
+		return append(append([]byte{}, sub[1]...), url+frag...)
+	})
+}
+
+// startImport expands a zip or tar archive of markdown and assets into
+// docs under the prefix query parameter: markdown becomes pages and
+// everything else assets, with relative links between them kept working.
+// The archive is the request body, or an upload made with POST
+// /api/v1/assets/uploads, too large to send through the API, named by the
+// upload query parameter. It answers at once with the import's id; GET
+// admin/imports/{id} reports what came of each file when it is done. An
+// import that outlives the invocation finishes when the container is next
+// thawed.
+func startImport(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	params := request.QueryStringParameters
+	job := importJob{
+		Id:      randomHex(8),
+		Prefix:  params["prefix"],
+		Upload:  params["upload"],
+		Started: time.Now().UTC(),
+		Status:  "running",
+		Summary: map[string]int{},
+		Files:   []importResult{},
+	}
+	op := "import"
+	if job.Prefix != "" && !validImportPrefix.MatchString(job.Prefix) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"prefix": "lowercase letters, digits, dashes and underscores, like eng-"})
+	}
+	err := requireAdministers(ctx, op, job.Prefix)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var archive []byte
+	if job.Upload != "" {
+		if assetBucket == "" || !validUploadId.MatchString(job.Upload) {
+			return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+		}
+		archive, err = blobs().GetUpload(ctx, job.Upload)
+		if blobstore.IsNotFound(err) {
+			return Response{}, docerr.WithDetails(op, docerr.ErrNotFound, err,
+				map[string]interface{}{"upload": "nothing has been uploaded, or it was imported already"})
+		}
+		if err != nil {
+			return Response{}, docerr.E(op, docerr.ErrBackend, err)
+		}
+	} else {
+		archive, err = requestBody(request)
+		if err != nil {
+			return Response{}, err
+		}
+	}
+
+	files, err := expandArchive(archive)
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("no files")
+	}
+	if err != nil {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"body": "a zip, tar or gzipped tar archive"})
+	}
+
+	err = putImport(job)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// The writes are audited as the request's, and made in its role.
+	run := withRole(detach(ctx), roleFrom(ctx))
+	run = context.WithValue(run, auditRequestKey{}, auditRequestFrom(ctx))
+	go runImport(run, job, files)
+
+	return jsonResponse(202, struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+		Files  int    `json:"files"`
+	}{job.Id, job.Status, len(files)}), nil
+}
+
+// runImport writes files as docs for job and stores what came of them.
+// Files are written one at a time, as each write may update the asset
+// references.
+func runImport(ctx context.Context, job importJob, files []archiveFile) {
+	docIds := map[string]string{}
+	for _, f := range files {
+		if docId := importDocId(job.Prefix, f.path); docId != "" {
+			docIds[path.Clean("/"+f.path)] = docId
+		}
+	}
+
+	written := map[string]string{}
+	for _, f := range files {
+		r := importResult{Path: f.path, DocId: docIds[path.Clean("/"+f.path)]}
+		switch {
+		case r.DocId == "":
+			r.Action, r.Error = "skipped", "no doc name can be made from the path"
+		case written[r.DocId] != "":
+			r.Action, r.Error = "skipped", "imported as "+r.DocId+" from "+written[r.DocId]
+		default:
+			written[r.DocId] = f.path
+			body := f.body
+			if isMarkdownPath(f.path) {
+				body = rewriteLinks(f.path, body, docIds)
+			}
+			res, err := writeDoc(ctx, r.DocId, body, false)
+			if err != nil {
+				r.Action, r.Error = "failed", err.Error()
+			} else {
+				r.Action, r.Version = res.Action, res.Version
+			}
+		}
+		job.Summary[r.Action]++
+		job.Files = append(job.Files, r)
+	}
+
+	job.Status = "done"
+	if job.Summary["failed"] > 0 {
+		job.Status = "failed"
+	}
+	finished := time.Now().UTC()
+	job.Finished = &finished
+
+	if job.Upload != "" {
+		if err := blobs().Discard(ctx, job.Upload); err != nil {
+			log.Printf("import %s: discarding upload %s: %v", job.Id, job.Upload, err)
+		}
+	}
+
+	err := putImport(job)
+	if err != nil {
+		log.Printf("import %s: %v", job.Id, err)
+		return
+	}
+	log.Printf("import %s %s: %v", job.Id, job.Status, job.Summary)
+}
+
+// importReport reports the import with the id path parameter.
+func importReport(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	job, err := getImport(ctx, request.PathParameters["id"])
+	if err != nil {
+		return Response{}, err
+	}
+	err = requireAdministers(ctx, "import "+job.Id, job.Prefix)
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, job), nil
+}
@@ -0,0 +1,191 @@
+//go:build integration
+// +build integration
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+)
+
+// localstackTransport sends every AWS API call to LocalStack. awsdocstore
+// builds its client from session.New(), which uses http.DefaultClient, so
+// swapping the default transport is enough to redirect it.
+type localstackTransport struct {
+	endpoint *url.URL
+}
+
+func (t localstackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.endpoint.Scheme
+	req.URL.Host = t.endpoint.Host
+	req.Host = t.endpoint.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// integrationStore is the LocalStack backed store. Other tests in the
+// package swap ds out, so each integration test reinstates it.
+var integrationStore docstore.DocStore
+
+func setenvDefault(key, value string) {
+	if os.Getenv(key) == "" {
+		os.Setenv(key, value)
+	}
+}
+
+func createTable(db *dynamodb.DynamoDB, input *dynamodb.CreateTableInput) error {
+	db.DeleteTable(&dynamodb.DeleteTableInput{TableName: input.TableName})
+	input.SetBillingMode(dynamodb.BillingModePayPerRequest)
+	_, err := db.CreateTable(input)
+	if err != nil {
+		return err
+	}
+	return db.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: input.TableName})
+}
+
+// createTables recreates the docs and revisions tables with the schema
+// awsdocstore expects.
+func createTables() error {
+	db := dynamodb.New(session.New())
+
+	err := createTable(db, &dynamodb.CreateTableInput{
+		TableName: aws.String("docs"),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("Id"), AttributeType: aws.String("S")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("Id"), KeyType: aws.String("HASH")},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return createTable(db, &dynamodb.CreateTableInput{
+		TableName: aws.String("revisions"),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("DocId"), AttributeType: aws.String("S")},
+			{AttributeName: aws.String("Id"), AttributeType: aws.String("N")},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("DocId"), KeyType: aws.String("HASH")},
+			{AttributeName: aws.String("Id"), KeyType: aws.String("RANGE")},
+		},
+	})
+}
+
+func TestMain(m *testing.M) {
+	setenvDefault("LOCALSTACK_ENDPOINT", "http://localhost:4566")
+	setenvDefault("AWS_REGION", "us-west-2")
+	setenvDefault("AWS_ACCESS_KEY_ID", "test")
+	setenvDefault("AWS_SECRET_ACCESS_KEY", "test")
+
+	endpoint, err := url.Parse(os.Getenv("LOCALSTACK_ENDPOINT"))
+	if err != nil {
+		panic(err)
+	}
+	http.DefaultClient.Transport = localstackTransport{endpoint}
+
+	err = createTables()
+	if err != nil {
+		panic(err)
+	}
+
+	integrationStore = awsdocstore.New()
+	os.Exit(m.Run())
+}
+
+func get(t *testing.T, docId string) Response {
+	req := events.APIGatewayProxyRequest{
+		Path:           "/" + docId,
+		PathParameters: map[string]string{"docId": docId},
+	}
+	resp, err := Handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func put(t *testing.T, docId, body string) {
+	_, err := ds.PutRevision(docId, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegrationRender(t *testing.T) {
+	useStore(integrationStore)
+
+	put(t, tmplDocName, testTemplate)
+	put(t, "hello", "Hello\n\nFirst *revision*.")
+
+	resp := get(t, "hello")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+	for _, want := range []string{"<title>Hello</title>", "<em>revision</em>", "Version 1"} {
+		if !strings.Contains(resp.Body, want) {
+			t.Errorf("body missing %q:\n%s", want, resp.Body)
+		}
+	}
+
+	put(t, "hello", "Hello\n\nSecond revision.")
+	resp = get(t, "hello")
+	if !strings.Contains(resp.Body, "Version 2") {
+		t.Errorf("expected the second revision:\n%s", resp.Body)
+	}
+}
+
+func TestIntegrationRawDoc(t *testing.T) {
+	useStore(integrationStore)
+
+	put(t, "style.css", "body { margin: 0; }")
+
+	resp := get(t, "style.css")
+	if resp.StatusCode != 200 || resp.Body != "body { margin: 0; }" {
+		t.Errorf("got status %d body %q", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestIntegrationNotFound(t *testing.T) {
+	useStore(integrationStore)
+
+	resp := get(t, "missing")
+	if resp.StatusCode != 404 {
+		t.Errorf("status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestIntegrationListDocs(t *testing.T) {
+	useStore(integrationStore)
+
+	put(t, "listed", "Listed")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		page, err := ds.ListDocs("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range page.Docs {
+			if doc.Id == "listed" {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("listed doc not returned by ListDocs")
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+)
+
+type ldThing struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type ldArticle struct {
+	Context             string   `json:"@context"`
+	Type                string   `json:"@type"`
+	Headline            string   `json:"headline"`
+	Description         string   `json:"description,omitempty"`
+	DateModified        string   `json:"dateModified"`
+	Version             int      `json:"version"`
+	URL                 string   `json:"url,omitempty"`
+	Author              *ldThing `json:"author,omitempty"`
+	Publisher           *ldThing `json:"publisher,omitempty"`
+	IsAccessibleForFree bool     `json:"isAccessibleForFree"`
+}
+
+// jsonLD builds a schema.org Article, or the type named in the doc's front
+// matter, describing the page.
+func jsonLD(cfg *siteConfig, docId string, fm frontMatter, meta docMetadata) string {
+	a := ldArticle{
+		Context:             "https://schema.org",
+		Type:                "Article",
+		Headline:            meta.Title,
+		Description:         fm.Description,
+		DateModified:        meta.TimestampISO,
+		Version:             meta.Version,
+		IsAccessibleForFree: true,
+	}
+	if fm.SchemaType != "" {
+		a.Type = fm.SchemaType
+	}
+	a.URL = canonicalURL(cfg, docId, fm)
+	if fm.Author != "" {
+		a.Author = &ldThing{Type: "Person", Name: fm.Author}
+	}
+	if cfg.Name != "" {
+		a.Publisher = &ldThing{Type: "Organization", Name: cfg.Name}
+	}
+
+	// json.Marshal escapes <, > and &, so the data can't close the script
+	// element early.
+	b, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("JSON-LD error for %s: %v", docId, err)
+		return ""
+	}
+
+	return `<script type="application/ld+json">` + string(b) + "</script>"
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// jwksURL is the JSON Web Key Set that bearer tokens are verified
+	// against, like a Cognito user pool's
+	// https://cognito-idp.{region}.amazonaws.com/{poolId}/.well-known/jwks.json.
+	// Bearer tokens are ignored when it is unset.
+	jwksURL = os.Getenv("JWKS_URL")
+
+	// jwtIssuer and jwtAudience, when set, must match a token's iss and
+	// its aud or client_id claims.
+	jwtIssuer   = os.Getenv("JWT_ISSUER")
+	jwtAudience = os.Getenv("JWT_AUDIENCE")
+
+	// jwksTTL is how long a warm container uses the key set before
+	// fetching it again. A token signed with an unknown key fetches it
+	// sooner, at most once a minute, to pick up rotated keys.
+	jwksTTL = envDuration("JWKS_TTL", time.Hour)
+
+	keySets = &jwksCache{}
+)
+
+type jwksCache struct {
+	sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// verifyJWT checks token's RS256 signature, expiry, issuer and audience,
+// and returns its claims.
+func verifyJWT(ctx context.Context, token string) (map[string]interface{}, error) {
+	op := "verify token"
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, docerr.E(op, docerr.ErrUnauthorized, errors.New("malformed token"))
+	}
+
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &hdr)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrUnauthorized, err)
+	}
+	if hdr.Alg != "RS256" {
+		return nil, docerr.E(op, docerr.ErrUnauthorized, fmt.Errorf("unsupported alg %q", hdr.Alg))
+	}
+
+	key, err := jwksKey(ctx, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrUnauthorized, err)
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrUnauthorized, err)
+	}
+
+	var claims map[string]interface{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrUnauthorized, err)
+	}
+	err = checkClaims(claims, time.Now())
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrUnauthorized, err)
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// checkClaims checks a verified token's time, issuer and audience claims.
+func checkClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Unix() >= int64(exp) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Unix() < int64(nbf) {
+		return errors.New("token not yet valid")
+	}
+	if jwtIssuer != "" && claims["iss"] != jwtIssuer {
+		return fmt.Errorf("issuer %v", claims["iss"])
+	}
+	if jwtAudience != "" && !hasAudience(claims, jwtAudience) {
+		return errors.New("wrong audience")
+	}
+	return nil
+}
+
+// hasAudience reports whether the token is meant for aud: Cognito ID
+// tokens name the app client in aud, access tokens in client_id.
+func hasAudience(claims map[string]interface{}, aud string) bool {
+	if claims["client_id"] == aud {
+		return true
+	}
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwksKey returns the key kid from the key set.
+func jwksKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	keySets.Lock()
+	defer keySets.Unlock()
+
+	age := time.Since(keySets.fetched)
+	if key, ok := keySets.keys[kid]; ok && age < jwksTTL {
+		return key, nil
+	}
+
+	if keySets.keys == nil || age >= time.Minute {
+		keys, err := fetchJWKS(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keySets.keys, keySets.fetched = keys, time.Now()
+	}
+
+	key, ok := keySets.keys[kid]
+	if !ok {
+		return nil, docerr.E("verify token", docerr.ErrUnauthorized, fmt.Errorf("unknown key %q", kid))
+	}
+	return key, nil
+}
+
+func fetchJWKS(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	op := "fetch " + jwksURL
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", jwksURL, nil)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrBackend, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrBackend, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, docerr.E(op, docerr.ErrBackend, fmt.Errorf("status %d", resp.StatusCode))
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
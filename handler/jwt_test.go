@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// testIssuer serves a key set for tokens it signs, and points the token
+// checks at it until the test ends.
+type testIssuer struct {
+	key *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+
+	url, iss, aud := jwksURL, jwtIssuer, jwtAudience
+	jwksURL, jwtIssuer, jwtAudience = jwks.URL, "https://issuer.example", "app"
+	keySets = &jwksCache{}
+	t.Cleanup(func() {
+		jwks.Close()
+		jwksURL, jwtIssuer, jwtAudience = url, iss, aud
+		keySets = &jwksCache{}
+	})
+	return &testIssuer{key}
+}
+
+// segment encodes v as a token segment.
+func segment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a token with the header and claims, signed with key.
+func (ti *testIssuer) sign(t *testing.T, key *rsa.PrivateKey, hdr, claims map[string]interface{}) string {
+	signed := segment(t, hdr) + "." + segment(t, claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// token returns a valid token for user in groups.
+func (ti *testIssuer) token(t *testing.T, user string, groups ...string) string {
+	return ti.sign(t, ti.key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, map[string]interface{}{
+		"sub":            user,
+		"iss":            "https://issuer.example",
+		"aud":            "app",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"cognito:groups": groups,
+	})
+}
+
+func TestVerifyJWT(t *testing.T) {
+	ti := newTestIssuer(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "k1"}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "reader",
+			"iss": "https://issuer.example",
+			"aud": "app",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", ti.sign(t, ti.key, rs256, claims(nil)), true},
+		{"audience list", ti.sign(t, ti.key, rs256, claims(map[string]interface{}{"aud": []string{"other", "app"}})), true},
+		{"client id", ti.sign(t, ti.key, rs256, claims(map[string]interface{}{"aud": nil, "client_id": "app"})), true},
+		{"expired", ti.sign(t, ti.key, rs256, claims(map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})), false},
+		{"no expiry", ti.sign(t, ti.key, rs256, claims(map[string]interface{}{"exp": nil})), false},
+		{"not yet valid", ti.sign(t, ti.key, rs256, claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})), false},
+		{"wrong audience", ti.sign(t, ti.key, rs256, claims(map[string]interface{}{"aud": "other"})), false},
+		{"wrong issuer", ti.sign(t, ti.key, rs256, claims(map[string]interface{}{"iss": "https://evil.example"})), false},
+		{"wrong alg", ti.sign(t, ti.key, map[string]interface{}{"alg": "HS256", "kid": "k1"}, claims(nil)), false},
+		{"alg none", segment(t, map[string]string{"alg": "none", "kid": "k1"}) + "." + segment(t, claims(nil)) + ".", false},
+		{"unknown kid", ti.sign(t, ti.key, map[string]interface{}{"alg": "RS256", "kid": "k2"}, claims(nil)), false},
+		{"wrong key", ti.sign(t, other, rs256, claims(nil)), false},
+		{"malformed", "not.a-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyJWT(context.Background(), tt.token)
+			if tt.ok && err != nil {
+				t.Errorf("verifyJWT: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, docerr.ErrUnauthorized) {
+				t.Errorf("verifyJWT: got %v, want unauthorized", err)
+			}
+		})
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	ti := newTestIssuer(t)
+	store := newMemStore()
+	store.put("open", "# Open")
+	store.put("secret", "---\nprivate: true\n---\n# Secret")
+	store.put("staff-only", "---\naccess: [staff]\n---\n# Staff")
+	useStore(store)
+
+	expired := ti.sign(t, ti.key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, map[string]interface{}{
+		"sub": "reader", "iss": "https://issuer.example", "aud": "app",
+		"exp": time.Now().Add(-time.Minute).Unix(), "cognito:groups": []string{"staff"},
+	})
+
+	tests := []struct {
+		name, docId, token string
+		private            bool
+		want               error
+	}{
+		{"public", "open", "", false, nil},
+		{"missing", "nothing-here", "", false, nil},
+		{"private anonymous", "secret", "", true, docerr.ErrUnauthorized},
+		{"private signed in", "secret", ti.token(t, "reader"), true, nil},
+		{"group member", "staff-only", ti.token(t, "reader", "staff"), true, nil},
+		{"group mismatch", "staff-only", ti.token(t, "reader", "other"), true, docerr.ErrForbidden},
+		{"no groups", "staff-only", ti.token(t, "reader"), true, docerr.ErrForbidden},
+		{"expired token", "staff-only", expired, true, docerr.ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{Headers: map[string]string{}}
+			if tt.token != "" {
+				request.Headers["Authorization"] = "Bearer " + tt.token
+			}
+			_, private, err := checkAccess(context.Background(), request, tt.docId)
+			if private != tt.private {
+				t.Errorf("private = %v, want %v", private, tt.private)
+			}
+			if (tt.want == nil && err != nil) || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("checkAccess: got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// The labels of a private doc are only listed to its readers.
+	request := events.APIGatewayProxyRequest{PathParameters: map[string]string{"docId": "secret"}}
+	if _, err := listLabels(context.Background(), request); !errors.Is(err, docerr.ErrUnauthorized) {
+		t.Errorf("listLabels of a private doc: got %v, want unauthorized", err)
+	}
+	request.Headers = map[string]string{"Authorization": "Bearer " + ti.token(t, "reader")}
+	resp, err := listLabels(context.Background(), request)
+	if err != nil || resp.Headers["Cache-Control"] != privateHeaders["Cache-Control"] {
+		t.Errorf("listLabels signed in: %v, headers %v", err, resp.Headers)
+	}
+}
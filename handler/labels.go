@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"gopkg.in/yaml.v2"
+)
+
+// labelsDocName holds the named revisions of docs, like "v1.2-release" or
+// "approved-legal", by docId and then label. It is only changed through
+// the label endpoints, which audit every change.
+const labelsDocName = "_labels"
+
+// validLabel matches a label. Labels appear in URLs, and can't be all
+// digits so they aren't mistaken for revision numbers.
+var validLabel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+func isLabel(s string) bool {
+	if !validLabel.MatchString(s) {
+		return false
+	}
+	_, err := strconv.Atoi(s)
+	return err != nil
+}
+
+// getLabels returns every doc's labels.
+func getLabels(ctx context.Context) (map[string]map[string]int, error) {
+	labels := map[string]map[string]int{}
+
+	flights.Forget("doc:" + labelsDocName)
+	doc, err := fetchDoc(ctx, labelsDocName)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return labels, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(doc.body, &labels)
+	if err != nil {
+		return nil, docerr.E("parse "+labelsDocName, docerr.ErrBackend, err)
+	}
+	return labels, nil
+}
+
+// docLabels returns the labels of docId's revisions.
+func docLabels(ctx context.Context, docId string) (map[string]int, error) {
+	labels, err := getLabels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if labels[docId] == nil {
+		return map[string]int{}, nil
+	}
+	return labels[docId], nil
+}
+
+// labelsByVersion inverts a doc's labels.
+func labelsByVersion(labels map[string]int) map[int][]string {
+	byVersion := map[int][]string{}
+	for label, n := range labels {
+		byVersion[n] = append(byVersion[n], label)
+	}
+	return byVersion
+}
+
+func putLabels(labels map[string]map[string]int) error {
+	b, err := yaml.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(labelsDocName, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+labelsDocName, err)
+	}
+	return nil
+}
+
+// listLabels reports the labels of the docId path parameter's revisions,
+// to readers allowed to read it.
+func listLabels(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	ctx, private, err := checkAccess(ctx, request, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	labels, err := docLabels(ctx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	resp := jsonResponse(200, struct {
+		Labels map[string]int `json:"labels"`
+	}{labels})
+	if private {
+		resp = withHeaders(resp, privateHeaders)
+	}
+	return resp, nil
+}
+
+// putLabel names a revision of the docId path parameter with the label
+// path parameter: {"version": 3}. A label already in use is moved.
+func putLabel(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId, label := request.PathParameters["docId"], request.PathParameters["label"]
+	op := "label " + docId
+	if !isLabel(label) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"label": "letters, digits, '.', '_' and '-', not all digits"})
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	var v struct {
+		Version int `json:"version"`
+	}
+	err = json.Unmarshal(body, &v)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+
+	_, err = fetchRevision(ctx, docId, v.Version)
+	if err != nil {
+		return Response{}, err
+	}
+
+	labels, err := getLabels(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	if labels[docId] == nil {
+		labels[docId] = map[string]int{}
+	}
+	prev, moved := labels[docId][label]
+	labels[docId][label] = v.Version
+	err = putLabels(labels)
+	if err != nil {
+		return Response{}, err
+	}
+
+	details := map[string]interface{}{"label": label, "version": v.Version}
+	if moved {
+		details["previous"] = prev
+	}
+	audit(request, "label.put", docId, details)
+	return jsonResponse(200, labels[docId]), nil
+}
+
+// deleteLabel removes the label path parameter from the docId path
+// parameter.
+func deleteLabel(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId, label := request.PathParameters["docId"], request.PathParameters["label"]
+
+	labels, err := getLabels(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	n, ok := labels[docId][label]
+	if !ok {
+		return Response{}, docerr.E("label "+docId+" "+label, docerr.ErrNotFound, nil)
+	}
+
+	delete(labels[docId], label)
+	if len(labels[docId]) == 0 {
+		delete(labels, docId)
+	}
+	err = putLabels(labels)
+	if err != nil {
+		return Response{}, err
+	}
+
+	audit(request, "label.delete", docId, map[string]interface{}{"label": label, "version": n})
+	return Response{StatusCode: 204}, nil
+}
+
+// labeledVersion returns the revision of docId named label.
+func labeledVersion(ctx context.Context, docId, label string) (int, error) {
+	labels, err := docLabels(ctx, docId)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := labels[label]
+	if !ok {
+		return 0, docerr.E("label "+docId+" "+label, docerr.ErrNotFound, nil)
+	}
+	return n, nil
+}
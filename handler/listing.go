@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// indexDocName is the doc served at "/". Without one, "/" lists every
+	// doc instead, through indexTmplDocName if there is one.
+	indexDocName     = "index"
+	indexTmplDocName = "index-template.html"
+
+	// indexJSONPath lists every doc as JSON.
+	indexJSONPath = "/index.json"
+)
+
+// listedDoc describes a doc in the JSON listing.
+type listedDoc struct {
+	DocId     string    `json:"docId"`
+	Title     string    `json:"title"`
+	Timestamp time.Time `json:"timestamp"`
+	Version   int       `json:"version"`
+}
+
+// recentDocs returns every listed doc, most recently updated first.
+func recentDocs(ctx context.Context) ([]docSummary, error) {
+	c, err := getCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := append([]docSummary{}, c...)
+	sort.Slice(docs, func(i, j int) bool {
+		if !docs[i].Timestamp.Equal(docs[j].Timestamp) {
+			return docs[i].Timestamp.After(docs[j].Timestamp)
+		}
+		return docs[i].DocId < docs[j].DocId
+	})
+	return docs, nil
+}
+
+// serveIndexJSON lists every doc for programs.
+func serveIndexJSON(ctx context.Context) (Response, error) {
+	docs, err := recentDocs(ctx)
+	if err != nil {
+		return Response{}, docerr.FromStore("list docs", err)
+	}
+
+	listing := []listedDoc{}
+	for _, d := range docs {
+		listing = append(listing, listedDoc{d.DocId, d.Title, d.Timestamp, d.Version})
+	}
+	return jsonResponse(200, struct {
+		Docs []listedDoc `json:"docs"`
+	}{listing}), nil
+}
+
+// serveIndex serves the index doc, or a generated list of every doc if
+// there isn't one.
+func serveIndex(ctx context.Context) (Response, error) {
+	resp, err := serveDoc(ctx, indexDocName)
+	if !errors.Is(err, docerr.ErrNotFound) {
+		return resp, err
+	}
+	return generatedIndex(ctx)
+}
+
+// generatedIndex lists every doc, for a site without an index doc.
+func generatedIndex(ctx context.Context) (Response, error) {
+	tf := renderFrom(ctx).tf
+	docs, err := recentDocs(ctx)
+	if err != nil {
+		return Response{}, docerr.FromStore("list docs", err)
+	}
+
+	var b strings.Builder
+	b.WriteString("<ul class=\"docs\">\n")
+	for _, d := range docs {
+		if d.Title == "" {
+			d.Title = d.DocId
+		}
+		fmt.Fprintf(&b, "<li><a href=\"/%s\">%s</a> <time datetime=\"%s\">%s</time></li>\n",
+			d.DocId, html.EscapeString(d.Title), tf.iso(d.Timestamp), tf.format(d.Timestamp))
+	}
+	b.WriteString("</ul>\n")
+
+	name := getConfig(ctx).Name
+	if name == "" {
+		name = "Documents"
+	}
+	meta := docMetadata{
+		Title:          name,
+		DocBody:        b.String(),
+		Docs:           docs,
+		RecentlyViewed: viewedDocs(ctx),
+		AsOf:           pageAsOfBanner(ctx, indexDocName),
+	}
+	if len(docs) > 0 {
+		meta.Timestamp = tf.format(docs[0].Timestamp)
+		meta.TimestampISO = tf.iso(docs[0].Timestamp)
+		meta.UpdatedAgo = ago(docs[0].Timestamp, time.Now())
+	}
+
+	resp, err := renderPage(ctx, indexTmplDocName, meta)
+	if missingTemplate(err) {
+		resp, err = renderPage(ctx, tmplDocName, meta)
+	}
+	if err == nil && len(meta.RecentlyViewed) > 0 {
+		resp = withHeaders(resp, map[string]string{"Cache-Control": "private"})
+	}
+	return resp, err
+}
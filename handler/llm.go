@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+var (
+	// openAIKey is the OpenAI API key, or a reference to where it is kept,
+	// like "ssm:/docstore/openai-key", for the "openai" model provider.
+	openAIKey = os.Getenv("OPENAI_API_KEY")
+
+	// modelTimeout bounds each request to a language model.
+	modelTimeout = envDuration("MODEL_TIMEOUT", 25*time.Second)
+)
+
+// languageModel completes prompts, for features like abstracts and
+// answers that need one.
+type languageModel interface {
+	Complete(ctx context.Context, prompt string, maxTokens int) (string, error)
+}
+
+// newLanguageModel returns the named provider's model: "bedrock", for a
+// model on Amazon Bedrock, or "openai". model may be empty for the
+// provider's default.
+func newLanguageModel(provider, model string) (languageModel, error) {
+	client := &http.Client{Timeout: modelTimeout}
+	switch provider {
+	case "bedrock":
+		if model == "" {
+			model = "anthropic.claude-3-haiku-20240307-v1:0"
+		}
+		return &bedrockModel{model, client}, nil
+	case "openai":
+		if openAIKey == "" {
+			return nil, fmt.Errorf("openai needs OPENAI_API_KEY")
+		}
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		return &openAIModel{model, client}, nil
+	}
+	return nil, fmt.Errorf("unknown model provider %q: want bedrock or openai", provider)
+}
+
+// bedrockModel is a model on Amazon Bedrock, used with the Converse API so
+// that any text model will do. The SDK this module is pinned to predates
+// Bedrock, so requests are signed here.
+type bedrockModel struct {
+	model  string
+	client *http.Client
+}
+
+func (b *bedrockModel) Complete(ctx context.Context, prompt string, maxTokens int) (string, error) {
+	var out struct {
+		Output struct {
+			Message struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"message"`
+		} `json:"output"`
+	}
+	err := bedrockRequest(ctx, b.client, b.model, "converse", map[string]interface{}{
+		"messages": []interface{}{map[string]interface{}{
+			"role":    "user",
+			"content": []interface{}{map[string]string{"text": prompt}},
+		}},
+		"inferenceConfig": map[string]int{"maxTokens": maxTokens},
+	}, &out)
+	if err != nil {
+		return "", err
+	}
+	var s strings.Builder
+	for _, c := range out.Output.Message.Content {
+		s.WriteString(c.Text)
+	}
+	return strings.TrimSpace(s.String()), nil
+}
+
+// bedrockRequest posts in to the action, like "converse" or "invoke", of
+// model on Amazon Bedrock and decodes the reply into out.
+func bedrockRequest(ctx context.Context, client *http.Client, model, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	sess := awsSession()
+	region := aws.StringValue(sess.Config.Region)
+	endpoint := fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com/model/%s/%s", region, url.PathEscape(model), action)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = v4.NewSigner(sess.Config.Credentials).Sign(req, bytes.NewReader(body), "bedrock", region, time.Now())
+	if err != nil {
+		return err
+	}
+	return doModelRequest(client, req, "bedrock "+model, out)
+}
+
+// openAIModel is a model on OpenAI's chat completions API.
+type openAIModel struct {
+	model  string
+	client *http.Client
+}
+
+func (o *openAIModel) Complete(ctx context.Context, prompt string, maxTokens int) (string, error) {
+	var out struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	err := openAIRequest(ctx, o.client, "chat/completions", map[string]interface{}{
+		"model":      o.model,
+		"messages":   []map[string]string{{"role": "user", "content": prompt}},
+		"max_tokens": maxTokens,
+	}, &out)
+	if err != nil {
+		return "", err
+	}
+	if len(out.Choices) == 0 {
+		return "", fmt.Errorf("openai %s: no choices", o.model)
+	}
+	return strings.TrimSpace(out.Choices[0].Message.Content), nil
+}
+
+// openAIRequest posts in to the OpenAI API at path, like
+// "chat/completions", and decodes the reply into out.
+func openAIRequest(ctx context.Context, client *http.Client, path string, in, out interface{}) error {
+	key, err := resolveSecret(ctx, openAIKey)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	return doModelRequest(client, req, "openai "+path, out)
+}
+
+// embedder turns text into a vector, near the vectors of texts with
+// similar meanings.
+type embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// newEmbedder returns the named provider's embedding model, like
+// newLanguageModel.
+func newEmbedder(provider, model string) (embedder, error) {
+	client := &http.Client{Timeout: modelTimeout}
+	switch provider {
+	case "bedrock":
+		if model == "" {
+			model = "amazon.titan-embed-text-v2:0"
+		}
+		return &bedrockEmbedder{model, client}, nil
+	case "openai":
+		if openAIKey == "" {
+			return nil, fmt.Errorf("openai needs OPENAI_API_KEY")
+		}
+		if model == "" {
+			model = "text-embedding-3-small"
+		}
+		return &openAIEmbedder{model, client}, nil
+	}
+	return nil, fmt.Errorf("unknown model provider %q: want bedrock or openai", provider)
+}
+
+// bedrockEmbedder is an Amazon Titan text embeddings model on Bedrock.
+type bedrockEmbedder struct {
+	model  string
+	client *http.Client
+}
+
+func (b *bedrockEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	var out struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	err := bedrockRequest(ctx, b.client, b.model, "invoke", map[string]string{"inputText": text}, &out)
+	return out.Embedding, err
+}
+
+// openAIEmbedder is an OpenAI embeddings model.
+type openAIEmbedder struct {
+	model  string
+	client *http.Client
+}
+
+func (o *openAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	var out struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	err := openAIRequest(ctx, o.client, "embeddings", map[string]string{"model": o.model, "input": text}, &out)
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Data) == 0 {
+		return nil, fmt.Errorf("openai %s: no embedding", o.model)
+	}
+	return out.Data[0].Embedding, nil
+}
+
+// doModelRequest sends req and decodes a successful JSON reply into out.
+func doModelRequest(client *http.Client, req *http.Request, op string, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: status %d: %.200s", op, resp.StatusCode, b)
+	}
+	return json.Unmarshal(b, out)
+}
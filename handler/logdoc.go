@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/gomarkdown/markdown"
+	mdhtml "github.com/gomarkdown/markdown/html"
+)
+
+// logDocType is the front matter type of changelog docs. A log doc is an
+// introduction followed by entries, each starting with a line holding "@"
+// and its time:
+//
+//	---
+//	type: log
+//	---
+//	Release Notes
+//
+//	@ 2020-09-01T12:00:00Z
+//	Shipped the thing.
+//
+// Entries are usually added through the API, and are rendered newest first
+// grouped by date, each date with an anchor.
+const logDocType = "log"
+
+const logEntryPrefix = "@ "
+
+type logEntry struct {
+	Time time.Time
+	Text string
+}
+
+// splitLog separates a log doc's body into its introduction and entries.
+// Lines starting with "@" that aren't followed by a time are entry text.
+func splitLog(body []byte) (intro string, entries []logEntry) {
+	var b strings.Builder
+	var cur *logEntry
+	flush := func() {
+		if cur == nil {
+			intro = b.String()
+		} else {
+			cur.Text = b.String()
+			entries = append(entries, *cur)
+		}
+		b.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(string(body), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, logEntryPrefix) {
+			t, err := time.Parse(time.RFC3339, strings.TrimSpace(trimmed[len(logEntryPrefix):]))
+			if err == nil {
+				flush()
+				cur = &logEntry{Time: t}
+				continue
+			}
+		}
+		b.WriteString(line)
+	}
+	flush()
+
+	return
+}
+
+// renderLog renders a log doc's entries newest first, grouped by date in
+// the reader's time zone, leaving out their raw HTML if stripHTML is set.
+func renderLog(entries []logEntry, tf timeFormat, stripHTML bool) string {
+	flags := mdhtml.CommonFlags
+	if stripHTML {
+		flags |= mdhtml.SkipHTML
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+
+	var b strings.Builder
+	date := ""
+	for _, e := range entries {
+		t := e.Time.In(tf.loc)
+		if d := t.Format("2006-01-02"); d != date {
+			if date != "" {
+				b.WriteString("</section>\n")
+			}
+			date = d
+			fmt.Fprintf(&b, "<section class=\"log-date\" id=\"%s\">\n<h2><a href=\"#%s\">%s</a></h2>\n", d, d, html.EscapeString(t.Format("January 2, 2006")))
+		}
+		fmt.Fprintf(&b, "<div class=\"log-entry\"><time datetime=\"%s\">%s</time>\n%s</div>\n",
+			tf.iso(e.Time), html.EscapeString(t.Format("15:04 MST")),
+			markdown.ToHTML([]byte(strings.TrimSpace(e.Text)+"\n"), nil, mdhtml.NewRenderer(mdhtml.RendererOptions{Flags: flags})))
+	}
+	if date != "" {
+		b.WriteString("</section>\n")
+	}
+	return b.String()
+}
+
+// logEntryRequest is the body of a request to append a log entry. Time
+// defaults to now.
+type logEntryRequest struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// appendLogEntry adds a timestamped entry to the log doc named by the docId
+// path parameter.
+func appendLogEntry(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	op := "log entry " + docId
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var entry logEntryRequest
+	err = json.Unmarshal(body, &entry)
+	if err != nil || strings.TrimSpace(entry.Text) == "" {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	lock, _ := patchLocks.LoadOrStore(docId, new(sync.Mutex))
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	flights.Forget("doc:" + docId)
+	latest, err := fetchDoc(ctx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if fm, _ := splitFrontMatter(docId, latest.body); fm.Type != logDocType {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"reason": "not a log doc"})
+	}
+
+	text := logEntryPrefix + entry.Time.UTC().Format(time.RFC3339) + "\n" + strings.TrimSpace(entry.Text)
+	ctx = withRevisionMessage(ctx, entry.Text)
+	res, err := writeDoc(ctx, docId, []byte(appendText(string(latest.body), text)), dryRun(request))
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, res), nil
+}
@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+var (
+	// loginFailuresTable is the DynamoDB table counting failed logins by
+	// source IP, with a TTL on the Expires attribute. Clients aren't locked
+	// out when it is unset, though logins are still audited.
+	loginFailuresTable = os.Getenv("LOGIN_FAILURES_TABLE")
+
+	// loginMaxFailures failed logins from an IP within loginFailureWindow
+	// lock it out for loginLockout, doubling with every failure after
+	// that up to loginLockoutMax.
+	loginMaxFailures   = int(envFloat("LOGIN_MAX_FAILURES", 5))
+	loginFailureWindow = envDuration("LOGIN_FAILURE_WINDOW", time.Hour)
+	loginLockout       = envDuration("LOGIN_LOCKOUT", time.Minute)
+	loginLockoutMax    = envDuration("LOGIN_LOCKOUT_MAX", time.Hour)
+
+	// loginCheckTTL is how long a warm container goes by what it read of
+	// an IP's lockout.
+	loginCheckTTL = envDuration("LOGIN_CHECK_TTL", 10*time.Second)
+
+	lockouts = &lockoutCache{entries: map[string]lockoutEntry{}}
+
+	// auditedLogins are the credentials whose successful logins this
+	// container has audited, so that a client using one for every request
+	// is only audited once.
+	auditedLogins = &loginSet{seen: map[string]bool{}}
+)
+
+// maxAuditedLogins bounds auditedLogins; it is emptied when full.
+const maxAuditedLogins = 10000
+
+// loginAttempt is a credential a request presented: the method, like
+// "api-key" or "bearer", the user it names if any, and whether it was
+// accepted.
+type loginAttempt struct {
+	method string
+	user   string
+	err    error
+}
+
+// loginAttempts collects the credentials checked while serving a request.
+type loginAttempts struct {
+	sync.Mutex
+	attempts []loginAttempt
+}
+
+type loginKey struct{}
+
+// noteLogin records that a credential presented with ctx's request was
+// checked by method, with err if it was refused.
+func noteLogin(ctx context.Context, method, user string, err error) {
+	if l, ok := ctx.Value(loginKey{}).(*loginAttempts); ok {
+		l.Lock()
+		l.attempts = append(l.attempts, loginAttempt{method, user, err})
+		l.Unlock()
+	}
+}
+
+type lockoutEntry struct {
+	until    time.Time
+	failures int
+	checked  time.Time
+}
+
+type lockoutCache struct {
+	sync.Mutex
+	entries map[string]lockoutEntry
+}
+
+func (c *lockoutCache) get(ip string) lockoutEntry {
+	c.Lock()
+	defer c.Unlock()
+	return c.entries[ip]
+}
+
+type loginSet struct {
+	sync.Mutex
+	seen map[string]bool
+}
+
+// firstSeen reports whether key hasn't been seen before, remembering it.
+func (s *loginSet) firstSeen(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.seen[key] {
+		return false
+	}
+	if len(s.seen) >= maxAuditedLogins {
+		s.seen = map[string]bool{}
+	}
+	s.seen[key] = true
+	return true
+}
+
+// loginFailures is the count of an IP's failed logins.
+type loginFailures struct {
+	Key         string
+	Failures    int
+	LockedUntil int64 `dynamodbav:",omitempty"`
+	Expires     int64
+}
+
+// lockoutDuration is how long failures failed logins lock an IP out for.
+func lockoutDuration(failures int) time.Duration {
+	if failures < loginMaxFailures {
+		return 0
+	}
+	d := float64(loginLockout) * math.Pow(2, float64(failures-loginMaxFailures))
+	if d > float64(loginLockoutMax) {
+		return loginLockoutMax
+	}
+	return time.Duration(d)
+}
+
+// lockedUntil returns when ip's lockout ends, or the zero time if it isn't
+// locked out.
+func lockedUntil(ctx context.Context, ip string) (time.Time, error) {
+	e := lockouts.get(ip)
+	now := time.Now()
+	if !e.checked.IsZero() && (now.Before(e.until) || now.Sub(e.checked) < loginCheckTTL) {
+		return e.until, nil
+	}
+
+	key, err := dynamodbattribute.MarshalMap(struct{ Key string }{"ip:" + ip})
+	if err != nil {
+		return time.Time{}, err
+	}
+	out, err := dynamo().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(loginFailuresTable),
+		Key:       key,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	var f loginFailures
+	err = dynamodbattribute.UnmarshalMap(out.Item, &f)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	e = lockoutEntry{failures: f.Failures, checked: now}
+	if f.Failures > 0 && f.Expires < now.Unix() {
+		// The failures are past their window but not yet swept by the
+		// TTL, and mustn't count towards the next lockout.
+		err = clearFailures(ctx, ip)
+		if err != nil {
+			return time.Time{}, err
+		}
+		e = lockoutEntry{checked: now}
+	}
+	if f.LockedUntil > 0 {
+		e.until = time.Unix(f.LockedUntil, 0)
+	}
+	lockouts.Lock()
+	lockouts.entries[ip] = e
+	lockouts.Unlock()
+	return e.until, nil
+}
+
+// recordFailure counts a failed login from ip, locking it out once it has
+// failed loginMaxFailures times within loginFailureWindow.
+func recordFailure(ctx context.Context, ip string) (failures int, until time.Time, err error) {
+	key, err := dynamodbattribute.MarshalMap(struct{ Key string }{"ip:" + ip})
+	if err != nil {
+		return 0, until, err
+	}
+	now := time.Now()
+	out, err := dynamo().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(loginFailuresTable),
+		Key:              key,
+		UpdateExpression: aws.String("ADD Failures :one SET Expires = :exp"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":exp": {N: aws.String(strconv.FormatInt(now.Add(loginFailureWindow).Unix(), 10))},
+		},
+		ReturnValues: aws.String("UPDATED_NEW"),
+	})
+	if err != nil {
+		return 0, until, err
+	}
+	failures, _ = strconv.Atoi(aws.StringValue(out.Attributes["Failures"].N))
+
+	d := lockoutDuration(failures)
+	if d == 0 {
+		lockouts.Lock()
+		lockouts.entries[ip] = lockoutEntry{failures: failures, checked: now}
+		lockouts.Unlock()
+		return failures, until, nil
+	}
+	until = now.Add(d)
+	_, err = dynamo().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(loginFailuresTable),
+		Key:              key,
+		UpdateExpression: aws.String("SET LockedUntil = :until, Expires = :exp"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {N: aws.String(strconv.FormatInt(until.Unix(), 10))},
+			":exp":   {N: aws.String(strconv.FormatInt(until.Add(loginFailureWindow).Unix(), 10))},
+		},
+	})
+
+	lockouts.Lock()
+	lockouts.entries[ip] = lockoutEntry{until: until, failures: failures, checked: now}
+	lockouts.Unlock()
+	return failures, until, err
+}
+
+// clearFailures forgets ip's failed logins after it logs in.
+func clearFailures(ctx context.Context, ip string) error {
+	key, err := dynamodbattribute.MarshalMap(struct{ Key string }{"ip:" + ip})
+	if err != nil {
+		return err
+	}
+	_, err = dynamo().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(loginFailuresTable),
+		Key:       key,
+	})
+	lockouts.Lock()
+	delete(lockouts.entries, ip)
+	lockouts.Unlock()
+	return err
+}
+
+// presentsCredentials reports whether request carries an API key or an
+// Authorization header, which a locked out client may not try.
+func presentsCredentials(request events.APIGatewayProxyRequest) bool {
+	return header(request, "X-Api-Key") != "" || header(request, "Authorization") != ""
+}
+
+// credentialKey identifies the credentials request presents without
+// keeping them.
+func credentialKey(request events.APIGatewayProxyRequest) string {
+	sum := sha256.Sum256([]byte(header(request, "X-Api-Key") + "\n" + header(request, "Authorization")))
+	return request.RequestContext.Identity.SourceIP + " " + hex.EncodeToString(sum[:8])
+}
+
+// withLogins refuses credentials from source IPs locked out for failing to
+// log in too often, and audits logins: every failure, and the first
+// success with each credential from each IP in a container. A request
+// whose credentials aren't checked, like an API key sent to a public
+// page, isn't a login.
+func withLogins(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		if !presentsCredentials(request) {
+			return next(ctx, request)
+		}
+
+		ip := request.RequestContext.Identity.SourceIP
+		if loginFailuresTable != "" && ip != "" {
+			until, err := lockedUntil(ctx, ip)
+			if err != nil {
+				log.Printf("login lockout of %s: %v", ip, err)
+			}
+			if wait := time.Until(until); wait > 0 {
+				metrics.Incr("LoginsRefused", nil)
+				audit(request, "login.locked", "", map[string]interface{}{"until": until.UTC()})
+				secs := int(math.Ceil(wait.Seconds()))
+				err := docerr.WithDetails("login", docerr.ErrTooManyAttempts, nil,
+					map[string]interface{}{"retryAfter": secs})
+				return withHeaders(errorResponse(request, err), map[string]string{"Retry-After": strconv.Itoa(secs)}), nil
+			}
+		}
+
+		l := &loginAttempts{}
+		resp, err := next(context.WithValue(ctx, loginKey{}, l), request)
+
+		l.Lock()
+		attempts := l.attempts
+		l.Unlock()
+		failed := false
+		for _, a := range attempts {
+			details := map[string]interface{}{"method": a.method}
+			if a.user != "" {
+				details["user"] = a.user
+			}
+			if a.err == nil {
+				if auditedLogins.firstSeen(credentialKey(request) + " " + a.method) {
+					audit(request, "login.success", "", details)
+				}
+				continue
+			}
+			failed = true
+			details["error"] = a.err.Error()
+			audit(request, "login.failure", "", details)
+			metrics.Incr("LoginFailures", map[string]string{"Method": a.method})
+		}
+		if len(attempts) == 0 || loginFailuresTable == "" || ip == "" {
+			return resp, err
+		}
+
+		if failed {
+			failures, until, ferr := recordFailure(ctx, ip)
+			if ferr != nil {
+				log.Printf("counting failed login from %s: %v", ip, ferr)
+			} else if !until.IsZero() {
+				audit(request, "login.lockout", "", map[string]interface{}{"failures": failures, "until": until.UTC()})
+			}
+		} else if lockouts.get(ip).failures > 0 {
+			if cerr := clearFailures(ctx, ip); cerr != nil {
+				log.Printf("clearing failed logins from %s: %v", ip, cerr)
+			}
+		}
+		return resp, err
+	}
+}
+
+// bearerUser returns the subject a bearer token claims, unverified, for
+// auditing a failed login with it.
+func bearerUser(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	var claims map[string]interface{}
+	if decodeSegment(parts[1], &claims) != nil {
+		return ""
+	}
+	for _, k := range []string{"email", "cognito:username", "username", "sub"} {
+		if v, ok := claims[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
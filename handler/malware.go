@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+var (
+	// malwareScanURL is a scanning service, like a clamav-rest container
+	// or a ClamAV Lambda behind a function URL, that uploaded assets are
+	// sent to before they're written. It takes the file as a POST body and
+	// answers with a malwareVerdict.
+	malwareScanURL = os.Getenv("MALWARE_SCAN_URL")
+
+	// malwareScanTimeout bounds a scan.
+	malwareScanTimeout = envDuration("MALWARE_SCAN_TIMEOUT", 10*time.Second)
+
+	// quarantineBucket is an S3 bucket that uploads failing the scan are
+	// kept in, for inspection, instead of the store.
+	quarantineBucket = os.Getenv("QUARANTINE_BUCKET")
+
+	malwareClient = &http.Client{}
+)
+
+// malwareVerdict is the scanning service's answer.
+type malwareVerdict struct {
+	Clean   bool     `json:"clean"`
+	Threats []string `json:"threats"`
+}
+
+// needsMalwareScan reports whether writes to docId are scanned: assets,
+// which are served as uploaded, when a scanning service is configured.
+func needsMalwareScan(docId string) bool {
+	return malwareScanURL != "" && !isPage(docId) && !strings.HasPrefix(docId, "_")
+}
+
+// scanMalware sends body to the scanning service. A scan that can't be
+// done is an error, so nothing unscanned becomes servable.
+func scanMalware(ctx context.Context, docId string, body []byte) (malwareVerdict, error) {
+	var v malwareVerdict
+	op := "malware scan " + docId
+
+	ctx, cancel := context.WithTimeout(ctx, malwareScanTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", malwareScanURL, bytes.NewReader(body))
+	if err != nil {
+		return v, docerr.E(op, docerr.ErrBackend, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := malwareClient.Do(req)
+	if err != nil {
+		return v, docerr.E(op, docerr.ErrBackend, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return v, docerr.E(op, docerr.ErrBackend, fmt.Errorf("scanner returned %s", resp.Status))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	if err != nil {
+		return v, docerr.E(op, docerr.ErrBackend, err)
+	}
+	return v, nil
+}
+
+// quarantine keeps an upload that failed the scan in the quarantine
+// bucket, keyed by its hash so repeated uploads are kept once.
+func quarantine(ctx context.Context, docId string, body []byte, v malwareVerdict) {
+	metrics.Incr("Quarantined", nil)
+	if quarantineBucket == "" {
+		return
+	}
+
+	key := fmt.Sprintf("quarantine/%s/%x", docId, sha256.Sum256(body))
+	_, err := s3.New(awsSession()).PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String(quarantineBucket),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(body),
+		Metadata: map[string]*string{"Threats": aws.String(strings.Join(v.Threats, ","))},
+	})
+	if err != nil {
+		log.Printf("quarantine %s: %v", docId, err)
+		return
+	}
+	log.Printf("quarantined %s as s3://%s/%s: %v", docId, quarantineBucket, key, v.Threats)
+}
+
+// checkMalware scans an upload to docId if it needs it, quarantining it
+// and refusing the write if it isn't clean.
+func checkMalware(ctx context.Context, docId string, body []byte) error {
+	if !needsMalwareScan(docId) {
+		return nil
+	}
+
+	v, err := scanMalware(ctx, docId, body)
+	if err != nil {
+		return err
+	}
+	if v.Clean {
+		return nil
+	}
+
+	quarantine(ctx, docId, body, v)
+	return docerr.WithDetails("write "+docId, docerr.ErrBadRequest, nil,
+		map[string]interface{}{"docId": docId, "threats": v.Threats})
+}
@@ -0,0 +1,380 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// mdExtensions are the markdown extensions docs are parsed with: the
+// common ones, which include tables, fenced code and {#id} heading ids,
+// plus footnotes and an automatic id for every heading.
+const mdExtensions = parser.CommonExtensions | parser.AutoHeadingIDs | parser.Footnotes
+
+// wikiLinkPattern matches [[docId]] and [[docId|text]].
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]+))?\]\]`)
+
+// htmlIdPattern matches the id or name of an element in a doc's raw HTML,
+// which a link can point at as well as a heading.
+var htmlIdPattern = regexp.MustCompile(`\b(?:id|name)\s*=\s*["']([^"']+)["']`)
+
+// tocEntry is a heading of a doc, for templates to build a table of
+// contents from.
+type tocEntry struct {
+	Level    int
+	ID, Text string
+
+	// Number is the heading's section number, like "1.2", in numbered
+	// docs.
+	Number string
+}
+
+// wikiLink is a [[docId]] link. Missing is set when no doc docId exists,
+// so readers and editors can spot dead links.
+type wikiLink struct {
+	ast.Container
+	DocId   string
+	Missing bool
+}
+
+// resolveFunc returns the title of docId, and whether it exists.
+type resolveFunc func(docId string) (title string, ok bool)
+
+// footnote is a doc's footnote, for themes to show in a popover by its
+// reference as well as in the list the page ends with.
+type footnote struct {
+	Number int
+
+	// ID is the id of the footnote in the list, and RefID that of its
+	// reference in the text, as the HTML renderer gives them.
+	ID, RefID string
+
+	// Text is the footnote without markup, and HTML rendered.
+	Text, HTML string
+}
+
+// markdownOptions are how a doc's markdown is rendered.
+type markdownOptions struct {
+	// Numbered numbers headings, figures and tables; see numbering.go.
+	Numbered bool
+
+	// StripHTML leaves out raw HTML; see rawhtml.go.
+	StripHTML bool
+
+	// Engine names the markdownRenderer to use, the default if empty.
+	Engine string
+}
+
+// gomarkdownRenderer renders docs with gomarkdown, the default engine,
+// and the one wiki links, numbering, footnotes and raw HTML stripping
+// were written for.
+type gomarkdownRenderer struct{}
+
+func (gomarkdownRenderer) Render(md []byte, resolve resolveFunc, opts markdownOptions) ([]byte, []tocEntry, []footnote) {
+	doc := markdown.Parse(md, parser.NewWithExtensions(mdExtensions))
+	if opts.StripHTML {
+		stripRawHTML(doc)
+	}
+	linkWiki(doc, resolve)
+	toc := headings(doc)
+	if opts.Numbered {
+		numberDoc(doc, toc)
+	}
+
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{
+		Flags:          mdhtml.CommonFlags,
+		RenderNodeHook: renderNode,
+	})
+	return markdown.Render(doc, renderer), toc, footnotes(doc, renderer)
+}
+
+// footnotes returns doc's footnotes in order, rendered with renderer.
+func footnotes(doc ast.Node, renderer markdown.Renderer) []footnote {
+	var notes []footnote
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		item, ok := node.(*ast.ListItem)
+		if !ok || !entering || item.RefLink == nil {
+			return ast.GoToNext
+		}
+
+		var b bytes.Buffer
+		for _, c := range item.GetChildren() {
+			ast.WalkFunc(c, func(n ast.Node, entering bool) ast.WalkStatus {
+				return renderer.RenderNode(&b, n, entering)
+			})
+		}
+		slug := footnoteSlug(item.RefLink)
+		notes = append(notes, footnote{
+			Number: len(notes) + 1,
+			ID:     "fn:" + slug,
+			RefID:  "fnref:" + slug,
+			Text:   strings.TrimSpace(plainText(item)),
+			HTML:   strings.TrimSpace(b.String()),
+		})
+		return ast.SkipChildren
+	})
+	return notes
+}
+
+// footnoteSlug makes a footnote's label into the fragment the HTML
+// renderer gives it: runs of anything but ASCII letters and digits become
+// a "-", and leading and trailing ones are dropped.
+func footnoteSlug(label []byte) string {
+	var b strings.Builder
+	sym := false
+	for _, c := range label {
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			b.WriteByte(c)
+			sym = false
+		} else if !sym {
+			b.WriteByte('-')
+			sym = true
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// docResolver resolves wiki links against the store.
+func docResolver(ctx context.Context) resolveFunc {
+	return func(docId string) (string, bool) {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		defer cancel()
+
+		doc, err := fetchDoc(fetchCtx, docId)
+		if errors.Is(err, docerr.ErrNotFound) {
+			return docId, false
+		}
+		if err != nil {
+			// Better an unflagged dead link than a flagged live one.
+			log.Printf("wiki link %s: %v", docId, err)
+			return docId, true
+		}
+		// Private docs' titles are only for their readers.
+		fm, body := splitFrontMatter(docId, doc.body)
+		if private, _ := getConfig(ctx).access(docId, fm); private {
+			return docId, true
+		}
+		if title := fm.title(body); title != "" {
+			return title, true
+		}
+		return docId, true
+	}
+}
+
+// linkWiki replaces the wiki links in doc's text with wikiLink nodes.
+// Code spans and blocks aren't text, so links in them are left alone.
+func linkWiki(doc ast.Node, resolve resolveFunc) {
+	var texts []*ast.Text
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if t, ok := node.(*ast.Text); ok && entering && wikiLinkPattern.Match(t.Literal) {
+			if _, inLink := t.Parent.(*ast.Link); !inLink {
+				texts = append(texts, t)
+			}
+		}
+		return ast.GoToNext
+	})
+
+	for _, t := range texts {
+		var nodes []ast.Node
+		last := 0
+		for _, m := range wikiLinkPattern.FindAllSubmatchIndex(t.Literal, -1) {
+			docId := string(t.Literal[m[2]:m[3]])
+			if docstore.ValidateDocId(docId) != nil {
+				continue
+			}
+
+			if m[0] > last {
+				nodes = append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: t.Literal[last:m[0]]}})
+			}
+			last = m[1]
+
+			link := &wikiLink{DocId: docId}
+			text := docId
+			if resolve != nil {
+				title, ok := resolve(docId)
+				text, link.Missing = title, !ok
+			}
+			if m[4] >= 0 {
+				text = string(t.Literal[m[4]:m[5]])
+			}
+			ast.AppendChild(link, &ast.Text{Leaf: ast.Leaf{Literal: []byte(text)}})
+			nodes = append(nodes, link)
+		}
+		if nodes == nil {
+			continue
+		}
+		if last < len(t.Literal) {
+			nodes = append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: t.Literal[last:]}})
+		}
+
+		parent := t.Parent
+		var children []ast.Node
+		for _, c := range parent.GetChildren() {
+			if c != ast.Node(t) {
+				children = append(children, c)
+				continue
+			}
+			for _, n := range nodes {
+				n.SetParent(parent)
+				children = append(children, n)
+			}
+		}
+		parent.SetChildren(children)
+	}
+}
+
+// renderNode renders wikiLink and figure nodes, leaving the rest to the
+// HTML renderer.
+func renderNode(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	switch n := node.(type) {
+	case *wikiLink:
+		renderWikiLink(w, n, entering)
+	case *figure:
+		renderFigure(w, n, entering)
+	default:
+		return ast.GoToNext, false
+	}
+	return ast.GoToNext, true
+}
+
+// renderWikiLink renders a wiki link to its doc, marked if it is missing.
+func renderWikiLink(w io.Writer, link *wikiLink, entering bool) {
+	if !entering {
+		io.WriteString(w, "</a>")
+		return
+	}
+
+	if link.Missing {
+		fmt.Fprintf(w, `<a class="wikilink missing" href="/%s" title="No such doc">`, html.EscapeString(link.DocId))
+	} else {
+		fmt.Fprintf(w, `<a class="wikilink" href="/%s">`, html.EscapeString(link.DocId))
+	}
+}
+
+// headings returns doc's headings with the ids the HTML renderer gives
+// them, which are made unique by numbering repeats.
+func headings(doc ast.Node) []tocEntry {
+	var toc []tocEntry
+	seen := map[string]int{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		h, ok := node.(*ast.Heading)
+		if !ok || !entering || h.IsTitleblock {
+			return ast.GoToNext
+		}
+
+		id := h.HeadingID
+		for count, found := seen[id]; found; count, found = seen[id] {
+			next := fmt.Sprintf("%s-%d", id, count+1)
+			if _, taken := seen[next]; !taken {
+				seen[id] = count + 1
+				id = next
+			} else {
+				id += "-1"
+			}
+		}
+		seen[id] = 0
+
+		toc = append(toc, tocEntry{Level: h.Level, ID: id, Text: plainText(h)})
+		return ast.SkipChildren
+	})
+	return toc
+}
+
+// brokenAnchors returns the #fragments that links in md point at but that
+// are neither the id of one of its headings, or of its figures if it is
+// numbered, nor of an element in its raw HTML, in the order they are first
+// linked.
+func brokenAnchors(md []byte, numbered bool) []string {
+	doc := markdown.Parse(md, parser.NewWithExtensions(mdExtensions))
+	ids := map[string]bool{}
+	for _, h := range headings(doc) {
+		ids[h.ID] = true
+	}
+	if numbered {
+		for _, f := range numberFigures(doc) {
+			ids[f.id()] = true
+		}
+	}
+	addIds := func(raw []byte) {
+		for _, m := range htmlIdPattern.FindAllSubmatch(raw, -1) {
+			ids[string(m[1])] = true
+		}
+	}
+
+	var fragments []string
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.HTMLBlock:
+			addIds(n.Literal)
+		case *ast.HTMLSpan:
+			addIds(n.Literal)
+		case *ast.Link:
+			// Footnote references point at the ids the renderer makes.
+			dest := string(n.Destination)
+			if n.NoteID == 0 && len(dest) > 1 && dest[0] == '#' {
+				fragments = append(fragments, dest[1:])
+			}
+		}
+		return ast.GoToNext
+	})
+
+	var broken []string
+	seen := map[string]bool{}
+	for _, f := range fragments {
+		if u, err := url.PathUnescape(f); err == nil {
+			f = u
+		}
+		if !ids[f] && !seen[f] {
+			seen[f] = true
+			broken = append(broken, f)
+		}
+	}
+	return broken
+}
+
+// anchorWarnings warns of the links within page docId that point at no
+// heading, which readers would only find by following them.
+func anchorWarnings(docId string, body []byte) []string {
+	if !isPage(docId) {
+		return nil
+	}
+	fm, body := splitFrontMatter(docId, body)
+	directives, _ := parseDirectives(body)
+	var warnings []string
+	for _, f := range brokenAnchors(body, directives.numbered(fm)) {
+		warnings = append(warnings, fmt.Sprintf("the link to #%s points at no heading or element of the doc", f))
+	}
+	return warnings
+}
+
+// plainText returns the text of node and its children without markup.
+func plainText(node ast.Node) string {
+	var b []byte
+	ast.WalkFunc(node, func(n ast.Node, entering bool) ast.WalkStatus {
+		switch n := n.(type) {
+		case *ast.Text:
+			b = append(b, n.Literal...)
+		case *ast.Code:
+			b = append(b, n.Literal...)
+		}
+		return ast.GoToNext
+	})
+	return string(b)
+}
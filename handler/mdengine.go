@@ -0,0 +1,49 @@
+package handler
+
+import "log"
+
+// defaultMarkdownEngine renders docs unless the site config picks
+// another engine.
+const defaultMarkdownEngine = "gomarkdown"
+
+// markdownConfig picks the engine docs' markdown is rendered with, for
+// example:
+//
+//	markdown:
+//	  engine: gomarkdown
+//
+// Engines are registered in markdownRenderers. Only the rendering of
+// pages goes through the engine: linting, search and quality checks
+// still parse docs with gomarkdown, whatever renders them.
+type markdownConfig struct {
+	Engine string `yaml:"engine"`
+}
+
+// markdownRenderer is a markdown engine: it converts a doc's markdown to
+// HTML and returns its headings and footnotes. Wiki links are looked up
+// with resolve; with a nil resolve every link is taken to exist.
+// Engines that can't do what opts asks, like numbering, render without
+// it.
+type markdownRenderer interface {
+	Render(md []byte, resolve resolveFunc, opts markdownOptions) ([]byte, []tocEntry, []footnote)
+}
+
+// markdownRenderers are the engines the site config can pick, by name.
+var markdownRenderers = map[string]markdownRenderer{
+	defaultMarkdownEngine: gomarkdownRenderer{},
+}
+
+// renderMarkdown renders md with the engine opts names, or the default
+// one if it names none or one that isn't registered.
+func renderMarkdown(md []byte, resolve resolveFunc, opts markdownOptions) ([]byte, []tocEntry, []footnote) {
+	engine := opts.Engine
+	if engine == "" {
+		engine = defaultMarkdownEngine
+	}
+	r, ok := markdownRenderers[engine]
+	if !ok {
+		log.Printf("unknown markdown engine %q", engine)
+		r = markdownRenderers[defaultMarkdownEngine]
+	}
+	return r.Render(md, resolve, opts)
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+var (
+	// maxDocBytes is the largest revision read from the store or written
+	// to it, so one huge doc can't exhaust the function's memory and get
+	// it killed mid request. With OVERSIZE_DOCS=truncate, pages bigger
+	// than it are cut short when read instead of refused; other docs,
+	// which would be corrupted, are always refused.
+	maxDocBytes  = int64(envFloat("MAX_DOC_BYTES", 32<<20))
+	oversizeDocs = envString("OVERSIZE_DOCS", "reject")
+
+	// memoryLimit is the function's memory in bytes, which Lambda sets,
+	// and memoryWarnRatio the share of it the process may hold before
+	// MemoryPressure is reported and freed memory handed back.
+	memoryLimit     = uint64(envFloat("AWS_LAMBDA_FUNCTION_MEMORY_SIZE", 0)) << 20
+	memoryWarnRatio = envFloat("MEMORY_WARN_RATIO", 0.8)
+)
+
+// readDoc reads the body of a revision of docId, reading no more than
+// maxDocBytes and a byte of it.
+func readDoc(op, docId string, r io.Reader) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxDocBytes+1))
+	if err != nil {
+		return nil, docerr.E("read "+op, docerr.ErrBackend, err)
+	}
+	if int64(len(body)) <= maxDocBytes {
+		return body, nil
+	}
+
+	if oversizeDocs == "truncate" && isPage(docId) {
+		metrics.Incr("OversizedDocs", map[string]string{"Action": "truncate"})
+		log.Printf("%s: truncated to %d bytes", op, maxDocBytes)
+		// Drop a character cut in two.
+		body = body[:maxDocBytes]
+		for i := 1; i < utf8.UTFMax && len(body) > 0; i++ {
+			if r, size := utf8.DecodeLastRune(body); r != utf8.RuneError || size > 1 {
+				break
+			}
+			body = body[:len(body)-1]
+		}
+		return body, nil
+	}
+	metrics.Incr("OversizedDocs", map[string]string{"Action": "reject"})
+	return nil, oversizeError(op, docId)
+}
+
+func oversizeError(op, docId string) error {
+	return docerr.WithDetails(op, docerr.ErrTooLarge, nil, map[string]interface{}{
+		"docId": docId,
+		"limit": maxDocBytes,
+	})
+}
+
+// withMemoryGuard reports how much each request allocates, as the
+// RequestAllocBytes metric, and when the process holds more than
+// memoryWarnRatio of the function's memory afterwards reports
+// MemoryPressure and returns what it can to the system. Allocations of
+// requests served at the same time, as the container runtime does, are
+// counted towards each of them.
+func withMemoryGuard(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		resp, err := next(ctx, request)
+		runtime.ReadMemStats(&after)
+
+		metrics.Emit("RequestAllocBytes", float64(after.TotalAlloc-before.TotalAlloc), metrics.Bytes, nil)
+		if memoryLimit > 0 && float64(after.Sys) > memoryWarnRatio*float64(memoryLimit) {
+			metrics.Emit("MemoryPressure", float64(after.Sys), metrics.Bytes, nil)
+			log.Printf("memory pressure after %s %s: %d of %d bytes in use", request.HTTPMethod, request.Path, after.Sys, memoryLimit)
+			debug.FreeOSMemory()
+		}
+		return resp, err
+	}
+}
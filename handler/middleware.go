@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type handlerFunc func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error)
+
+// middleware wraps a handlerFunc with behaviour common to every route.
+type middleware func(next handlerFunc) handlerFunc
+
+// chain wraps h in mws. The first middleware is the outermost.
+func chain(h handlerFunc, mws ...middleware) handlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// handle is route wrapped in all of the middleware.
+var handle = chain(route,
+	withTrace,
+	withDebug,
+	withMemoryGuard,
+	withOTLP,
+	withAccessLog,
+	withIAM,
+	withConfigHeaders,
+	withCORS,
+	withMissingPages,
+	withConditional,
+	withCanary,
+	withRenderContext,
+	withSchema,
+	withErrors,
+	withTenantUsage,
+	withLogins,
+	withAuditRequest,
+)
+
+// withErrors turns errors into error responses so that outer middleware
+// sees every response.
+func withErrors(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		resp, err := next(ctx, request)
+		if err != nil {
+			return errorPage(ctx, request, err), nil
+		}
+		return resp, nil
+	}
+}
+
+// withHeaders returns a copy of resp with headers added. Responses are
+// shared between coalesced requests and the render cache, so their headers
+// are never modified in place.
+func withHeaders(resp Response, headers map[string]string) Response {
+	merged := make(map[string]string, len(resp.Headers)+len(headers))
+	for k, v := range resp.Headers {
+		merged[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range headers {
+		merged[http.CanonicalHeaderKey(k)] = v
+	}
+	resp.Headers = merged
+	return resp
+}
+
+// withDefaultHeaders returns a copy of resp with the headers it doesn't
+// already set added.
+func withDefaultHeaders(resp Response, headers map[string]string) Response {
+	missing := map[string]string{}
+	for k, v := range headers {
+		if _, ok := resp.Headers[http.CanonicalHeaderKey(k)]; !ok {
+			missing[k] = v
+		}
+	}
+	return withHeaders(resp, missing)
+}
+
+// header returns the value of the request header name, ignoring case.
+func header(request events.APIGatewayProxyRequest, name string) string {
+	if v, ok := request.Headers[name]; ok {
+		return v
+	}
+	for k, v := range request.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// verbatimElements keep their contents as written when minifying.
+var verbatimElements = []string{"pre", "textarea", "script", "style"}
+
+// minifyHTML shrinks a page by stripping comments and collapsing runs of
+// whitespace to a single space. Conditional comments and the contents of
+// pre, textarea, script and style elements are left alone, as is
+// everything from a comment that never ends.
+func minifyHTML(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	space := false
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "<!--") && !strings.HasPrefix(s[i:], "<!--[if") {
+			end := strings.Index(s[i+4:], "-->")
+			if end < 0 {
+				if space {
+					b.WriteByte(' ')
+				}
+				b.WriteString(s[i:])
+				return strings.TrimSpace(b.String())
+			}
+			i += 4 + end + 3
+			continue
+		}
+
+		if s[i] == '<' {
+			if end := verbatimEnd(s, i); end > i {
+				if space {
+					b.WriteByte(' ')
+					space = false
+				}
+				b.WriteString(s[i:end])
+				i = end
+				continue
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if isHTMLSpace(r) {
+			space = true
+			i += size
+			continue
+		}
+
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteString(s[i : i+size])
+		i += size
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// isHTMLSpace reports whether r is one of the whitespace characters HTML
+// collapses. Others, like no-break spaces, are content.
+func isHTMLSpace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// verbatimEnd returns where the verbatim element starting at i in s ends,
+// or i if no verbatim element starts there.
+func verbatimEnd(s string, i int) int {
+	for _, name := range verbatimElements {
+		open := "<" + name
+		if !hasPrefixFold(s[i:], open) {
+			continue
+		}
+		// Make sure this is <pre> or <pre ...>, not <prefix>.
+		next := i + len(open)
+		if next < len(s) && s[next] != '>' && !isHTMLSpace(rune(s[next])) {
+			continue
+		}
+
+		close := "</" + name + ">"
+		end := indexFold(s[next:], close)
+		if end < 0 {
+			return len(s)
+		}
+		return next + end + len(close)
+	}
+	return i
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring the case of
+// ASCII letters. Only ASCII is folded so that positions in s stay byte for
+// byte those of the page; prefix must be lowercase.
+func hasPrefixFold(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFold returns the index of the first instance of the lowercase
+// substr in s, ignoring the case of ASCII letters, or -1.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if hasPrefixFold(s[i:], substr) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,27 @@
+package handler
+
+import "testing"
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"whitespace", "<p>a \n\t b</p>\n\n<p>c</p>", "<p>a b</p> <p>c</p>"},
+		{"comments", "<p>a</p><!-- gone --><!--[if IE]>kept<![endif]-->", "<p>a</p><!--[if IE]>kept<![endif]-->"},
+		{"verbatim", "<PRE>  a\n  b</pre>  <p>c</p>", "<PRE>  a\n  b</pre> <p>c</p>"},
+		{"verbatim close case", "<script>if (a  <  b) {}</SCRIPT>  x", "<script>if (a  <  b) {}</SCRIPT> x"},
+		{"not verbatim", "<prefix>  a  </prefix>", "<prefix> a </prefix>"},
+		{"case changes length", "<p>\u212a\u212a\u212a\u212a</p><p>x</p>", "<p>\u212a\u212a\u212a\u212a</p><p>x</p>"},
+		{"case changes length before verbatim", "<p>\u0130\u0130</p>  <pre>  x  </pre>", "<p>\u0130\u0130</p> <pre>  x  </pre>"},
+		{"multibyte", "voilà  Ångström", "voilà Ångström"},
+		{"no-break space", "a\u00a0\u00a0b", "a\u00a0\u00a0b"},
+		{"unterminated comment", "<p>a</p>  <!-- open <p>b</p>", "<p>a</p> <!-- open <p>b</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := minifyHTML(tt.in); got != tt.want {
+				t.Errorf("minifyHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
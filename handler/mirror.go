@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drocamor/docstore"
+)
+
+var (
+	// mirrorArchive is an archive of another site, from "docctl export
+	// -all" or a snapshot, packed as a file in the deployment package or
+	// an s3://bucket/key. When it is set the site is a read-only mirror
+	// serving the archive instead of the store.
+	mirrorArchive = os.Getenv("MIRROR_ARCHIVE")
+
+	// mirrorMode serves the site read-only, with every page saying it is
+	// a mirror: of mirrorArchive, or of a replica of another site's tables
+	// with MIRROR set. Writes are refused, so a mirror only needs read
+	// access to its store.
+	mirrorMode = mirrorArchive != "" || envBool("MIRROR")
+
+	// mirrorOf is the address of the site mirrored, which the banner
+	// links to.
+	mirrorOf = os.Getenv("MIRROR_OF")
+
+	// mirrorAsOf is when the newest revision in mirrorArchive was made.
+	mirrorAsOf time.Time
+
+	// readOnlyRoutes are the API routes taking a body that don't write
+	// anything, and so are answered by mirrors too.
+	readOnlyRoutes = map[string]bool{
+		apiV1 + "ask": true,
+	}
+)
+
+// mirrorBanner describes the mirror a page is shown on.
+type mirrorBanner struct {
+	Source string
+	AsOf   string
+}
+
+// defaultMirrorBanner is used unless the page template defines its own
+// with {{define "mirror"}}...{{end}}.
+var defaultMirrorBanner = template.Must(template.New("mirror").Parse(
+	`<div class="mirror">This is a read-only mirror` +
+		`{{if .Source}} of <a href="{{.Source}}">{{.Source}}</a>{{end}}` +
+		`{{if .AsOf}}, as of {{.AsOf}}{{end}}.</div>
+`))
+
+// pageMirrorBanner returns the banner for pages of a mirror, or nil if
+// the site isn't one.
+func pageMirrorBanner(tf timeFormat) *mirrorBanner {
+	if !mirrorMode {
+		return nil
+	}
+	b := &mirrorBanner{Source: mirrorOf}
+	if !mirrorAsOf.IsZero() {
+		b.AsOf = tf.format(mirrorAsOf)
+	}
+	return b
+}
+
+// mirrorBannerHTML renders the mirror banner with the page template's
+// "mirror" definition if it has one.
+func mirrorBannerHTML(tmpl *template.Template, b *mirrorBanner) (string, error) {
+	t := tmpl.Lookup("mirror")
+	if t == nil {
+		t = defaultMirrorBanner
+	}
+
+	var s strings.Builder
+	err := t.Execute(&s, b)
+	return s.String(), err
+}
+
+// writeRefused reports whether a request with method to the API route
+// pattern would write, and so isn't answered by a mirror.
+func writeRefused(method, pattern string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return mirrorMode && !readOnlyRoutes[pattern]
+}
+
+// The docstore's errors are only told apart by message.
+var (
+	errDocNotFound      = fmt.Errorf("Doc not found.")
+	errRevisionNotFound = fmt.Errorf("Revision not found.")
+	errMirrorReadOnly   = fmt.Errorf("the mirror is read-only")
+)
+
+// archiveStore is a read-only docstore.DocStore of the revisions in an
+// archive, held in memory.
+type archiveStore struct {
+	docIds []string
+	docs   map[string][]fetchedDoc // oldest revision first
+}
+
+// openMirrorArchive reads mirrorArchive into an archiveStore. An archive
+// that can't be read is logged, and the mirror is served empty rather
+// than from a store it may write to.
+func openMirrorArchive() docstore.DocStore {
+	start := time.Now()
+	revs, err := readArchive(mirrorArchive)
+	if err != nil {
+		log.Printf("serving nothing, as mirror archive %s can't be read: %v", mirrorArchive, err)
+	}
+
+	a := &archiveStore{docs: map[string][]fetchedDoc{}}
+	for _, rev := range revs {
+		docId := rev.meta.DocId
+		if _, ok := a.docs[docId]; !ok {
+			a.docIds = append(a.docIds, docId)
+		}
+		a.docs[docId] = append(a.docs[docId], rev)
+		if rev.meta.Timestamp.After(mirrorAsOf) {
+			mirrorAsOf = rev.meta.Timestamp
+		}
+	}
+	sort.Strings(a.docIds)
+	for _, docId := range a.docIds {
+		revs := a.docs[docId]
+		sort.Slice(revs, func(i, j int) bool { return revs[i].meta.Id < revs[j].meta.Id })
+	}
+	if err == nil {
+		log.Printf("loaded %d docs from mirror archive %s in %v", len(a.docIds), mirrorArchive, time.Since(start))
+	}
+	return a
+}
+
+// archivedRevision reads a revision held by an archiveStore.
+type archivedRevision struct {
+	meta docstore.RevisionMetadata
+	*bytes.Reader
+}
+
+func newArchivedRevision(doc fetchedDoc) *archivedRevision {
+	return &archivedRevision{doc.meta, bytes.NewReader(doc.body)}
+}
+
+func (r *archivedRevision) Metadata() docstore.RevisionMetadata {
+	return r.meta
+}
+
+func (a *archiveStore) GetDoc(docId string) (docstore.Revision, error) {
+	revs, ok := a.docs[docId]
+	if !ok {
+		return nil, errDocNotFound
+	}
+	return newArchivedRevision(revs[len(revs)-1]), nil
+}
+
+func (a *archiveStore) GetRevision(docId string, revisionId int) (docstore.Revision, error) {
+	revs, ok := a.docs[docId]
+	if !ok {
+		return nil, errDocNotFound
+	}
+	for _, rev := range revs {
+		if rev.meta.Id == revisionId {
+			return newArchivedRevision(rev), nil
+		}
+	}
+	return nil, errRevisionNotFound
+}
+
+func (a *archiveStore) PutRevision(docId string, body io.Reader) (docstore.Revision, error) {
+	return nil, errMirrorReadOnly
+}
+
+// ListDocs lists every doc in one page.
+func (a *archiveStore) ListDocs(token string) (docstore.DocPage, error) {
+	page := docstore.DocPage{Docs: make([]docstore.Doc, 0, len(a.docIds))}
+	for _, docId := range a.docIds {
+		revs := a.docs[docId]
+		page.Docs = append(page.Docs, docstore.Doc{Id: docId, LatestRevision: revs[len(revs)-1].meta.Id})
+	}
+	return page, nil
+}
+
+func (a *archiveStore) ListRevisions(docId string, token string) (docstore.RevisionPage, error) {
+	revs, ok := a.docs[docId]
+	if !ok {
+		return docstore.RevisionPage{}, errDocNotFound
+	}
+	var page docstore.RevisionPage
+	for _, rev := range revs {
+		page.Revisions = append(page.Revisions, rev.meta)
+	}
+	return page, nil
+}
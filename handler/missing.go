@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// missingPagesTable is the DynamoDB table counting requests for pages
+	// that don't exist, keyed by Path and the Referer's host, with a TTL on
+	// the Expires attribute. Counting is off when it is unset; the 404s
+	// are still logged.
+	missingPagesTable = os.Getenv("MISSING_PAGES_TABLE")
+
+	// missingTimeout bounds how long recording a 404 can delay its
+	// response.
+	missingTimeout = envDuration("MISSING_TIMEOUT", time.Second)
+
+	// missingTTL is how long a count is kept after the last request it
+	// counted.
+	missingTTL = envDuration("MISSING_TTL", 30*24*time.Hour)
+)
+
+const (
+	// noReferer stands in for a missing Referer header, since key
+	// attributes can't be empty.
+	noReferer = "-"
+
+	// otherReferers counts the requests for a path from the referrers
+	// past its first maxMissingReferers.
+	otherReferers = "(other)"
+
+	// refererSlots is the Referer of the item counting the referrers a
+	// path has counts for. Hosts can't contain #.
+	refererSlots = "#referers"
+
+	maxMissingReferers = 20
+	maxMissingPath     = 512
+)
+
+// withMissingPages logs page requests answered with 404 along with their
+// referrer, so broken inbound links can be found and redirected. Only the
+// referrer's host is counted; the log has the whole URL.
+func withMissingPages(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		resp, err := next(ctx, request)
+		if err != nil || resp.StatusCode != http.StatusNotFound ||
+			request.HTTPMethod != "GET" || strings.HasPrefix(request.Path, apiPrefix) {
+			return resp, err
+		}
+
+		referer := header(request, "Referer")
+		b, _ := json.Marshal(struct {
+			Missing string `json:"missing"`
+			Referer string `json:"referer,omitempty"`
+		}{request.Path, referer})
+		log.Print(string(b))
+
+		recordMissing(ctx, clip(request.Path, maxMissingPath), refererHost(referer))
+
+		return resp, err
+	}
+}
+
+// refererHost returns the host of a Referer header, or noReferer.
+func refererHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return noReferer
+	}
+	return clip(strings.ToLower(u.Host), 255)
+}
+
+// clip shortens s to at most n bytes without splitting a character.
+func clip(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// recordMissing counts a request for path from referer. A path has counts
+// for at most maxMissingReferers referrers, and requests from any others
+// are counted under otherReferers, so made up Referer headers can't grow
+// the table without bound.
+func recordMissing(ctx context.Context, path, referer string) {
+	if missingPagesTable == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, missingTimeout)
+	defer cancel()
+
+	counted, err := countMissing(ctx, path, referer, "attribute_exists(#c)", nil)
+	if err == nil && !counted {
+		// A new referrer takes one of the path's slots if it has any left.
+		if referer != otherReferers {
+			counted, err = countMissing(ctx, path, refererSlots, "attribute_not_exists(#c) OR #c < :max",
+				&dynamodb.AttributeValue{N: aws.String(strconv.Itoa(maxMissingReferers))})
+			if err == nil && !counted {
+				referer = otherReferers
+			}
+		}
+		if err == nil {
+			_, err = countMissing(ctx, path, referer, "", nil)
+		}
+	}
+	if err != nil {
+		log.Printf("error recording missing page %s: %v", path, err)
+	}
+}
+
+// countMissing adds one to the count of path and referer, if condition
+// holds when it is set, and puts off the count's expiry. It reports
+// whether it counted.
+func countMissing(ctx context.Context, path, referer, condition string, max *dynamodb.AttributeValue) (bool, error) {
+	key, err := dynamodbattribute.MarshalMap(struct{ Path, Referer string }{path, referer})
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	in := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(missingPagesTable),
+		Key:              key,
+		UpdateExpression: aws.String("ADD #c :one SET LastSeen = :now, Expires = :expires"),
+		ExpressionAttributeNames: map[string]*string{
+			"#c": aws.String("Count"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":     {N: aws.String("1")},
+			":now":     {S: aws.String(now.UTC().Format(time.RFC3339))},
+			":expires": {N: aws.String(strconv.FormatInt(now.Add(missingTTL).Unix(), 10))},
+		},
+	}
+	if condition != "" {
+		in.ConditionExpression = aws.String(condition)
+	}
+	if max != nil {
+		in.ExpressionAttributeValues[":max"] = max
+	}
+
+	_, err = dynamo().UpdateItemWithContext(ctx, in)
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type missingReferer struct {
+	Referer string `json:"referer"`
+	Count   int    `json:"count"`
+}
+
+type missingPage struct {
+	Path     string           `json:"path"`
+	Count    int              `json:"count"`
+	LastSeen string           `json:"lastSeen"`
+	Referers []missingReferer `json:"referers"`
+}
+
+// missingStats reports the most requested missing pages with the
+// referrers sending readers to them, most requested first.
+func missingStats(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if missingPagesTable == "" {
+		return Response{}, docerr.E("missing stats", docerr.ErrNotFound, nil)
+	}
+
+	now := time.Now().Unix()
+	pages := map[string]*missingPage{}
+	var scanErr error
+	err := dynamo().ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(missingPagesTable),
+	}, func(out *dynamodb.ScanOutput, last bool) bool {
+		var items []struct {
+			Path, Referer, LastSeen string
+			Count                   int
+			Expires                 int64
+		}
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &items)
+
+		for _, item := range items {
+			// Expired counts may linger until DynamoDB's TTL sweep gets
+			// to them.
+			if item.Referer == refererSlots || (item.Expires != 0 && item.Expires < now) {
+				continue
+			}
+			p, ok := pages[item.Path]
+			if !ok {
+				p = &missingPage{Path: item.Path}
+				pages[item.Path] = p
+			}
+			p.Count += item.Count
+			if item.LastSeen > p.LastSeen {
+				p.LastSeen = item.LastSeen
+			}
+			p.Referers = append(p.Referers, missingReferer{item.Referer, item.Count})
+		}
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return Response{}, docerr.E("missing stats", docerr.ErrBackend, err)
+	}
+
+	var report []missingPage
+	for _, p := range pages {
+		sort.Slice(p.Referers, func(i, j int) bool { return p.Referers[i].Count > p.Referers[j].Count })
+		if len(p.Referers) > 10 {
+			p.Referers = p.Referers[:10]
+		}
+		report = append(report, *p)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Count > report[j].Count })
+	if len(report) > 100 {
+		report = report[:100]
+	}
+
+	return jsonResponse(200, struct {
+		Pages []missingPage `json:"pages"`
+	}{report}), nil
+}
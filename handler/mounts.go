@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/mountstore"
+)
+
+// storeMounts are the other docstores presented as part of the site, from
+// STORE_MOUNTS, a comma separated list of a name and where the store is:
+//
+//	eng:docs-eng/revisions-eng,hr:docs-hr/revisions-hr
+//
+// Deployed, a store is its docs and revisions tables; previewed with the
+// local command, it is a directory. A store mounted as "eng" has its doc
+// "guide" shown as the page "eng-guide", also found at /eng/guide, and
+// listed, searched and linked like any other page. System docs like the
+// site config and nav only come from the site's own store.
+var storeMounts = parseStoreMounts(os.Getenv("STORE_MOUNTS"))
+
+type storeMount struct {
+	name, where string
+}
+
+// prefix is the docId prefix the mount's docs are under.
+func (m storeMount) prefix() string {
+	return m.name + "-"
+}
+
+func parseStoreMounts(s string) (mounts []storeMount) {
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" ||
+			strings.ContainsAny(parts[0], "-_.") || docstore.ValidateDocId(parts[0]) != nil {
+			log.Printf("ignoring STORE_MOUNTS entry %q", field)
+			continue
+		}
+		mounts = append(mounts, storeMount{parts[0], parts[1]})
+	}
+	return
+}
+
+// withMounts mounts the storeMounts over root, opening each with open, or
+// returns root if there are none.
+func withMounts(root docstore.DocStore, open func(where string) docstore.DocStore) docstore.DocStore {
+	if len(storeMounts) == 0 {
+		return root
+	}
+	var opts []mountstore.MountStoreOption
+	for _, m := range storeMounts {
+		log.Printf("mounting %s at %s", m.where, m.prefix())
+		opts = append(opts, mountstore.WithMount(m.prefix(), open(m.where)))
+	}
+	return mountstore.New(root, opts...)
+}
+
+// openTables opens the store in the docs and revisions tables named by
+// where, like "docs-eng/revisions-eng".
+func openTables(where string) docstore.DocStore {
+	parts := strings.SplitN(where, "/", 2)
+	opts := []awsdocstore.AwsDocStoreOption{awsdocstore.WithDocTable(parts[0])}
+	if len(parts) == 2 {
+		opts = append(opts, awsdocstore.WithRevisionTable(parts[1]))
+	}
+	return awsdocstore.New(opts...)
+}
+
+// mountedPage returns the docId of the page at a mount's path, like
+// "eng-guide" for /eng/guide, if request is for one.
+func mountedPage(request events.APIGatewayProxyRequest) (string, bool) {
+	page, ok := request.PathParameters["page"]
+	if !ok {
+		return "", false
+	}
+	for _, m := range storeMounts {
+		if request.PathParameters["docId"] == m.name && page != "" {
+			return m.prefix() + page, true
+		}
+	}
+	return "", false
+}
+
+// serveMounted redirects a mount's path to its page, whose address stays
+// the one canonical URL.
+func serveMounted(request events.APIGatewayProxyRequest) (Response, error) {
+	docId, ok := mountedPage(request)
+	if !ok {
+		return Response{}, docerr.E("route "+request.Path, docerr.ErrNotFound, nil)
+	}
+	return Response{
+		StatusCode: 301,
+		Headers: map[string]string{
+			"Location": "/" + docId,
+		},
+	}, nil
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+)
+
+// normalizeConfig tidies docs as they are saved, so the diffs between
+// revisions show only real changes, for example:
+//
+//	normalize:
+//	  trailingWhitespace: true
+//	  lineEndings: lf
+//	  finalNewline: true
+//	  headingOffset: 1
+//
+// HeadingOffset shifts the headings of pages down that many levels, say
+// for docs written with "#" headings under a template that supplies the
+// h1. Raw docs like stylesheets and images are saved as given.
+type normalizeConfig struct {
+	TrailingWhitespace bool   `yaml:"trailingWhitespace"`
+	LineEndings        string `yaml:"lineEndings"` // lf or crlf
+	FinalNewline       bool   `yaml:"finalNewline"`
+	HeadingOffset      int    `yaml:"headingOffset"`
+}
+
+// normalize applies c to body, the content of docId.
+func normalize(c normalizeConfig, docId string, body []byte) []byte {
+	if !isPage(docId) && !strings.HasPrefix(docId, "_") {
+		return body
+	}
+	if c == (normalizeConfig{}) {
+		return body
+	}
+
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	fenced := false
+	_, rest, _ := frontMatterBlock(body)
+	bodyStart := strings.Count(string(body[:len(body)-len(rest)]), "\n")
+	for i, line := range lines {
+		if c.TrailingWhitespace {
+			line = strings.TrimRight(line, " \t")
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		} else if !fenced && i >= bodyStart && c.HeadingOffset != 0 && isPage(docId) {
+			line = shiftHeading(line, c.HeadingOffset)
+		}
+		lines[i] = line
+	}
+	text = strings.Join(lines, "\n")
+
+	if c.FinalNewline {
+		text = strings.TrimRight(text, "\n") + "\n"
+	}
+	if c.LineEndings == "crlf" {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	} else if c.LineEndings != "lf" && bytes.Contains(body, []byte("\r\n")) {
+		// Line endings weren't asked to change, so put them back.
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return []byte(text)
+}
+
+// shiftHeading moves an ATX heading line by offset levels, keeping it
+// between h1 and h6.
+func shiftHeading(line string, offset int) string {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ' && line[level] != '\t') {
+		return line
+	}
+
+	n := level + offset
+	if n < 1 {
+		n = 1
+	}
+	if n > 6 {
+		n = 6
+	}
+	return strings.Repeat("#", n) + line[level:]
+}
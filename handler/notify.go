@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+var (
+	// notifyProvider is where notifications go: "sns" publishes them to
+	// notifyTopicArn, "ses" emails them, "slack" posts them to a Slack
+	// incoming webhook and "webhook" posts their JSON to any URL. It
+	// defaults to "sns" when NOTIFY_TOPIC_ARN is set, and nothing is sent
+	// when both are unset.
+	notifyProvider = os.Getenv("NOTIFY_PROVIDER")
+
+	// notifyTopicArn is the SNS topic notifications are published to,
+	// each with a user message attribute for subscriptions to filter on.
+	notifyTopicArn = os.Getenv("NOTIFY_TOPIC_ARN")
+
+	// notifyEmailFrom is the address "ses" sends from. Readers' own
+	// notifications go to their user name when it is an address, and the
+	// rest to notifyEmailTo.
+	notifyEmailFrom = os.Getenv("NOTIFY_EMAIL_FROM")
+	notifyEmailTo   = os.Getenv("NOTIFY_EMAIL_TO")
+
+	// notifyWebhookURL is what "slack" and "webhook" post to, or a
+	// reference to where it is kept, like "ssm:/docstore/slack-webhook".
+	notifyWebhookURL = os.Getenv("NOTIFY_WEBHOOK_URL")
+
+	// notifyTimeout bounds sending each notification.
+	notifyTimeout = envDuration("NOTIFY_TIMEOUT", 10*time.Second)
+
+	notifierOnce sync.Once
+	docNotifier  notificationProvider
+)
+
+// notification is something to tell a reader, or the site's editors when
+// User is empty. Message is the JSON sent to providers that take it, and
+// Subject and Text a summary for those that show it to people.
+type notification struct {
+	Type    string
+	User    string
+	Subject string
+	Text    string
+	Message interface{}
+}
+
+// notificationProvider sends notifications wherever a team gets them.
+type notificationProvider interface {
+	Notify(ctx context.Context, n notification) error
+}
+
+// getNotifier returns the configured notification provider, or nil if
+// notifications are off.
+func getNotifier() notificationProvider {
+	notifierOnce.Do(func() {
+		provider := notifyProvider
+		if provider == "" && notifyTopicArn != "" {
+			provider = "sns"
+		}
+		if provider == "" {
+			return
+		}
+		n, err := newNotifier(provider)
+		if err != nil {
+			log.Printf("ignoring NOTIFY_PROVIDER: %v", err)
+			return
+		}
+		docNotifier = n
+	})
+	return docNotifier
+}
+
+// newNotifier returns the named provider, checking it has what it needs.
+func newNotifier(provider string) (notificationProvider, error) {
+	client := &http.Client{Timeout: notifyTimeout}
+	switch provider {
+	case "sns":
+		if notifyTopicArn == "" {
+			return nil, fmt.Errorf("sns needs NOTIFY_TOPIC_ARN")
+		}
+		return snsNotifier{notifyTopicArn}, nil
+	case "ses":
+		if notifyEmailFrom == "" {
+			return nil, fmt.Errorf("ses needs NOTIFY_EMAIL_FROM")
+		}
+		return sesNotifier{notifyEmailFrom, notifyEmailTo}, nil
+	case "slack":
+		if notifyWebhookURL == "" {
+			return nil, fmt.Errorf("slack needs NOTIFY_WEBHOOK_URL")
+		}
+		return slackNotifier{notifyWebhookURL, client}, nil
+	case "webhook":
+		if notifyWebhookURL == "" {
+			return nil, fmt.Errorf("webhook needs NOTIFY_WEBHOOK_URL")
+		}
+		return webhookNotifier{notifyWebhookURL, client}, nil
+	}
+	return nil, fmt.Errorf("unknown notification provider %q: want sns, ses, slack or webhook", provider)
+}
+
+// snsNotifier publishes the JSON message to an SNS topic.
+type snsNotifier struct {
+	topicArn string
+}
+
+func (s snsNotifier) Notify(ctx context.Context, n notification) error {
+	b, err := json.Marshal(n.Message)
+	if err != nil {
+		return err
+	}
+	in := &sns.PublishInput{
+		TopicArn: aws.String(s.topicArn),
+		Subject:  aws.String(subject(n.Subject)),
+		Message:  aws.String(string(b)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(n.Type)},
+		},
+	}
+	if n.User != "" {
+		in.MessageAttributes["user"] = &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(n.User)}
+	}
+	_, err = sns.New(awsSession()).PublishWithContext(ctx, in)
+	return err
+}
+
+// sesNotifier emails the summary with SES.
+type sesNotifier struct {
+	from, to string
+}
+
+func (s sesNotifier) Notify(ctx context.Context, n notification) error {
+	to := s.to
+	if strings.Contains(n.User, "@") {
+		to = n.User
+	}
+	if to == "" {
+		return fmt.Errorf("no address for %q: set NOTIFY_EMAIL_TO", n.User)
+	}
+	_, err := ses.New(awsSession()).SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source:      aws.String(s.from),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(to)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(n.Subject), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(n.Text), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	return err
+}
+
+// slackNotifier posts the summary to a Slack incoming webhook.
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (s slackNotifier) Notify(ctx context.Context, n notification) error {
+	text := "*" + n.Subject + "*\n" + n.Text
+	return postNotification(ctx, s.client, s.url, n.Type, map[string]string{"text": text})
+}
+
+// webhookNotifier posts the JSON message to a URL, with its type in the
+// X-Docstore-Event header.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (w webhookNotifier) Notify(ctx context.Context, n notification) error {
+	return postNotification(ctx, w.client, w.url, n.Type, n.Message)
+}
+
+// postNotification posts v as JSON to the webhook at ref, resolving it if
+// it is a secret reference.
+func postNotification(ctx context.Context, client *http.Client, ref, typ string, v interface{}) error {
+	u, err := resolveSecret(ctx, ref)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Docstore-Event", typ)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook: status %d: %.200s", resp.StatusCode, b)
+	}
+	return nil
+}
+
+// subject shortens s to the 100 characters SNS allows in a subject.
+func subject(s string) string {
+	r := []rune(s)
+	if len(r) > 100 {
+		return string(r[:99]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// tableCaptionPrefix starts a paragraph captioning the table just before
+// or after it, as in pandoc: "Table: Supported regions".
+const tableCaptionPrefix = "Table:"
+
+// figureLabels are what figures of each kind are called in captions.
+var figureLabels = map[string]string{
+	"figure": "Figure",
+	"table":  "Table",
+}
+
+// figure is a numbered image or table with its caption. Its one child is
+// the image or table.
+type figure struct {
+	ast.Container
+	Kind    string
+	Number  int
+	Caption string
+}
+
+// id returns the anchor of the figure, like "table-2".
+func (f *figure) id() string {
+	return f.Kind + "-" + strconv.Itoa(f.Number)
+}
+
+// numberDoc numbers doc's headings, like 1.2.3, and its figures and
+// tables, for docs whose front matter sets numbered. toc is doc's headings
+// as headings returns them, and is given their numbers. A heading that
+// starts the doc is its title and isn't numbered, and numbering starts at
+// the level of the highest of the rest.
+func numberDoc(doc ast.Node, toc []tocEntry) {
+	var hs []*ast.Heading
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		h, ok := node.(*ast.Heading)
+		if !ok || !entering || h.IsTitleblock {
+			return ast.GoToNext
+		}
+		hs = append(hs, h)
+		return ast.SkipChildren
+	})
+
+	first := 0
+	if children := doc.GetChildren(); len(hs) > 0 && len(children) > 0 && children[0] == ast.Node(hs[0]) {
+		first = 1
+	}
+	top := 6
+	for _, h := range hs[first:] {
+		if h.Level < top {
+			top = h.Level
+		}
+	}
+
+	var counters [7]int
+	for i := first; i < len(hs); i++ {
+		h := hs[i]
+		counters[h.Level]++
+		for l := h.Level + 1; l < len(counters); l++ {
+			counters[l] = 0
+		}
+		var parts []string
+		for l := top; l <= h.Level; l++ {
+			parts = append(parts, strconv.Itoa(counters[l]))
+		}
+		n := strings.Join(parts, ".")
+		if i < len(toc) {
+			toc[i].Number = n
+		}
+
+		span := &ast.HTMLSpan{Leaf: ast.Leaf{Literal: []byte(`<span class="secno">` + n + `</span> `)}}
+		span.SetParent(h)
+		h.SetChildren(append([]ast.Node{span}, h.GetChildren()...))
+	}
+
+	numberFigures(doc)
+}
+
+// numberFigures makes figures of doc's tables and of its images that stand
+// alone in a paragraph, numbering each kind in order. An image's caption
+// is its title, or else its alt text, and a table's is a paragraph next to
+// it starting with tableCaptionPrefix, which is removed.
+func numberFigures(doc ast.Node) []*figure {
+	var nodes []ast.Node
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Table:
+			nodes = append(nodes, n)
+			return ast.SkipChildren
+		case *ast.Paragraph:
+			if soleImage(n) != nil {
+				nodes = append(nodes, n)
+			}
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+
+	var figures []*figure
+	counts := map[string]int{}
+	for _, node := range nodes {
+		f := &figure{}
+		child := node
+		switch n := node.(type) {
+		case *ast.Table:
+			f.Kind = "table"
+			if p := tableCaption(n); p != nil {
+				f.Caption = strings.TrimSpace(strings.TrimPrefix(plainText(p), tableCaptionPrefix))
+				ast.RemoveFromTree(p)
+			}
+		case *ast.Paragraph:
+			img := soleImage(n)
+			f.Kind, f.Caption = "figure", string(img.Title)
+			if f.Caption == "" {
+				f.Caption = plainText(img)
+			}
+			child = img
+		}
+		counts[f.Kind]++
+		f.Number = counts[f.Kind]
+		replaceNode(node, f)
+		child.SetParent(f)
+		f.SetChildren([]ast.Node{child})
+		figures = append(figures, f)
+	}
+	return figures
+}
+
+// soleImage returns the image that is all of p, if it is one.
+func soleImage(p *ast.Paragraph) *ast.Image {
+	var img *ast.Image
+	for _, c := range p.GetChildren() {
+		switch c := c.(type) {
+		case *ast.Image:
+			if img != nil {
+				return nil
+			}
+			img = c
+		case *ast.Text:
+			if strings.TrimSpace(string(c.Literal)) != "" {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+	return img
+}
+
+// tableCaption returns the caption paragraph after t, or else before it.
+func tableCaption(t *ast.Table) ast.Node {
+	siblings := t.GetParent().GetChildren()
+	for i, c := range siblings {
+		if c != ast.Node(t) {
+			continue
+		}
+		for _, j := range []int{i + 1, i - 1} {
+			if j < 0 || j >= len(siblings) {
+				continue
+			}
+			if p, ok := siblings[j].(*ast.Paragraph); ok && strings.HasPrefix(plainText(p), tableCaptionPrefix) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// replaceNode puts with in old's place in the tree.
+func replaceNode(old, with ast.Node) {
+	parent := old.GetParent()
+	children := parent.GetChildren()
+	for i, c := range children {
+		if c == old {
+			children[i] = with
+		}
+	}
+	with.SetParent(parent)
+}
+
+// renderFigure renders a figure and its caption, above a table and below
+// an image.
+func renderFigure(w io.Writer, f *figure, entering bool) {
+	caption := fmt.Sprintf(`<figcaption><span class="figno">%s %d.</span>`, figureLabels[f.Kind], f.Number)
+	if f.Caption != "" {
+		caption += " " + html.EscapeString(f.Caption)
+	}
+	caption += "</figcaption>"
+	if entering {
+		fmt.Fprintf(w, `<figure class="%s" id="%s">`, f.Kind, f.id())
+		if f.Kind == "table" {
+			io.WriteString(w, caption)
+		}
+		return
+	}
+	if f.Kind == "figure" {
+		io.WriteString(w, caption)
+	}
+	io.WriteString(w, "</figure>\n")
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// oembedPath is the oEmbed endpoint, which tools that unfurl links ask
+	// for a card describing a doc URL.
+	oembedPath = "/oembed"
+
+	oembedWidth   = 600
+	oembedHeight  = 200
+	excerptLength = 240
+)
+
+// oembedResponse is a "rich" oEmbed response. Description isn't in the
+// spec but is read by several consumers.
+type oembedResponse struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title"`
+	Description  string `json:"description,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+	ProviderName string `json:"provider_name,omitempty"`
+	ProviderURL  string `json:"provider_url,omitempty"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// oembedLink is a link element for a page's head pointing oEmbed
+// consumers at the card for docId, or "" if the site's address isn't
+// configured.
+func oembedLink(cfg *siteConfig, docId, title string) string {
+	base := strings.TrimSuffix(cfg.BaseURL, "/")
+	if base == "" {
+		return ""
+	}
+	href := base + oembedPath + "?" + url.Values{"url": {base + "/" + docId}, "format": {"json"}}.Encode()
+	return fmt.Sprintf(`<link rel="alternate" type="application/json+oembed" href="%s" title="%s">`,
+		html.EscapeString(href), html.EscapeString(title))
+}
+
+// excerpt returns the start of text, cut at a word, for a card.
+func excerpt(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= n {
+		return text
+	}
+	cut := strings.LastIndex(text[:n], " ")
+	if cut <= 0 {
+		cut = n
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return text[:cut] + "…"
+}
+
+// serveOEmbed answers /oembed?url= with a card for the doc at url: its
+// title, an excerpt and its author. Only JSON is offered, and private docs
+// get no card, as whoever sees it may not be able to read them.
+func serveOEmbed(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	params := request.QueryStringParameters
+	op := "oembed " + params["url"]
+	if f := params["format"]; f != "" && f != "json" {
+		return jsonResponse(501, map[string]string{"error": "only json is supported"}), nil
+	}
+
+	cfg := getConfig(ctx)
+	u, err := url.Parse(params["url"])
+	if err != nil || params["url"] == "" {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"url": "the URL of a doc is required"})
+	}
+	if base, err := url.Parse(cfg.BaseURL); err == nil && cfg.BaseURL != "" && !strings.EqualFold(u.Host, base.Host) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	docId := strings.TrimPrefix(u.Path, "/")
+	if docId == "" {
+		docId = indexDocName
+	}
+	if strings.Contains(docId, "/") || !isPage(docId) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	doc, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	d := summarize(docId, doc)
+	if private, _ := cfg.access(docId, d.fm); private {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	width := oembedWidth
+	if n, err := strconv.Atoi(params["maxwidth"]); err == nil && n > 0 && n < width {
+		width = n
+	}
+	height := oembedHeight
+	if n, err := strconv.Atoi(params["maxheight"]); err == nil && n > 0 && n < height {
+		height = n
+	}
+
+	title := d.Title
+	if title == "" {
+		title = docId
+	}
+	description := d.fm.Description
+	if description == "" {
+		description = excerpt(d.text, excerptLength)
+	}
+	author := d.fm.Author
+	if author == "" {
+		author = d.fm.Owner
+	}
+	link := "/" + docId
+	if cfg.BaseURL != "" {
+		link = strings.TrimSuffix(cfg.BaseURL, "/") + link
+	}
+
+	var card strings.Builder
+	fmt.Fprintf(&card, `<blockquote class="docstore-card"><a href="%s"><strong>%s</strong></a>`,
+		html.EscapeString(link), html.EscapeString(title))
+	if description != "" {
+		fmt.Fprintf(&card, "<p>%s</p>", html.EscapeString(description))
+	}
+	if author != "" {
+		fmt.Fprintf(&card, "<footer>%s</footer>", html.EscapeString(author))
+	}
+	card.WriteString("</blockquote>")
+
+	resp := jsonResponse(200, oembedResponse{
+		Type:         "rich",
+		Version:      "1.0",
+		Title:        title,
+		Description:  description,
+		AuthorName:   author,
+		ProviderName: cfg.Name,
+		ProviderURL:  cfg.BaseURL,
+		HTML:         card.String(),
+		Width:        width,
+		Height:       height,
+	})
+	return withHeaders(resp, map[string]string{"Cache-Control": "public, max-age=300"}), nil
+}
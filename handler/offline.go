@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// manifestPath serves the web app manifest and serviceWorkerPath the
+	// service worker script, when the site is readable offline.
+	manifestPath      = "/manifest.webmanifest"
+	serviceWorkerPath = "/sw.js"
+)
+
+// offlineConfig makes the site installable and readable offline, for
+// readers with flaky connections, for example:
+//
+//	offline:
+//	  enabled: true
+//	  shortName: Docs
+//	  themeColor: "#1a73e8"
+//	  icons:
+//	    - src: icon-192.png
+//	      sizes: 192x192
+//	  assets: [style.css, site.js]
+//
+// Readers' browsers then keep the home page, the assets and the pages the
+// reader viewed last, and every page they go on to read, for when the
+// network is gone. Templates link the manifest and register the service
+// worker with {{offlineTags}}.
+type offlineConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// ShortName is the name under an installed site's icon, the site's
+	// name by default.
+	ShortName string `yaml:"shortName"`
+
+	ThemeColor      string `yaml:"themeColor"`
+	BackgroundColor string `yaml:"backgroundColor"`
+
+	Icons []offlineIcon `yaml:"icons"`
+
+	// Assets are the store assets kept for offline reading, like the
+	// theme's CSS and scripts.
+	Assets []string `yaml:"assets"`
+}
+
+// offlineIcon is an icon of the installed site, a store asset.
+type offlineIcon struct {
+	Src   string `yaml:"src" json:"src"`
+	Sizes string `yaml:"sizes" json:"sizes,omitempty"`
+	Type  string `yaml:"type" json:"type,omitempty"`
+}
+
+// offlineTags links the web app manifest and registers the service worker,
+// or is empty unless the site is readable offline.
+func offlineTags(cfg *siteConfig) string {
+	if !cfg.Offline.Enabled {
+		return ""
+	}
+	return `<link rel="manifest" href="` + manifestPath + `">
+<script>
+if ("serviceWorker" in navigator) {
+  navigator.serviceWorker.register("` + serviceWorkerPath + `");
+}
+</script>
+`
+}
+
+// serveManifest answers with the site's web app manifest.
+func serveManifest(ctx context.Context) (Response, error) {
+	cfg := getConfig(ctx)
+	if !cfg.Offline.Enabled {
+		return Response{}, docerr.E("route "+manifestPath, docerr.ErrNotFound, nil)
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = "Docs"
+	}
+	short := cfg.Offline.ShortName
+	if short == "" {
+		short = name
+	}
+	icons := []offlineIcon{}
+	for _, icon := range cfg.Offline.Icons {
+		icon.Src = offlineAssetURL(ctx, icon.Src)
+		icons = append(icons, icon)
+	}
+
+	b, _ := json.Marshal(struct {
+		Name            string        `json:"name"`
+		ShortName       string        `json:"short_name"`
+		StartURL        string        `json:"start_url"`
+		Scope           string        `json:"scope"`
+		Display         string        `json:"display"`
+		ThemeColor      string        `json:"theme_color,omitempty"`
+		BackgroundColor string        `json:"background_color,omitempty"`
+		Icons           []offlineIcon `json:"icons"`
+	}{name, short, "/", "/", "standalone", cfg.Offline.ThemeColor, cfg.Offline.BackgroundColor, icons})
+
+	return Response{
+		StatusCode: 200,
+		Body:       string(b),
+		Headers: map[string]string{
+			"Content-Type":  "application/manifest+json",
+			"Cache-Control": "max-age=3600",
+		},
+	}, nil
+}
+
+// offlineAssetURL returns the revision stamped URL of a store asset, so
+// kept copies are replaced when it changes.
+func offlineAssetURL(ctx context.Context, docId string) string {
+	info, err := getAsset(ctx, docId)
+	if err != nil {
+		log.Printf("offline asset %s: %v", docId, err)
+		return assetsPrefix + docId
+	}
+	return assetURL(docId, info.revision)
+}
+
+// offlineURLs lists what a reader's browser keeps for offline reading:
+// the home page, the configured assets, and the listed pages the reader
+// viewed last.
+func offlineURLs(ctx context.Context) []string {
+	cfg := getConfig(ctx)
+	urls := []string{"/"}
+	for _, docId := range cfg.Offline.Assets {
+		urls = append(urls, offlineAssetURL(ctx, docId))
+	}
+	for _, icon := range cfg.Offline.Icons {
+		urls = append(urls, offlineAssetURL(ctx, icon.Src))
+	}
+	for _, d := range viewedDocs(ctx) {
+		urls = append(urls, "/"+d.DocId)
+	}
+	return urls
+}
+
+// serviceWorker answers with the service worker script for the reader of
+// request. It keeps the reader's offlineURLs when installed, serves pages
+// from the network and falls back to the copies kept, and serves assets,
+// which are stamped with their revision, from the copies first. Private
+// docs, which vary by Authorization, and responses served with "no-store"
+// aren't kept.
+func serviceWorker(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if !getConfig(ctx).Offline.Enabled {
+		return Response{}, docerr.E("route "+serviceWorkerPath, docerr.ErrNotFound, nil)
+	}
+
+	ctx = withRecentlyViewed(ctx, requestRecentlyViewed(request))
+	urls := offlineURLs(ctx)
+	list, _ := json.Marshal(urls)
+	sum := sha256.Sum256(list)
+	cache := "docs-" + hex.EncodeToString(sum[:])[:12]
+
+	script := strings.NewReplacer("$CACHE", cache, "$URLS", string(list), "$ASSETS", assetsPrefix, "$API", apiPrefix).Replace(serviceWorkerScript)
+	return Response{
+		StatusCode: 200,
+		Body:       script,
+		Headers: map[string]string{
+			"Content-Type": "text/javascript; charset=utf-8",
+			// The script lists the reader's own recently viewed pages, and
+			// browsers should check for a new one on every visit.
+			"Cache-Control": "private, no-cache",
+		},
+	}, nil
+}
+
+// serviceWorkerScript is the service worker, with $CACHE, $URLS, $ASSETS
+// and $API filled in by serviceWorker.
+const serviceWorkerScript = `var CACHE = "$CACHE";
+var URLS = $URLS;
+
+self.addEventListener("install", function (event) {
+  event.waitUntil(caches.open(CACHE).then(function (cache) {
+    return Promise.all(URLS.map(function (url) {
+      return cache.add(url).catch(function () {});
+    }));
+  }).then(function () {
+    return self.skipWaiting();
+  }));
+});
+
+self.addEventListener("activate", function (event) {
+  event.waitUntil(caches.keys().then(function (keys) {
+    return Promise.all(keys.filter(function (key) {
+      return key.indexOf("docs-") === 0 && key !== CACHE;
+    }).map(function (key) {
+      return caches.delete(key);
+    }));
+  }).then(function () {
+    return self.clients.claim();
+  }));
+});
+
+function keep(request, response) {
+  if (response.ok && !/no-store/.test(response.headers.get("Cache-Control") || "") &&
+      !/authorization/i.test(response.headers.get("Vary") || "")) {
+    var copy = response.clone();
+    caches.open(CACHE).then(function (cache) {
+      cache.put(request, copy);
+    });
+  }
+  return response;
+}
+
+self.addEventListener("fetch", function (event) {
+  var request = event.request;
+  var url = new URL(request.url);
+  if (request.method !== "GET" || url.origin !== location.origin || url.pathname.indexOf("$API") === 0) {
+    return;
+  }
+  if (url.pathname.indexOf("$ASSETS") === 0) {
+    event.respondWith(caches.match(request).then(function (kept) {
+      return kept || fetch(request).then(function (response) {
+        return keep(request, response);
+      });
+    }));
+    return;
+  }
+  event.respondWith(fetch(request).then(function (response) {
+    return keep(request, response);
+  }).catch(function () {
+    return caches.match(request).then(function (kept) {
+      return kept || caches.match("/");
+    });
+  }));
+});
+`
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/metrics"
+	"github.com/drocamor/n22t.docstore/otlp"
+)
+
+var (
+	// otlpExporter sends spans and metrics to the OpenTelemetry collector
+	// at OTEL_EXPORTER_OTLP_ENDPOINT instead of CloudWatch. It is nil when
+	// the endpoint is unset.
+	otlpExporter *otlp.Exporter
+
+	// otlpFlushTimeout bounds how long exporting can delay a response.
+	// Lambda may freeze the container once the response is sent, so
+	// everything is flushed before then.
+	otlpFlushTimeout = envDuration("OTEL_FLUSH_TIMEOUT", time.Second)
+)
+
+func init() {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return
+	}
+
+	otlpExporter = otlp.New(endpoint, envString("OTEL_SERVICE_NAME", metrics.Namespace))
+	metrics.Sink = func(name string, value float64, unit metrics.Unit, dims map[string]string) {
+		otlpExporter.RecordMetric(name, value, string(unit), dims)
+	}
+}
+
+// withOTLP records a span for each request and flushes it, along with the
+// request's metrics, to the collector.
+func withOTLP(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		if otlpExporter == nil {
+			return next(ctx, request)
+		}
+
+		start := time.Now()
+		resp, err := next(ctx, request)
+
+		if tc, ok := traceFrom(ctx); ok {
+			otlpExporter.RecordSpan(otlp.Span{
+				TraceId:      tc.traceId,
+				SpanId:       tc.spanId,
+				ParentSpanId: tc.parentId,
+				Name:         request.HTTPMethod + " " + request.Resource,
+				Start:        start,
+				End:          time.Now(),
+				Attributes: map[string]string{
+					"http.method":      request.HTTPMethod,
+					"http.target":      request.Path,
+					"http.status_code": fmt.Sprint(resp.StatusCode),
+				},
+				Error: err != nil || resp.StatusCode >= 500,
+			})
+		}
+
+		flushCtx, cancel := context.WithTimeout(context.Background(), otlpFlushTimeout)
+		defer cancel()
+		if ferr := otlpExporter.Flush(flushCtx); ferr != nil {
+			log.Printf("error exporting telemetry: %v", ferr)
+		}
+
+		return resp, err
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// pageBreak is the line that splits a long doc into pages, served as
+// /{docId} and then /docs/{docId}/page/2 and on.
+const pageBreak = "<!-- page -->"
+
+// docPager describes the page of a paged doc being shown, for templates
+// to draw pager controls from. Docs without page breaks have none.
+type docPager struct {
+	Page, Pages int
+
+	// Prev and Next are the URLs of the pages either side, or empty at
+	// either end.
+	Prev, Next string
+
+	Links []pageLink
+}
+
+// pageLink is a page of a paged doc in a pager.
+type pageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+type pageKey struct{}
+
+// withPage returns ctx rendering page n of paged docs.
+func withPage(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, pageKey{}, n)
+}
+
+// pageFrom returns the page of paged docs ctx renders, the first unless
+// set.
+func pageFrom(ctx context.Context) int {
+	if n, ok := ctx.Value(pageKey{}).(int); ok {
+		return n
+	}
+	return 1
+}
+
+// pageURL is where page n of docId is served.
+func pageURL(docId string, n int) string {
+	if n == 1 {
+		return "/" + docId
+	}
+	return docsPrefix + docId + "/page/" + strconv.Itoa(n)
+}
+
+// splitPages splits md at its page breaks, outside code blocks.
+func splitPages(md []byte) [][]byte {
+	var pages [][]byte
+	var fence string
+	start := 0
+	lines := bytes.SplitAfter(md, []byte("\n"))
+	pos := 0
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(string(line))
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+		case trimmed == pageBreak:
+			pages = append(pages, md[start:pos])
+			start = pos + len(line)
+		}
+		pos += len(line)
+	}
+	return append(pages, md[start:])
+}
+
+// paginate returns page n of md, clamped to the pages there are, with the
+// pager for it, or md whole and no pager if it has no page breaks.
+func paginate(docId string, md []byte, n int) ([]byte, *docPager) {
+	pages := splitPages(md)
+	if len(pages) == 1 {
+		return md, nil
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > len(pages) {
+		n = len(pages)
+	}
+
+	p := &docPager{Page: n, Pages: len(pages)}
+	if n > 1 {
+		p.Prev = pageURL(docId, n-1)
+	}
+	if n < len(pages) {
+		p.Next = pageURL(docId, n+1)
+	}
+	for i := 1; i <= len(pages); i++ {
+		p.Links = append(p.Links, pageLink{Number: i, URL: pageURL(docId, i), Current: i == n})
+	}
+	return pages[n-1], p
+}
+
+// servePage renders page n of the latest revision of docId.
+func servePage(ctx context.Context, docId, page string) (Response, error) {
+	op := fmt.Sprintf("page %s of %s", page, docId)
+	n, err := strconv.Atoi(page)
+	if err != nil || !isPage(docId) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, err)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	doc, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	_, body := splitFrontMatter(docId, doc.body)
+	if n < 1 || n > len(splitPages(body)) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	return renderRevision(withPage(ctx, n), docId, doc)
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// docParams is a doc's front matter, with its defaults, for templates to
+// read fields of any name from with a type and a fallback:
+//
+//	{{.Params.GetString "owner" "unowned"}}
+//	{{with .Params.GetDate "review_by"}}Review by {{.Format "2 Jan 2006"}}{{end}}
+//	{{range .Params.GetStrings "reviewers"}}...{{end}}
+//
+// Keys may name nested fields, like "support.channel". A field that is
+// missing or of the wrong type gives the fallback, or the type's zero
+// value without one.
+type docParams map[string]interface{}
+
+// frontMatterParams returns the front matter of doc, with the defaults
+// for docId, as params.
+func frontMatterParams(docId string, doc []byte) docParams {
+	block, _, _ := frontMatterBlock(doc)
+	block, err := applyDefaults(docId, block)
+	params := docParams{}
+	if err == nil {
+		err = yaml.Unmarshal(block, &params)
+	}
+	if err != nil {
+		log.Printf("front matter error in %s: %v", docId, err)
+		return docParams{}
+	}
+	return params
+}
+
+// Has reports whether the field key is set.
+func (p docParams) Has(key string) bool {
+	return p.Get(key) != nil
+}
+
+// Get returns the field key as it was written, or nil.
+func (p docParams) Get(key string) interface{} {
+	var v interface{} = map[string]interface{}(p)
+	for _, k := range strings.Split(key, ".") {
+		switch m := v.(type) {
+		case map[string]interface{}:
+			v = m[k]
+		case map[interface{}]interface{}:
+			v = m[k]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// GetString reads text, or a number or boolean as written.
+func (p docParams) GetString(key string, def ...string) string {
+	switch v := p.Get(key).(type) {
+	case string:
+		return v
+	case int, float64, bool:
+		return fmt.Sprint(v)
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return ""
+}
+
+// GetInt reads a whole number, written as one or as text.
+func (p docParams) GetInt(key string, def ...int) int {
+	switch v := p.Get(key).(type) {
+	case int:
+		return v
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return n
+		}
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return 0
+}
+
+// GetFloat reads a number, written as one or as text.
+func (p docParams) GetFloat(key string, def ...float64) float64 {
+	switch v := p.Get(key).(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f
+		}
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return 0
+}
+
+// GetBool reads true or false, written as such or as text.
+func (p docParams) GetBool(key string, def ...bool) bool {
+	switch v := p.Get(key).(type) {
+	case bool:
+		return v
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b
+		}
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return false
+}
+
+// GetDate reads a date like 2020-09-01 or an RFC 3339 time. The fallback
+// is written the same way.
+func (p docParams) GetDate(key string, def ...string) time.Time {
+	if t, ok := paramTime(p.Get(key)); ok {
+		return t
+	}
+	if len(def) > 0 {
+		t, _ := paramTime(def[0])
+		return t
+	}
+	return time.Time{}
+}
+
+func paramTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		v = strings.TrimSpace(v)
+		if t, err := time.Parse("2006-01-02", v); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// GetStrings reads a list, or a single value as a list of one.
+func (p docParams) GetStrings(key string, def ...string) []string {
+	switch v := p.Get(key).(type) {
+	case []interface{}:
+		s := make([]string, 0, len(v))
+		for _, e := range v {
+			if e != nil {
+				s = append(s, fmt.Sprint(e))
+			}
+		}
+		return s
+	case string, int, float64, bool:
+		return []string{fmt.Sprint(v)}
+	}
+	return def
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// docPatch is the body of a PATCH request, either
+//
+//	{"op": "append", "text": "..."}
+//
+// to add text to the end of the doc, or
+//
+//	{"op": "replaceSection", "section": "deploy-steps", "text": "..."}
+//
+// to replace the content under the heading with that anchor, up to the
+// next heading of the same or a higher level. IfVersion, if set, refuses
+// the patch unless it is the latest revision.
+type docPatch struct {
+	Op        string `json:"op"`
+	Section   string `json:"section"`
+	Text      string `json:"text"`
+	IfVersion int    `json:"ifVersion"`
+}
+
+var (
+	atxHeading = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+
+	// patchLocks serializes patches to a doc within a container so that
+	// concurrent appends both land. The docstore has no conditional
+	// writes, so patches from different containers can still interleave.
+	patchLocks sync.Map
+)
+
+// anchor is the id a heading's text gets, the same as the markdown
+// renderer's automatic heading ids.
+func anchor(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// withNewline ends s with a newline if it doesn't already.
+func withNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
+
+// appendText adds text to the end of doc as a new paragraph.
+func appendText(doc, text string) string {
+	doc = withNewline(doc)
+	if doc != "" && !strings.HasSuffix(doc, "\n\n") {
+		doc += "\n"
+	}
+	return doc + withNewline(text)
+}
+
+// replaceSection replaces the content under the heading with the anchor
+// section, keeping the heading itself.
+func replaceSection(doc, section, text string) (string, bool) {
+	lines := strings.SplitAfter(doc, "\n")
+
+	start, level := -1, 0
+	fenced := false
+	for i, line := range lines {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+
+		m := atxHeading.FindStringSubmatch(trimmed)
+		if m == nil {
+			continue
+		}
+
+		if start < 0 {
+			if anchor(m[2]) == section {
+				start, level = i, len(m[1])
+			}
+			continue
+		}
+
+		if len(m[1]) <= level {
+			rest := strings.Join(lines[i:], "")
+			return strings.Join(lines[:start+1], "") + "\n" + withNewline(text) + "\n" + rest, true
+		}
+	}
+
+	if start < 0 {
+		return doc, false
+	}
+	return withNewline(strings.Join(lines[:start+1], "")) + "\n" + withNewline(text), true
+}
+
+// patchDoc applies a docPatch to the latest revision of the docId path
+// parameter, writing the result as a new revision.
+func patchDoc(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	op := "patch " + docId
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var p docPatch
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+
+	lock, _ := patchLocks.LoadOrStore(docId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Read past the coalescing in fetchDoc, which could hand back a
+	// revision from before a patch that just finished.
+	flights.Forget("doc:" + docId)
+	latest, err := fetchDoc(ctx, docId)
+	if err != nil && !(errors.Is(err, docerr.ErrNotFound) && p.Op == "append") {
+		return Response{}, err
+	}
+
+	if p.IfVersion != 0 && p.IfVersion != latest.meta.Id {
+		return Response{}, docerr.WithDetails(op, docerr.ErrConflict, nil,
+			map[string]interface{}{"ifVersion": p.IfVersion, "latest": latest.meta.Id})
+	}
+
+	var patched string
+	switch p.Op {
+	case "append":
+		patched = appendText(string(latest.body), p.Text)
+	case "replaceSection":
+		var ok bool
+		patched, ok = replaceSection(string(latest.body), p.Section, p.Text)
+		if !ok {
+			return Response{}, docerr.WithDetails(op, docerr.ErrNotFound, nil,
+				map[string]interface{}{"section": p.Section})
+		}
+	default:
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"op": p.Op})
+	}
+
+	res, err := writeDoc(ctx, docId, []byte(patched), dryRun(request))
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, res), nil
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const permalinkPrefix = "/permalink/"
+
+// permalinkURL is the permanent address of revision rev of docId.
+func permalinkURL(docId string, rev int) string {
+	return permalinkPrefix + docId + "/" + strconv.Itoa(rev)
+}
+
+// servePermalink serves /permalink/{docId}/{rev}: exactly that revision,
+// for citing in tickets and audits. The page never changes, so it is
+// rendered without the old revision banner and with the site's time format
+// rather than the reader's, and caches may keep it forever.
+func servePermalink(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	parts := strings.Split(strings.TrimPrefix(request.Path, permalinkPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" || strings.HasPrefix(parts[0], "_") {
+		return Response{}, docerr.E("permalink "+request.Path, docerr.ErrNotFound, nil)
+	}
+	docId := parts[0]
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Response{}, docerr.E("permalink "+request.Path, docerr.ErrNotFound, err)
+	}
+
+	ctx, private, err := checkAccess(ctx, request, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	rev, err := fetchRevision(fetchCtx, docId, n)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Rendered as for a reader without a time format of their own.
+	ctx = withRender(ctx, newRenderContext(ctx, events.APIGatewayProxyRequest{Path: request.Path}))
+	resp, err := renderWith(ctx, docId, rev, tmplDocName, nil)
+	if err != nil {
+		return resp, err
+	}
+
+	if private {
+		return withHeaders(resp, privateHeaders), nil
+	}
+	if _, ok := resp.Headers["Cache-Control"]; ok {
+		return resp, nil
+	}
+	return withHeaders(resp, map[string]string{"Cache-Control": immutableCacheControl}), nil
+}
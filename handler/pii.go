@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/comprehend"
+	"github.com/drocamor/n22t.docstore/sensitive"
+)
+
+// piiConfig sets how content being saved is checked for personal data and
+// credentials, for example:
+//
+//	pii:
+//	  policy: block
+//	  patterns:
+//	    employee_id: 'EMP-\d{6}'
+//	  comprehend: true
+//
+// Policy "warn" reports findings with the write, and "block" refuses it.
+// Checking is off by default. Comprehend also asks Amazon Comprehend to
+// detect PII, which finds names and addresses patterns can't.
+type piiConfig struct {
+	Policy     string            `yaml:"policy"`
+	Patterns   map[string]string `yaml:"patterns"`
+	Comprehend bool              `yaml:"comprehend"`
+}
+
+// comprehendMinScore is the confidence below which Comprehend's findings
+// are ignored.
+const comprehendMinScore = 0.8
+
+// comprehendMaxBytes is the most text Comprehend's DetectPiiEntities takes.
+const comprehendMaxBytes = 100000
+
+// patterns returns the built-in patterns with the configured ones added.
+func (c piiConfig) patterns() []sensitive.Pattern {
+	patterns := sensitive.Default
+	for kind, expr := range c.Patterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Printf("invalid pii pattern %s: %v", kind, err)
+			continue
+		}
+		patterns = append(patterns, sensitive.Pattern{Kind: kind, Re: re})
+	}
+	return patterns
+}
+
+// scanSensitive checks body for sensitive data as the config asks.
+func scanSensitive(ctx context.Context, c piiConfig, body []byte) []sensitive.Finding {
+	if c.Policy == "" || c.Policy == "off" {
+		return nil
+	}
+
+	text := string(body)
+	findings := sensitive.Scan(text, c.patterns())
+
+	if c.Comprehend {
+		found, err := comprehendPII(ctx, text)
+		if err != nil {
+			log.Printf("comprehend error: %v", err)
+		}
+		findings = append(findings, found...)
+	}
+	return findings
+}
+
+// comprehendPII asks Amazon Comprehend for the PII in text.
+func comprehendPII(ctx context.Context, text string) ([]sensitive.Finding, error) {
+	if len(text) > comprehendMaxBytes {
+		text = text[:comprehendMaxBytes]
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	out, err := comprehend.New(awsSession()).DetectPiiEntitiesWithContext(ctx, &comprehend.DetectPiiEntitiesInput{
+		LanguageCode: aws.String("en"),
+		Text:         aws.String(text),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []sensitive.Finding
+	for _, e := range out.Entities {
+		if aws.Float64Value(e.Score) < comprehendMinScore {
+			continue
+		}
+		start, end := int(aws.Int64Value(e.BeginOffset)), int(aws.Int64Value(e.EndOffset))
+		if start < 0 || end > len(text) || start > end {
+			continue
+		}
+		findings = append(findings, sensitive.Finding{
+			Kind:    "comprehend:" + strings.ToLower(aws.StringValue(e.Type)),
+			Line:    strings.Count(text[:start], "\n") + 1,
+			Offset:  start,
+			Excerpt: sensitive.Mask(text[start:end]),
+		})
+	}
+	return findings, nil
+}
+
+// describeFinding summarises f for a write's problems.
+func describeFinding(f sensitive.Finding) string {
+	return fmt.Sprintf("line %d: possible %s %s", f.Line, f.Kind, f.Excerpt)
+}
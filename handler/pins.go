@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// defaultPinLabel names the revisions pinned docs are served at unless the
+// site config names another label.
+const defaultPinLabel = "live"
+
+// pinConfig pins templates, stylesheets and system docs like _variables to
+// a revision, so that editing one doesn't change the site until the edit
+// is promoted:
+//
+//	pins:
+//	  docs: [doc-template.html, style.css]
+//
+// A pinned doc is served at the revision with its label, which promoting
+// moves. Until it is first promoted it is served at its latest revision.
+// The site config itself can't be pinned.
+type pinConfig struct {
+	Label string   `yaml:"label"`
+	Docs  []string `yaml:"docs"`
+
+	// Canary is the percentage of readers served the latest revisions of
+	// pinned templates and theme docs, to try them before promoting them.
+	Canary int `yaml:"canary"`
+}
+
+func (p pinConfig) label() string {
+	if p.Label == "" {
+		return defaultPinLabel
+	}
+	return p.Label
+}
+
+// pinned reports whether docId is pinned.
+func (p pinConfig) pinned(docId string) bool {
+	for _, d := range p.Docs {
+		if d == docId {
+			return true
+		}
+	}
+	return false
+}
+
+// pinnedVersion returns the revision docId is pinned to, or false if it
+// isn't pinned, hasn't been promoted yet, or ctx serves the canary.
+func pinnedVersion(ctx context.Context, docId string) (int, bool, error) {
+	pins := getConfig(ctx).Pins
+	if !pins.pinned(docId) || servesCanary(ctx) {
+		return 0, false, nil
+	}
+	n, err := labeledVersion(ctx, docId, pins.label())
+	if errors.Is(err, docerr.ErrNotFound) {
+		log.Printf("%s is pinned but hasn't been promoted; serving its latest revision", docId)
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+// pinStatus is where a pinned doc stands: the revision served and the
+// latest, which is waiting to be promoted if they differ.
+type pinStatus struct {
+	DocId    string `json:"docId"`
+	Version  int    `json:"version,omitempty"`
+	Latest   int    `json:"latest"`
+	Promoted bool   `json:"promoted"`
+	Pending  bool   `json:"pending"`
+}
+
+func getPinStatus(ctx context.Context, docId string) (pinStatus, error) {
+	latest, err := fetchDoc(ctx, docId)
+	if err != nil {
+		return pinStatus{}, err
+	}
+	n, ok, err := pinnedVersion(ctx, docId)
+	if err != nil {
+		return pinStatus{}, err
+	}
+	s := pinStatus{DocId: docId, Version: n, Latest: latest.meta.Id, Promoted: ok}
+	s.Pending = ok && n != s.Latest
+	return s, nil
+}
+
+// listPins reports every pinned doc's status.
+func listPins(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	pins := getConfig(ctx).Pins
+	docs := append([]string{}, pins.Docs...)
+	sort.Strings(docs)
+
+	statuses := []pinStatus{}
+	for _, docId := range docs {
+		s, err := getPinStatus(ctx, docId)
+		if errors.Is(err, docerr.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return Response{}, err
+		}
+		statuses = append(statuses, s)
+	}
+	return jsonResponse(200, struct {
+		Label  string      `json:"label"`
+		Canary int         `json:"canary,omitempty"`
+		Pins   []pinStatus `json:"pins"`
+	}{pins.label(), pins.Canary, statuses}), nil
+}
+
+// promotePin serves the pinned docId path parameter at its latest
+// revision, or at the revision in the body: {"version": 3}. Other warm
+// containers pick the change up within their template and config TTLs.
+func promotePin(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	op := "promote " + docId
+	pins := getConfig(ctx).Pins
+	if !pins.pinned(docId) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"reason": "not pinned in the site config"})
+	}
+
+	var v struct {
+		Version int `json:"version"`
+	}
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &v)
+		if err != nil {
+			return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+		}
+	}
+
+	var rev fetchedDoc
+	if v.Version == 0 {
+		rev, err = fetchDoc(ctx, docId)
+	} else {
+		rev, err = fetchRevision(ctx, docId, v.Version)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+
+	labels, err := getLabels(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	if labels[docId] == nil {
+		labels[docId] = map[string]int{}
+	}
+	prev, promoted := labels[docId][pins.label()]
+	labels[docId][pins.label()] = rev.meta.Id
+	err = putLabels(labels)
+	if err != nil {
+		return Response{}, err
+	}
+	forgetPinned(docId)
+
+	details := map[string]interface{}{"label": pins.label(), "version": rev.meta.Id}
+	if promoted {
+		details["previous"] = prev
+	}
+	audit(request, "pin.promote", docId, details)
+
+	s, err := getPinStatus(ctx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, s), nil
+}
+
+// forgetPinned drops this container's copies of a doc just promoted.
+func forgetPinned(docId string) {
+	canary := docId + "@" + variantCanary
+	templates.Lock()
+	delete(templates.entries, docId)
+	delete(templates.entries, canary)
+	templates.Unlock()
+
+	assets.Lock()
+	delete(assets.entries, docId)
+	delete(assets.entries, canary)
+	assets.Unlock()
+
+	forgetSystemDoc(docId)
+}
+
+// pinWarnings warns that writing the pinned docId won't change the site
+// until it is promoted.
+func pinWarnings(ctx context.Context, docId string) []string {
+	if !getConfig(ctx).Pins.pinned(docId) {
+		return nil
+	}
+	return []string{"pinned; promote it with POST " + apiV1 + "admin/pins/" + docId + "/promote to serve this revision"}
+}
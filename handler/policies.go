@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// policiesDocName holds the site's lifecycle policies, rules for the pages
+// under a path prefix or with one of some tags, for example:
+//
+//	policies:
+//	  - name: meeting notes
+//	    prefix: /notes-
+//	    tags: [meeting]
+//	    archiveAfterDays: 365
+//	  - name: runbooks
+//	    prefix: /runbook-
+//	    requireOwner: true
+//	    requireReview: true
+//
+// A page matches a policy when it is under its prefix, if it has one, and
+// has one of its tags, if it has any. requireOwner refuses writes of
+// matching pages without an owner in their front matter, and requireReview
+// writes by anyone but the admins of the page, so changes come through
+// suggested edits. Those are checked on every write. archiveAfterDays
+// marks matching pages archived once they have gone that long without a
+// revision, which the scheduled policy run does along with reporting the
+// pages breaking the other rules.
+const policiesDocName = "_policies"
+
+type lifecyclePolicy struct {
+	Name             string   `yaml:"name" json:"name"`
+	Prefix           string   `yaml:"prefix" json:"prefix,omitempty"`
+	Tags             []string `yaml:"tags" json:"tags,omitempty"`
+	ArchiveAfterDays int      `yaml:"archiveAfterDays" json:"archiveAfterDays,omitempty"`
+	RequireOwner     bool     `yaml:"requireOwner" json:"requireOwner,omitempty"`
+	RequireReview    bool     `yaml:"requireReview" json:"requireReview,omitempty"`
+}
+
+type policiesDoc struct {
+	Policies []lifecyclePolicy `yaml:"policies"`
+}
+
+// matches reports whether the page docId with front matter fm is one the
+// policy governs.
+func (p lifecyclePolicy) matches(docId string, fm frontMatter) bool {
+	if !strings.HasPrefix("/"+docId, p.Prefix) {
+		return false
+	}
+	if len(p.Tags) == 0 {
+		return true
+	}
+	for _, t := range p.Tags {
+		for _, tag := range fm.Tags {
+			if strings.EqualFold(t, tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parsePolicies(body []byte) (policiesDoc, error) {
+	var p policiesDoc
+	err := yaml.UnmarshalStrict(body, &p)
+	return p, err
+}
+
+// lintPolicies checks the body of the policies doc.
+func lintPolicies(body []byte) (problems []string) {
+	p, err := parsePolicies(body)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	for i, policy := range p.Policies {
+		name := policy.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+			problems = append(problems, fmt.Sprintf("policy %s: no name", name))
+		}
+		if policy.Prefix != "" && !strings.HasPrefix(policy.Prefix, "/") {
+			problems = append(problems, fmt.Sprintf("policy %s: prefix %q doesn't start with /", name, policy.Prefix))
+		}
+		if policy.ArchiveAfterDays < 0 {
+			problems = append(problems, fmt.Sprintf("policy %s: archiveAfterDays is negative", name))
+		}
+		if policy.ArchiveAfterDays == 0 && !policy.RequireOwner && !policy.RequireReview {
+			problems = append(problems, fmt.Sprintf("policy %s: does nothing", name))
+		}
+	}
+	return problems
+}
+
+// getPolicies returns the site's lifecycle policies. A policies doc that
+// doesn't parse is logged and has none, like the other system docs.
+func getPolicies(ctx context.Context) []lifecyclePolicy {
+	body, ok := getSystemDoc(ctx, policiesDocName)
+	if !ok {
+		return nil
+	}
+	p, err := parsePolicies(body)
+	if err != nil {
+		log.Printf("%s error: %v", policiesDocName, err)
+		return nil
+	}
+	return p.Policies
+}
+
+// ownerProblems returns the problems of writing body to the page docId
+// under the policies requiring an owner. Owners given by the defaults docs
+// count.
+func ownerProblems(ctx context.Context, docId string, body []byte) (problems []string) {
+	if !isPage(docId) {
+		return nil
+	}
+	fm, _ := splitFrontMatter(docId, body)
+	if fm.Owner != "" {
+		return nil
+	}
+	for _, p := range getPolicies(ctx) {
+		if p.RequireOwner && p.matches(docId, fm) {
+			problems = append(problems, fmt.Sprintf("policy %q: the doc needs an owner in its front matter", p.Name))
+		}
+	}
+	return problems
+}
+
+// checkReview refuses a write of body to docId by anyone but its admins if
+// a policy requires changes to it to be reviewed. The doc is governed if
+// either its latest revision or body matches, so a change can't escape
+// review by dropping a tag.
+func checkReview(ctx context.Context, docId string, body []byte) error {
+	if !isPage(docId) || roleFrom(ctx) == roleAdmin || roleFrom(ctx) == rolePrefixAdmin {
+		return nil
+	}
+	policies := getPolicies(ctx)
+	if len(policies) == 0 {
+		return nil
+	}
+
+	fm, _ := splitFrontMatter(docId, body)
+	var old frontMatter
+	if latest, err := fetchDoc(ctx, docId); err == nil {
+		old, _ = splitFrontMatter(docId, latest.body)
+	} else if !errors.Is(err, docerr.ErrNotFound) {
+		return err
+	}
+	for _, p := range policies {
+		if p.RequireReview && (p.matches(docId, fm) || p.matches(docId, old)) {
+			return docerr.WithDetails("write "+docId, docerr.ErrForbidden, nil,
+				map[string]interface{}{"reason": fmt.Sprintf("policy %q requires review; suggest an edit", p.Name)})
+		}
+	}
+	return nil
+}
+
+// policyFinding is what a policy run found a page needing: "archive",
+// which the run does, or "owner", which someone has to.
+type policyFinding struct {
+	Policy string `json:"policy"`
+	DocId  string `json:"docId"`
+	Action string `json:"action"`
+	Detail string `json:"detail"`
+	Error  string `json:"error,omitempty"`
+}
+
+// evaluatePolicies checks every page against the policies as of now,
+// archiving the pages due to be unless dryRun is set.
+func evaluatePolicies(ctx context.Context, now time.Time, dryRun bool) ([]policyFinding, error) {
+	policies := getPolicies(ctx)
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	docs, err := listAllDocs(ctx)
+	if err != nil {
+		return nil, docerr.FromStore("ListDocs", err)
+	}
+
+	var mu sync.Mutex
+	var findings []policyFinding
+	sem := make(chan struct{}, catalogWorkers)
+	var wg sync.WaitGroup
+	for _, d := range docs {
+		if !isPage(d.Id) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d docstore.Doc) {
+			defer func() { <-sem; wg.Done() }()
+			f := evaluateDoc(ctx, policies, d.Id, now, dryRun)
+			mu.Lock()
+			findings = append(findings, f...)
+			mu.Unlock()
+		}(d)
+	}
+	wg.Wait()
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].DocId != findings[j].DocId {
+			return findings[i].DocId < findings[j].DocId
+		}
+		return findings[i].Policy < findings[j].Policy
+	})
+	return findings, ctx.Err()
+}
+
+// evaluateDoc checks the page docId against policies.
+func evaluateDoc(ctx context.Context, policies []lifecyclePolicy, docId string, now time.Time, dryRun bool) []policyFinding {
+	doc, err := fetchDoc(ctx, docId)
+	if err != nil {
+		log.Printf("policies: %s: %v", docId, err)
+		return nil
+	}
+	fm, _ := splitFrontMatter(docId, doc.body)
+
+	var findings []policyFinding
+	var archive *lifecyclePolicy
+	for i, p := range policies {
+		if !p.matches(docId, fm) {
+			continue
+		}
+		if p.RequireOwner && fm.Owner == "" {
+			findings = append(findings, policyFinding{p.Name, docId, "owner", "has no owner", ""})
+		}
+		due := time.Duration(p.ArchiveAfterDays) * 24 * time.Hour
+		if archive == nil && p.ArchiveAfterDays > 0 && !fm.Archived && now.Sub(doc.meta.Timestamp) >= due {
+			archive = &policies[i]
+		}
+	}
+	if archive == nil {
+		return findings
+	}
+
+	f := policyFinding{archive.Name, docId, "archive",
+		fmt.Sprintf("no revision for %d days", int(now.Sub(doc.meta.Timestamp).Hours()/24)), ""}
+	if !dryRun {
+		if err := archiveDoc(ctx, docId, doc.body, archive.Name); err != nil {
+			f.Error = err.Error()
+		}
+	}
+	return append(findings, f)
+}
+
+// archiveDoc writes body with archived set in its front matter, as a
+// revision saying which policy archived it.
+func archiveDoc(ctx context.Context, docId string, body []byte, policy string) error {
+	block, rest, _ := frontMatterBlock(body)
+	var fm yaml.MapSlice
+	if err := yaml.Unmarshal(block, &fm); err != nil {
+		return fmt.Errorf("front matter: %v", err)
+	}
+	fm = mergeMapSlice(fm, yaml.MapSlice{{Key: "archived", Value: true}})
+	b, err := yaml.Marshal(fm)
+	if err != nil {
+		return err
+	}
+
+	ctx = withRevisionMessage(withRole(ctx, roleAdmin), fmt.Sprintf("Archived by policy %q", policy))
+	_, err = writeDoc(ctx, docId, []byte("---\n"+string(b)+"---\n"+string(rest)), false)
+	if err == nil {
+		audit(auditRequestFrom(ctx), "policy.archive", docId, map[string]interface{}{"policy": policy})
+	}
+	return err
+}
+
+// runPolicies is the scheduled policy run. It archives the pages due to
+// be and tells the site's editors what it did and found.
+func runPolicies(ctx context.Context) error {
+	start := time.Now()
+	findings, err := evaluatePolicies(ctx, start, false)
+	counts := map[string]int{}
+	for _, f := range findings {
+		counts[f.Action]++
+		if f.Error != "" {
+			counts["failed"]++
+		}
+	}
+	for action, n := range counts {
+		metrics.Emit("PolicyFindings", float64(n), metrics.Count, map[string]string{"Action": action})
+	}
+	log.Printf("policy run: %v in %v", counts, time.Since(start))
+	if len(findings) > 0 {
+		notifyPolicies(ctx, findings)
+	}
+	return err
+}
+
+// notifyPolicies tells the site's editors what a policy run found.
+func notifyPolicies(ctx context.Context, findings []policyFinding) {
+	notifier := getNotifier()
+	if notifier == nil {
+		return
+	}
+	var lines []string
+	for _, f := range findings {
+		line := fmt.Sprintf("%s: %s (%s, policy %q)", f.DocId, f.Action, f.Detail, f.Policy)
+		if f.Error != "" {
+			line += ": " + f.Error
+		}
+		lines = append(lines, line)
+	}
+	err := notifier.Notify(ctx, notification{
+		Type:    "policy.report",
+		Subject: fmt.Sprintf("Policy run: %d pages need attention", len(findings)),
+		Text:    strings.Join(lines, "\n"),
+		Message: struct {
+			Type     string          `json:"type"`
+			Findings []policyFinding `json:"findings"`
+		}{"policy.report", findings},
+	})
+	if err != nil {
+		log.Printf("notify policy run: %v", err)
+	}
+}
+
+// policyReport answers with the policies and what a run would do now,
+// without doing it.
+func policyReport(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	findings, err := evaluatePolicies(ctx, time.Now(), true)
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, struct {
+		Policies []lifecyclePolicy `json:"policies"`
+		Findings []policyFinding   `json:"findings"`
+	}{getPolicies(ctx), findings}), nil
+}
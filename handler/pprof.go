@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	pprofPrefix = "/debug/pprof/"
+
+	// maxProfileRenders bounds the renders one profile request runs.
+	maxProfileRenders = 1000
+)
+
+var (
+	// pprofEnabled turns on the /debug/pprof/ routes. Leave it off in
+	// production.
+	pprofEnabled = envBool("PPROF_ENABLED")
+)
+
+// pprofHandler serves runtime profiles over the Lambda proxy integration
+// to clients with the admin API key.
+//
+// /debug/pprof/profile?doc=index&n=100 CPU profiles n renders of doc;
+// any other name, like /debug/pprof/heap, is looked up with pprof.Lookup.
+// Profiles are returned base64 encoded; they arrive as raw bytes when the
+// client sends "Accept: application/octet-stream", otherwise pipe them
+// through base64 -d before handing them to go tool pprof.
+func pprofHandler(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if err := requireAdmin(request); err != nil {
+		return Response{}, err
+	}
+	name := strings.TrimPrefix(request.Path, pprofPrefix)
+
+	var b bytes.Buffer
+	if name == "profile" {
+		err := profileRenders(ctx, &b, request.QueryStringParameters)
+		if err != nil {
+			return Response{}, err
+		}
+	} else {
+		p := pprof.Lookup(name)
+		if p == nil {
+			return Response{}, docerr.E("pprof "+name, docerr.ErrNotFound, nil)
+		}
+
+		err := p.WriteTo(&b, 0)
+		if err != nil {
+			return Response{}, err
+		}
+	}
+
+	resp := Response{
+		StatusCode:      200,
+		IsBase64Encoded: true,
+		Body:            base64.StdEncoding.EncodeToString(b.Bytes()),
+		Headers: map[string]string{
+			"Content-Type": "application/octet-stream",
+		},
+	}
+
+	return resp, nil
+}
+
+// profileRenders writes a CPU profile of rendering a doc up to
+// maxProfileRenders times, bypassing the render cache and request
+// coalescing. It stops early when ctx is done.
+func profileRenders(ctx context.Context, b *bytes.Buffer, params map[string]string) error {
+	docId, ok := params["doc"]
+	if !ok {
+		docId = "index"
+	}
+
+	n, err := strconv.Atoi(params["n"])
+	if err != nil || n < 1 {
+		n = 100
+	}
+	if n > maxProfileRenders {
+		n = maxProfileRenders
+	}
+
+	doc, err := fetchDoc(ctx, docId)
+	if err != nil {
+		return err
+	}
+
+	err = pprof.StartCPUProfile(b)
+	if err != nil {
+		return err
+	}
+	defer pprof.StopCPUProfile()
+
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err = renderRevision(ctx, docId, doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// docNav finds the docs either side of a page in the catalog's order,
+// pinned docs first and then the most recently updated, the first time a
+// template asks for them.
+type docNav struct {
+	once       sync.Once
+	ctx        context.Context
+	docId      string
+	prev, next *docSummary
+}
+
+func newDocNav(ctx context.Context, docId string) *docNav {
+	return &docNav{ctx: ctx, docId: docId}
+}
+
+func (n *docNav) find() {
+	n.once.Do(func() {
+		c, err := getCatalog(n.ctx)
+		if err != nil {
+			log.Printf("prev and next of %s: %v", n.docId, err)
+			return
+		}
+		n.prev, n.next = adjacentDocs(c, n.docId)
+	})
+}
+
+// adjacentDocs returns the docs before and after docId in c, with nil at
+// either end or if docId isn't listed.
+func adjacentDocs(c catalog, docId string) (prev, next *docSummary) {
+	for i := range c {
+		if c[i].DocId != docId {
+			continue
+		}
+		if i > 0 {
+			prev = &c[i-1]
+		}
+		if i+1 < len(c) {
+			next = &c[i+1]
+		}
+		return
+	}
+	return
+}
+
+// Prev is the doc before the page in the order of the site's index, for
+// themes to link to or bind the left arrow key to. It is nil for the first
+// doc and for docs left out of listings.
+func (m docMetadata) Prev() *docSummary {
+	if m.nav == nil {
+		return nil
+	}
+	m.nav.find()
+	return m.nav.prev
+}
+
+// Next is the doc after the page in the order of the site's index, and nil
+// for the last doc and for docs left out of listings.
+func (m docMetadata) Next() *docSummary {
+	if m.nav == nil {
+		return nil
+	}
+	m.nav.find()
+	return m.nav.next
+}
+
+// getDocNav answers with the docs before and after the docId path
+// parameter in the order of the site's index, for themes navigating
+// between pages from script.
+func getDocNav(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	ctx, private, err := checkAccess(ctx, request, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	c, err := getCatalog(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	var nav struct {
+		Prev *listedDoc `json:"prev"`
+		Next *listedDoc `json:"next"`
+	}
+	prev, next := adjacentDocs(c, docId)
+	if prev != nil {
+		nav.Prev = &listedDoc{prev.DocId, prev.Title, prev.Timestamp, prev.Version}
+	}
+	if next != nil {
+		nav.Next = &listedDoc{next.DocId, next.Title, next.Timestamp, next.Version}
+	}
+
+	resp := jsonResponse(200, nav)
+	if private {
+		resp = withHeaders(resp, privateHeaders)
+	}
+	return resp, nil
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"os"
+
+	"github.com/drocamor/docstore/awsdocstore"
+)
+
+// profile is the environment profile, like "dev", "stage" or "prod",
+// chosen with PROFILE. It selects the site config doc, and the deployment
+// sets the docstore tables from it, so one build can serve every stage.
+// Without one the site reads _config and the default tables.
+var profile = os.Getenv("PROFILE")
+
+// configDocNames are the docs to look for the site config in, in order:
+// the profile's own config, like "_config.prod", then the shared one.
+func configDocNames() []string {
+	if profile == "" {
+		return []string{configDocName}
+	}
+	return []string{configDocName + "." + profile, configDocName}
+}
+
+// storeOptions picks the docstore tables from DOCS_TABLE and
+// REVISIONS_TABLE, leaving the docstore defaults when they are unset.
+func storeOptions() (opts []awsdocstore.AwsDocStoreOption) {
+	if t := os.Getenv("DOCS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithDocTable(t))
+	}
+	if t := os.Getenv("REVISIONS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithRevisionTable(t))
+	}
+	return
+}
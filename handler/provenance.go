@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// sourceHeader names the system a write syncs a doc from, like "github".
+// Sync jobs send it with every write of a synced doc.
+const sourceHeader = "X-Docstore-Source"
+
+// docSource records where a doc synced from another system, like GitHub,
+// Confluence or Notion, comes from, in its front matter:
+//
+//	---
+//	source:
+//	  system: github
+//	  id: acme/handbook/docs/onboarding.md
+//	  url: https://github.com/acme/handbook/blob/main/docs/onboarding.md
+//	  synced: 2020-09-01T13:00:00Z
+//	---
+//
+// Its pages say where it is synced from, linking to where it is edited,
+// and writes that aren't the sync's, which the sync would overwrite, are
+// refused unless an admin detaches the doc with ?detach=1.
+type docSource struct {
+	System string    `yaml:"system" json:"system"`
+	Id     string    `yaml:"id" json:"id,omitempty"`
+	URL    string    `yaml:"url" json:"url,omitempty"`
+	Synced time.Time `yaml:"synced" json:"synced,omitempty"`
+}
+
+// sourceBanner describes where a synced page comes from.
+type sourceBanner struct {
+	System string
+	URL    string
+	Synced string
+}
+
+// defaultSourceBanner is used unless the page template defines its own
+// with {{define "source"}}...{{end}}.
+var defaultSourceBanner = template.Must(template.New("source").Parse(
+	`<div class="synced">Synced from {{.System}}{{if .Synced}} on {{.Synced}}{{end}}.` +
+		`{{if .URL}} <a href="{{.URL}}">Edit it there</a>.{{end}}</div>
+`))
+
+// pageSourceBanner returns the banner for a revision of a synced doc, or
+// nil if the doc isn't synced. Every revision of a synced doc is the
+// sync's, so a source without a sync time was synced when the revision
+// was written.
+func pageSourceBanner(fm frontMatter, rev fetchedDoc, tf timeFormat) *sourceBanner {
+	if fm.Source == nil || fm.Source.System == "" {
+		return nil
+	}
+	synced := fm.Source.Synced
+	if synced.IsZero() {
+		synced = rev.meta.Timestamp
+	}
+	return &sourceBanner{System: fm.Source.System, URL: fm.Source.URL, Synced: tf.format(synced)}
+}
+
+// sourceBannerHTML renders the synced from banner with the page template's
+// "source" definition if it has one.
+func sourceBannerHTML(tmpl *template.Template, b *sourceBanner) (string, error) {
+	t := tmpl.Lookup("source")
+	if t == nil {
+		t = defaultSourceBanner
+	}
+
+	var s strings.Builder
+	err := t.Execute(&s, b)
+	return s.String(), err
+}
+
+// checkSource refuses a write to a synced doc that doesn't come from its
+// source, which would diverge from it until the next sync overwrote it.
+func checkSource(ctx context.Context, docId string, latest fetchedDoc, body []byte, res *writeResult) {
+	old, _ := splitFrontMatter(docId, latest.body)
+	if old.Source == nil || old.Source.System == "" {
+		return
+	}
+
+	request := auditRequestFrom(ctx)
+	if strings.EqualFold(header(request, sourceHeader), old.Source.System) {
+		return
+	}
+	if request.QueryStringParameters["detach"] == "1" && roleFrom(ctx) == roleAdmin {
+		if fm, _ := splitFrontMatter(docId, body); fm.Source != nil {
+			res.Warnings = append(res.Warnings, "detached, but the front matter still has a source, so the doc stays synced")
+		}
+		return
+	}
+
+	p := "synced from " + old.Source.System + "; "
+	if old.Source.URL != "" {
+		p += "edit it at " + old.Source.URL
+	} else {
+		p += "edit it there"
+	}
+	res.Problems = append(res.Problems, p+", or have an admin detach it with ?detach=1")
+}
+
+// lintSource checks a synced doc's source names its system.
+func lintSource(fm frontMatter) []string {
+	if fm.Source != nil && fm.Source.System == "" {
+		return []string{"front matter: source: no system"}
+	}
+	return nil
+}
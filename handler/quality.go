@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+const (
+	// A doc is fresh for freshFor after its last revision, and stale, for
+	// scoring, by staleAfter.
+	freshFor   = 180 * 24 * time.Hour
+	staleAfter = 730 * 24 * time.Hour
+
+	// longDocWords is how long a doc must be to need headings and links,
+	// and maxLinkDensity how many links per hundred words is too many.
+	longDocWords   = 300
+	maxLinkDensity = 10.0
+
+	maxTitleLength = 80
+)
+
+var qualities = &qualityCache{entries: map[string]docQuality{}}
+
+// docQuality is a heuristic score of how well kept a doc is, out of 100,
+// with the checks making it up and what to fix.
+type docQuality struct {
+	DocId   string    `json:"docId"`
+	Title   string    `json:"title"`
+	Version int       `json:"version"`
+	Updated time.Time `json:"updated"`
+	Score   int       `json:"score"`
+
+	// Checks are each check's share of its weight earned, from 0 to 1.
+	Checks map[string]float64 `json:"checks"`
+	Issues []string           `json:"issues,omitempty"`
+}
+
+// qualityChecks weigh each check, out of 100 in all.
+var qualityChecks = map[string]float64{
+	"title":       10,
+	"description": 15,
+	"headings":    20,
+	"links":       15,
+	"freshness":   20,
+	"altText":     20,
+}
+
+type qualityCache struct {
+	sync.Mutex
+	entries map[string]docQuality
+}
+
+// assessQuality scores a revision of a doc at now.
+func assessQuality(docId string, doc fetchedDoc, now time.Time) docQuality {
+	fm, body := splitFrontMatter(docId, doc.body)
+	q := docQuality{
+		DocId:   docId,
+		Title:   fm.title(body),
+		Version: doc.meta.Id,
+		Updated: doc.meta.Timestamp,
+		Checks:  map[string]float64{},
+	}
+	check := func(name string, earned float64, issue string, args ...interface{}) {
+		q.Checks[name] = math.Round(earned*100) / 100
+		if earned < 1 {
+			q.Issues = append(q.Issues, fmt.Sprintf(issue, args...))
+		}
+	}
+
+	switch {
+	case strings.TrimSpace(q.Title) == "":
+		check("title", 0, "no title")
+	case len(q.Title) > maxTitleLength:
+		check("title", 0.5, "title longer than %d characters", maxTitleLength)
+	default:
+		check("title", 1, "")
+	}
+
+	if strings.TrimSpace(fm.Description) == "" {
+		check("description", 0, "no description in the front matter")
+	} else {
+		check("description", 1, "")
+	}
+
+	var headings, skipped, links, images, withAlt int
+	level := 1
+	root := markdown.Parse(body, parser.NewWithExtensions(mdExtensions))
+	ast.WalkFunc(root, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Heading:
+			headings++
+			if n.Level > level+1 {
+				skipped++
+			}
+			level = n.Level
+		case *ast.Link:
+			links++
+		case *ast.Image:
+			images++
+			if strings.TrimSpace(plainText(n)) != "" {
+				withAlt++
+			}
+		}
+		return ast.GoToNext
+	})
+	words := len(strings.Fields(docText(body)))
+
+	switch {
+	case words >= longDocWords && headings == 0:
+		check("headings", 0, "%d words without headings", words)
+	case skipped > 0:
+		check("headings", 0.5, "%d headings skip a level", skipped)
+	default:
+		check("headings", 1, "")
+	}
+
+	density := 0.0
+	if words > 0 {
+		density = 100 * float64(links) / float64(words)
+	}
+	switch {
+	case words >= longDocWords && links == 0:
+		check("links", 0.5, "no links to related docs")
+	case density > maxLinkDensity:
+		check("links", 0.5, "%.0f links per hundred words", density)
+	default:
+		check("links", 1, "")
+	}
+
+	age := now.Sub(doc.meta.Timestamp)
+	switch {
+	case age <= freshFor:
+		check("freshness", 1, "")
+	case age >= staleAfter:
+		check("freshness", 0, "not updated in %d days", int(age.Hours()/24))
+	default:
+		check("freshness", 1-float64(age-freshFor)/float64(staleAfter-freshFor),
+			"not updated in %d days", int(age.Hours()/24))
+	}
+
+	if images == 0 {
+		check("altText", 1, "")
+	} else {
+		check("altText", float64(withAlt)/float64(images), "%d of %d images without alt text", images-withAlt, images)
+	}
+
+	score := 0.0
+	for name, weight := range qualityChecks {
+		score += weight * q.Checks[name]
+	}
+	q.Score = int(math.Round(score))
+	return q
+}
+
+// quality returns the quality of the latest revision of the page d
+// summarises, fetching it unless that revision's is cached. Freshness is
+// reckoned when it was cached, which is close enough for reports.
+func quality(ctx context.Context, d docSummary) (docQuality, error) {
+	qualities.Lock()
+	q, ok := qualities.entries[d.DocId]
+	qualities.Unlock()
+	if ok && q.Version == d.Version {
+		return q, nil
+	}
+
+	doc, err := fetchDoc(ctx, d.DocId)
+	if err != nil {
+		return docQuality{}, err
+	}
+	q = assessQuality(d.DocId, doc, time.Now())
+	qualities.Lock()
+	qualities.entries[d.DocId] = q
+	qualities.Unlock()
+	return q, nil
+}
+
+// qualityStats reports the quality of every page, listed or not, worst
+// first, for prioritizing cleanup, with the site's average. The docId
+// query parameter limits it to one doc, and max to docs scoring at most
+// that.
+func qualityStats(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	params := request.QueryStringParameters
+	maxScore := 100
+	if v := params["max"]; v != "" {
+		_, err := fmt.Sscan(v, &maxScore)
+		if err != nil {
+			return Response{}, docerr.WithDetails("quality stats", docerr.ErrBadRequest, err,
+				map[string]interface{}{"max": "a score from 0 to 100"})
+		}
+	}
+
+	pages, err := allPages(ctx)
+	if err != nil {
+		return Response{}, docerr.FromStore("quality stats", err)
+	}
+	if docId := params["docId"]; docId != "" {
+		var one []docSummary
+		for _, d := range pages {
+			if d.DocId == docId {
+				one = append(one, d)
+			}
+		}
+		if len(one) == 0 {
+			return Response{}, docerr.E("quality stats "+docId, docerr.ErrNotFound, nil)
+		}
+		pages = one
+	}
+
+	results := make([]docQuality, len(pages))
+	errs := make([]error, len(pages))
+	sem := make(chan struct{}, catalogWorkers)
+	var wg sync.WaitGroup
+	for i, d := range pages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, d docSummary) {
+			defer func() { <-sem; wg.Done() }()
+			results[i], errs[i] = quality(ctx, d)
+		}(i, d)
+	}
+	wg.Wait()
+
+	report := []docQuality{}
+	total := 0
+	for i, q := range results {
+		if errs[i] != nil {
+			return Response{}, docerr.FromStore("quality stats "+pages[i].DocId, errs[i])
+		}
+		total += q.Score
+		if q.Score <= maxScore {
+			report = append(report, q)
+		}
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		if report[i].Score != report[j].Score {
+			return report[i].Score < report[j].Score
+		}
+		return report[i].DocId < report[j].DocId
+	})
+
+	average := 0.0
+	if len(results) > 0 {
+		average = math.Round(10*float64(total)/float64(len(results))) / 10
+	}
+	return jsonResponse(200, struct {
+		Average float64      `json:"average"`
+		Docs    []docQuality `json:"docs"`
+	}{average, report}), nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// quotaConfig limits the size of writes, for example:
+//
+//	quotas:
+//	  store: 1073741824
+//	  roles:
+//	    writer:
+//	      doc: 262144
+//	      attachment: 5242880
+//	    admin:
+//	      doc: 1048576
+//	      attachment: 52428800
+//	    anonymous:
+//	      doc: 65536
+//
+// Sizes are in bytes and zero or unset is no limit. Attachments are docs
+// served as uploaded, like "logo.png", and the store limit is on the latest
+// revisions of every doc together.
+type quotaConfig struct {
+	Store int64                `yaml:"store"`
+	Roles map[string]roleQuota `yaml:"roles"`
+}
+
+type roleQuota struct {
+	Doc        int64 `yaml:"doc"`
+	Attachment int64 `yaml:"attachment"`
+}
+
+var (
+	// storeSizeTTL is how long a warm container reuses the store's total
+	// size before adding it up again.
+	storeSizeTTL = envDuration("STORE_SIZE_TTL", 5*time.Minute)
+
+	storeSizes = &storeSizeCache{}
+)
+
+// checkQuota refuses writing size bytes to docId if it would go over the
+// client role's limit for the doc, its tenant's storage quota, or the
+// store's limit.
+func checkQuota(ctx context.Context, docId string, size int) error {
+	cfg := getConfig(ctx)
+	q, role := cfg.Quotas, roleFrom(ctx)
+
+	kind, limit := "doc", q.Roles[role].Doc
+	if strings.Contains(docId, ".") {
+		kind, limit = "attachment", q.Roles[role].Attachment
+	}
+	if limit > 0 && int64(size) > limit {
+		return quotaError(docId, kind, role, limit, int64(size))
+	}
+
+	_, t, ok := tenantOf(cfg, docId)
+	if q.Store <= 0 && (!ok || t.Quota.Storage <= 0) {
+		return nil
+	}
+
+	old, err := baseSize(ctx, docId)
+	if err != nil {
+		return err
+	}
+	delta := int64(size) - old
+
+	err = checkTenantStorage(ctx, docId, delta)
+	if err != nil || q.Store <= 0 {
+		return err
+	}
+
+	total, err := getStoreSize(ctx)
+	if err != nil {
+		return err
+	}
+	if total+delta > q.Store {
+		return quotaError(docId, "store", role, q.Store, total+delta)
+	}
+	return nil
+}
+
+func quotaError(docId, kind, role string, limit, size int64) error {
+	return docerr.WithDetails("write "+docId, docerr.ErrTooLarge, nil, map[string]interface{}{
+		"docId": docId,
+		"quota": kind,
+		"role":  role,
+		"limit": limit,
+		"size":  size,
+	})
+}
+
+type storeSizeCache struct {
+	sync.Mutex
+	total   int64
+	fetched time.Time
+}
+
+// getStoreSize returns the total size of the latest revision of every doc,
+// adding it up again when the cached total is older than storeSizeTTL.
+func getStoreSize(ctx context.Context) (int64, error) {
+	storeSizes.Lock()
+	defer storeSizes.Unlock()
+
+	if !storeSizes.fetched.IsZero() && time.Since(storeSizes.fetched) < storeSizeTTL {
+		return storeSizes.total, nil
+	}
+
+	docs, err := listAllDocs(ctx)
+	if err != nil {
+		return 0, docerr.FromStore("ListDocs", err)
+	}
+
+	var total int64
+	for _, d := range docs {
+		doc, err := fetchDoc(ctx, d.Id)
+		if err != nil {
+			log.Printf("store size: skipping %s: %v", d.Id, err)
+			continue
+		}
+		total += int64(len(doc.body))
+	}
+
+	storeSizes.total, storeSizes.fetched = total, time.Now()
+	return total, nil
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// The rawHTML policies of a prefix, for sites whose public docs mustn't
+// carry markup the theme doesn't control, like scripts, iframes and
+// inline styles, while internal ones may:
+//
+//	prefixes:
+//	  /:
+//	    rawHTML: strip
+//	  /internal/:
+//	    rawHTML: allow
+//
+// With "strip" raw HTML is dropped when the doc is rendered and writes
+// containing it are warned; with "block" writes containing it are refused
+// as well. The longest prefix setting rawHTML applies, and HTML comments,
+// which page breaks and audience markers are, are always allowed.
+const (
+	rawHTMLAllow = "allow"
+	rawHTMLStrip = "strip"
+	rawHTMLBlock = "block"
+)
+
+// rawHTML returns the rawHTML policy that applies to docId.
+func (c *siteConfig) rawHTML(docId string) string {
+	policy := rawHTMLAllow
+	for _, p := range c.matchingPrefixes("/" + docId) {
+		if p.RawHTML != "" {
+			policy = p.RawHTML
+		}
+	}
+	return policy
+}
+
+// stripsHTML reports whether docId's raw HTML is left out when rendered.
+func (c *siteConfig) stripsHTML(docId string) bool {
+	policy := c.rawHTML(docId)
+	return policy == rawHTMLStrip || policy == rawHTMLBlock
+}
+
+// isRawHTML reports whether node is raw HTML other than a comment.
+func isRawHTML(node ast.Node) bool {
+	var literal []byte
+	switch n := node.(type) {
+	case *ast.HTMLBlock:
+		literal = n.Literal
+	case *ast.HTMLSpan:
+		literal = n.Literal
+	default:
+		return false
+	}
+	return !bytes.HasPrefix(bytes.TrimSpace(literal), []byte("<!--"))
+}
+
+// stripRawHTML removes the raw HTML from doc.
+func stripRawHTML(doc ast.Node) {
+	var nodes []ast.Node
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if entering && isRawHTML(node) {
+			nodes = append(nodes, node)
+		}
+		return ast.GoToNext
+	})
+	// ast.RemoveFromTree can't remove leaves.
+	for _, n := range nodes {
+		parent := n.GetParent()
+		var kept []ast.Node
+		for _, c := range parent.GetChildren() {
+			if c != n {
+				kept = append(kept, c)
+			}
+		}
+		parent.SetChildren(kept)
+	}
+}
+
+// rawHTMLProblems describes the raw HTML in a page's markdown, naming the
+// first few elements.
+func rawHTMLProblems(docId string, body []byte) []string {
+	if !isPage(docId) {
+		return nil
+	}
+	_, md := splitFrontMatter(docId, body)
+	doc := markdown.Parse(md, parser.NewWithExtensions(mdExtensions))
+
+	var tags []string
+	count := 0
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering || !isRawHTML(node) {
+			return ast.GoToNext
+		}
+		tag := strings.TrimSpace(string(node.AsLeaf().Literal))
+		if strings.HasPrefix(tag, "</") {
+			return ast.GoToNext
+		}
+		count++
+		if i := strings.IndexAny(tag, " \t\n>"); i > 0 && len(tags) < 3 {
+			tags = append(tags, tag[:i]+">")
+		}
+		return ast.GoToNext
+	})
+	if count == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("raw HTML isn't allowed under this prefix (%d found: %s)", count, strings.Join(tags, ", "))}
+}
+
+// checkRawHTML applies the rawHTML policy of docId's prefix to a write.
+func checkRawHTML(c *siteConfig, docId string, body []byte, res *writeResult) {
+	switch c.rawHTML(docId) {
+	case rawHTMLStrip:
+		for _, p := range rawHTMLProblems(docId, body) {
+			res.Warnings = append(res.Warnings, p+"; it will be left out when rendered")
+		}
+	case rawHTMLBlock:
+		res.Problems = append(res.Problems, rawHTMLProblems(docId, body)...)
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/cachestore"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+var (
+	// cacheURL is the shared read-through cache in front of the store,
+	// like memcached://docs.abc123.cfg.usw2.cache.amazonaws.com:11211 for
+	// an ElastiCache for Memcached cluster. There is no shared cache when
+	// it is unset.
+	cacheURL = os.Getenv("CACHE_URL")
+
+	// cacheTimeout bounds each cache call, after which the store is used.
+	cacheTimeout = envDuration("CACHE_TIMEOUT", 100*time.Millisecond)
+
+	// cacheLatestTTL is how long the cache remembers a doc's latest
+	// revision, and so how stale a doc written by another client, like
+	// docctl, can be.
+	cacheLatestTTL = envDuration("CACHE_LATEST_TTL", 5*time.Second)
+)
+
+// withReadCache puts the cache at cacheURL in front of ds. Only memcached
+// is supported; DAX would need its own DynamoDB client inside the store.
+func withReadCache(ds docstore.DocStore) docstore.DocStore {
+	if cacheURL == "" {
+		return ds
+	}
+
+	u, err := url.Parse(cacheURL)
+	if err != nil || u.Scheme != "memcached" || u.Host == "" {
+		log.Printf("ignoring CACHE_URL %q: want memcached://host:port", cacheURL)
+		return ds
+	}
+
+	// Stages can share a cluster, as they don't share tables.
+	prefix := os.Getenv("DOCS_TABLE") + ":"
+	return cachestore.New(ds, cachestore.NewMemcached(u.Host, prefix, cacheTimeout),
+		cachestore.WithLatestTTL(cacheLatestTTL),
+		cachestore.WithErrorHandler(func(err error) {
+			log.Printf("cache error: %v", err)
+			metrics.Incr("CacheError", nil)
+		}),
+	)
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// readerPrefix serves docs in reader mode, and readerTmplDocName is the
+	// template they are rendered with if there is one.
+	readerPrefix      = "/reader/"
+	readerTmplDocName = "reader-template.html"
+)
+
+// readerTemplate renders reader mode pages when the site has no reader
+// template: the doc alone, in large type, with no navigation or scripts.
+var readerTemplate = template.Must(template.New("docPage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta name="robots" content="noindex">
+<style>
+body { margin: 0 auto; max-width: 40em; padding: 1.5em; font: 1.375rem/1.6 Georgia, serif; color: #222; background: #fdfdfb; }
+img, video { max-width: 100%; height: auto; }
+pre { overflow-x: auto; font-size: 0.8em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.25em 0.5em; }
+footer { margin-top: 3em; font-size: 0.75em; color: #666; }
+@media (prefers-color-scheme: dark) { body { color: #ddd; background: #111; } a { color: #8ab4f8; } }
+</style>
+</head>
+<body>
+<main>
+{{.DocBody}}
+</main>
+<footer>Version {{.Version}}, updated {{.Timestamp}}</footer>
+</body>
+</html>
+`))
+
+// serveReader serves /reader/{docId}: the latest revision of a page with
+// only its content, for reading apps, kiosks and iframes. Sites can style
+// it with a reader template, and the site config's prefixes can set
+// framing headers for /reader/ apart from the rest of the site.
+func serveReader(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := strings.TrimPrefix(request.Path, readerPrefix)
+	if docId == "" || strings.Contains(docId, "/") || !isPage(docId) {
+		return Response{}, docerr.E("reader "+request.Path, docerr.ErrNotFound, nil)
+	}
+
+	ctx = withAudiences(ctx, requestAudiences(getConfig(ctx), request))
+	ctx, private, err := checkAccess(ctx, request, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	rev, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	meta, fm, personalized := pageMeta(ctx, docId, rev, nil)
+	meta.Robots = "noindex"
+
+	resp, err := renderPage(ctx, readerTmplDocName, meta)
+	if missingTemplate(err) {
+		var b bytes.Buffer
+		err = readerTemplate.Execute(&b, meta)
+		if err != nil {
+			return Response{}, docerr.E("execute reader template", docerr.ErrTemplate, err)
+		}
+		resp = Response{StatusCode: 200, Body: b.String(), Headers: map[string]string{"Content-Type": "text/html"}}
+	}
+	if err != nil {
+		return resp, err
+	}
+
+	headers := fm.headers(docId)
+	for k, v := range validators(rev.meta.Id, rev.meta.Timestamp, resp.Body) {
+		headers[k] = v
+	}
+	// The doc's own page is the one to index.
+	headers["X-Robots-Tag"] = "noindex"
+	if _, ok := headers["Cache-Control"]; personalized && !ok {
+		headers["Cache-Control"] = "private"
+	}
+	if private {
+		for k, v := range privateHeaders {
+			headers[k] = v
+		}
+	}
+	return withHeaders(resp, headers), nil
+}
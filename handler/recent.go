@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// recentCookie lists the docs a reader viewed last, most recent first,
+	// as comma separated, escaped docIds. recentScript keeps it, so pages
+	// stay cacheable.
+	recentCookie = "recent"
+
+	maxRecentlyViewed = 8
+)
+
+// recentScript records the page in the reader's recentCookie, keeping the
+// last maxRecentlyViewed, so templates can include it with {{recentScript}}
+// on the pages worth remembering.
+const recentScript = `<script>
+(function () {
+  var docId = location.pathname.split("/").pop().replace(/,/g, "%2C");
+  if (!docId) {
+    return;
+  }
+  var m = document.cookie.match(/(?:^|; )` + recentCookie + `=([^;]*)/);
+  var ids = (m ? m[1].split(",") : []).filter(function (id) { return id && id !== docId; });
+  ids.unshift(docId);
+  document.cookie = "` + recentCookie + `=" + ids.slice(0, 8).join(",") +
+    "; path=/; max-age=2592000; samesite=lax";
+})();
+</script>
+`
+
+// recentlyViewed is the docIds a reader viewed last, most recent first.
+type recentlyViewed []string
+
+// key identifies the list in coalescing keys, since home pages rendered
+// for different readers differ. Those pages aren't cached; see serveDoc.
+func (r recentlyViewed) key() string {
+	return strings.Join(r, ",")
+}
+
+type recentKey struct{}
+
+// withRecentlyViewed returns ctx carrying the docs the reader viewed last.
+func withRecentlyViewed(ctx context.Context, r recentlyViewed) context.Context {
+	return context.WithValue(ctx, recentKey{}, r)
+}
+
+func recentlyViewedFrom(ctx context.Context) recentlyViewed {
+	r, _ := ctx.Value(recentKey{}).(recentlyViewed)
+	return r
+}
+
+// requestRecentlyViewed reads the docs the reader of request viewed last
+// from their recentCookie.
+func requestRecentlyViewed(request events.APIGatewayProxyRequest) recentlyViewed {
+	var r recentlyViewed
+	seen := map[string]bool{}
+	for _, v := range strings.Split(cookie(request, recentCookie), ",") {
+		docId, err := url.PathUnescape(v)
+		if err != nil || docId == "" || seen[docId] {
+			continue
+		}
+		seen[docId] = true
+		r = append(r, docId)
+		if len(r) == maxRecentlyViewed {
+			break
+		}
+	}
+	return r
+}
+
+// viewedDocs summarises the listed docs the reader viewed last, skipping
+// any since unlisted, made private or removed.
+func viewedDocs(ctx context.Context) []docSummary {
+	r := recentlyViewedFrom(ctx)
+	if len(r) == 0 {
+		return nil
+	}
+	c, err := getCatalog(ctx)
+	if err != nil {
+		return nil
+	}
+	byId := map[string]docSummary{}
+	for _, d := range c {
+		byId[d.DocId] = d
+	}
+	var docs []docSummary
+	for _, docId := range r {
+		if d, ok := byId[docId]; ok {
+			docs = append(docs, d)
+		}
+	}
+	return docs
+}
+
+// recentlyUpdated returns the n listed docs updated last, pinned or not.
+func (c catalog) recentlyUpdated(n int) []docSummary {
+	docs := append(catalog{}, c...)
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Timestamp.After(docs[j].Timestamp)
+	})
+	if n >= 0 && len(docs) > n {
+		docs = docs[:n]
+	}
+	return docs
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// redirectsDocName maps paths that no longer have a doc to where readers
+// should go instead, as YAML:
+//
+//	/old-guide: /guide
+//	/setup: https://setup.example.com/
+//
+// A redirect map for a prefix of the site config, like "_redirects.eng-"
+// for "/eng-", may only redirect paths under the prefix to paths on the
+// site, so that its admins can keep it up. See delegation.go.
+//
+// Docs can also list their old names in their front matter's aliases.
+// Either way a request for a path with no doc is redirected permanently,
+// with the site's map taking precedence over those of prefixes, and
+// those over aliases.
+const redirectsDocName = "_redirects"
+
+var (
+	// redirectHitsTable is the DynamoDB table counting how often each
+	// redirect is followed, keyed by Path, so obsolete ones can be
+	// retired. Counting is off when it is unset.
+	redirectHitsTable = os.Getenv("REDIRECT_HITS_TABLE")
+
+	// redirectTimeout bounds how long counting a redirect can delay it.
+	redirectTimeout = envDuration("REDIRECT_TIMEOUT", time.Second)
+)
+
+// redirect sends requests for Path to Target. Source is where it is
+// set: a redirect map, or the doc whose aliases list it.
+type redirect struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+	Source string `json:"source"`
+}
+
+// parseRedirects parses the redirect map.
+func parseRedirects(body []byte) (map[string]string, error) {
+	m := map[string]string{}
+	err := yaml.UnmarshalStrict(body, &m)
+	return m, err
+}
+
+// isRedirectsDoc reports whether docId holds a redirect map.
+func isRedirectsDoc(docId string) bool {
+	return docId == redirectsDocName || strings.HasPrefix(docId, redirectsDocName+".")
+}
+
+// redirectProblem returns what is wrong with redirecting path to target
+// in the redirect map docId, or "".
+func redirectProblem(docId, path, target string) string {
+	switch {
+	case !strings.HasPrefix(path, "/"):
+		return "doesn't start with /"
+	case target == "":
+		return "no target"
+	case docId == redirectsDocName:
+		return ""
+	case !strings.HasPrefix(path, "/"+strings.TrimPrefix(docId, redirectsDocName+".")):
+		return "isn't under the prefix " + strings.TrimPrefix(docId, redirectsDocName+".")
+	case !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//"):
+		return "a prefix may only redirect to paths on the site"
+	}
+	return ""
+}
+
+// lintRedirects checks the redirect map docId parses and that its
+// redirects are ones it may make.
+func lintRedirects(docId string, body []byte) []string {
+	m, err := parseRedirects(body)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	for path, target := range m {
+		if p := redirectProblem(docId, path, target); p != "" {
+			problems = append(problems, path+": "+p)
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// getRedirects returns every redirect by path: those of the redirect
+// maps and the aliases of the listed docs.
+func getRedirects(ctx context.Context) map[string]redirect {
+	redirects := map[string]redirect{}
+	if c, err := getCatalog(ctx); err == nil {
+		for _, d := range c {
+			for _, alias := range d.fm.Aliases {
+				path := "/" + strings.TrimPrefix(alias, "/")
+				redirects[path] = redirect{path, "/" + d.DocId, d.DocId}
+			}
+		}
+	} else {
+		log.Printf("aliases: %v", err)
+	}
+
+	var names []string
+	for prefix := range getConfig(ctx).Prefixes {
+		names = append(names, redirectsDocName+"."+strings.TrimPrefix(prefix, "/"))
+	}
+	// Longer prefixes are more specific, and the site's map is last so it
+	// takes precedence.
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) < len(names[j]) })
+	names = append(names, redirectsDocName)
+
+	for _, name := range names {
+		body, ok := getSystemDoc(ctx, name)
+		if !ok {
+			continue
+		}
+		m, err := parseRedirects(body)
+		if err != nil {
+			log.Printf("%s error: %v", name, err)
+		}
+		for path, target := range m {
+			if p := redirectProblem(name, path, target); p != "" {
+				log.Printf("%s: ignoring %s: %s", name, path, p)
+				continue
+			}
+			redirects[path] = redirect{path, target, name}
+		}
+	}
+	return redirects
+}
+
+// serveRedirect redirects request to where its path has moved, counting
+// the hit, or returns false if it hasn't.
+func serveRedirect(ctx context.Context, request events.APIGatewayProxyRequest) (Response, bool) {
+	r, ok := getRedirects(ctx)[request.Path]
+	if !ok || r.Target == request.Path {
+		return Response{}, false
+	}
+
+	metrics.Incr("RedirectHits", nil)
+	recordRedirectHit(ctx, r)
+	return Response{
+		StatusCode: 301,
+		Headers: map[string]string{
+			"Location":      r.Target,
+			"Cache-Control": "public, max-age=3600",
+		},
+	}, true
+}
+
+// recordRedirectHit counts a request redirected by r.
+func recordRedirectHit(ctx context.Context, r redirect) {
+	if redirectHitsTable == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redirectTimeout)
+	defer cancel()
+
+	key, err := dynamodbattribute.MarshalMap(struct{ Path string }{r.Path})
+	if err != nil {
+		log.Printf("error recording redirect %s: %v", r.Path, err)
+		return
+	}
+
+	_, err = dynamo().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(redirectHitsTable),
+		Key:              key,
+		UpdateExpression: aws.String("ADD Hits :one SET LastHit = :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":now": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		log.Printf("error recording redirect %s: %v", r.Path, err)
+	}
+}
+
+type redirectStat struct {
+	redirect
+	Hits    int    `json:"hits"`
+	LastHit string `json:"lastHit,omitempty"`
+}
+
+// redirectStats reports every redirect with how often it has been
+// followed, least followed first, so those nobody uses any more can be
+// retired with confidence.
+func redirectStats(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if redirectHitsTable == "" {
+		return Response{}, docerr.E("redirect stats", docerr.ErrNotFound, nil)
+	}
+
+	hits := map[string]redirectStat{}
+	var scanErr error
+	err := dynamo().ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(redirectHitsTable),
+	}, func(out *dynamodb.ScanOutput, last bool) bool {
+		var items []struct {
+			Path, LastHit string
+			Hits          int
+		}
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &items)
+		for _, item := range items {
+			hits[item.Path] = redirectStat{Hits: item.Hits, LastHit: item.LastHit}
+		}
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return Response{}, docerr.E("redirect stats", docerr.ErrBackend, err)
+	}
+
+	var report []redirectStat
+	for path, r := range getRedirects(ctx) {
+		s := hits[path]
+		s.redirect = r
+		report = append(report, s)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Hits != report[j].Hits {
+			return report[i].Hits < report[j].Hits
+		}
+		return report[i].Path < report[j].Path
+	})
+
+	return jsonResponse(200, struct {
+		Redirects []redirectStat `json:"redirects"`
+	}{report}), nil
+}
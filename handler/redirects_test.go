@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+func TestPrefixRedirects(t *testing.T) {
+	store := newMemStore()
+	store.put(configDocName, "prefixes:\n  /eng-:\n    admins: [eng-leads]\n")
+	store.put(redirectsDocName, "/eng-shared: /site-wins\n")
+	store.put(redirectsDocName+".eng-", "/eng-old: /eng-new\n/eng-shared: /eng-loses\n/ops-runbook: /eng-runbook\n/eng-away: https://elsewhere.example/\n")
+	useStore(store)
+
+	tests := []struct {
+		path, location string
+	}{
+		{"/eng-old", "/eng-new"},
+		{"/eng-shared", "/site-wins"},
+		{"/ops-runbook", ""},
+		{"/eng-away", ""},
+	}
+	for _, tt := range tests {
+		resp, ok := serveRedirect(context.Background(), events.APIGatewayProxyRequest{Path: tt.path})
+		if got := resp.Headers["Location"]; ok != (tt.location != "") || got != tt.location {
+			t.Errorf("%s: redirected %v to %q, want %q", tt.path, ok, got, tt.location)
+		}
+	}
+
+	if problems := lintRedirects(redirectsDocName+".eng-", []byte("/eng-old: /eng-new\n/ops-runbook: /x\n/eng-away: //elsewhere.example/\n")); len(problems) != 2 {
+		t.Errorf("lintRedirects = %q, want the path outside the prefix and the target off the site", problems)
+	}
+	if problems := lintRedirects(redirectsDocName, []byte("/ops-runbook: https://elsewhere.example/\n")); len(problems) != 0 {
+		t.Errorf("lintRedirects of the site's map = %q, want none", problems)
+	}
+
+	ctx := withRole(context.WithValue(context.Background(), delegationKey{}, []string{"eng-"}), rolePrefixAdmin)
+	for docId, want := range map[string]bool{
+		redirectsDocName + ".eng-": true,
+		redirectsDocName + ".ops-": false,
+		redirectsDocName:           false,
+	} {
+		if got := administers(ctx, docId); got != want {
+			t.Errorf("prefix admin administers %s = %v, want %v", docId, got, want)
+		}
+	}
+
+	// Comparing with a URL fetches it, which prefix admins may not do.
+	request := events.APIGatewayProxyRequest{
+		PathParameters:        map[string]string{"docId": "eng-new"},
+		QueryStringParameters: map[string]string{"url": "http://169.254.169.254/"},
+	}
+	if _, err := compareDoc(ctx, request); !errors.Is(err, docerr.ErrForbidden) {
+		t.Errorf("compareDoc with a url as a prefix admin: got %v, want forbidden", err)
+	}
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// renderContext is what rendering a page for a request depends on
+// besides the doc: the request, how its reader reads times, the variant
+// serving it and the feature flags in force, along with when rendering
+// started and how long each stage took. withRenderContext puts one in
+// every request's ctx, and the pipeline's stages, from routing a page to
+// executing its template, read it from there rather than being handed
+// parts of it as parameters. Templates get it as .Render.
+//
+// Who the reader is isn't given to templates: rendered pages are shared
+// between readers by the render cache. Stages that need to know read it
+// from the request.
+type renderContext struct {
+	request events.APIGatewayProxyRequest
+	tf      timeFormat
+
+	// Path is the path requested, and Variant the canary variant serving
+	// it, "stable" when no canary is running.
+	Path    string
+	Variant string
+
+	// Started is when the request started.
+	Started time.Time
+
+	features map[string]bool
+
+	mu     sync.Mutex
+	stages []renderStage
+}
+
+// renderStage is how long a stage of rendering, like "markdown", took.
+type renderStage struct {
+	Name     string
+	Duration time.Duration
+}
+
+// newRenderContext returns the render context of request, served with
+// ctx.
+func newRenderContext(ctx context.Context, request events.APIGatewayProxyRequest) *renderContext {
+	cfg := getConfig(ctx)
+	variant := variantFrom(ctx)
+	if variant == "" {
+		variant = variantStable
+	}
+	return &renderContext{
+		request:  request,
+		tf:       requestTimeFormat(cfg, request),
+		Path:     request.Path,
+		Variant:  variant,
+		Started:  time.Now(),
+		features: cfg.Features,
+	}
+}
+
+type renderCtxKey struct{}
+
+// withRender returns ctx rendering with rc.
+func withRender(ctx context.Context, rc *renderContext) context.Context {
+	return context.WithValue(ctx, renderCtxKey{}, rc)
+}
+
+// renderFrom returns the render context of ctx. Work that doesn't serve a
+// request, like smoke tests and profiling, renders as for a request
+// without cookies or headers.
+func renderFrom(ctx context.Context) *renderContext {
+	if rc, ok := ctx.Value(renderCtxKey{}).(*renderContext); ok {
+		return rc
+	}
+	return newRenderContext(ctx, events.APIGatewayProxyRequest{})
+}
+
+// withRenderContext gives each request its render context.
+func withRenderContext(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		return next(withRender(ctx, newRenderContext(ctx, request)), request)
+	}
+}
+
+// Feature reports whether the site config's feature flag name is on, for
+// templates trying out changes: {{if .Render.Feature "newNav"}}.
+func (rc *renderContext) Feature(name string) bool {
+	return rc != nil && rc.features[name]
+}
+
+// Stages lists the stages of rendering the page and how long each took,
+// in the order they first ran.
+func (rc *renderContext) Stages() []renderStage {
+	if rc == nil {
+		return nil
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]renderStage(nil), rc.stages...)
+}
+
+// noteStage adds d to the time stage name took.
+func (rc *renderContext) noteStage(name string, d time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for i := range rc.stages {
+		if rc.stages[i].Name == name {
+			rc.stages[i].Duration += d
+			return
+		}
+	}
+	rc.stages = append(rc.stages, renderStage{name, d})
+}
+
+// varies identifies what a page rendered with ctx varies by besides the
+// doc, in cache and coalescing keys.
+func varies(ctx context.Context) string {
+	return variantSuffix(ctx) + "|" + renderFrom(ctx).tf.key() + "|" + audiencesFrom(ctx).key() + "|" + recentlyViewedFrom(ctx).key()
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// revisionMetaTable is the DynamoDB table holding the metadata of each
+	// revision written, keyed by DocId and Revision. Who wrote revisions
+	// and why isn't kept when it is unset.
+	revisionMetaTable = os.Getenv("REVISION_META_TABLE")
+
+	// revisionMetaTimeout bounds how long reading a revision's author and
+	// message can delay rendering it.
+	revisionMetaTimeout = envDuration("REVISION_META_TIMEOUT", 500*time.Millisecond)
+
+	revisionMetas = &revisionMetaCache{entries: map[string]revisionMeta{}}
+)
+
+const (
+	// revisionMessageHeader carries a commit style message saying why a
+	// write was made, for the history of the docs it writes.
+	revisionMessageHeader = "X-Revision-Message"
+
+	// maxRevisionMessage is how many bytes of a message are kept.
+	maxRevisionMessage = 1000
+
+	// maxRevisionMetas bounds revisionMetas; it is emptied when full.
+	maxRevisionMetas = 10000
+)
+
+// revisionMeta is what is known about a revision beyond its docId,
+// version and timestamp: its content type, its size in bytes and SHA-256
+// hash, and who wrote it with what message. Revisions written before
+// REVISION_META_TABLE was set have no author or message.
+type revisionMeta struct {
+	DocId       string    `dynamodbav:"DocId" json:"docId"`
+	Version     int       `dynamodbav:"Revision" json:"version"`
+	Timestamp   time.Time `dynamodbav:"Timestamp" json:"timestamp"`
+	ContentType string    `dynamodbav:"ContentType" json:"contentType,omitempty"`
+	Size        int       `dynamodbav:"Size" json:"size,omitempty"`
+	SHA256      string    `dynamodbav:"SHA256" json:"sha256,omitempty"`
+	Author      string    `dynamodbav:"Author,omitempty" json:"author,omitempty"`
+	Message     string    `dynamodbav:"Message,omitempty" json:"message,omitempty"`
+}
+
+// revisionMetaCache holds revisions' metadata, which never changes once
+// written, by docId and version.
+type revisionMetaCache struct {
+	sync.Mutex
+	entries map[string]revisionMeta
+}
+
+func revisionMetaKey(docId string, version int) string {
+	return docId + "@" + strconv.Itoa(version)
+}
+
+func (c *revisionMetaCache) get(docId string, version int) (revisionMeta, bool) {
+	c.Lock()
+	defer c.Unlock()
+	m, ok := c.entries[revisionMetaKey(docId, version)]
+	return m, ok
+}
+
+func (c *revisionMetaCache) put(m revisionMeta) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.entries) >= maxRevisionMetas {
+		c.entries = map[string]revisionMeta{}
+	}
+	c.entries[revisionMetaKey(m.DocId, m.Version)] = m
+}
+
+// contentMeta returns the metadata of a revision that its content gives.
+func contentMeta(meta docstore.RevisionMetadata, body []byte) revisionMeta {
+	sum := sha256.Sum256(body)
+	ct := "text/markdown; charset=utf-8"
+	if !isPage(meta.DocId) {
+		ct = rawContentType(meta.DocId, body)
+	}
+	return revisionMeta{
+		DocId:       meta.DocId,
+		Version:     meta.Id,
+		Timestamp:   meta.Timestamp,
+		ContentType: ct,
+		Size:        len(body),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+}
+
+// writeAuthor names who is writing with ctx's request: the IAM principal
+// or signed in reader, or else the role of the API key used.
+func writeAuthor(ctx context.Context) string {
+	if arn := iamPrincipal(ctx); arn != "" {
+		return arn
+	}
+	request := auditRequestFrom(ctx)
+	if request.RequestContext.Authorizer != nil {
+		if name := principalName(request); name != reviewersGroup {
+			return name
+		}
+	}
+	return roleFrom(ctx)
+}
+
+// revisionMessagesConfig asks writers for a message saying why they made
+// each change, for example:
+//
+//	revisionMessages:
+//	  policy: warn
+//
+// With policy "warn" a write without a message is made with a warning,
+// and with "block" it is refused. Log entries and accepted suggestions
+// bring their own messages.
+type revisionMessagesConfig struct {
+	Policy string `yaml:"policy"`
+}
+
+type revisionMessageKey struct{}
+
+// withRevisionMessage returns ctx carrying msg as the message of its
+// writes when the request doesn't give one.
+func withRevisionMessage(ctx context.Context, msg string) context.Context {
+	return context.WithValue(ctx, revisionMessageKey{}, msg)
+}
+
+// writeMessage returns the message of ctx's writes: the one its request
+// gives in revisionMessageHeader, or else the one ctx carries, cut to
+// maxRevisionMessage bytes.
+func writeMessage(ctx context.Context) string {
+	msg := strings.TrimSpace(header(auditRequestFrom(ctx), revisionMessageHeader))
+	if msg == "" {
+		msg, _ = ctx.Value(revisionMessageKey{}).(string)
+		msg = strings.TrimSpace(msg)
+	}
+	if len(msg) <= maxRevisionMessage {
+		return msg
+	}
+	msg = msg[:maxRevisionMessage]
+	for !utf8.ValidString(msg) {
+		msg = msg[:len(msg)-1]
+	}
+	return msg
+}
+
+// checkRevisionMessage applies the revisionMessages policy to a write
+// without a message.
+func checkRevisionMessage(ctx context.Context, res *writeResult) {
+	if writeMessage(ctx) != "" {
+		return
+	}
+	const p = "no message says why the change was made; send one in " + revisionMessageHeader
+	switch getConfig(ctx).RevisionMessages.Policy {
+	case "warn":
+		res.Warnings = append(res.Warnings, p)
+	case "block":
+		res.Problems = append(res.Problems, p)
+	}
+}
+
+// recordRevisionMeta keeps the metadata of a revision just written, with
+// the author and message of ctx's request.
+func recordRevisionMeta(ctx context.Context, meta docstore.RevisionMetadata, body []byte) {
+	m := contentMeta(meta, body)
+	m.Author = writeAuthor(ctx)
+	m.Message = writeMessage(ctx)
+	revisionMetas.put(m)
+	if revisionMetaTable == "" {
+		return
+	}
+
+	item, err := dynamodbattribute.MarshalMap(m)
+	if err == nil {
+		_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(revisionMetaTable),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		log.Printf("recording metadata of %s@%d: %v", m.DocId, m.Version, err)
+	}
+}
+
+// docRevisionMeta returns the metadata of a fetched revision, with its
+// author and message if they were recorded.
+func docRevisionMeta(ctx context.Context, doc fetchedDoc) revisionMeta {
+	if m, ok := revisionMetas.get(doc.meta.DocId, doc.meta.Id); ok {
+		return m
+	}
+	m := contentMeta(doc.meta, doc.body)
+	if revisionMetaTable == "" {
+		return m
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, revisionMetaTimeout)
+	defer cancel()
+	key, err := dynamodbattribute.MarshalMap(struct {
+		DocId    string
+		Revision int
+	}{doc.meta.DocId, doc.meta.Id})
+	if err != nil {
+		return m
+	}
+	out, err := dynamo().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(revisionMetaTable),
+		Key:       key,
+	})
+	if err != nil {
+		log.Printf("reading metadata of %s@%d: %v", doc.meta.DocId, doc.meta.Id, err)
+		return m
+	}
+	var stored revisionMeta
+	if out.Item != nil && dynamodbattribute.UnmarshalMap(out.Item, &stored) == nil {
+		m.Author, m.Message = stored.Author, stored.Message
+	}
+	revisionMetas.put(m)
+	return m
+}
+
+// queryRevisionMeta returns the recorded metadata of the revisions of
+// docId by version.
+func queryRevisionMeta(ctx context.Context, docId string) (map[int]revisionMeta, error) {
+	metas := map[int]revisionMeta{}
+	if revisionMetaTable == "" {
+		return metas, nil
+	}
+
+	var scanErr error
+	err := dynamo().QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(revisionMetaTable),
+		KeyConditionExpression:    aws.String("DocId = :d"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":d": {S: aws.String(docId)}},
+	}, func(out *dynamodb.QueryOutput, last bool) bool {
+		var page []revisionMeta
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &page)
+		for _, m := range page {
+			metas[m.Version] = m
+		}
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return nil, docerr.E("revision metadata "+docId, docerr.ErrBackend, err)
+	}
+	return metas, nil
+}
+
+// revisionHistory lists the revisions of docId newest first, with what is
+// recorded about each.
+func revisionHistory(ctx context.Context, docId string) ([]revisionMeta, error) {
+	revs, err := listRevisions(ctx, docId)
+	if err != nil {
+		return nil, err
+	}
+	metas, err := queryRevisionMeta(ctx, docId)
+	if err != nil {
+		log.Printf("history of %s: %v", docId, err)
+	}
+
+	history := make([]revisionMeta, 0, len(revs))
+	for _, r := range revs {
+		m, ok := metas[r.Id]
+		if !ok {
+			m, _ = revisionMetas.get(docId, r.Id)
+		}
+		m.DocId, m.Version, m.Timestamp = docId, r.Id, r.Timestamp
+		history = append(history, m)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Version > history[j].Version })
+	return history, nil
+}
+
+// listDocRevisions answers with the revisions of the docId path parameter,
+// newest first, with their metadata, to readers who may read the doc.
+func listDocRevisions(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	ctx, private, err := checkAccess(ctx, request, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	if _, err := fetchDoc(fetchCtx, docId); err != nil {
+		return Response{}, err
+	}
+	history, err := revisionHistory(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	resp := jsonResponse(200, struct {
+		Revisions []revisionMeta `json:"revisions"`
+	}{history})
+	if private {
+		resp = withHeaders(resp, privateHeaders)
+	}
+	return resp, nil
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// robotsConfig adds site wide settings to the generated robots.txt.
+// Prefixes are disallowed in the prefixes section of the config:
+//
+//	robots:
+//	  sitemap: https://docs.example.com/sitemap.xml
+//	prefixes:
+//	  /internal-:
+//	    robots: disallow
+type robotsConfig struct {
+	Sitemap string `yaml:"sitemap"`
+}
+
+// robotsTxt generates robots.txt from the site config.
+func robotsTxt(cfg *siteConfig) Response {
+	var disallow []string
+	for prefix, p := range cfg.Prefixes {
+		if strings.EqualFold(p.Robots, "disallow") {
+			disallow = append(disallow, prefix)
+		}
+	}
+	sort.Strings(disallow)
+
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if len(disallow) == 0 {
+		b.WriteString("Disallow:\n")
+	}
+	for _, prefix := range disallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", prefix)
+	}
+	if cfg.Robots.Sitemap != "" {
+		fmt.Fprintf(&b, "\nSitemap: %s\n", cfg.Robots.Sitemap)
+	}
+
+	return Response{
+		StatusCode: 200,
+		Body:       b.String(),
+		Headers: map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		},
+	}
+}
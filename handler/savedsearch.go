@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// savedSearchesTable is the DynamoDB table holding signed in readers'
+	// saved searches, keyed by User and Name. Saved searches are off when
+	// it is unset.
+	savedSearchesTable = os.Getenv("SAVED_SEARCHES_TABLE")
+)
+
+// savedSearch is a search a reader named to run again. Query holds its
+// parameters as /search takes them, like "q=lambda&tag=ops". Readers who
+// Subscribe are notified as new and updated docs match it.
+type savedSearch struct {
+	User      string    `dynamodbav:"User" json:"-"`
+	Name      string    `dynamodbav:"Name" json:"name"`
+	Query     string    `dynamodbav:"Query" json:"query"`
+	Subscribe bool      `dynamodbav:"Subscribe" json:"subscribe"`
+	Created   time.Time `dynamodbav:"Created" json:"created"`
+}
+
+// params decodes s's query parameters.
+func (s savedSearch) params() (map[string]string, error) {
+	v, err := url.ParseQuery(s.Query)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{}
+	for k := range v {
+		params[k] = v.Get(k)
+	}
+	return params, nil
+}
+
+// parse reads the search s saves.
+func (s savedSearch) parse() (searchQuery, error) {
+	params, err := s.params()
+	if err != nil {
+		return searchQuery{}, err
+	}
+	return parseSearchQuery(params)
+}
+
+// requireSignedIn returns the reader request comes from, who must be
+// signed in to keep saved searches.
+func requireSignedIn(ctx context.Context, request events.APIGatewayProxyRequest) (*reader, error) {
+	if savedSearchesTable == "" {
+		return nil, docerr.E("saved searches", docerr.ErrNotFound, nil)
+	}
+	r, err := requestReader(ctx, request)
+	if err != nil {
+		return nil, docerr.E("saved searches", docerr.ErrUnauthorized, err)
+	}
+	if r == nil || r.Name == "" {
+		return nil, docerr.E("saved searches", docerr.ErrUnauthorized, nil)
+	}
+	return r, nil
+}
+
+// getSavedSearches returns user's saved searches by name.
+func getSavedSearches(ctx context.Context, user string) ([]savedSearch, error) {
+	var all []savedSearch
+	var scanErr error
+	err := dynamo().QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(savedSearchesTable),
+		KeyConditionExpression:    aws.String("#u = :u"),
+		ExpressionAttributeNames:  map[string]*string{"#u": aws.String("User")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":u": {S: aws.String(user)}},
+	}, func(out *dynamodb.QueryOutput, last bool) bool {
+		var page []savedSearch
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &page)
+		all = append(all, page...)
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return nil, docerr.E("saved searches", docerr.ErrBackend, err)
+	}
+	return all, nil
+}
+
+// subscribedSearches returns every saved search someone subscribed to.
+func subscribedSearches(ctx context.Context) ([]savedSearch, error) {
+	var all []savedSearch
+	var scanErr error
+	err := dynamo().ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(savedSearchesTable),
+		FilterExpression:          aws.String("Subscribe = :t"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":t": {BOOL: aws.Bool(true)}},
+	}, func(out *dynamodb.ScanOutput, last bool) bool {
+		var page []savedSearch
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &page)
+		all = append(all, page...)
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return nil, docerr.E("subscribed searches", docerr.ErrBackend, err)
+	}
+	return all, nil
+}
+
+// listSavedSearches reports the signed in reader's saved searches.
+func listSavedSearches(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	r, err := requireSignedIn(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	saved, err := getSavedSearches(ctx, r.Name)
+	if err != nil {
+		return Response{}, err
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+	if saved == nil {
+		saved = []savedSearch{}
+	}
+	return jsonResponse(200, struct {
+		Searches []savedSearch `json:"searches"`
+	}{saved}), nil
+}
+
+// putSavedSearch saves a search under the name path parameter for the
+// signed in reader, replacing any by that name:
+// {"query": "q=lambda&tag=ops", "subscribe": true}.
+func putSavedSearch(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	r, err := requireSignedIn(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	name := request.PathParameters["name"]
+	op := "save search " + name
+	if !validDraftName.MatchString(name) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"name": "lowercase letters, digits, '_' and '-'"})
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	var s savedSearch
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+	sq, err := s.parse()
+	if err != nil {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"query": "search parameters, like q=lambda&tag=ops"})
+	}
+	if len(searchTerms(sq.Q)) == 0 && !sq.filtered() {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"query": "a query with at least one word, or a filter"})
+	}
+
+	s.User, s.Name, s.Query = r.Name, name, sq.values().Encode()
+	s.Created = time.Now().UTC()
+	item, err := dynamodbattribute.MarshalMap(s)
+	if err != nil {
+		return Response{}, err
+	}
+	_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(savedSearchesTable),
+		Item:      item,
+	})
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+	return jsonResponse(200, s), nil
+}
+
+// deleteSavedSearch removes the signed in reader's search with the name
+// path parameter.
+func deleteSavedSearch(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	r, err := requireSignedIn(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	key, err := dynamodbattribute.MarshalMap(struct{ User, Name string }{r.Name, request.PathParameters["name"]})
+	if err != nil {
+		return Response{}, err
+	}
+	_, err = dynamo().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(savedSearchesTable),
+		Key:       key,
+	})
+	if err != nil {
+		return Response{}, docerr.E("delete saved search", docerr.ErrBackend, err)
+	}
+	return Response{StatusCode: 204}, nil
+}
+
+// savedSearchResults runs the signed in reader's search with the name
+// path parameter, as searchJSON would.
+func savedSearchResults(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	r, err := requireSignedIn(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	name := request.PathParameters["name"]
+	key, err := dynamodbattribute.MarshalMap(struct{ User, Name string }{r.Name, name})
+	if err != nil {
+		return Response{}, err
+	}
+	out, err := dynamo().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(savedSearchesTable),
+		Key:       key,
+	})
+	if err != nil {
+		return Response{}, docerr.E("saved search "+name, docerr.ErrBackend, err)
+	}
+	if out.Item == nil {
+		return Response{}, docerr.E("saved search "+name, docerr.ErrNotFound, nil)
+	}
+	var s savedSearch
+	err = dynamodbattribute.UnmarshalMap(out.Item, &s)
+	if err != nil {
+		return Response{}, err
+	}
+	params, err := s.params()
+	if err != nil {
+		return Response{}, docerr.E("saved search "+name, docerr.ErrBackend, err)
+	}
+
+	if limit, ok := request.QueryStringParameters["limit"]; ok {
+		params["limit"] = limit
+	}
+	request.QueryStringParameters = params
+	return searchJSON(ctx, request)
+}
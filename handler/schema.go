@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+const (
+	// schemaHeader is the version of the write payload schema a client
+	// writes with. Clients that don't send it are taken to write with
+	// schema 1, the one they were written for.
+	schemaHeader = "X-Docstore-Schema"
+
+	// schemaMinHeader and schemaMaxHeader tell clients the oldest and
+	// newest schemas the server accepts, on every API and write response,
+	// so they can tell their users to upgrade before a write is refused.
+	schemaMinHeader = "X-Docstore-Schema-Min"
+	schemaMaxHeader = "X-Docstore-Schema-Max"
+
+	// writeSchema is the newest write payload schema, raised whenever the
+	// bodies of write requests change in a way older servers would
+	// misread:
+	//
+	//	1: a doc's body, or {"docs": [{"docId", "body"}]} for bulk writes
+	writeSchema = 1
+)
+
+// minWriteSchema is the oldest schema writes are accepted with. Raising
+// it with MIN_WRITE_SCHEMA once the payload has changed turns clients
+// still writing the old one away with 426 Upgrade Required, rather than
+// letting their writes be misread.
+var minWriteSchema = int(envFloat("MIN_WRITE_SCHEMA", 1))
+
+// isWriteRequest reports whether request writes through the API or the
+// plain write endpoint.
+func isWriteRequest(request events.APIGatewayProxyRequest) bool {
+	switch request.HTTPMethod {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return strings.HasPrefix(request.Path, apiPrefix) || strings.HasPrefix(request.Path, docsPrefix)
+}
+
+// checkSchema refuses a write made with a payload schema the server
+// doesn't accept.
+func checkSchema(request events.APIGatewayProxyRequest) error {
+	op := request.HTTPMethod + " " + request.Path
+	n := 1
+	if v := header(request, schemaHeader); v != "" {
+		var err error
+		n, err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 1 {
+			return docerr.WithDetails(op, docerr.ErrBadRequest, err, map[string]interface{}{"header": schemaHeader})
+		}
+	}
+
+	details := map[string]interface{}{"schema": n, "min": minWriteSchema, "max": writeSchema}
+	switch {
+	case n < minWriteSchema:
+		details["upgrade"] = fmt.Sprintf("this client writes with schema %d, which the server no longer accepts; upgrade it to one writing schema %d to %d", n, minWriteSchema, writeSchema)
+		return docerr.WithDetails(op, docerr.ErrUpgradeRequired, nil, details)
+	case n > writeSchema:
+		details["upgrade"] = fmt.Sprintf("this client writes with schema %d, newer than the server accepts; deploy a newer server or use an older client", n)
+		return docerr.WithDetails(op, docerr.ErrBadRequest, nil, details)
+	}
+	return nil
+}
+
+// withSchema tells API and write clients the write schemas the server
+// accepts, and turns away writes made with others.
+func withSchema(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		write := isWriteRequest(request)
+		if !write && !strings.HasPrefix(request.Path, apiPrefix) {
+			return next(ctx, request)
+		}
+
+		var resp Response
+		var err error
+		if write {
+			err = checkSchema(request)
+		}
+		if err != nil {
+			resp = errorPage(ctx, request, err)
+		} else if resp, err = next(ctx, request); err != nil {
+			return resp, err
+		}
+		return withHeaders(resp, map[string]string{
+			schemaMinHeader: strconv.Itoa(minWriteSchema),
+			schemaMaxHeader: strconv.Itoa(writeSchema),
+		}), nil
+	}
+}
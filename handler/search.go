@@ -0,0 +1,617 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+const (
+	// searchPath is the search results page, and searchTmplDocName the
+	// template it is rendered with if there is one.
+	searchPath        = "/search"
+	searchTmplDocName = "search-template.html"
+
+	// snippetRadius is roughly how much text either side of a match a
+	// snippet shows, and maxSnippets how many snippets a result has.
+	snippetRadius = 80
+	maxSnippets   = 3
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchResult is a doc matching a search. Its snippets are HTML: the
+// doc's text, escaped, with the matched terms in <mark> elements.
+type searchResult struct {
+	DocId     string    `json:"docId"`
+	Title     string    `json:"title"`
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Tags      []string  `json:"tags,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Score     int       `json:"score"`
+	Snippets  []string  `json:"snippets"`
+	Summary   string    `json:"summary,omitempty"`
+
+	titleHTML string
+	text      string
+}
+
+// docText returns the text of a doc's markdown without markup, with
+// blocks on lines of their own, for searching.
+func docText(md []byte) string {
+	doc := markdown.Parse(md, parser.NewWithExtensions(mdExtensions))
+	var b strings.Builder
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Text:
+			b.Write(n.Literal)
+		case *ast.Code:
+			b.Write(n.Literal)
+		case *ast.CodeBlock:
+			b.Write(n.Literal)
+			b.WriteByte('\n')
+		case *ast.Softbreak, *ast.Hardbreak:
+			b.WriteByte(' ')
+		case *ast.Paragraph, *ast.Heading, *ast.TableCell:
+			if !entering {
+				b.WriteByte('\n')
+			}
+		}
+		return ast.GoToNext
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// searchTerms splits a query into distinct lowercase words.
+func searchTerms(q string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, t := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// termPattern matches any of terms at the start of a word, ignoring case,
+// and captures the term.
+func termPattern(terms []string) *regexp.Regexp {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^\pL\pN])(` + strings.Join(quoted, "|") + `)`)
+}
+
+// findTerms returns the spans of s that re's terms matched.
+func findTerms(re *regexp.Regexp, s string) [][2]int {
+	var spans [][2]int
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		spans = append(spans, [2]int{m[2], m[3]})
+	}
+	return spans
+}
+
+// searchQuery is a search: the words to look for, and filters on the
+// docs to look in.
+type searchQuery struct {
+	Q string
+
+	// Tags, Owner and Prefix limit results to docs with every one of the
+	// tags, with the owner, or with ids starting with the prefix. From and
+	// To limit them to docs last updated in that range.
+	Tags     []string
+	Owner    string
+	Prefix   string
+	From, To time.Time
+}
+
+// parseSearchQuery reads a search from the q, tag, owner, prefix, from
+// and to parameters in params. tag takes a comma separated list, and from
+// and to dates like 2020-09-01 or RFC 3339 times.
+func parseSearchQuery(params map[string]string) (searchQuery, error) {
+	sq := searchQuery{
+		Q:      strings.TrimSpace(params["q"]),
+		Owner:  strings.TrimSpace(params["owner"]),
+		Prefix: strings.TrimPrefix(strings.TrimSpace(params["prefix"]), "/"),
+	}
+	for _, t := range strings.Split(params["tag"], ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			sq.Tags = append(sq.Tags, t)
+		}
+	}
+
+	var err error
+	sq.From, err = parseSearchTime(params["from"], false)
+	if err == nil {
+		sq.To, err = parseSearchTime(params["to"], true)
+	}
+	if err != nil {
+		return sq, docerr.WithDetails("search", docerr.ErrBadRequest, err,
+			map[string]interface{}{"from": "a date like 2020-09-01", "to": "a date like 2020-09-30"})
+	}
+	return sq, nil
+}
+
+// parseSearchTime parses a from or to parameter. A to date includes the
+// whole day.
+func parseSearchTime(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		if end {
+			t = t.Add(24*time.Hour - time.Nanosecond)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// filtered reports whether sq has any filters.
+func (sq searchQuery) filtered() bool {
+	return len(sq.Tags) > 0 || sq.Owner != "" || sq.Prefix != "" || !sq.From.IsZero() || !sq.To.IsZero()
+}
+
+// admits reports whether d passes sq's filters.
+func (sq searchQuery) admits(d docSummary) bool {
+	for _, want := range sq.Tags {
+		found := false
+		for _, t := range d.fm.Tags {
+			found = found || strings.EqualFold(t, want)
+		}
+		if !found {
+			return false
+		}
+	}
+	return (sq.Owner == "" || strings.EqualFold(d.fm.Owner, sq.Owner)) &&
+		strings.HasPrefix(d.DocId, sq.Prefix) &&
+		(sq.From.IsZero() || !d.Timestamp.Before(sq.From)) &&
+		(sq.To.IsZero() || !d.Timestamp.After(sq.To))
+}
+
+// values encodes sq as query parameters.
+func (sq searchQuery) values() url.Values {
+	v := url.Values{}
+	set := func(k, s string) {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	set("q", sq.Q)
+	set("tag", strings.Join(sq.Tags, ","))
+	set("owner", sq.Owner)
+	set("prefix", sq.Prefix)
+	if !sq.From.IsZero() {
+		set("from", sq.From.Format(time.RFC3339))
+	}
+	if !sq.To.IsZero() {
+		set("to", sq.To.Format(time.RFC3339))
+	}
+	return v
+}
+
+var (
+	// searchProviderName picks how docs are searched: "keyword", for the
+	// docs containing a query's words, or "semantic", for those near it in
+	// meaning too. See semanticSearch.
+	searchProviderName = envString("SEARCH_PROVIDER", "keyword")
+
+	searchProviderOnce sync.Once
+	docSearch          searchProvider
+)
+
+// searchProvider finds the listed docs matching a search, best matches
+// first.
+type searchProvider interface {
+	Search(ctx context.Context, sq searchQuery) ([]searchResult, error)
+}
+
+// getSearchProvider returns the configured search provider, falling back
+// to keyword search.
+func getSearchProvider() searchProvider {
+	searchProviderOnce.Do(func() {
+		docSearch = keywordSearch{}
+		switch searchProviderName {
+		case "keyword":
+		case "semantic":
+			e, err := newEmbedder(embeddingProvider, os.Getenv("EMBEDDING_MODEL"))
+			if err != nil {
+				log.Printf("ignoring SEARCH_PROVIDER: %v", err)
+				return
+			}
+			docSearch = semanticSearch{embedder: e}
+		default:
+			log.Printf("ignoring SEARCH_PROVIDER %q: want keyword or semantic", searchProviderName)
+		}
+	})
+	return docSearch
+}
+
+// search runs sq with the configured search provider.
+func search(ctx context.Context, sq searchQuery) ([]searchResult, error) {
+	return getSearchProvider().Search(ctx, sq)
+}
+
+// keywordSearch returns the listed docs passing a search's filters and
+// containing every word of its query, best matches first. A match in a
+// doc's title counts for more than one in its text. Without words, every
+// doc passing the filters is returned, most recently updated first.
+type keywordSearch struct{}
+
+func (keywordSearch) Search(ctx context.Context, sq searchQuery) ([]searchResult, error) {
+	terms := searchTerms(sq.Q)
+	if len(terms) == 0 && !sq.filtered() {
+		return nil, nil
+	}
+	var re *regexp.Regexp
+	if len(terms) > 0 {
+		re = termPattern(terms)
+	}
+
+	c, err := getCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []searchResult{}
+	for _, d := range c {
+		if r, ok := matchDoc(sq, terms, re, d); ok {
+			results = append(results, r)
+		}
+	}
+	sortResults(results)
+	return results, nil
+}
+
+// sortResults orders results by score, then most recently updated first.
+func sortResults(results []searchResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Timestamp.After(results[j].Timestamp)
+	})
+}
+
+// matchDoc reports whether d passes sq's filters and contains every one
+// of terms, which re matches.
+func matchDoc(sq searchQuery, terms []string, re *regexp.Regexp, d docSummary) (searchResult, bool) {
+	if !sq.admits(d) {
+		return searchResult{}, false
+	}
+
+	var inTitle, inText [][2]int
+	if re != nil {
+		inTitle, inText = findTerms(re, d.Title), findTerms(re, d.text)
+	}
+	found := map[string]bool{}
+	for _, s := range inTitle {
+		found[strings.ToLower(d.Title[s[0]:s[1]])] = true
+	}
+	for _, s := range inText {
+		found[strings.ToLower(d.text[s[0]:s[1]])] = true
+	}
+	if len(found) < len(terms) {
+		return searchResult{}, false
+	}
+
+	return searchResult{
+		DocId:     d.DocId,
+		Title:     d.Title,
+		Version:   d.Version,
+		Timestamp: d.Timestamp,
+		Tags:      d.fm.Tags,
+		Owner:     d.fm.Owner,
+		Score:     5*len(inTitle) + len(inText),
+		Snippets:  snippets(d.text, inText),
+		titleHTML: markTerms(d.Title, inTitle, 0, len(d.Title)),
+		text:      d.text,
+	}, true
+}
+
+// searchFacets count the results of a search by tag, owner and the month
+// they were last updated in, like "2020-09", for refining it.
+type searchFacets struct {
+	Tags    map[string]int `json:"tags"`
+	Owners  map[string]int `json:"owners"`
+	Updated map[string]int `json:"updated"`
+}
+
+func facets(results []searchResult) searchFacets {
+	f := searchFacets{Tags: map[string]int{}, Owners: map[string]int{}, Updated: map[string]int{}}
+	for _, r := range results {
+		for _, t := range r.Tags {
+			f.Tags[t]++
+		}
+		if r.Owner != "" {
+			f.Owners[r.Owner]++
+		}
+		f.Updated[r.Timestamp.UTC().Format("2006-01")]++
+	}
+	return f
+}
+
+// snippets returns up to maxSnippets passages of text around the matches
+// in spans, which don't overlap one another.
+func snippets(text string, spans [][2]int) []string {
+	out := []string{}
+	end := -1
+	for _, s := range spans {
+		if len(out) == maxSnippets {
+			break
+		}
+		if s[0] < end {
+			continue
+		}
+
+		from, to := wordStart(text, s[0]-snippetRadius), wordEnd(text, s[1]+snippetRadius)
+		if from < end {
+			from = end
+		}
+		end = to
+
+		snippet := markTerms(text, spans, from, to)
+		if from > 0 {
+			snippet = "…" + snippet
+		}
+		if to < len(text) {
+			snippet += "…"
+		}
+		out = append(out, snippet)
+	}
+	return out
+}
+
+// wordStart moves i back to the start of the word it falls in.
+func wordStart(text string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	if j := strings.LastIndexAny(text[:i], " \n"); j >= 0 {
+		return j + 1
+	}
+	return 0
+}
+
+// wordEnd moves i on to the end of the word it falls in.
+func wordEnd(text string, i int) int {
+	if i >= len(text) {
+		return len(text)
+	}
+	if j := strings.IndexAny(text[i:], " \n"); j >= 0 {
+		return i + j
+	}
+	return len(text)
+}
+
+// markTerms returns text[from:to] escaped as HTML, with the spans that
+// fall in it marked.
+func markTerms(text string, spans [][2]int, from, to int) string {
+	var b strings.Builder
+	pos := from
+	for _, s := range spans {
+		if s[0] < pos || s[1] > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:s[0]]))
+		b.WriteString("<mark>" + html.EscapeString(text[s[0]:s[1]]) + "</mark>")
+		pos = s[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// searchLimit returns the number of results request asks for.
+func searchLimit(request events.APIGatewayProxyRequest) int {
+	n, err := strconv.Atoi(request.QueryStringParameters["limit"])
+	switch {
+	case err != nil || n <= 0:
+		return defaultSearchLimit
+	case n > maxSearchLimit:
+		return maxSearchLimit
+	}
+	return n
+}
+
+// searchJSON answers a search for programs. See parseSearchQuery for its
+// parameters.
+func searchJSON(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	sq, err := parseSearchQuery(request.QueryStringParameters)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(searchTerms(sq.Q)) == 0 && !sq.filtered() {
+		return Response{}, docerr.WithDetails("search", docerr.ErrBadRequest, nil,
+			map[string]interface{}{"q": "a query with at least one word, or a filter"})
+	}
+
+	results, err := search(ctx, sq)
+	if err != nil {
+		return Response{}, docerr.FromStore("search", err)
+	}
+	total, f := len(results), facets(results)
+	if limit := searchLimit(request); total > limit {
+		results = results[:limit]
+	}
+	for i, r := range results {
+		results[i].Summary = quickAbstract(ctx, r.DocId, r.Version, r.Title, r.text)
+	}
+	var suggestions []didYouMean
+	if total == 0 {
+		suggestions, err = suggestDocs(ctx, sq.Q)
+		if err != nil {
+			return Response{}, docerr.FromStore("search", err)
+		}
+	}
+	return jsonResponse(200, struct {
+		Query      string         `json:"query"`
+		Total      int            `json:"total"`
+		Results    []searchResult `json:"results"`
+		Facets     searchFacets   `json:"facets"`
+		DidYouMean []didYouMean   `json:"didYouMean,omitempty"`
+	}{sq.Q, total, results, f, suggestions}), nil
+}
+
+// serveSearch serves the search page, with the results of the search in
+// its query parameters if there is one, and links refining it by facet.
+func serveSearch(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	sq, err := parseSearchQuery(request.QueryStringParameters)
+	if err != nil {
+		return Response{}, err
+	}
+	results, err := search(ctx, sq)
+	if err != nil {
+		return Response{}, docerr.FromStore("search", err)
+	}
+	total, f := len(results), facets(results)
+	if limit := searchLimit(request); total > limit {
+		results = results[:limit]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<form class=\"search\" action=\"%s\" role=\"search\">\n"+
+		"<input type=\"search\" name=\"q\" value=\"%s\" aria-label=\"Search\">\n", searchPath, html.EscapeString(sq.Q))
+	// Searching again keeps the filters.
+	v := sq.values()
+	for _, k := range []string{"tag", "owner", "prefix", "from", "to"} {
+		if v.Get(k) != "" {
+			fmt.Fprintf(&b, "<input type=\"hidden\" name=\"%s\" value=\"%s\">\n", k, html.EscapeString(v.Get(k)))
+		}
+	}
+	b.WriteString("<button type=\"submit\">Search</button>\n</form>\n")
+
+	switch {
+	case sq.Q == "" && !sq.filtered():
+	case total == 0:
+		b.WriteString("<p class=\"search-empty\">No docs match.</p>\n")
+		suggestions, err := suggestDocs(ctx, sq.Q)
+		if err != nil {
+			return Response{}, docerr.FromStore("search", err)
+		}
+		if len(suggestions) > 0 {
+			b.WriteString("<p class=\"did-you-mean\">Did you mean:</p>\n<ul class=\"did-you-mean\">\n")
+			for _, s := range suggestions {
+				title := s.Title
+				if title == "" {
+					title = s.DocId
+				}
+				fmt.Fprintf(&b, "<li><a href=\"/%s\">%s</a></li>\n", url.PathEscape(s.DocId), html.EscapeString(title))
+			}
+			b.WriteString("</ul>\n")
+		}
+	default:
+		writeFacets(&b, sq, f, total)
+		b.WriteString("<ol class=\"search-results\">\n")
+		for _, r := range results {
+			title := r.titleHTML
+			if title == "" {
+				title = html.EscapeString(r.DocId)
+			}
+			fmt.Fprintf(&b, "<li><a href=\"/%s\">%s</a>\n", url.PathEscape(r.DocId), title)
+			for _, s := range r.Snippets {
+				fmt.Fprintf(&b, "<p class=\"snippet\">%s</p>\n", s)
+			}
+			b.WriteString("</li>\n")
+		}
+		b.WriteString("</ol>\n")
+	}
+
+	// Templates don't escape the title, and the query is the reader's.
+	title := "Search"
+	if sq.Q != "" {
+		title = "Search: " + html.EscapeString(sq.Q)
+	}
+	meta := docMetadata{
+		Title:   title,
+		DocBody: b.String(),
+		Robots:  "noindex",
+	}
+	resp, err := renderPage(ctx, searchTmplDocName, meta)
+	if missingTemplate(err) {
+		resp, err = renderPage(ctx, tmplDocName, meta)
+	}
+	if err != nil {
+		return resp, err
+	}
+	return withHeaders(resp, map[string]string{"X-Robots-Tag": "noindex"}), nil
+}
+
+// writeFacets writes links refining sq, which has total results, by each
+// of f's values, most common first.
+func writeFacets(b *strings.Builder, sq searchQuery, f searchFacets, total int) {
+	groups := []struct {
+		name   string
+		counts map[string]int
+		refine func(sq searchQuery, v string) searchQuery
+	}{
+		{"Tags", f.Tags, func(sq searchQuery, v string) searchQuery {
+			sq.Tags = append(append([]string{}, sq.Tags...), v)
+			return sq
+		}},
+		{"Owners", f.Owners, func(sq searchQuery, v string) searchQuery {
+			sq.Owner = v
+			return sq
+		}},
+		{"Updated", f.Updated, func(sq searchQuery, v string) searchQuery {
+			from, _ := time.Parse("2006-01", v)
+			sq.From, sq.To = from, from.AddDate(0, 1, 0).Add(-time.Nanosecond)
+			return sq
+		}},
+	}
+
+	b.WriteString("<aside class=\"search-facets\">\n")
+	for _, g := range groups {
+		// A value every result has can't refine the search.
+		var values []string
+		for v, n := range g.counts {
+			if n < total {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if g.counts[values[i]] != g.counts[values[j]] {
+				return g.counts[values[i]] > g.counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+
+		fmt.Fprintf(b, "<h2>%s</h2>\n<ul>\n", g.name)
+		for _, v := range values {
+			href := searchPath + "?" + g.refine(sq, v).values().Encode()
+			fmt.Fprintf(b, "<li><a href=\"%s\">%s</a> <span class=\"count\">%d</span></li>\n",
+				html.EscapeString(href), html.EscapeString(v), g.counts[v])
+		}
+		b.WriteString("</ul>\n")
+	}
+	b.WriteString("</aside>\n")
+}
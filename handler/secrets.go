@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	// Secret values may be given as references to where they are kept
+	// rather than in the clear, like "ssm:/docstore/admin-key" or
+	// "secretsmanager:docstore/webhook".
+	ssmPrefix            = "ssm:"
+	secretsManagerPrefix = "secretsmanager:"
+)
+
+var secrets = &secretCache{values: map[string]string{}}
+
+// secretCache holds resolved secrets for the life of the container, so each
+// reference costs one lookup.
+type secretCache struct {
+	sync.Mutex
+	values map[string]string
+}
+
+// resolveSecret returns the secret ref refers to, or ref itself if it is a
+// plain value rather than a reference.
+func resolveSecret(ctx context.Context, ref string) (string, error) {
+	if !strings.HasPrefix(ref, ssmPrefix) && !strings.HasPrefix(ref, secretsManagerPrefix) {
+		return ref, nil
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	if v, ok := secrets.values[ref]; ok {
+		return v, nil
+	}
+
+	var v string
+	var err error
+	if name := strings.TrimPrefix(ref, ssmPrefix); name != ref {
+		v, err = ssmParameter(ctx, name)
+	} else {
+		v, err = secretsManagerSecret(ctx, strings.TrimPrefix(ref, secretsManagerPrefix))
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve secret %s: %w", ref, err)
+	}
+
+	secrets.values[ref] = v
+	return v, nil
+}
+
+func ssmParameter(ctx context.Context, name string) (string, error) {
+	out, err := ssm.New(awsSession()).GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+func secretsManagerSecret(ctx context.Context, id string) (string, error) {
+	out, err := secretsmanager.New(awsSession()).GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.SecretString), nil
+}
+
+// envSecret reads the environment variable key, resolving it if it is a
+// secret reference. It is empty if the secret can't be resolved, so
+// whatever it guards stays disabled.
+func envSecret(key string) string {
+	v, err := resolveSecret(context.Background(), os.Getenv(key))
+	if err != nil {
+		log.Printf("invalid %s: %v", key, err)
+		return ""
+	}
+	return v
+}
+
+// secret is a config value that may be a secret reference, resolved when
+// the config is loaded.
+type secret string
+
+func (s *secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ref string
+	if err := unmarshal(&ref); err != nil {
+		return err
+	}
+
+	v, err := resolveSecret(context.Background(), ref)
+	if err != nil {
+		return err
+	}
+
+	*s = secret(v)
+	return nil
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// sectionStatsTable is the DynamoDB table counting how often each
+	// section of a doc is linked to, keyed by DocId and Section. Section
+	// tracking is off when it is unset.
+	sectionStatsTable = os.Getenv("SECTION_STATS_TABLE")
+
+	validSection = regexp.MustCompile(`^[A-Za-z0-9_:.-]{1,128}$`)
+)
+
+// beaconScript reports the section a reader lands on or jumps to, so
+// templates can include it with {{beaconScript}}.
+const beaconScript = `<script>
+(function () {
+  function send() {
+    var section = decodeURIComponent(location.hash.slice(1));
+    var docId = location.pathname.split("/").pop() || "index";
+    if (section && navigator.sendBeacon) {
+      navigator.sendBeacon("` + apiV1 + `beacon", JSON.stringify({docId: docId, section: section}));
+    }
+  }
+  window.addEventListener("hashchange", send);
+  send();
+})();
+</script>
+`
+
+type sectionHit struct {
+	DocId   string `json:"docId"`
+	Section string `json:"section"`
+	Count   int    `json:"count,omitempty"`
+}
+
+// sectionBeacon counts a visit to a section of a doc.
+func sectionBeacon(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if sectionStatsTable == "" {
+		return Response{}, docerr.E("beacon", docerr.ErrNotFound, nil)
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var hit sectionHit
+	err = json.Unmarshal(body, &hit)
+	if err != nil {
+		return Response{}, docerr.E("beacon", docerr.ErrBadRequest, err)
+	}
+	if docstore.ValidateDocId(hit.DocId) != nil || !validSection.MatchString(hit.Section) {
+		return Response{}, docerr.WithDetails("beacon", docerr.ErrBadRequest, nil,
+			map[string]interface{}{"docId": hit.DocId, "section": hit.Section})
+	}
+
+	key, err := dynamodbattribute.MarshalMap(struct{ DocId, Section string }{hit.DocId, hit.Section})
+	if err != nil {
+		return Response{}, err
+	}
+
+	_, err = dynamo().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(sectionStatsTable),
+		Key:              key,
+		UpdateExpression: aws.String("ADD #c :one"),
+		ExpressionAttributeNames: map[string]*string{
+			"#c": aws.String("Count"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+		},
+	})
+	if err != nil {
+		return Response{}, docerr.E("beacon", docerr.ErrBackend, err)
+	}
+
+	return Response{StatusCode: http.StatusNoContent}, nil
+}
+
+// sectionStats reports the most visited sections, of one doc if the docId
+// query parameter is given or else of the whole site.
+func sectionStats(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if sectionStatsTable == "" {
+		return Response{}, docerr.E("section stats", docerr.ErrNotFound, nil)
+	}
+
+	var hits []sectionHit
+	collect := func(items []map[string]*dynamodb.AttributeValue) error {
+		var page []sectionHit
+		err := dynamodbattribute.UnmarshalListOfMaps(items, &page)
+		hits = append(hits, page...)
+		return err
+	}
+
+	var err error
+	if docId := request.QueryStringParameters["docId"]; docId != "" {
+		err = dynamo().QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(sectionStatsTable),
+			KeyConditionExpression:    aws.String("DocId = :d"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":d": {S: aws.String(docId)}},
+		}, func(out *dynamodb.QueryOutput, last bool) bool {
+			return collect(out.Items) == nil
+		})
+	} else {
+		err = dynamo().ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+			TableName: aws.String(sectionStatsTable),
+		}, func(out *dynamodb.ScanOutput, last bool) bool {
+			return collect(out.Items) == nil
+		})
+	}
+	if err != nil {
+		return Response{}, docerr.E("section stats", docerr.ErrBackend, err)
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Count > hits[j].Count })
+	if len(hits) > 100 {
+		hits = hits[:100]
+	}
+
+	return jsonResponse(200, struct {
+		Sections []sectionHit `json:"sections"`
+	}{hits}), nil
+}
@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	// embeddingChunk is roughly how much of a doc's text each vector
+	// stands for, and maxEmbeddedChunks how many vectors a doc gets at
+	// most, which keeps its item under DynamoDB's 400 KB limit.
+	embeddingChunk    = 1500
+	maxEmbeddedChunks = 64
+
+	// maxEmbedPerSearch is how many docs a search embeds when it finds
+	// them missing from the index, so a cold index is filled in over a
+	// few searches rather than holding one up. Docs not embedded yet are
+	// still found by their words.
+	maxEmbedPerSearch = 16
+)
+
+var (
+	// embeddingProvider is the provider, "bedrock" or "openai", of the
+	// EMBEDDING_MODEL model that SEARCH_PROVIDER=semantic embeds docs
+	// and queries with.
+	embeddingProvider = envString("EMBEDDING_PROVIDER", "bedrock")
+
+	// embeddingsTable is the DynamoDB table docs' vectors are kept in,
+	// keyed by DocId. Without it they are only kept for the life of a warm
+	// container.
+	embeddingsTable = os.Getenv("EMBEDDINGS_TABLE")
+
+	// semanticMinScore is how similar, from 0 to 1, a doc must be to a
+	// query for semantic search to return it without any of its words.
+	semanticMinScore = envFloat("SEMANTIC_MIN_SCORE", 0.4)
+
+	embeddings = &embeddingIndex{docs: map[string]docEmbeddings{}}
+)
+
+// docEmbeddings are the vectors of the chunks of a revision of a doc.
+type docEmbeddings struct {
+	version int
+	chunks  []embeddedChunk
+}
+
+// embeddedChunk is the vector of the doc text from start to end, scaled
+// to unit length so that a dot product is the cosine similarity.
+type embeddedChunk struct {
+	start, end int
+	vector     []float32
+}
+
+// embeddingIndex holds docs' vectors by docId. loaded is set once the
+// table has been read into it.
+type embeddingIndex struct {
+	sync.Mutex
+	docs   map[string]docEmbeddings
+	loaded bool
+}
+
+func (x *embeddingIndex) get(docId string) (docEmbeddings, bool) {
+	x.Lock()
+	defer x.Unlock()
+	e, ok := x.docs[docId]
+	return e, ok
+}
+
+func (x *embeddingIndex) put(docId string, e docEmbeddings) {
+	x.Lock()
+	defer x.Unlock()
+	x.docs[docId] = e
+}
+
+// storedEmbeddings is a doc's vectors as kept in embeddingsTable, each
+// packed as little endian float32s.
+type storedEmbeddings struct {
+	DocId   string
+	Version int
+	Chunks  []storedChunk
+}
+
+type storedChunk struct {
+	Start, End int
+	Vector     []byte
+}
+
+// semanticSearch finds docs by meaning as well as words: a doc is
+// returned if it contains every word of the query, as with keyword
+// search, or if one of its chunks is at least semanticMinScore similar to
+// the query. Its score adds the two.
+type semanticSearch struct {
+	embedder embedder
+	keyword  keywordSearch
+}
+
+func (s semanticSearch) Search(ctx context.Context, sq searchQuery) ([]searchResult, error) {
+	if strings.TrimSpace(sq.Q) == "" {
+		return s.keyword.Search(ctx, sq)
+	}
+	c, err := getCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := s.embedder.Embed(ctx, sq.Q)
+	if err != nil {
+		// Words alone are better than no results.
+		log.Printf("embedding query: %v", err)
+		return s.keyword.Search(ctx, sq)
+	}
+	unitVector(query)
+	err = s.fill(ctx, c)
+	if err != nil {
+		log.Printf("embedding docs: %v", err)
+	}
+
+	terms := searchTerms(sq.Q)
+	re := termPattern(terms)
+	var results []searchResult
+	for _, d := range c {
+		if !sq.admits(d) {
+			continue
+		}
+		best, chunk := 0.0, embeddedChunk{}
+		if e, ok := embeddings.get(d.DocId); ok && e.version == d.Version {
+			for _, ch := range e.chunks {
+				if sim := dot(query, ch.vector); sim > best {
+					best, chunk = sim, ch
+				}
+			}
+		}
+
+		r, ok := matchDoc(sq, terms, re, d)
+		switch {
+		case ok:
+		case best >= semanticMinScore:
+			r, _ = matchDoc(searchQuery{}, nil, nil, d)
+			r.Snippets = []string{chunkSnippet(d.text, chunk)}
+		default:
+			continue
+		}
+		r.Score += int(100 * best)
+		results = append(results, r)
+	}
+	sortResults(results)
+	return results, nil
+}
+
+// fill embeds up to maxEmbedPerSearch of the docs in c missing from the
+// index, reading the index from embeddingsTable first if it hasn't been.
+func (s semanticSearch) fill(ctx context.Context, c catalog) error {
+	err := loadEmbeddings(ctx)
+	if err != nil {
+		return err
+	}
+
+	var missing []docSummary
+	for _, d := range c {
+		if e, ok := embeddings.get(d.DocId); !ok || e.version != d.Version {
+			missing = append(missing, d)
+		}
+	}
+	if len(missing) > maxEmbedPerSearch {
+		missing = missing[:maxEmbedPerSearch]
+	}
+
+	errs := make([]error, len(missing))
+	sem := make(chan struct{}, 4)
+	var wg sync.WaitGroup
+	for i, d := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, d docSummary) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = s.embedDoc(ctx, d)
+		}(i, d)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEmbeddings reads every doc's vectors from embeddingsTable into the
+// index, once per container.
+func loadEmbeddings(ctx context.Context) error {
+	ch := flights.DoChan("embeddings", func() (interface{}, error) {
+		embeddings.Lock()
+		loaded := embeddings.loaded
+		embeddings.Unlock()
+		if loaded || embeddingsTable == "" {
+			return nil, nil
+		}
+
+		var scanErr error
+		err := dynamo().ScanPagesWithContext(context.Background(), &dynamodb.ScanInput{
+			TableName: aws.String(embeddingsTable),
+		}, func(out *dynamodb.ScanOutput, last bool) bool {
+			var page []storedEmbeddings
+			scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &page)
+			for _, stored := range page {
+				e := docEmbeddings{version: stored.Version}
+				for _, c := range stored.Chunks {
+					e.chunks = append(e.chunks, embeddedChunk{c.Start, c.End, unpackVector(c.Vector)})
+				}
+				// A doc embedded since the scan started is newer.
+				if cur, ok := embeddings.get(stored.DocId); !ok || cur.version < e.version {
+					embeddings.put(stored.DocId, e)
+				}
+			}
+			return scanErr == nil
+		})
+		if err == nil {
+			err = scanErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		embeddings.Lock()
+		embeddings.loaded = true
+		embeddings.Unlock()
+		return nil, nil
+	})
+	_, err := await(ctx, "load embeddings", ch)
+	return err
+}
+
+// embedDoc embeds the chunks of d and keeps their vectors.
+func (s semanticSearch) embedDoc(ctx context.Context, d docSummary) error {
+	key := fmt.Sprintf("embed:%s@%d", d.DocId, d.Version)
+	ch := flights.DoChan(key, func() (interface{}, error) {
+		ctx := context.Background()
+		e := docEmbeddings{version: d.Version}
+		stored := storedEmbeddings{DocId: d.DocId, Version: d.Version}
+		for _, span := range embeddingChunks(d.text, embeddingChunk) {
+			v, err := s.embedder.Embed(ctx, d.Title+"\n\n"+d.text[span[0]:span[1]])
+			if err != nil {
+				return nil, err
+			}
+			unitVector(v)
+			e.chunks = append(e.chunks, embeddedChunk{span[0], span[1], v})
+			stored.Chunks = append(stored.Chunks, storedChunk{span[0], span[1], packVector(v)})
+		}
+		if embeddingsTable != "" {
+			item, err := dynamodbattribute.MarshalMap(stored)
+			if err == nil {
+				_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+					TableName: aws.String(embeddingsTable),
+					Item:      item,
+				})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		embeddings.put(d.DocId, e)
+		return nil, nil
+	})
+	_, err := await(ctx, key, ch)
+	return err
+}
+
+// embedChanges embeds the listed, public pages changed in event, so that
+// semantic search finds new revisions without embedding them itself.
+func embedChanges(ctx context.Context, event events.DynamoDBEvent) error {
+	s, ok := getSearchProvider().(semanticSearch)
+	if !ok {
+		return nil
+	}
+	cfg := getConfig(ctx)
+	for _, docId := range changedPages(event) {
+		doc, err := fetchDoc(ctx, docId)
+		if err != nil {
+			return err
+		}
+		d := summarize(docId, doc)
+		if private, _ := cfg.access(docId, d.fm); !d.fm.listed() || private {
+			continue
+		}
+		err = s.embedDoc(ctx, d)
+		if err != nil {
+			return fmt.Errorf("embed %s: %w", docId, err)
+		}
+	}
+	return nil
+}
+
+// embeddingChunks splits text into spans of about n bytes, between lines
+// where it can and between words otherwise. Past maxEmbeddedChunks, the
+// rest of the text isn't embedded.
+func embeddingChunks(text string, n int) [][2]int {
+	var spans [][2]int
+	start := 0
+	for start < len(text) && len(spans) < maxEmbeddedChunks {
+		end := len(text)
+		if end-start > n {
+			end = start + n
+			if i := strings.LastIndexByte(text[start:end], '\n'); i > n/2 {
+				end = start + i + 1
+			} else if i := strings.LastIndexByte(text[start:end], ' '); i > 0 {
+				end = start + i + 1
+			} else {
+				end = wordEnd(text, end)
+			}
+		}
+		if strings.TrimSpace(text[start:end]) != "" {
+			spans = append(spans, [2]int{start, end})
+		}
+		start = end
+	}
+	return spans
+}
+
+// chunkSnippet is the start of the chunk of text a doc matched a query
+// by, escaped as HTML, for a result without matched words to show.
+func chunkSnippet(text string, c embeddedChunk) string {
+	s := html.EscapeString(excerpt(text[c.start:c.end], 2*snippetRadius))
+	if c.start > 0 {
+		s = "…" + s
+	}
+	return s
+}
+
+// unitVector scales v to length 1.
+func unitVector(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+func dot(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func packVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+func unpackVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
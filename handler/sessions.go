@@ -0,0 +1,423 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+var (
+	// sessionsTable is the DynamoDB table recording signed in readers'
+	// sessions, keyed by User and Id, with a TTL on the Expires attribute.
+	// Sessions aren't recorded, and can't be revoked, when it is unset.
+	sessionsTable = os.Getenv("SESSIONS_TABLE")
+
+	// sessionTTL is how long a warm container goes by the sessions it read
+	// for a reader, so how long a session revoked in another container may
+	// still be used.
+	sessionTTL = envDuration("SESSION_TTL", time.Minute)
+
+	// sessionMaxAge is how long a session is kept after the reader signed
+	// in: as long as the identity provider's refresh tokens last.
+	sessionMaxAge = envDuration("SESSION_MAX_AGE", 30*24*time.Hour)
+
+	userSessions = &sessionCache{entries: map[string]cachedSessions{}}
+
+	errSessionRevoked = errors.New("the session was revoked")
+)
+
+// revokedBeforeId is the Id of the record holding when all of a reader's
+// sessions were last revoked, in its Issued. Sessions signed in before
+// then are refused, even those not recorded yet.
+const revokedBeforeId = "*"
+
+// readerSession is a signed in reader's session: the tokens issued from one
+// sign in, which share their origin_jti, or else their jti or sid.
+type readerSession struct {
+	User      string    `dynamodbav:"User" json:"-"`
+	Id        string    `dynamodbav:"Id" json:"id"`
+	Issued    time.Time `dynamodbav:"Issued" json:"issued"`
+	Seen      time.Time `dynamodbav:"Seen" json:"seen"`
+	Expires   int64     `dynamodbav:"Expires" json:"-"`
+	UserAgent string    `dynamodbav:"UserAgent,omitempty" json:"userAgent,omitempty"`
+	SourceIP  string    `dynamodbav:"SourceIP,omitempty" json:"sourceIp,omitempty"`
+	Revoked   bool      `dynamodbav:"Revoked" json:"revoked"`
+	Current   bool      `dynamodbav:"-" json:"current,omitempty"`
+}
+
+// sessionClaims returns the session a token's claims belong to and when
+// the reader signed in, or "" if the token doesn't say.
+func sessionClaims(claims map[string]interface{}) (id string, issued time.Time) {
+	for _, k := range []string{"origin_jti", "jti", "sid"} {
+		if v, ok := claims[k].(string); ok && v != "" {
+			id = v
+			break
+		}
+	}
+	for _, k := range []string{"auth_time", "iat"} {
+		// Authorizers pass claims on as strings.
+		switch v := claims[k].(type) {
+		case float64:
+			return id, time.Unix(int64(v), 0).UTC()
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return id, time.Unix(n, 0).UTC()
+			}
+		}
+	}
+	return id, issued
+}
+
+type cachedSessions struct {
+	byId          map[string]readerSession
+	revokedBefore time.Time
+	fetched       time.Time
+}
+
+type sessionCache struct {
+	sync.Mutex
+	entries map[string]cachedSessions
+}
+
+// querySessions returns user's sessions, with the record of when they were
+// all revoked, if any, last.
+func querySessions(ctx context.Context, user string) ([]readerSession, error) {
+	var all []readerSession
+	var scanErr error
+	err := dynamo().QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(sessionsTable),
+		KeyConditionExpression:    aws.String("#u = :u"),
+		ExpressionAttributeNames:  map[string]*string{"#u": aws.String("User")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":u": {S: aws.String(user)}},
+	}, func(out *dynamodb.QueryOutput, last bool) bool {
+		var page []readerSession
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &page)
+		all = append(all, page...)
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return nil, docerr.E("sessions", docerr.ErrBackend, err)
+	}
+
+	// Expired sessions may linger until DynamoDB's TTL sweep gets to
+	// them.
+	now := time.Now().Unix()
+	sessions := all[:0]
+	var cutoff *readerSession
+	for _, s := range all {
+		switch {
+		case s.Expires < now:
+		case s.Id == revokedBeforeId:
+			c := s
+			cutoff = &c
+		default:
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Seen.After(sessions[j].Seen) })
+	if cutoff != nil {
+		sessions = append(sessions, *cutoff)
+	}
+	return sessions, nil
+}
+
+// getSessions returns user's sessions, reading them again when the cached
+// copy is older than sessionTTL.
+func getSessions(ctx context.Context, user string) (cachedSessions, error) {
+	userSessions.Lock()
+	defer userSessions.Unlock()
+
+	c, ok := userSessions.entries[user]
+	if ok && time.Since(c.fetched) < sessionTTL {
+		return c, nil
+	}
+
+	sessions, err := querySessions(ctx, user)
+	if err != nil {
+		return c, err
+	}
+	c = cachedSessions{byId: map[string]readerSession{}, fetched: time.Now()}
+	for _, s := range sessions {
+		if s.Id == revokedBeforeId {
+			c.revokedBefore = s.Issued
+		} else {
+			c.byId[s.Id] = s
+		}
+	}
+	userSessions.entries[user] = c
+	return c, nil
+}
+
+// forgetSessions drops the cached copy of user's sessions, so that a
+// revocation applies at once in this container.
+func forgetSessions(user string) {
+	userSessions.Lock()
+	delete(userSessions.entries, user)
+	userSessions.Unlock()
+}
+
+// checkSession refuses the session of r if it was revoked, and records it
+// if it is new. The sessions table being unavailable doesn't keep readers
+// out; it is logged.
+func checkSession(ctx context.Context, request events.APIGatewayProxyRequest, r *reader) error {
+	if sessionsTable == "" || r.Name == "" || r.session == "" {
+		return nil
+	}
+
+	c, err := getSessions(ctx, r.Name)
+	if err != nil {
+		log.Printf("checking session of %s: %v", r.Name, err)
+		return nil
+	}
+	s, known := c.byId[r.session]
+	if s.Revoked || (!c.revokedBefore.IsZero() && r.issued.Before(c.revokedBefore)) {
+		return docerr.E("session", docerr.ErrUnauthorized, errSessionRevoked)
+	}
+	if known {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	s = readerSession{
+		User:      r.Name,
+		Id:        r.session,
+		Issued:    r.issued,
+		Seen:      now,
+		Expires:   r.issued.Add(sessionMaxAge).Unix(),
+		UserAgent: header(request, "User-Agent"),
+		SourceIP:  request.RequestContext.Identity.SourceIP,
+	}
+	if r.issued.IsZero() {
+		s.Expires = now.Add(sessionMaxAge).Unix()
+	}
+	err = recordSession(ctx, s)
+	if err != nil {
+		log.Printf("recording session of %s: %v", r.Name, err)
+		return nil
+	}
+
+	userSessions.Lock()
+	if c, ok := userSessions.entries[r.Name]; ok {
+		c.byId[s.Id] = s
+	}
+	userSessions.Unlock()
+	return nil
+}
+
+// recordSession stores s unless another container has already, which
+// would otherwise undo a revocation made since.
+func recordSession(ctx context.Context, s readerSession) error {
+	item, err := dynamodbattribute.MarshalMap(s)
+	if err != nil {
+		return err
+	}
+	_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(sessionsTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(Id)"),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return err
+}
+
+// revokeSession marks user's session id revoked.
+func revokeSession(ctx context.Context, user, id string) error {
+	op := "revoke session " + id
+	key, err := dynamodbattribute.MarshalMap(struct{ User, Id string }{user, id})
+	if err != nil {
+		return err
+	}
+	_, err = dynamo().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(sessionsTable),
+		Key:                       key,
+		UpdateExpression:          aws.String("SET Revoked = :t"),
+		ConditionExpression:       aws.String("attribute_exists(Id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":t": {BOOL: aws.Bool(true)}},
+	})
+	forgetSessions(user)
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	if err != nil {
+		return docerr.E(op, docerr.ErrBackend, err)
+	}
+	return nil
+}
+
+// revokeAllSessions refuses every session user signed in to before now,
+// recorded or not, and marks the recorded ones revoked.
+func revokeAllSessions(ctx context.Context, user string) (int, error) {
+	now := time.Now().UTC()
+	item, err := dynamodbattribute.MarshalMap(readerSession{
+		User:    user,
+		Id:      revokedBeforeId,
+		Issued:  now,
+		Seen:    now,
+		Expires: now.Add(sessionMaxAge).Unix(),
+		Revoked: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(sessionsTable),
+		Item:      item,
+	})
+	forgetSessions(user)
+	if err != nil {
+		return 0, docerr.E("revoke sessions", docerr.ErrBackend, err)
+	}
+
+	sessions, err := querySessions(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, s := range sessions {
+		if s.Id == revokedBeforeId || s.Revoked {
+			continue
+		}
+		err = revokeSession(ctx, user, s.Id)
+		if err != nil && !errors.Is(err, docerr.ErrNotFound) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// requireSessions returns the reader request comes from, who must be
+// signed in, or an error if sessions aren't recorded.
+func requireSessions(ctx context.Context, request events.APIGatewayProxyRequest) (*reader, error) {
+	if sessionsTable == "" {
+		return nil, docerr.E("sessions", docerr.ErrNotFound, nil)
+	}
+	r, err := requestReader(ctx, request)
+	if err != nil {
+		return nil, docerr.E("sessions", docerr.ErrUnauthorized, err)
+	}
+	if r == nil || r.Name == "" {
+		return nil, docerr.E("sessions", docerr.ErrUnauthorized, nil)
+	}
+	return r, nil
+}
+
+// sessionsResponse lists user's sessions, marking current, the session the
+// request is made in.
+func sessionsResponse(ctx context.Context, user, current string) (Response, error) {
+	all, err := querySessions(ctx, user)
+	if err != nil {
+		return Response{}, err
+	}
+	sessions := []readerSession{}
+	for _, s := range all {
+		if s.Id != revokedBeforeId {
+			s.Current = s.Id == current
+			sessions = append(sessions, s)
+		}
+	}
+	return jsonResponse(200, struct {
+		User     string          `json:"user"`
+		Sessions []readerSession `json:"sessions"`
+	}{user, sessions}), nil
+}
+
+// listSessions lists the signed in reader's sessions, most recently
+// started first, so that one they don't recognise can be revoked.
+func listSessions(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	r, err := requireSessions(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	return sessionsResponse(ctx, r.Name, r.session)
+}
+
+// deleteSession revokes the signed in reader's session with the id path
+// parameter. Requests made in it are refused from then on.
+func deleteSession(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	r, err := requireSessions(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	id := request.PathParameters["id"]
+	err = revokeSession(ctx, r.Name, id)
+	if err != nil {
+		return Response{}, err
+	}
+	audit(request, "session.revoke", "", map[string]interface{}{"user": r.Name, "session": id})
+	return Response{StatusCode: 204}, nil
+}
+
+// deleteSessions revokes all of the signed in reader's sessions, the one
+// the request is made in included.
+func deleteSessions(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	r, err := requireSessions(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	n, err := revokeAllSessions(ctx, r.Name)
+	if err != nil {
+		return Response{}, err
+	}
+	audit(request, "session.revoke-all", "", map[string]interface{}{"user": r.Name, "sessions": n})
+	return Response{StatusCode: 204}, nil
+}
+
+// listUserSessions lists the sessions of the reader named by the user path
+// parameter.
+func listUserSessions(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if sessionsTable == "" {
+		return Response{}, docerr.E("sessions", docerr.ErrNotFound, nil)
+	}
+	return sessionsResponse(ctx, request.PathParameters["user"], "")
+}
+
+// deleteUserSession revokes the session with the id path parameter of the
+// reader named by the user path parameter.
+func deleteUserSession(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if sessionsTable == "" {
+		return Response{}, docerr.E("sessions", docerr.ErrNotFound, nil)
+	}
+	user, id := request.PathParameters["user"], request.PathParameters["id"]
+	err := revokeSession(ctx, user, id)
+	if err != nil {
+		return Response{}, err
+	}
+	audit(request, "session.revoke", "", map[string]interface{}{"user": user, "session": id})
+	return Response{StatusCode: 204}, nil
+}
+
+// deleteUserSessions revokes every session of the reader named by the
+// user path parameter, as when their account is compromised.
+func deleteUserSessions(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if sessionsTable == "" {
+		return Response{}, docerr.E("sessions", docerr.ErrNotFound, nil)
+	}
+	user := request.PathParameters["user"]
+	n, err := revokeAllSessions(ctx, user)
+	if err != nil {
+		return Response{}, err
+	}
+	audit(request, "session.revoke-all", "", map[string]interface{}{"user": user, "sessions": n})
+	return Response{StatusCode: 204}, nil
+}
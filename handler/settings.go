@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"gopkg.in/yaml.v2"
+)
+
+// settingsDoc is a system doc holding settings, as GET and PUT
+// admin/settings/{docId} exchange it, so that an admin UI can edit it as
+// a form rather than as YAML:
+//
+//	{
+//	  "docId": "_config",
+//	  "version": 7,
+//	  "settings": {"name": "Docs", "minify": true}
+//	}
+//
+// Writing sends IfVersion, the version that was edited, to refuse the
+// write if the doc has changed since.
+type settingsDoc struct {
+	DocId     string      `json:"docId"`
+	Version   int         `json:"version"`
+	Timestamp *time.Time  `json:"timestamp,omitempty"`
+	IfVersion int         `json:"ifVersion,omitempty"`
+	Settings  interface{} `json:"settings"`
+}
+
+// isSettingsDoc reports whether docId holds settings that can be edited
+// as JSON: the config, variables, defaults, front matter schemas, status
+// and policies.
+func isSettingsDoc(docId string) bool {
+	return docId == configDocName || strings.HasPrefix(docId, configDocName+".") ||
+		docId == variablesDocName || docId == statusDocName || docId == policiesDocName ||
+		isDefaultsDoc(docId) || isSchemaDoc(docId)
+}
+
+// jsonValue converts v, as parsed from YAML, to what encoding/json can
+// format, whose maps are keyed by strings.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
+
+// settingsBody formats settings as the body of docId: JSON for a schema,
+// which is JSON Schema, and YAML for the rest. Comments in the doc as it
+// was are lost.
+func settingsBody(docId string, settings interface{}) ([]byte, error) {
+	if isSchemaDoc(docId) {
+		b, err := json.MarshalIndent(settings, "", "  ")
+		return append(b, '\n'), err
+	}
+	return yaml.Marshal(settings)
+}
+
+// getSettings answers with the settings doc of the docId path parameter as
+// JSON. A doc that doesn't exist yet has version 0 and no settings.
+func getSettings(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	op := "settings " + docId
+	if !isSettingsDoc(docId) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	s := settingsDoc{DocId: docId, Settings: map[string]interface{}{}}
+	flights.Forget("doc:" + docId)
+	doc, err := fetchDoc(ctx, docId)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return jsonResponse(200, s), nil
+	}
+	if err != nil {
+		return Response{}, err
+	}
+
+	var v interface{}
+	err = yaml.Unmarshal(doc.body, &v)
+	if err != nil {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"reason": "the doc doesn't parse; fix it in the raw editor"})
+	}
+	if v != nil {
+		s.Settings = jsonValue(v)
+	}
+	s.Version, s.Timestamp = doc.meta.Id, &doc.meta.Timestamp
+	return jsonResponse(200, s), nil
+}
+
+// putSettings writes the settings in the request body, a settingsDoc, as
+// a new revision of the docId path parameter, once they pass the checks
+// any write of the doc does. With dryRun=true it only reports the
+// problems, for a UI to show as the settings are edited.
+func putSettings(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	op := "settings " + docId
+	if !isSettingsDoc(docId) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	var s settingsDoc
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+	if s.Settings == nil {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"settings": "the settings are required"})
+	}
+	b, err := settingsBody(docId, s.Settings)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBadRequest, err)
+	}
+
+	lock, _ := patchLocks.LoadOrStore(docId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if s.IfVersion != 0 {
+		flights.Forget("doc:" + docId)
+		latest, err := fetchDoc(ctx, docId)
+		if err != nil && !errors.Is(err, docerr.ErrNotFound) {
+			return Response{}, err
+		}
+		if latest.meta.Id != s.IfVersion {
+			return Response{}, docerr.WithDetails(op, docerr.ErrConflict, nil,
+				map[string]interface{}{"ifVersion": s.IfVersion, "latest": latest.meta.Id})
+		}
+	}
+
+	res, err := writeDoc(ctx, docId, b, dryRun(request))
+	if err != nil {
+		return Response{}, err
+	}
+	status := 200
+	if res.Action == "create" && !res.DryRun {
+		status = 201
+	}
+	return jsonResponse(status, res), nil
+}
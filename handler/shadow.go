@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// shadowTemplate names a candidate template doc. When set, a sample of
+	// successful renders is repeated with it and the outputs are compared.
+	shadowTemplate = os.Getenv("SHADOW_TEMPLATE")
+
+	// shadowRate is the fraction of requests, from 0 to 1, that are
+	// shadow rendered.
+	shadowRate = envFloat("SHADOW_SAMPLE_RATE", 0.01)
+
+	shadowStats struct {
+		sync.Mutex
+		sampled, differed int
+	}
+)
+
+// shadowReport describes one shadow render. Reports are logged as JSON so
+// they can be aggregated with CloudWatch Logs Insights, e.g.
+//
+//	filter shadow | stats count(*) by match
+type shadowReport struct {
+	Shadow         bool   `json:"shadow"`
+	DocId          string `json:"docId"`
+	Revision       int    `json:"revision"`
+	Template       string `json:"template"`
+	Match          bool   `json:"match"`
+	Error          string `json:"error,omitempty"`
+	PrimaryBytes   int    `json:"primaryBytes"`
+	ShadowBytes    int    `json:"shadowBytes"`
+	FirstDiffLine  int    `json:"firstDiffLine,omitempty"`
+	PrimaryLine    string `json:"primaryLine,omitempty"`
+	ShadowLine     string `json:"shadowLine,omitempty"`
+	SampledTotal   int    `json:"sampledTotal"`
+	DifferingTotal int    `json:"differingTotal"`
+}
+
+// maybeShadow renders a sample of requests with the candidate template and
+// logs how the output differs from what was served. It runs after the
+// response is on its way so it never delays readers; a shadow render that
+// outlives the invocation finishes when the container is next thawed.
+func maybeShadow(ctx context.Context, docId string, rev fetchedDoc, primary Response) {
+	if shadowTemplate == "" || strings.Contains(docId, ".") || rand.Float64() >= shadowRate {
+		return
+	}
+
+	go func() {
+		report := shadowReport{
+			Shadow:       true,
+			DocId:        docId,
+			Revision:     rev.meta.Id,
+			Template:     shadowTemplate,
+			PrimaryBytes: len(primary.Body),
+		}
+
+		ctx, cancel := context.WithTimeout(detach(ctx), templateTimeout)
+		defer cancel()
+
+		resp, err := renderWith(ctx, docId, rev, shadowTemplate, nil)
+		if err != nil {
+			report.Error = err.Error()
+		} else {
+			report.ShadowBytes = len(resp.Body)
+			report.FirstDiffLine, report.PrimaryLine, report.ShadowLine = firstDiff(primary.Body, resp.Body)
+			report.Match = report.FirstDiffLine == 0
+		}
+
+		shadowStats.Lock()
+		shadowStats.sampled++
+		if !report.Match {
+			shadowStats.differed++
+		}
+		report.SampledTotal, report.DifferingTotal = shadowStats.sampled, shadowStats.differed
+		shadowStats.Unlock()
+
+		b, err := json.Marshal(report)
+		if err != nil {
+			log.Printf("shadow report error: %v", err)
+			return
+		}
+		log.Printf("%s", b)
+	}()
+}
+
+// firstDiff returns the 1-based number of the first line that differs
+// between a and b along with that line from each, or 0 if they are equal.
+func firstDiff(a, b string) (line int, aLine, bLine string) {
+	if a == b {
+		return
+	}
+
+	as, bs := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		if i < len(as) {
+			aLine = as[i]
+		} else {
+			aLine = ""
+		}
+		if i < len(bs) {
+			bLine = bs[i]
+		} else {
+			bLine = ""
+		}
+		if i >= len(as) || i >= len(bs) || aLine != bLine {
+			return i + 1, aLine, bLine
+		}
+	}
+
+	return
+}
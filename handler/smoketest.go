@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// smokeTestPrefix starts the ids of the docs holding smoke test results,
+// one per run, like "_smoketest.3f9a0c12d4e5b6a7".
+const smokeTestPrefix = "_smoketest."
+
+var (
+	// smokeMaxBytes and smokeLatencyBudget are the largest page and the
+	// slowest render a smoke test lets pass.
+	smokeMaxBytes      = int(envFloat("SMOKE_MAX_BYTES", 1<<20))
+	smokeLatencyBudget = envDuration("SMOKE_LATENCY_BUDGET", time.Second)
+)
+
+// smokeTest is a run rendering every page headlessly, and the pages that
+// failed: those that errored, came out over smokeMaxBytes, or took longer
+// than smokeLatencyBudget to render.
+type smokeTest struct {
+	Id       string        `json:"id"`
+	Template string        `json:"template"`
+	Canary   bool          `json:"canary,omitempty"`
+	Started  time.Time     `json:"started"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Status   string        `json:"status"` // running, passed or failed
+	Error    string        `json:"error,omitempty"`
+	Total    int           `json:"total"`
+	Failures []smokeResult `json:"failures"`
+}
+
+// smokeResult is why one page failed a smoke test.
+type smokeResult struct {
+	DocId     string `json:"docId"`
+	Version   int    `json:"version,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Bytes     int    `json:"bytes,omitempty"`
+	Oversized bool   `json:"oversized,omitempty"`
+	Millis    int64  `json:"millis"`
+	Slow      bool   `json:"slow,omitempty"`
+}
+
+func getSmokeTest(ctx context.Context, id string) (smokeTest, error) {
+	var s smokeTest
+	op := "smoke test " + id
+	if id == "" || docstore.ValidateDocId(id) != nil || strings.Contains(id, ".") {
+		return s, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	flights.Forget("doc:" + smokeTestPrefix + id)
+	doc, err := fetchDoc(ctx, smokeTestPrefix+id)
+	if err != nil {
+		return s, err
+	}
+
+	err = json.Unmarshal(doc.body, &s)
+	if err != nil {
+		return s, docerr.E("parse "+op, docerr.ErrBackend, err)
+	}
+	return s, nil
+}
+
+func putSmokeTest(s smokeTest) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(smokeTestPrefix+s.Id, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+smokeTestPrefix+s.Id, err)
+	}
+	return nil
+}
+
+// startSmokeTest starts rendering every page with the current pipeline, to
+// run before promoting a template or config change. The template query
+// parameter renders with a candidate template instead, and canary=true
+// with the latest, unpromoted, revisions of pinned templates and theme
+// docs. It answers at once with the run's id; GET admin/smoketest/{id}
+// reports its results when it is done. A run that outlives the invocation
+// finishes when the container is next thawed.
+func startSmokeTest(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	params := request.QueryStringParameters
+	s := smokeTest{
+		Id:       randomHex(8),
+		Template: tmplDocName,
+		Canary:   params["canary"] == "true",
+		Started:  time.Now().UTC(),
+		Status:   "running",
+		Failures: []smokeResult{},
+	}
+	if t := params["template"]; t != "" {
+		s.Template = t
+		if _, err := fetchDoc(ctx, t); err != nil {
+			return Response{}, err
+		}
+	}
+
+	err := putSmokeTest(s)
+	if err != nil {
+		return Response{}, err
+	}
+
+	run := detach(ctx)
+	if s.Canary {
+		run = withVariant(run, variantCanary)
+	}
+	go runSmokeTest(run, s)
+
+	return jsonResponse(202, struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}{s.Id, s.Status}), nil
+}
+
+// runSmokeTest renders every page for s and stores the results.
+func runSmokeTest(ctx context.Context, s smokeTest) {
+	results, err := smokeTestPages(ctx, s.Template)
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	s.Total = len(results)
+	for _, r := range results {
+		if r.Error != "" || r.Oversized || r.Slow {
+			s.Failures = append(s.Failures, r)
+		}
+	}
+	s.Status = "passed"
+	if err != nil || len(s.Failures) > 0 {
+		s.Status = "failed"
+	}
+	finished := time.Now().UTC()
+	s.Finished = &finished
+
+	err = putSmokeTest(s)
+	if err != nil {
+		log.Printf("smoke test %s: %v", s.Id, err)
+		return
+	}
+	log.Printf("smoke test %s %s: %d of %d pages failed", s.Id, s.Status, len(s.Failures), s.Total)
+}
+
+// smokeTestPages renders every page with tmplName, bypassing the render
+// cache.
+func smokeTestPages(ctx context.Context, tmplName string) ([]smokeResult, error) {
+	docs, err := listAllDocs(ctx)
+	if err != nil {
+		return nil, docerr.FromStore("ListDocs", err)
+	}
+	var docIds []string
+	for _, d := range docs {
+		if isPage(d.Id) {
+			docIds = append(docIds, d.Id)
+		}
+	}
+
+	results := make([]smokeResult, len(docIds))
+	sem := make(chan struct{}, catalogWorkers)
+	var wg sync.WaitGroup
+	for i, docId := range docIds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, docId string) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = smokeTestPage(ctx, docId, tmplName)
+		}(i, docId)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// smokeTestPage renders the latest revision of docId with tmplName.
+func smokeTestPage(ctx context.Context, docId, tmplName string) smokeResult {
+	r := smokeResult{DocId: docId}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	doc, err := fetchDoc(fetchCtx, docId)
+	cancel()
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Version = doc.meta.Id
+
+	renderCtx, cancel := context.WithTimeout(ctx, templateTimeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := renderWith(renderCtx, docId, doc, tmplName, nil)
+	elapsed := time.Since(start)
+	r.Millis = elapsed.Milliseconds()
+	r.Slow = elapsed > smokeLatencyBudget
+
+	switch {
+	case err != nil:
+		r.Error = err.Error()
+	case resp.Headers[degradedHeader] != "":
+		r.Error = "rendered with the fallback template"
+	}
+	r.Status = resp.StatusCode
+	r.Bytes = len(resp.Body)
+	r.Oversized = r.Bytes > smokeMaxBytes
+	return r
+}
+
+// smokeTestReport reports the smoke test with the id path parameter.
+func smokeTestReport(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	s, err := getSmokeTest(ctx, request.PathParameters["id"])
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(200, s), nil
+}
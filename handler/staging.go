@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/blobstore"
+	"github.com/drocamor/n22t.docstore/metrics"
+	"github.com/drocamor/n22t.docstore/teestore"
+)
+
+// stagingMirror is the docs and revisions tables of a staging site, like
+// "docs-staging/revisions-staging", that successful writes are copied to
+// in the background, so staging always has the content to try template
+// and renderer changes against. See package teestore for what is and
+// isn't promised of the copies.
+var stagingMirror = os.Getenv("STAGING_MIRROR")
+
+// unmirroredDocs are the docs staging keeps its own of: the site config,
+// which describes the deployment, and the server's working state. Their
+// prefixes are in unmirroredPrefixes.
+var (
+	unmirroredDocs = map[string]bool{
+		configDocName:         true,
+		rendersDocName:        true,
+		holdsDocName:          true,
+		draftsDocName:         true,
+		blobstore.RefsDocName: true,
+	}
+	unmirroredPrefixes = []string{draftPrefix, importPrefix, smokeTestPrefix, suggestionPrefix, consistencyPrefix}
+)
+
+// stagingMirrored reports whether writes of docId are copied to staging.
+func stagingMirrored(docId string) bool {
+	if unmirroredDocs[docId] {
+		return false
+	}
+	for _, prefix := range unmirroredPrefixes {
+		if strings.HasPrefix(docId, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// withStagingMirror copies the writes to ds to stagingMirror, if it is
+// set, opening it with open.
+func withStagingMirror(ds docstore.DocStore, open func(where string) docstore.DocStore) docstore.DocStore {
+	if stagingMirror == "" || mirrorMode {
+		return ds
+	}
+	log.Printf("copying writes to %s", stagingMirror)
+	return teestore.New(ds, open(stagingMirror),
+		teestore.WithFilter(stagingMirrored),
+		teestore.WithErrorHandler(func(docId string, err error) {
+			log.Printf("copying %s to staging: %v", docId, err)
+			metrics.Incr("StagingMirrorErrors", nil)
+		}),
+	)
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"gopkg.in/yaml.v2"
+)
+
+// statusDocName holds the status page's components, for example:
+//
+//	components:
+//	  - name: API
+//	    state: operational
+//	  - name: Search
+//	    state: degraded
+//	    description: Results may be a few minutes out of date.
+//	incidents: incidents
+//
+// Incidents names a log doc holding the incident history. Components are
+// updated by writing the doc through the API.
+const statusDocName = "_status"
+
+// Component states from best to worst.
+var componentStates = []string{"operational", "maintenance", "degraded", "partial_outage", "major_outage"}
+
+type statusComponent struct {
+	Name        string `yaml:"name" json:"name"`
+	State       string `yaml:"state" json:"state"`
+	Description string `yaml:"description" json:"description,omitempty"`
+}
+
+type statusDoc struct {
+	Components []statusComponent `yaml:"components"`
+	Incidents  string            `yaml:"incidents"`
+}
+
+func stateRank(state string) int {
+	for i, s := range componentStates {
+		if s == state {
+			return i
+		}
+	}
+	return -1
+}
+
+// overall is the worst state of any component.
+func (s statusDoc) overall() string {
+	worst := componentStates[0]
+	for _, c := range s.Components {
+		if stateRank(c.State) > stateRank(worst) {
+			worst = c.State
+		}
+	}
+	return worst
+}
+
+// lintStatus checks the body of the status doc.
+func lintStatus(body []byte) (problems []string) {
+	var s statusDoc
+	err := yaml.UnmarshalStrict(body, &s)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	for _, c := range s.Components {
+		if stateRank(c.State) < 0 {
+			problems = append(problems, fmt.Sprintf("component %q: unknown state %q", c.Name, c.State))
+		}
+	}
+	return
+}
+
+// getStatus reads the status doc. A missing one means no components.
+func getStatus(ctx context.Context) (s statusDoc, updated time.Time, err error) {
+	doc, err := fetchDoc(ctx, statusDocName)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return s, updated, nil
+	}
+	if err != nil {
+		return
+	}
+
+	err = yaml.Unmarshal(doc.body, &s)
+	if err != nil {
+		return s, updated, docerr.E("parse "+statusDocName, docerr.ErrBackend, err)
+	}
+	return s, doc.meta.Timestamp, nil
+}
+
+// stateLabel turns a state like "partial_outage" into "Partial outage".
+func stateLabel(state string) string {
+	s := strings.Replace(state, "_", " ", -1)
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// serveStatus renders the status page: the overall state, each component,
+// and the incident history, through the page template.
+func serveStatus(ctx context.Context) (Response, error) {
+	tf := renderFrom(ctx).tf
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	s, updated, err := getStatus(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var b strings.Builder
+	overall := s.overall()
+	fmt.Fprintf(&b, "<div class=\"status status-%s\">%s</div>\n", overall, html.EscapeString(stateLabel(overall)))
+	b.WriteString("<ul class=\"components\">\n")
+	for _, c := range s.Components {
+		fmt.Fprintf(&b, "<li class=\"status-%s\"><strong>%s</strong> %s",
+			html.EscapeString(c.State), html.EscapeString(c.Name), html.EscapeString(stateLabel(c.State)))
+		if c.Description != "" {
+			fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(c.Description))
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n")
+
+	if s.Incidents != "" {
+		incidents, err := fetchDoc(ctx, s.Incidents)
+		if err != nil && !errors.Is(err, docerr.ErrNotFound) {
+			return Response{}, err
+		}
+		_, body := splitFrontMatter(s.Incidents, incidents.body)
+		_, entries := splitLog(body)
+		b.WriteString("<h2>Incident history</h2>\n")
+		if len(entries) == 0 {
+			b.WriteString("<p>No incidents reported.</p>\n")
+		}
+		b.WriteString(renderLog(entries, tf, getConfig(ctx).stripsHTML(s.Incidents)))
+	}
+
+	meta := docMetadata{Title: "Status", DocBody: b.String()}
+	if !updated.IsZero() {
+		meta.Timestamp, meta.TimestampISO = tf.format(updated), tf.iso(updated)
+		meta.UpdatedAgo = ago(updated, time.Now())
+	}
+
+	resp, err := renderPage(ctx, tmplDocName, meta)
+	if err != nil {
+		return resp, err
+	}
+	return withHeaders(resp, map[string]string{"Cache-Control": "no-cache"}), nil
+}
+
+// statusJSON reports the overall and per component states.
+func statusJSON(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	s, updated, err := getStatus(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	components := s.Components
+	if components == nil {
+		components = []statusComponent{}
+	}
+
+	body := struct {
+		Status     string            `json:"status"`
+		Updated    *time.Time        `json:"updated,omitempty"`
+		Components []statusComponent `json:"components"`
+	}{Status: s.overall(), Components: components}
+	if !updated.IsZero() {
+		body.Updated = &updated
+	}
+
+	return withHeaders(jsonResponse(200, body), map[string]string{"Cache-Control": "no-cache"}), nil
+}
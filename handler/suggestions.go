@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/textdiff"
+)
+
+// suggestionPrefix starts the ids of the docs holding suggested edits, one
+// per suggestion, like "_suggestion.3f9a0c12d4e5b6a7". Like other "_" docs
+// they're never served to readers.
+const suggestionPrefix = "_suggestion."
+
+// suggestion is an edit proposed by a reader, pending until an editor
+// accepts or rejects it. Accepting writes Body as a new revision of DocId.
+type suggestion struct {
+	Id          string    `json:"id"`
+	DocId       string    `json:"docId"`
+	BaseVersion int       `json:"baseVersion"`
+	Body        string    `json:"body"`
+	Note        string    `json:"note,omitempty"`
+	Submitted   time.Time `json:"submitted"`
+	Status      string    `json:"status"` // pending, accepted or rejected
+	Version     int       `json:"version,omitempty"`
+}
+
+func getSuggestion(ctx context.Context, id string) (suggestion, error) {
+	var s suggestion
+	op := "suggestion " + id
+	if id == "" || docstore.ValidateDocId(id) != nil || strings.Contains(id, ".") {
+		return s, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	flights.Forget("doc:" + suggestionPrefix + id)
+	doc, err := fetchDoc(ctx, suggestionPrefix+id)
+	if err != nil {
+		return s, err
+	}
+
+	err = json.Unmarshal(doc.body, &s)
+	if err != nil {
+		return s, docerr.E("parse "+op, docerr.ErrBackend, err)
+	}
+	return s, nil
+}
+
+func putSuggestion(s suggestion) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(suggestionPrefix+s.Id, bytes.NewReader(b))
+	if err != nil {
+		return docerr.FromStore("PutRevision "+suggestionPrefix+s.Id, err)
+	}
+	return nil
+}
+
+// suggestEdit stores the body as a suggested edit of the docId path
+// parameter, for anyone to call: {"body": "...", "note": "fix typo"}. It is
+// checked like a write, and suggestions with problems are refused.
+func suggestEdit(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := request.PathParameters["docId"]
+	op := "suggest " + docId
+	if !isPage(docId) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	if _, _, err := checkAccess(ctx, request, docId); err != nil {
+		return Response{}, err
+	}
+
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	var s suggestion
+	err = json.Unmarshal(body, &s)
+	if err != nil || s.Body == "" {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"body": "the suggested text is required"})
+	}
+
+	res, err := planWrite(withRole(ctx, roleAnonymous), docId, []byte(s.Body))
+	if err != nil {
+		return Response{}, err
+	}
+	if res.Action == "create" {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	if len(res.Problems) > 0 {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"docId": docId, "problems": res.Problems})
+	}
+	if res.Action == "unchanged" {
+		return jsonResponse(200, res), nil
+	}
+
+	s = suggestion{
+		Id:          randomHex(8),
+		DocId:       docId,
+		BaseVersion: res.BaseVersion,
+		Body:        s.Body,
+		Note:        s.Note,
+		Submitted:   time.Now().UTC(),
+		Status:      "pending",
+	}
+	err = putSuggestion(s)
+	if err != nil {
+		return Response{}, err
+	}
+	notifySuggestion(ctx, s)
+
+	return jsonResponse(202, struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}{s.Id, s.Status}), nil
+}
+
+// notifySuggestion asks the site's editors to review a new suggestion. A
+// notification that can't be sent doesn't fail the suggestion, which is
+// still in the review queue.
+func notifySuggestion(ctx context.Context, s suggestion) {
+	notifier := getNotifier()
+	if notifier == nil {
+		return
+	}
+	m := struct {
+		Type   string `json:"type"`
+		Id     string `json:"id"`
+		DocId  string `json:"docId"`
+		Note   string `json:"note,omitempty"`
+		Review string `json:"review,omitempty"`
+	}{Type: "suggestion.pending", Id: s.Id, DocId: s.DocId, Note: s.Note}
+	if base := getConfig(ctx).BaseURL; base != "" {
+		m.Review = strings.TrimSuffix(base, "/") + apiV1 + "admin/suggestions/" + s.Id
+	}
+	err := notifier.Notify(ctx, notification{
+		Type:    m.Type,
+		Subject: "Suggested edit of " + s.DocId,
+		Text:    strings.TrimSpace(strings.Join([]string{s.Note, m.Review}, "\n")),
+		Message: m,
+	})
+	if err != nil {
+		log.Printf("notify suggestion %s: %v", s.Id, err)
+	}
+}
+
+// suggestionSummary describes a suggestion in the review queue.
+type suggestionSummary struct {
+	Id          string    `json:"id"`
+	DocId       string    `json:"docId"`
+	BaseVersion int       `json:"baseVersion"`
+	Note        string    `json:"note,omitempty"`
+	Submitted   time.Time `json:"submitted"`
+}
+
+// listSuggestions reports the pending suggestions, oldest first.
+func listSuggestions(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docs, err := listAllDocs(ctx)
+	if err != nil {
+		return Response{}, docerr.FromStore("ListDocs", err)
+	}
+
+	queue := []suggestionSummary{}
+	for _, d := range docs {
+		if !strings.HasPrefix(d.Id, suggestionPrefix) {
+			continue
+		}
+		s, err := getSuggestion(ctx, strings.TrimPrefix(d.Id, suggestionPrefix))
+		if err != nil {
+			return Response{}, err
+		}
+		if s.Status == "pending" {
+			queue = append(queue, suggestionSummary{s.Id, s.DocId, s.BaseVersion, s.Note, s.Submitted})
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool { return queue[i].Submitted.Before(queue[j].Submitted) })
+
+	return jsonResponse(200, struct {
+		Suggestions []suggestionSummary `json:"suggestions"`
+	}{queue}), nil
+}
+
+// reviewSuggestion reports the suggestion with the id path parameter and
+// how it changes the revision it was made against.
+func reviewSuggestion(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	s, err := getSuggestion(ctx, request.PathParameters["id"])
+	if err != nil {
+		return Response{}, err
+	}
+
+	base, err := fetchRevision(ctx, s.DocId, s.BaseVersion)
+	if err != nil {
+		return Response{}, err
+	}
+	diff := textdiff.Lines(string(base.body), s.Body)
+	deleted, inserted := textdiff.Stats(diff)
+
+	return jsonResponse(200, struct {
+		suggestion
+		Deleted  int    `json:"deleted"`
+		Inserted int    `json:"inserted"`
+		Diff     string `json:"diff"`
+	}{s, deleted, inserted, diffText(diff)}), nil
+}
+
+// pendingSuggestion gets the suggestion with the id path parameter,
+// refusing it if it has already been reviewed.
+func pendingSuggestion(ctx context.Context, request events.APIGatewayProxyRequest) (suggestion, error) {
+	s, err := getSuggestion(ctx, request.PathParameters["id"])
+	if err != nil {
+		return s, err
+	}
+	if s.Status != "pending" {
+		return s, docerr.WithDetails("suggestion "+s.Id, docerr.ErrConflict, nil,
+			map[string]interface{}{"status": s.Status})
+	}
+	return s, nil
+}
+
+// acceptSuggestion writes the suggestion with the id path parameter as a
+// new revision of its doc. A suggestion made against a revision that is no
+// longer the latest is refused, so it can't silently undo later edits,
+// unless ?force=true is given.
+func acceptSuggestion(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	s, err := pendingSuggestion(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	latest, err := fetchDoc(ctx, s.DocId)
+	if err != nil && !errors.Is(err, docerr.ErrNotFound) {
+		return Response{}, err
+	}
+	if latest.meta.Id != s.BaseVersion && request.QueryStringParameters["force"] != "true" {
+		return Response{}, docerr.WithDetails("accept "+s.Id, docerr.ErrConflict, nil,
+			map[string]interface{}{"baseVersion": s.BaseVersion, "latest": latest.meta.Id})
+	}
+
+	msg := s.Note
+	if msg == "" {
+		msg = "Accepted suggestion " + s.Id
+	}
+	ctx = withRevisionMessage(ctx, msg)
+	res, err := writeDoc(ctx, s.DocId, []byte(s.Body), false)
+	if err != nil {
+		return Response{}, err
+	}
+
+	s.Status, s.Version = "accepted", res.Version
+	err = putSuggestion(s)
+	if err != nil {
+		return Response{}, err
+	}
+
+	audit(request, "suggestion.accept", s.DocId, map[string]interface{}{"suggestion": s.Id, "version": res.Version})
+	return jsonResponse(200, res), nil
+}
+
+// rejectSuggestion closes the suggestion with the id path parameter
+// without changing its doc.
+func rejectSuggestion(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	s, err := pendingSuggestion(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	s.Status = "rejected"
+	err = putSuggestion(s)
+	if err != nil {
+		return Response{}, err
+	}
+
+	audit(request, "suggestion.reject", s.DocId, map[string]interface{}{"suggestion": s.Id})
+	return Response{StatusCode: 204}, nil
+}
@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// summarySuffix follows /docs/{docId} for the doc's abstract.
+const summarySuffix = "/summary"
+
+var (
+	// summaryProvider writes docs' abstracts: "extractive" picks the doc's
+	// most representative sentences in process, and "bedrock" or "openai"
+	// has the SUMMARY_MODEL language model write one. Summaries are off
+	// when it is unset.
+	summaryProvider = os.Getenv("SUMMARY_PROVIDER")
+
+	// summariesTable is the DynamoDB table abstracts are kept in, keyed by
+	// DocId and Version. Without it they are only kept for the life of a
+	// warm container.
+	summariesTable = os.Getenv("SUMMARIES_TABLE")
+
+	summaries = &summaryCache{entries: map[string]string{}}
+
+	summarizerOnce sync.Once
+	docSummarizer  summarizer
+)
+
+// summarizer writes an abstract of a doc from its title and text.
+type summarizer interface {
+	Summarize(ctx context.Context, title, text string) (string, error)
+
+	// Local reports whether summaries are cheap enough to write while
+	// rendering a page.
+	Local() bool
+}
+
+// getSummarizer returns the configured summarizer, or nil if summaries are
+// off.
+func getSummarizer() summarizer {
+	summarizerOnce.Do(func() {
+		switch summaryProvider {
+		case "":
+		case "extractive":
+			docSummarizer = extractiveSummarizer{sentences: 3}
+		default:
+			lm, err := newLanguageModel(summaryProvider, os.Getenv("SUMMARY_MODEL"))
+			if err != nil {
+				log.Printf("ignoring SUMMARY_PROVIDER: %v", err)
+				return
+			}
+			docSummarizer = modelSummarizer{lm}
+		}
+	})
+	return docSummarizer
+}
+
+type summaryCache struct {
+	sync.Mutex
+	entries map[string]string
+}
+
+func (c *summaryCache) get(key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	s, ok := c.entries[key]
+	return s, ok
+}
+
+func (c *summaryCache) put(key, s string) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = s
+}
+
+// storedSummary is an abstract of a revision, as kept in summariesTable.
+type storedSummary struct {
+	DocId   string
+	Version int
+	Summary string
+}
+
+// docAbstract returns the abstract of revision version of docId, writing
+// it if it isn't kept already.
+func docAbstract(ctx context.Context, docId string, version int, title, text string) (string, error) {
+	s := getSummarizer()
+	if s == nil {
+		return "", docerr.E("summary "+docId, docerr.ErrNotFound, nil)
+	}
+
+	key := fmt.Sprintf("%s@%d", docId, version)
+	if abstract, ok := summaries.get(key); ok {
+		return abstract, nil
+	}
+
+	ch := flights.DoChan("summary:"+key, func() (interface{}, error) {
+		ctx := context.Background()
+		var itemKey map[string]*dynamodb.AttributeValue
+		if summariesTable != "" {
+			itemKey, _ = dynamodbattribute.MarshalMap(struct {
+				DocId   string
+				Version int
+			}{docId, version})
+			out, err := dynamo().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+				TableName: aws.String(summariesTable),
+				Key:       itemKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if out.Item != nil {
+				var stored storedSummary
+				err = dynamodbattribute.UnmarshalMap(out.Item, &stored)
+				if err != nil {
+					return nil, err
+				}
+				summaries.put(key, stored.Summary)
+				return stored.Summary, nil
+			}
+		}
+
+		abstract, err := s.Summarize(ctx, title, text)
+		if err != nil {
+			return nil, err
+		}
+		if summariesTable != "" {
+			item, err := dynamodbattribute.MarshalMap(storedSummary{docId, version, abstract})
+			if err == nil {
+				_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+					TableName: aws.String(summariesTable),
+					Item:      item,
+				})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		summaries.put(key, abstract)
+		return abstract, nil
+	})
+	v, err := await(ctx, "summary "+key, ch)
+	if err != nil {
+		return "", docerr.E("summary "+key, docerr.ErrBackend, err)
+	}
+	return v.(string), nil
+}
+
+// quickAbstract returns the abstract of a revision if it can be had
+// without waiting on a remote summarizer: one already kept, or one the
+// summarizer writes in process. Otherwise it returns "".
+func quickAbstract(ctx context.Context, docId string, version int, title, text string) string {
+	s := getSummarizer()
+	if s == nil {
+		return ""
+	}
+	if abstract, ok := summaries.get(fmt.Sprintf("%s@%d", docId, version)); ok || !s.Local() {
+		return abstract
+	}
+	abstract, err := docAbstract(ctx, docId, version, title, text)
+	if err != nil {
+		log.Printf("summary of %s: %v", docId, err)
+	}
+	return abstract
+}
+
+// serveSummary answers /docs/{docId}/summary with the abstract of the
+// latest revision of docId.
+func serveSummary(ctx context.Context, docId string) (Response, error) {
+	if getSummarizer() == nil || !isPage(docId) {
+		return Response{}, docerr.E("summary "+docId, docerr.ErrNotFound, nil)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	doc, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	d := summarize(docId, doc)
+	abstract, err := docAbstract(ctx, docId, d.Version, d.Title, d.text)
+	if err != nil {
+		return Response{}, err
+	}
+	resp := jsonResponse(200, struct {
+		DocId   string `json:"docId"`
+		Version int    `json:"version"`
+		Title   string `json:"title"`
+		Summary string `json:"summary"`
+	}{docId, d.Version, d.Title, abstract})
+	return withHeaders(resp, validators(doc.meta.Id, doc.meta.Timestamp, resp.Body)), nil
+}
+
+// extractiveSummarizer picks the sentences whose words are most common in
+// the doc, in the order they appear.
+type extractiveSummarizer struct {
+	sentences int
+}
+
+var sentenceEnd = regexp.MustCompile(`[.!?](\s+|$)`)
+
+func (e extractiveSummarizer) Local() bool { return true }
+
+func (e extractiveSummarizer) Summarize(ctx context.Context, title, text string) (string, error) {
+	var sentences []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		// Headings and list items without a sentence in them, like the
+		// title, say little on their own.
+		if line == "" || line == title || !sentenceEnd.MatchString(line) {
+			continue
+		}
+		start := 0
+		for _, m := range sentenceEnd.FindAllStringIndex(line, -1) {
+			sentences = append(sentences, strings.TrimSpace(line[start:m[1]]))
+			start = m[1]
+		}
+		if rest := strings.TrimSpace(line[start:]); rest != "" {
+			sentences = append(sentences, rest)
+		}
+	}
+	if len(sentences) <= e.sentences {
+		return strings.Join(sentences, " "), nil
+	}
+
+	freq := map[string]int{}
+	for _, s := range sentences {
+		for _, w := range searchTerms(s) {
+			if len(w) > 3 {
+				freq[w]++
+			}
+		}
+	}
+
+	type scored struct {
+		i     int
+		score float64
+	}
+	ranked := make([]scored, len(sentences))
+	for i, s := range sentences {
+		words := searchTerms(s)
+		total := 0
+		for _, w := range words {
+			total += freq[w]
+		}
+		ranked[i] = scored{i, float64(total) / math.Sqrt(float64(len(words)+1))}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	ranked = ranked[:e.sentences]
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].i < ranked[j].i })
+
+	picked := make([]string, len(ranked))
+	for i, r := range ranked {
+		picked[i] = sentences[r.i]
+	}
+	return strings.Join(picked, " "), nil
+}
+
+// modelSummarizer has a language model write abstracts.
+type modelSummarizer struct {
+	lm languageModel
+}
+
+// maxModelText is how much of a doc the model is given.
+const maxModelText = 50000
+
+func (m modelSummarizer) Local() bool { return false }
+
+func (m modelSummarizer) Summarize(ctx context.Context, title, text string) (string, error) {
+	if len(text) > maxModelText {
+		text = text[:maxModelText]
+	}
+	return m.lm.Complete(ctx, "Write a two or three sentence abstract of this document for a search result preview. "+
+		"Reply with the abstract alone.\n\nTitle: "+title+"\n\n"+text, 300)
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// maxSuggestedTags is how many tags are suggested for a doc saved without
+// any.
+const maxSuggestedTags = 5
+
+var (
+	// tagProvider suggests tags for docs saved without them: "tfidf", the
+	// default, picks the doc's most distinctive words and the site's
+	// existing tags it mentions, and "bedrock" or "openai" has the
+	// TAG_MODEL language model choose them. "off" turns suggestions off.
+	tagProvider = envString("TAG_PROVIDER", "tfidf")
+
+	tagModelOnce sync.Once
+	tagModel     languageModel
+
+	tagCorpora = &tagCorpusCache{}
+
+	// commonWords say little about what a doc is about.
+	commonWords = map[string]bool{
+		"about": true, "after": true, "also": true, "been": true, "before": true,
+		"but": true, "each": true, "from": true, "have": true, "here": true,
+		"into": true, "just": true, "like": true, "more": true, "most": true,
+		"must": true, "need": true, "only": true, "other": true, "over": true,
+		"same": true, "should": true, "some": true, "than": true, "that": true,
+		"their": true, "them": true, "then": true, "there": true, "these": true,
+		"they": true, "this": true, "those": true, "use": true, "used": true,
+		"uses": true, "using": true, "very": true, "were": true, "will": true,
+		"without": true, "would": true, "your": true, "you": true,
+	}
+)
+
+// tagCorpus is what the site's listed docs say about how distinctive a
+// word is: how many docs use it, and the tags already in use.
+type tagCorpus struct {
+	docs int
+	df   map[string]int
+	tags map[string]string // lowercase tag to tag as first written
+}
+
+// tagCorpusCache holds the corpus built from the catalog, rebuilt when it
+// is older than catalogTTL.
+type tagCorpusCache struct {
+	sync.Mutex
+	corpus *tagCorpus
+	built  time.Time
+}
+
+func (c *tagCorpusCache) get(ctx context.Context) (*tagCorpus, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.corpus != nil && time.Since(c.built) < catalogTTL {
+		return c.corpus, nil
+	}
+
+	docs, err := getCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	corpus := &tagCorpus{docs: len(docs), df: map[string]int{}, tags: map[string]string{}}
+	for _, d := range docs {
+		for w := range tagWords(d.Title + "\n" + d.text) {
+			corpus.df[w]++
+		}
+		for _, t := range d.fm.Tags {
+			if _, ok := corpus.tags[strings.ToLower(t)]; !ok && strings.TrimSpace(t) != "" {
+				corpus.tags[strings.ToLower(t)] = t
+			}
+		}
+	}
+	c.corpus, c.built = corpus, time.Now()
+	return corpus, nil
+}
+
+// tagWords counts the lowercase words of text.
+func tagWords(text string) map[string]int {
+	counts := map[string]int{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '-'
+	}) {
+		if w = strings.Trim(w, "-"); w != "" {
+			counts[w]++
+		}
+	}
+	return counts
+}
+
+// newTag reports whether w could be suggested as a tag of its own: longer
+// than three letters, not all digits, and not common. The site's existing
+// tags may be any words.
+func newTag(w string) bool {
+	return len(w) > 3 && !commonWords[w] && !questionWords[w] && strings.IndexFunc(w, unicode.IsLetter) >= 0
+}
+
+// suggestTags returns tags for the page docId if body's front matter has
+// none, for the author to accept, or nil. Suggestions are best effort: a
+// failure is logged rather than holding up the write.
+func suggestTags(ctx context.Context, docId string, body []byte) []string {
+	if tagProvider == "off" || !isPage(docId) {
+		return nil
+	}
+	fm, md := splitFrontMatter(docId, body)
+	if len(fm.Tags) > 0 {
+		return nil
+	}
+	title := fm.title(md)
+	public, _ := filterAudience(md, audiences{})
+	text := docText(public)
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	corpus, err := tagCorpora.get(ctx)
+	if err != nil {
+		log.Printf("suggest tags for %s: %v", docId, err)
+		return nil
+	}
+	if lm := getTagModel(); lm != nil {
+		tags, err := modelTags(ctx, lm, corpus, title, text)
+		if err == nil {
+			return tags
+		}
+		log.Printf("suggest tags for %s: %v", docId, err)
+	}
+	return corpus.suggest(title, text)
+}
+
+// suggest scores the words of a doc by TF-IDF against the corpus, with
+// words of the title counting double, and returns the best. The site's
+// existing tags the doc mentions are preferred, so that docs share tags
+// rather than each getting its own.
+func (c *tagCorpus) suggest(title, text string) []string {
+	tf := tagWords(text)
+	for w, n := range tagWords(title) {
+		tf[w] += 2 * n
+	}
+	tfidf := func(w string) float64 {
+		n := tf[w]
+		if n == 0 {
+			return 0
+		}
+		return (1 + math.Log(float64(n))) * math.Log(float64(c.docs+1)/float64(c.df[w]+1))
+	}
+
+	type candidate struct {
+		tag   string
+		score float64
+	}
+	var candidates []candidate
+	taken := map[string]bool{}
+	for lower, tag := range c.tags {
+		score := 0.0
+		words := tagWords(lower)
+		if len(words) == 0 {
+			continue
+		}
+		for w := range words {
+			s := tfidf(w)
+			if s == 0 {
+				score = 0
+				break
+			}
+			score += s
+		}
+		if score > 0 {
+			candidates = append(candidates, candidate{tag, 2 * score / float64(len(words))})
+			for w := range words {
+				taken[w] = true
+			}
+		}
+	}
+	for w := range tf {
+		// A word seen once, and not in the title, is rarely the subject.
+		if !taken[w] && tf[w] > 1 && newTag(w) {
+			candidates = append(candidates, candidate{w, tfidf(w)})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].tag < candidates[j].tag
+	})
+	var tags []string
+	for _, cand := range candidates {
+		if len(tags) == maxSuggestedTags || cand.score <= 0 {
+			break
+		}
+		tags = append(tags, cand.tag)
+	}
+	return tags
+}
+
+// getTagModel returns the language model suggesting tags, or nil if tags
+// are suggested by TF-IDF.
+func getTagModel() languageModel {
+	tagModelOnce.Do(func() {
+		switch tagProvider {
+		case "tfidf", "off":
+			return
+		}
+		lm, err := newLanguageModel(tagProvider, os.Getenv("TAG_MODEL"))
+		if err != nil {
+			log.Printf("ignoring TAG_PROVIDER: %v", err)
+			return
+		}
+		tagModel = lm
+	})
+	return tagModel
+}
+
+// modelTags has lm choose tags for a doc, preferring the site's existing
+// ones.
+func modelTags(ctx context.Context, lm languageModel, corpus *tagCorpus, title, text string) ([]string, error) {
+	existing := make([]string, 0, len(corpus.tags))
+	for _, t := range corpus.tags {
+		existing = append(existing, t)
+	}
+	sort.Strings(existing)
+	if len(text) > maxModelText {
+		text = text[:maxModelText]
+	}
+
+	reply, err := lm.Complete(ctx, "Suggest up to five short tags for this document. "+
+		"Use the site's existing tags where they fit: "+strings.Join(existing, ", ")+". "+
+		"Reply with the tags alone, separated by commas.\n\nTitle: "+title+"\n\n"+text, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(reply, ",") {
+		t = strings.Trim(strings.TrimSpace(t), `"'.`)
+		if t == "" || len(t) > 40 || seen[strings.ToLower(t)] {
+			continue
+		}
+		if known, ok := corpus.tags[strings.ToLower(t)]; ok {
+			t = known
+		}
+		seen[strings.ToLower(t)] = true
+		tags = append(tags, t)
+		if len(tags) == maxSuggestedTags {
+			break
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestMissingTemplate(t *testing.T) {
+	store := newMemStore()
+	store.put("page", "# Page")
+	store.put("themed", "---\ntemplate: nothing\n---\n# Themed")
+	useStore(store)
+
+	request := func(docId string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Path: "/" + docId, PathParameters: map[string]string{"docId": docId}}
+	}
+
+	// A page that exists but can't be rendered is a template error, not a
+	// missing page.
+	resp, err := Handler(context.Background(), request("page"))
+	if err != nil || resp.StatusCode != 500 {
+		t.Errorf("page without a template: status %d, err %v, want 500", resp.StatusCode, err)
+	}
+
+	// A page choosing a template that doesn't exist gets the default one.
+	store.put(tmplDocName, testTemplate)
+	useStore(store)
+	resp, err = Handler(context.Background(), request("themed"))
+	if err != nil || resp.StatusCode != 200 {
+		t.Errorf("page with a missing template: status %d, err %v, want 200", resp.StatusCode, err)
+	}
+}
@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+// tenantConfig is one team hosted on a shared deployment, owning the docs
+// whose ids start with its prefix, for example:
+//
+//	tenants:
+//	  payments:
+//	    prefix: payments-
+//	    tags:
+//	      costCenter: "1234"
+//	    quota:
+//	      storage: 104857600
+//	      requests: 1000000
+//
+// Tags are reported with the tenant's usage and its metrics, for billing.
+// Storage is in bytes across the tenant's docs and requests are page views
+// per calendar month; zero or unset is no limit.
+type tenantConfig struct {
+	Prefix string            `yaml:"prefix"`
+	Tags   map[string]string `yaml:"tags"`
+	Quota  struct {
+		Storage  int64 `yaml:"storage"`
+		Requests int64 `yaml:"requests"`
+	} `yaml:"quota"`
+}
+
+var (
+	// tenantUsageTable is the DynamoDB table holding each tenant's usage,
+	// keyed by Tenant and Period: a month like "2026-10" for request
+	// counts, or storagePeriod for bytes stored. Usage isn't tracked when
+	// it is unset.
+	tenantUsageTable = os.Getenv("TENANT_USAGE_TABLE")
+
+	// tenantUsageTimeout bounds how long tracking usage can delay a
+	// response.
+	tenantUsageTimeout = envDuration("TENANT_USAGE_TIMEOUT", time.Second)
+)
+
+// storagePeriod is the Period of the item holding a tenant's bytes stored.
+const storagePeriod = "storage"
+
+// tenantOf returns the tenant owning docId, the one with the longest
+// matching prefix, if any.
+func tenantOf(cfg *siteConfig, docId string) (name string, t tenantConfig, ok bool) {
+	for n, c := range cfg.Tenants {
+		if c.Prefix != "" && strings.HasPrefix(docId, c.Prefix) && (!ok || len(c.Prefix) > len(t.Prefix)) {
+			name, t, ok = n, c, true
+		}
+	}
+	return
+}
+
+// tenantDims are the metric dimensions for a tenant: its name and tags.
+func tenantDims(name string, t tenantConfig) map[string]string {
+	dims := map[string]string{"Tenant": name}
+	for k, v := range t.Tags {
+		dims[k] = v
+	}
+	return dims
+}
+
+// addUsage adds n to attr of the tenant's usage item for period, returning
+// the new total.
+func addUsage(ctx context.Context, tenant, period, attr string, n int64) (int64, error) {
+	key, err := dynamodbattribute.MarshalMap(struct{ Tenant, Period string }{tenant, period})
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := dynamo().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tenantUsageTable),
+		Key:                       key,
+		UpdateExpression:          aws.String("ADD #a :n"),
+		ExpressionAttributeNames:  map[string]*string{"#a": aws.String(attr)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":n": {N: aws.String(strconv.FormatInt(n, 10))}},
+		ReturnValues:              aws.String("UPDATED_NEW"),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	total, _ := strconv.ParseInt(aws.StringValue(out.Attributes[attr].N), 10, 64)
+	return total, nil
+}
+
+// getUsage reads attr of the tenant's usage item for period.
+func getUsage(ctx context.Context, tenant, period, attr string) (int64, error) {
+	key, err := dynamodbattribute.MarshalMap(struct{ Tenant, Period string }{tenant, period})
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := dynamo().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tenantUsageTable),
+		Key:       key,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	v, ok := out.Item[attr]
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+}
+
+// withTenantUsage counts page requests against the tenant owning the page,
+// refusing them once the tenant is over its monthly request quota.
+func withTenantUsage(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		docId, ok := request.PathParameters["docId"]
+		if !ok || request.HTTPMethod != "GET" || strings.HasPrefix(request.Path, apiPrefix) {
+			return next(ctx, request)
+		}
+
+		name, t, ok := tenantOf(getConfig(ctx), docId)
+		if !ok {
+			return next(ctx, request)
+		}
+		metrics.Incr("TenantRequests", tenantDims(name, t))
+
+		if tenantUsageTable == "" {
+			return next(ctx, request)
+		}
+
+		usageCtx, cancel := context.WithTimeout(ctx, tenantUsageTimeout)
+		count, err := addUsage(usageCtx, name, time.Now().UTC().Format("2006-01"), "Requests", 1)
+		cancel()
+		if err != nil {
+			log.Printf("tenant usage error: %v", err)
+			return next(ctx, request)
+		}
+
+		if t.Quota.Requests > 0 && count > t.Quota.Requests {
+			return Response{}, docerr.WithDetails("tenant "+name, docerr.ErrQuotaExceeded, nil, map[string]interface{}{
+				"tenant": name,
+				"quota":  "requests",
+				"limit":  t.Quota.Requests,
+			})
+		}
+
+		return next(ctx, request)
+	}
+}
+
+// checkTenantStorage refuses a write growing the tenant owning docId by
+// delta bytes if that would put it over its storage quota.
+func checkTenantStorage(ctx context.Context, docId string, delta int64) error {
+	name, t, ok := tenantOf(getConfig(ctx), docId)
+	if !ok || t.Quota.Storage <= 0 || tenantUsageTable == "" || delta <= 0 {
+		return nil
+	}
+
+	used, err := getUsage(ctx, name, storagePeriod, "Bytes")
+	if err != nil {
+		return docerr.E("tenant usage "+name, docerr.ErrBackend, err)
+	}
+	if used+delta > t.Quota.Storage {
+		return docerr.WithDetails("write "+docId, docerr.ErrTooLarge, nil, map[string]interface{}{
+			"docId":  docId,
+			"quota":  "tenant",
+			"tenant": name,
+			"limit":  t.Quota.Storage,
+			"size":   used + delta,
+		})
+	}
+	return nil
+}
+
+// recordTenantStorage adds a write's change in size to the usage of the
+// tenant owning docId.
+func recordTenantStorage(ctx context.Context, docId string, delta int64) {
+	name, t, ok := tenantOf(getConfig(ctx), docId)
+	if !ok {
+		return
+	}
+	metrics.Incr("TenantWrites", tenantDims(name, t))
+
+	if tenantUsageTable == "" || delta == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tenantUsageTimeout)
+	defer cancel()
+
+	_, err := addUsage(ctx, name, storagePeriod, "Bytes", delta)
+	if err != nil {
+		log.Printf("tenant usage error: %v", err)
+	}
+}
+
+type tenantUsage struct {
+	Tenant   string            `json:"tenant"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Bytes    int64             `json:"bytes"`
+	Requests map[string]int64  `json:"requests"`
+	Quota    struct {
+		Storage  int64 `json:"storage,omitempty"`
+		Requests int64 `json:"requests,omitempty"`
+	} `json:"quota"`
+}
+
+// tenantStats reports each tenant's bytes stored and requests per month,
+// with its tags and quotas.
+func tenantStats(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	if tenantUsageTable == "" {
+		return Response{}, docerr.E("tenant stats", docerr.ErrNotFound, nil)
+	}
+
+	cfg := getConfig(ctx)
+	tenants := map[string]*tenantUsage{}
+	usage := func(name string) *tenantUsage {
+		u, ok := tenants[name]
+		if !ok {
+			t := cfg.Tenants[name]
+			u = &tenantUsage{Tenant: name, Tags: t.Tags, Requests: map[string]int64{}}
+			u.Quota.Storage, u.Quota.Requests = t.Quota.Storage, t.Quota.Requests
+			tenants[name] = u
+		}
+		return u
+	}
+	for name := range cfg.Tenants {
+		usage(name)
+	}
+
+	var scanErr error
+	err := dynamo().ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tenantUsageTable),
+	}, func(out *dynamodb.ScanOutput, last bool) bool {
+		var items []struct {
+			Tenant, Period  string
+			Bytes, Requests int64
+		}
+		scanErr = dynamodbattribute.UnmarshalListOfMaps(out.Items, &items)
+
+		for _, item := range items {
+			u := usage(item.Tenant)
+			if item.Period == storagePeriod {
+				u.Bytes = item.Bytes
+			} else {
+				u.Requests[item.Period] = item.Requests
+			}
+		}
+		return scanErr == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return Response{}, docerr.E("tenant stats", docerr.ErrBackend, err)
+	}
+
+	var report []tenantUsage
+	for _, u := range tenants {
+		report = append(report, *u)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Tenant < report[j].Tenant })
+
+	return jsonResponse(http.StatusOK, struct {
+		Tenants []tenantUsage `json:"tenants"`
+	}{report}), nil
+}
+
+// baseSize returns the size of the latest revision of docId, or zero if it
+// doesn't exist yet.
+func baseSize(ctx context.Context, docId string) (int64, error) {
+	doc, err := fetchDoc(ctx, docId)
+	if errors.Is(err, docerr.ErrNotFound) {
+		return 0, nil
+	}
+	return int64(len(doc.body)), err
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// themePrefix starts the ids of the docs of installed themes: the theme
+// "paper" has its page template at "_themes.paper.doc-template.html" and
+// its stylesheet at "_themes.paper.style.css". A page tries it with
+// "template: _themes.paper.doc" in its front matter; installing it with
+// activate makes it the site's.
+const themePrefix = "_themes."
+
+var (
+	validThemeName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+	// themeExts are the extensions of the files of a theme archive that
+	// are installed, of which .html files only if they are templates; the
+	// rest, like a README or screenshots, are left out.
+	themeExts = map[string]bool{".html": true, ".css": true, ".js": true}
+
+	// themeRequired are the templates every theme must have.
+	themeRequired = []string{tmplDocName}
+)
+
+// themeFiles picks the files of a theme out of an archive's: those at its
+// top, or under the one directory everything is in, as in the archives Git
+// hosts serve of a branch. They are returned by the docId they keep.
+func themeFiles(files []archiveFile) map[string][]byte {
+	dir := ""
+	if len(files) > 0 {
+		dir = strings.SplitN(path.Clean("/" + files[0].path)[1:], "/", 2)[0] + "/"
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(path.Clean("/" + f.path)[1:], dir) {
+			dir = ""
+			break
+		}
+	}
+
+	theme := map[string][]byte{}
+	for _, f := range files {
+		name := strings.TrimPrefix(path.Clean("/" + f.path)[1:], dir)
+		if strings.Contains(name, "/") || !themeExts[path.Ext(name)] || docstore.ValidateDocId(name) != nil ||
+			(path.Ext(name) == ".html" && !strings.HasSuffix(name, "-template.html")) {
+			continue
+		}
+		theme[name] = f.body
+	}
+	return theme
+}
+
+// installTheme installs the theme in a zip, tar or gzipped tar archive as
+// the docs of the name path parameter under themePrefix. The archive is
+// the request body or, with the url query parameter, fetched from there,
+// like a Git host's archive of a branch, if it is no larger than
+// importMaxBytes. Every template is parsed and
+// checked against the data it is executed with, and one missing, failing
+// or referring to what doesn't exist fails the install before any doc is
+// written. With activate the theme's docs are written over the site's own
+// too, making it the site's theme; with dryRun nothing is written.
+func installTheme(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	name := request.PathParameters["name"]
+	op := "install theme " + name
+	if !validThemeName.MatchString(name) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"name": "lowercase letters, digits and dashes, like paper"})
+	}
+
+	var archive []byte
+	var err error
+	if u := request.QueryStringParameters["url"]; u != "" {
+		archive, err = fetchURL(ctx, op, u, importMaxBytes)
+		if err == nil && int64(len(archive)) > importMaxBytes {
+			return Response{}, docerr.WithDetails(op, docerr.ErrTooLarge, nil,
+				map[string]interface{}{"url": u, "limit": importMaxBytes})
+		}
+	} else {
+		archive, err = requestBody(request)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+
+	files, err := expandArchive(archive)
+	if err != nil {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"body": "a zip, tar or gzipped tar archive"})
+	}
+	theme := themeFiles(files)
+
+	var missing []string
+	for _, t := range themeRequired {
+		if _, ok := theme[t]; !ok {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"theme": name, "missing": missing})
+	}
+
+	activate := request.QueryStringParameters["activate"] != ""
+	var docs []docWrite
+	for file, body := range theme {
+		docs = append(docs, docWrite{themePrefix + name + "." + file, body})
+		if activate {
+			docs = append(docs, docWrite{file, body})
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].docId < docs[j].docId })
+
+	// Writing the templates lints them, so every doc is planned before
+	// any is written.
+	results, status, err := writeAll(ctx, docs, dryRun(request))
+	if err != nil {
+		return Response{}, err
+	}
+	if status == 200 && !dryRun(request) {
+		audit(auditRequestFrom(ctx), "theme.install", themePrefix+name,
+			map[string]interface{}{"docs": len(theme), "activated": activate})
+	}
+	return jsonResponse(status, struct {
+		Theme string        `json:"theme"`
+		Docs  []writeResult `json:"docs"`
+	}{name, results}), nil
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// timeConfig sets how timestamps are shown to readers, for example:
+//
+//	time:
+//	  format: "Jan 2, 2006 3:04 PM MST"
+//	  zone: America/Los_Angeles
+//	  locales:
+//	    en-GB: "2 Jan 2006 15:04 MST"
+//	    de: "02.01.2006 15:04 MST"
+//
+// Formats are Go time layouts. Readers can pick a zone and locale with the
+// "tz" and "locale" cookies; otherwise the locale comes from their
+// Accept-Language header.
+type timeConfig struct {
+	Format  string            `yaml:"format"`
+	Zone    string            `yaml:"zone"`
+	Locales map[string]string `yaml:"locales"`
+}
+
+// timeFormat is how timestamps are formatted for one request.
+type timeFormat struct {
+	layout string
+	loc    *time.Location
+}
+
+var defaultTimeFormat = timeFormat{layout: time.RFC850, loc: time.UTC}
+
+// key identifies the format in cache and coalescing keys, since pages
+// rendered with different formats differ.
+func (tf timeFormat) key() string {
+	return tf.layout + "|" + tf.loc.String()
+}
+
+func (tf timeFormat) format(t time.Time) string {
+	return t.In(tf.loc).Format(tf.layout)
+}
+
+// iso formats t as ISO 8601 for machines, such as <time datetime="">.
+func (tf timeFormat) iso(t time.Time) string {
+	return t.In(tf.loc).Format(time.RFC3339)
+}
+
+// cookie returns the value of the named request cookie.
+func cookie(request events.APIGatewayProxyRequest, name string) string {
+	r := http.Request{Header: http.Header{"Cookie": {header(request, "Cookie")}}}
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// acceptLanguages returns the language tags of an Accept-Language header in
+// order of preference.
+func acceptLanguages(h string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+
+	var langs []lang
+	for _, part := range strings.Split(h, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		l := lang{tag: strings.TrimSpace(fields[0]), q: 1}
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				q, err := strconv.ParseFloat(f[2:], 64)
+				if err == nil {
+					l.q = q
+				}
+			}
+		}
+		if l.tag != "" && l.tag != "*" && l.q > 0 {
+			langs = append(langs, l)
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// localeLayout finds the configured layout for a language tag, falling back
+// from a regional tag like "en-GB" to its base language "en".
+func (c timeConfig) localeLayout(tag string) (string, bool) {
+	for k, layout := range c.Locales {
+		if strings.EqualFold(k, tag) {
+			return layout, true
+		}
+	}
+
+	if i := strings.Index(tag, "-"); i > 0 {
+		return c.localeLayout(tag[:i])
+	}
+
+	return "", false
+}
+
+// loadLocation loads a zone, logging and returning nil if it is unknown.
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("unknown time zone %q: %v", name, err)
+		return nil
+	}
+	return loc
+}
+
+// requestTimeFormat picks the time format for request from the site config
+// and the reader's cookies and Accept-Language header.
+func requestTimeFormat(cfg *siteConfig, request events.APIGatewayProxyRequest) timeFormat {
+	tf := defaultTimeFormat
+	c := cfg.Time
+
+	if c.Format != "" {
+		tf.layout = c.Format
+	}
+	if c.Zone != "" {
+		if loc := loadLocation(c.Zone); loc != nil {
+			tf.loc = loc
+		}
+	}
+
+	langs := acceptLanguages(header(request, "Accept-Language"))
+	if locale := cookie(request, "locale"); locale != "" {
+		langs = append([]string{locale}, langs...)
+	}
+	for _, tag := range langs {
+		if layout, ok := c.localeLayout(tag); ok {
+			tf.layout = layout
+			break
+		}
+	}
+
+	if tz := cookie(request, "tz"); tz != "" {
+		if loc := loadLocation(tz); loc != nil {
+			tf.loc = loc
+		}
+	}
+
+	return tf
+}
+
+// ago describes how long before now t was, like "3 days ago".
+func ago(t, now time.Time) string {
+	d := now.Sub(t)
+
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit + " ago"
+		}
+		return strconv.Itoa(n) + " " + unit + "s ago"
+	}
+
+	day := 24 * time.Hour
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < day:
+		return plural(int(d/time.Hour), "hour")
+	case d < 2*day:
+		return "yesterday"
+	case d < 14*day:
+		return plural(int(d/day), "day")
+	case d < 60*day:
+		return plural(int(d/(7*day)), "week")
+	case d < 365*day:
+		return plural(int(d/(30*day)), "month")
+	}
+	return plural(int(d/(365*day)), "year")
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// templateBuiltins are the functions text/template gives every template.
+var templateBuiltins = []string{
+	"and", "call", "eq", "ge", "gt", "html", "index", "js", "le", "len", "lt",
+	"ne", "not", "or", "print", "printf", "println", "slice", "urlquery",
+}
+
+// serverPkg is the import path of the server's own types.
+var serverPkg = reflect.TypeOf(docMetadata{}).PkgPath()
+
+// contextField is a field or method templates can refer to, with the
+// fields and methods of its type, or of its elements for slices and maps.
+type contextField struct {
+	Name   string         `json:"name"`
+	Type   string         `json:"type"`
+	Method bool           `json:"method,omitempty"`
+	Fields []contextField `json:"fields,omitempty"`
+}
+
+// contextFunc is a function templates can call.
+type contextFunc struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+}
+
+// templateContext is the reference of what templates can use.
+type templateContext struct {
+	// Templates gives the fields of the data each template, or definition
+	// of the page template, is executed with.
+	Templates map[string][]contextField `json:"templates"`
+	Functions []contextFunc             `json:"functions"`
+	Builtins  []string                  `json:"builtins"`
+}
+
+// contextFields lists the exported fields and methods of t, following
+// them into the server's own types until one repeats a type above it.
+// Those of other packages' types, like time.Time, are documented there.
+func contextFields(t reflect.Type, seen map[reflect.Type]bool) []contextField {
+	t = indirectType(t)
+	if t == nil || seen[t] || t.PkgPath() != serverPkg {
+		return nil
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	var fields []contextField
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			fields = append(fields, contextField{
+				Name:   f.Name,
+				Type:   typeName(f.Type),
+				Fields: contextFields(elemType(f.Type), seen),
+			})
+		}
+	}
+
+	pt := reflect.PtrTo(t)
+	for i := 0; i < pt.NumMethod(); i++ {
+		m := pt.Method(i)
+		if m.Type.NumOut() == 0 {
+			continue
+		}
+		var in, out []string
+		for j := 1; j < m.Type.NumIn(); j++ {
+			in = append(in, typeName(m.Type.In(j)))
+		}
+		for j := 0; j < m.Type.NumOut(); j++ {
+			out = append(out, typeName(m.Type.Out(j)))
+		}
+		sig := fmt.Sprintf("(%s) %s", strings.Join(in, ", "), strings.Join(out, ", "))
+		if len(out) > 1 {
+			sig = fmt.Sprintf("(%s) (%s)", strings.Join(in, ", "), strings.Join(out, ", "))
+		}
+		fields = append(fields, contextField{
+			Name:   m.Name,
+			Type:   sig,
+			Method: true,
+			Fields: contextFields(elemType(m.Type.Out(0)), seen),
+		})
+	}
+	return fields
+}
+
+// indirectType returns the type pointers of t point to.
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// elemType returns what range over a value of type t yields, or t if
+// it isn't ranged over.
+func elemType(t reflect.Type) reflect.Type {
+	t = indirectType(t)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return t.Elem()
+	}
+	return t
+}
+
+// typeName names t as template authors see it, without the package of
+// the server's own types.
+func typeName(t reflect.Type) string {
+	return strings.Replace(t.String(), "main.", "", -1)
+}
+
+// describeTemplateContext builds the reference from the types templates
+// are executed with and the functions they are given, so it can't fall
+// behind them.
+func describeTemplateContext() templateContext {
+	c := templateContext{Templates: map[string][]contextField{}, Builtins: templateBuiltins}
+	for name, shape := range templateShapes {
+		c.Templates[name] = contextFields(shape, map[reflect.Type]bool{})
+	}
+	for name, fn := range templateFuncs {
+		c.Functions = append(c.Functions, contextFunc{
+			Name:      name,
+			Signature: typeName(reflect.TypeOf(fn)),
+		})
+	}
+	sort.Slice(c.Functions, func(i, j int) bool { return c.Functions[i].Name < c.Functions[j].Name })
+	return c
+}
+
+// templateContextReference answers with what templates can use, as JSON
+// or, for readers asking for it, as markdown.
+func templateContextReference(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	c := describeTemplateContext()
+	if acceptFormat(request) != formatMarkdown {
+		return jsonResponse(200, c), nil
+	}
+
+	var b strings.Builder
+	b.WriteString("# Template context\n")
+	names := make([]string, 0, len(c.Templates))
+	for name := range c.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n## %s\n\n", name)
+		writeContextFields(&b, c.Templates[name], 0)
+	}
+	b.WriteString("\n## Functions\n\n")
+	for _, f := range c.Functions {
+		fmt.Fprintf(&b, "- `%s`: `%s`\n", f.Name, f.Signature)
+	}
+	fmt.Fprintf(&b, "\nAnd text/template's own: %s.\n", strings.Join(c.Builtins, ", "))
+
+	return Response{
+		StatusCode: 200,
+		Body:       b.String(),
+		Headers:    map[string]string{"Content-Type": formatTypes[formatMarkdown] + "; charset=utf-8"},
+	}, nil
+}
+
+// writeContextFields lists fields as nested markdown items.
+func writeContextFields(b *strings.Builder, fields []contextField, depth int) {
+	for _, f := range fields {
+		fmt.Fprintf(b, "%s- `.%s` `%s`\n", strings.Repeat("  ", depth), f.Name, f.Type)
+		writeContextFields(b, f.Fields, depth+1)
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/metrics"
+)
+
+// templateDims are the dimensions of template metrics: the template a
+// page is rendered with and the variant serving it, so a theme change
+// being tried on the canary can be told apart from stable.
+func templateDims(ctx context.Context, tmplName string) map[string]string {
+	variant := variantFrom(ctx)
+	if variant == "" {
+		variant = variantStable
+	}
+	return map[string]string{"Template": tmplName, "Variant": variant}
+}
+
+// noteTemplateDuration records how long executing tmplName took, as the
+// TemplateDuration metric.
+func noteTemplateDuration(ctx context.Context, tmplName string, d time.Duration) {
+	metrics.Emit("TemplateDuration", float64(d.Milliseconds()), metrics.Milliseconds, templateDims(ctx, tmplName))
+}
+
+// noteTemplateError counts a template that failed to parse or execute as
+// TemplateErrors, and logs it with the template's name. Other errors,
+// like the template being unreachable, aren't the template's fault and
+// aren't counted.
+func noteTemplateError(ctx context.Context, tmplName string, err error) {
+	if !errors.Is(err, docerr.ErrTemplate) {
+		return
+	}
+	dims := templateDims(ctx, tmplName)
+	metrics.Incr("TemplateErrors", dims)
+	log.Printf("template %s (%s): %v", tmplName, dims["Variant"], err)
+}
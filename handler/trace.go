@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// W3C trace context headers, https://www.w3.org/TR/trace-context/
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// traceContext is the W3C trace context of a request. spanId identifies
+// this service's part of the trace and parentId the caller's, if any.
+type traceContext struct {
+	traceId, spanId, parentId, flags string
+	state                            string
+}
+
+// traceparent formats tc as a traceparent header with this service's span
+// as the parent.
+func (tc traceContext) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", tc.traceId, tc.spanId, tc.flags)
+}
+
+type traceKey struct{}
+
+// traceFrom returns the trace context of the request ctx belongs to.
+func traceFrom(ctx context.Context) (traceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(traceContext)
+	return tc, ok
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestTrace continues the trace request belongs to, or starts a new one
+// if it has no valid traceparent header.
+func requestTrace(request events.APIGatewayProxyRequest) traceContext {
+	tc := traceContext{spanId: randomHex(8)}
+
+	m := traceparentPattern.FindStringSubmatch(strings.TrimSpace(header(request, traceparentHeader)))
+	if m == nil || m[1] == strings.Repeat("0", 32) || m[2] == strings.Repeat("0", 16) {
+		tc.traceId, tc.flags = randomHex(16), "01"
+		return tc
+	}
+
+	tc.traceId, tc.parentId, tc.flags = m[1], m[2], m[3]
+	tc.state = header(request, tracestateHeader)
+	return tc
+}
+
+// withTrace joins each request to its W3C trace: the trace id prefixes the
+// request's log lines, goes out on AWS calls made with the request's
+// context, and comes back on the response.
+func withTrace(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+		tc := requestTrace(request)
+		ctx = context.WithValue(ctx, traceKey{}, tc)
+
+		// Lambda runs one request at a time per container, so the log
+		// prefix is the request's own.
+		prefix := log.Prefix()
+		log.SetPrefix("trace=" + tc.traceId + " ")
+		defer log.SetPrefix(prefix)
+
+		resp, err := next(ctx, request)
+		if err != nil {
+			return resp, err
+		}
+
+		headers := map[string]string{traceparentHeader: tc.traceparent()}
+		if tc.state != "" {
+			headers[tracestateHeader] = tc.state
+		}
+		return withHeaders(resp, headers), nil
+	}
+}
+
+// propagateTrace adds the trace context to outgoing AWS requests.
+func propagateTrace(r *request.Request) {
+	tc, ok := traceFrom(r.Context())
+	if !ok {
+		return
+	}
+
+	r.HTTPRequest.Header.Set(traceparentHeader, tc.traceparent())
+	if tc.state != "" {
+		r.HTTPRequest.Header.Set(tracestateHeader, tc.state)
+	}
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/translate"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"gopkg.in/yaml.v2"
+)
+
+// translateChunk is how much text goes in each request to Amazon
+// Translate, which takes at most 5000 bytes at a time.
+const translateChunk = 4500
+
+var (
+	// translateLanguages are the languages, like "es,fr,de", that readers
+	// may ask for docs in with ?translate=. Machine translation is off
+	// when it is unset.
+	translateLanguages = os.Getenv("TRANSLATE_LANGUAGES")
+
+	// translateSource is the language docs are written in, or "auto" to
+	// have Translate detect it.
+	translateSource = envString("TRANSLATE_SOURCE", "auto")
+
+	// translationsTable is the DynamoDB table translations are kept in,
+	// keyed by DocId and Key, the revision and language. Without it they
+	// are only kept for the life of a warm container.
+	translationsTable = os.Getenv("TRANSLATIONS_TABLE")
+
+	translations = &translationCache{entries: map[string][]byte{}}
+)
+
+// translationCache holds translated revisions by docId, revision and
+// language.
+type translationCache struct {
+	sync.Mutex
+	entries map[string][]byte
+}
+
+func (c *translationCache) get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	b, ok := c.entries[key]
+	return b, ok
+}
+
+func (c *translationCache) put(key string, b []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = b
+}
+
+// translation is a revision of a doc in another language, as stored.
+type translation struct {
+	DocId string
+	Key   string
+	Body  []byte
+}
+
+// translatable reports whether readers may ask for docs in lang.
+func translatable(lang string) bool {
+	for _, l := range strings.Split(translateLanguages, ",") {
+		if l = strings.TrimSpace(l); l != "" && strings.EqualFold(l, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveTranslation renders the latest revision of docId machine
+// translated into lang, with a banner saying so and linking to the
+// original. Crawlers are asked not to index it.
+func serveTranslation(ctx context.Context, docId, lang string) (Response, error) {
+	op := "translate " + docId
+	if !translatable(lang) || !isPage(docId) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	lang = strings.ToLower(lang)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	doc, err := fetchDoc(fetchCtx, docId)
+	if err != nil {
+		return Response{}, err
+	}
+
+	key := fmt.Sprintf("%d#%s", doc.meta.Id, lang)
+	ch := flights.DoChan("translate:"+docId+"@"+key, func() (interface{}, error) {
+		return translatedDoc(context.Background(), docId, key, doc.body, lang)
+	})
+	v, err := await(ctx, op, ch)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	translated := fetchedDoc{meta: doc.meta, body: v.([]byte)}
+	resp, err := renderWith(ctx, docId, translated, tmplDocName, nil)
+	if err != nil {
+		return resp, err
+	}
+	return withHeaders(resp, map[string]string{
+		"Content-Language": lang,
+		"X-Robots-Tag":     "noindex",
+	}), nil
+}
+
+// translatedDoc returns body, revision key of docId, translated into lang,
+// translating it only if no translation is kept.
+func translatedDoc(ctx context.Context, docId, key string, body []byte, lang string) ([]byte, error) {
+	cacheKey := docId + "@" + key
+	if b, ok := translations.get(cacheKey); ok {
+		return b, nil
+	}
+
+	var itemKey map[string]*dynamodb.AttributeValue
+	if translationsTable != "" {
+		var err error
+		itemKey, err = dynamodbattribute.MarshalMap(struct{ DocId, Key string }{docId, key})
+		if err != nil {
+			return nil, err
+		}
+		out, err := dynamo().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(translationsTable),
+			Key:       itemKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out.Item != nil {
+			var t translation
+			err = dynamodbattribute.UnmarshalMap(out.Item, &t)
+			if err != nil {
+				return nil, err
+			}
+			translations.put(cacheKey, t.Body)
+			return t.Body, nil
+		}
+	}
+
+	b, err := translateDoc(ctx, docId, body, lang)
+	if err != nil {
+		return nil, err
+	}
+
+	if translationsTable != "" {
+		item, err := dynamodbattribute.MarshalMap(translation{docId, key, b})
+		if err != nil {
+			return nil, err
+		}
+		_, err = dynamo().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(translationsTable),
+			Item:      item,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	translations.put(cacheKey, b)
+	return b, nil
+}
+
+// translateDoc translates a doc's source into lang: its front matter
+// title and the text of its body, leaving fenced code alone. A banner
+// marking the machine translation is put at the top of the body.
+func translateDoc(ctx context.Context, docId string, doc []byte, lang string) ([]byte, error) {
+	client := translate.New(awsSession())
+	text := func(s string) (string, error) {
+		out, err := client.TextWithContext(ctx, &translate.TextInput{
+			SourceLanguageCode: aws.String(translateSource),
+			TargetLanguageCode: aws.String(lang),
+			Text:               aws.String(s),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(out.TranslatedText), nil
+	}
+
+	block, body, _ := frontMatterBlock(doc)
+	var fm yaml.MapSlice
+	err := yaml.Unmarshal(block, &fm)
+	if err != nil {
+		return nil, fmt.Errorf("front matter: %v", err)
+	}
+
+	var tb bytes.Buffer
+	for _, c := range translateChunks(string(body), translateChunk) {
+		if !c.translate {
+			tb.WriteString(c.text)
+			continue
+		}
+		t, err := text(c.text)
+		if err != nil {
+			return nil, err
+		}
+		tb.WriteString(t)
+		tb.WriteString("\n\n")
+	}
+
+	// The banner goes first in the body, so the title, when it would be
+	// the body's first line, is set in the front matter.
+	titled := false
+	for i, item := range fm {
+		if k, _ := item.Key.(string); k == "title" {
+			if title, _ := item.Value.(string); title != "" {
+				fm[i].Value, err = text(title)
+				if err != nil {
+					return nil, err
+				}
+				titled = true
+			}
+		}
+	}
+	if !titled {
+		fm = append(fm, yaml.MapItem{Key: "title", Value: firstLine(tb.Bytes())})
+	}
+	block, err = yaml.Marshal(fm)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	b.Write(block)
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "<div class=\"machine-translation\">Machine translated. <a href=\"/%s\">Read the original</a>.</div>\n\n",
+		html.EscapeString(docId))
+	b.Write(tb.Bytes())
+	return b.Bytes(), nil
+}
+
+type translateChunkText struct {
+	text      string
+	translate bool
+}
+
+// translateChunks splits a doc's body into runs of paragraphs of at most
+// n bytes to translate, and the fenced code between them to keep as it
+// is.
+func translateChunks(body string, n int) []translateChunkText {
+	var chunks []translateChunkText
+	var cur strings.Builder
+	flush := func() {
+		if strings.TrimSpace(cur.String()) != "" {
+			chunks = append(chunks, translateChunkText{strings.TrimSpace(cur.String()), true})
+		}
+		cur.Reset()
+	}
+
+	paragraphs := strings.Split(body, "\n\n")
+	for i := 0; i < len(paragraphs); i++ {
+		p := paragraphs[i]
+		if strings.HasPrefix(strings.TrimSpace(p), "```") {
+			flush()
+			// Take paragraphs up to the closing fence.
+			code := p
+			for strings.Count(code, "```") < 2 && i+1 < len(paragraphs) {
+				i++
+				code += "\n\n" + paragraphs[i]
+			}
+			chunks = append(chunks, translateChunkText{code + "\n\n", false})
+			continue
+		}
+
+		// A paragraph too long for one request is split between lines,
+		// or between words if a line is too long too.
+		pieces := []string{p}
+		if len(p) > n {
+			pieces = nil
+			for _, line := range strings.Split(p, "\n") {
+				if len(line) > n {
+					pieces = append(pieces, audioChunks(line, n)...)
+				} else {
+					pieces = append(pieces, line)
+				}
+			}
+		}
+		for _, p := range pieces {
+			if cur.Len() > 0 && cur.Len()+2+len(p) > n {
+				flush()
+			}
+			if cur.Len() > 0 {
+				cur.WriteString("\n\n")
+			}
+			cur.WriteString(p)
+		}
+	}
+	flush()
+	return chunks
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/blobstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+)
+
+// uploadURLTTL is how long a pre-signed upload URL lasts. Uploads that
+// aren't completed are deleted by the maintenance function.
+const uploadURLTTL = 15 * time.Minute
+
+var (
+	// maxUploadBytes is the largest asset that can be uploaded with a
+	// pre-signed URL. Completing an upload reads it into memory.
+	maxUploadBytes = int64(envFloat("ASSET_UPLOAD_MAX_BYTES", 100<<20))
+
+	validUploadId = regexp.MustCompile(`^[0-9a-f]{32}\.[a-z0-9]+$`)
+)
+
+// startUpload issues a pre-signed URL that an asset too large to send
+// through the API can be PUT to, straight to the asset bucket, for a body
+// like {"name": "manual.pdf", "size": 52428800}. Once it is uploaded, POST
+// to the complete URL stores it.
+func startUpload(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	var u struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+	err = json.Unmarshal(body, &u)
+	op := "upload " + u.Name
+	if assetBucket == "" {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+	ext := strings.ToLower(path.Ext(u.Name))
+	if err != nil || !validAssetExt.MatchString(ext) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, err,
+			map[string]interface{}{"name": "a file name with an extension, like manual.pdf"})
+	}
+	if u.Size <= 0 || u.Size > maxUploadBytes {
+		return Response{}, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"size": "the size in bytes, at most " + formatSize(int(maxUploadBytes))})
+	}
+
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	id := randomHex(16) + ext
+	link, err := blobs().PresignUpload(id, contentType, u.Size, uploadURLTTL)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	return jsonResponse(201, struct {
+		Id        string            `json:"id"`
+		UploadURL string            `json:"uploadUrl"`
+		Method    string            `json:"method"`
+		Headers   map[string]string `json:"headers"`
+		Expires   time.Time         `json:"expires"`
+		Complete  string            `json:"complete"`
+	}{
+		Id:        id,
+		UploadURL: link,
+		Method:    "PUT",
+		Headers:   map[string]string{"Content-Type": contentType},
+		Expires:   time.Now().Add(uploadURLTTL).UTC(),
+		Complete:  apiV1 + "assets/uploads/" + id + "/complete",
+	}), nil
+}
+
+// completeUpload stores the asset uploaded for the id path parameter by
+// its content hash, like an asset sent to POST /api/v1/assets, answering
+// with the URL it is served at.
+func completeUpload(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	id := request.PathParameters["id"]
+	op := "complete upload " + id
+	if assetBucket == "" || !validUploadId.MatchString(id) {
+		return Response{}, docerr.E(op, docerr.ErrNotFound, nil)
+	}
+
+	store := blobs()
+	body, err := store.GetUpload(ctx, id)
+	if blobstore.IsNotFound(err) {
+		return Response{}, docerr.WithDetails(op, docerr.ErrNotFound, err,
+			map[string]interface{}{"reason": "nothing has been uploaded, or the upload was completed already"})
+	}
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+
+	err = checkMalware(ctx, id, body)
+	if err != nil {
+		if derr := store.Discard(ctx, id); derr != nil {
+			log.Printf("discarding upload %s: %v", id, derr)
+		}
+		return Response{}, err
+	}
+
+	hash, existed, err := store.Promote(ctx, id, body)
+	if err != nil {
+		return Response{}, docerr.E(op, docerr.ErrBackend, err)
+	}
+	return storedAsset(ctx, hash, path.Ext(id), len(body), existed)
+}
@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/tmpllint"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// validateTimeout bounds the startup checks so a slow docstore can't
+	// hold up the cold start.
+	validateTimeout = envDuration("VALIDATE_TIMEOUT", 5*time.Second)
+
+	health = &healthState{}
+)
+
+// healthState holds the problems found by the last validation.
+type healthState struct {
+	sync.Mutex
+	problems []string
+	checked  time.Time
+}
+
+// validateSite checks the site config and page template, returning a
+// description of each problem found. Problems found here would otherwise
+// turn up as errors or surprises on every request.
+func validateSite(ctx context.Context) (problems []string) {
+	for _, name := range configDocNames() {
+		doc, err := fetchDoc(ctx, name)
+		if errors.Is(err, docerr.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			break
+		}
+
+		for _, p := range lintConfig(doc.body) {
+			problems = append(problems, name+": "+p)
+		}
+		break
+	}
+
+	tmplDoc, err := fetchDoc(ctx, tmplDocName)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", tmplDocName, err))
+	} else {
+		for _, p := range lintTemplate(tmplDocName, tmplDoc.body) {
+			problems = append(problems, tmplDocName+": "+p)
+		}
+	}
+
+	return
+}
+
+// lintConfig checks the body of a config doc.
+func lintConfig(body []byte) []string {
+	cfg := &siteConfig{}
+	err := yaml.UnmarshalStrict(body, cfg)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return validateConfig(cfg)
+}
+
+// templateShapes are the types of the data page templates, and the
+// definitions of theirs rendering banners, are executed with.
+var templateShapes = map[string]reflect.Type{
+	"docPage": reflect.TypeOf(docMetadata{}),
+	"banner":  reflect.TypeOf(revisionBanner{}),
+	"asOf":    reflect.TypeOf(asOfBanner{}),
+	"mirror":  reflect.TypeOf(mirrorBanner{}),
+	"source":  reflect.TypeOf(sourceBanner{}),
+}
+
+// checkTemplate parses the body of template doc docId and checks it
+// against the data it is executed with. The definitions of a blocks doc
+// are checked as executed with the page's.
+func checkTemplate(docId string, body []byte) (tmpllint.Report, error) {
+	tmpl, err := template.New("docPage").Funcs(templateFuncs).Parse(string(body))
+	if err != nil {
+		return tmpllint.Report{}, err
+	}
+
+	shapes := templateShapes
+	if strings.HasPrefix(docId, blocksDocPrefix) {
+		shapes = map[string]reflect.Type{}
+		for _, t := range tmpl.Templates() {
+			shapes[t.Name()] = templateShapes["docPage"]
+		}
+		for name, shape := range templateShapes {
+			shapes[name] = shape
+		}
+	}
+	return tmpllint.Check(tmpl, shapes, templateFuncs), nil
+}
+
+// lintTemplate checks that the body of template doc docId parses and only
+// refers to fields, methods and templates that exist, which would
+// otherwise fail every page using it.
+func lintTemplate(docId string, body []byte) []string {
+	r, err := checkTemplate(docId, body)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return r.Undefined
+}
+
+// templateWarnings reports the page fields a page template doesn't use,
+// which may be ones its author meant to show.
+func templateWarnings(docId string, body []byte) []string {
+	if !strings.HasSuffix(docId, "-template.html") {
+		return nil
+	}
+	r, err := checkTemplate(docId, body)
+	if err != nil || len(r.Unused) == 0 {
+		return nil
+	}
+	return []string{"template doesn't use " + strings.Join(r.Unused, ", ")}
+}
+
+// validateConfig checks the settings of cfg that parse but can't work.
+func validateConfig(cfg *siteConfig) (problems []string) {
+	if cfg.Time.Zone != "" {
+		if _, err := time.LoadLocation(cfg.Time.Zone); err != nil {
+			problems = append(problems, fmt.Sprintf("time.zone: %v", err))
+		}
+	}
+
+	for prefix, p := range cfg.Prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			problems = append(problems, fmt.Sprintf("prefixes: %q doesn't start with /", prefix))
+		}
+		if p.Robots != "" && !strings.EqualFold(p.Robots, "disallow") {
+			problems = append(problems, fmt.Sprintf("prefixes.%s.robots: unknown value %q", prefix, p.Robots))
+		}
+		switch p.RawHTML {
+		case "", rawHTMLAllow, rawHTMLStrip, rawHTMLBlock:
+		default:
+			problems = append(problems, fmt.Sprintf("prefixes.%s.rawHTML: unknown value %q", prefix, p.RawHTML))
+		}
+	}
+
+	switch cfg.PII.Policy {
+	case "", "off", "warn", "block":
+	default:
+		problems = append(problems, fmt.Sprintf("pii.policy: unknown value %q", cfg.PII.Policy))
+	}
+	for kind, expr := range cfg.PII.Patterns {
+		if _, err := regexp.Compile(expr); err != nil {
+			problems = append(problems, fmt.Sprintf("pii.patterns.%s: %v", kind, err))
+		}
+	}
+
+	if _, ok := markdownRenderers[cfg.Markdown.Engine]; cfg.Markdown.Engine != "" && !ok {
+		problems = append(problems, fmt.Sprintf("markdown.engine: unknown engine %q", cfg.Markdown.Engine))
+	}
+
+	switch cfg.RevisionMessages.Policy {
+	case "", "off", "warn", "block":
+	default:
+		problems = append(problems, fmt.Sprintf("revisionMessages.policy: unknown value %q", cfg.RevisionMessages.Policy))
+	}
+
+	switch cfg.Normalize.LineEndings {
+	case "", "lf", "crlf":
+	default:
+		problems = append(problems, fmt.Sprintf("normalize.lineEndings: unknown value %q", cfg.Normalize.LineEndings))
+	}
+
+	if cfg.Quotas.Store < 0 {
+		problems = append(problems, "quotas.store: must not be negative")
+	}
+	for role, q := range cfg.Quotas.Roles {
+		if role != roleAdmin && role != roleWriter && role != rolePrefixAdmin && role != roleAnonymous {
+			problems = append(problems, fmt.Sprintf("quotas.roles: unknown role %q", role))
+		}
+		if q.Doc < 0 || q.Attachment < 0 {
+			problems = append(problems, fmt.Sprintf("quotas.roles.%s: limits must not be negative", role))
+		}
+	}
+
+	for pattern, g := range cfg.IAM.Principals {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("iam.principals: %q: %v", pattern, err))
+		}
+		if g.Role != roleAdmin && g.Role != roleWriter && g.Role != rolePrefixAdmin {
+			problems = append(problems, fmt.Sprintf("iam.principals.%s: unknown role %q", pattern, g.Role))
+		}
+		if g.Role == rolePrefixAdmin && len(g.Prefixes) == 0 {
+			problems = append(problems, fmt.Sprintf("iam.principals.%s: a prefix admin needs prefixes", pattern))
+		}
+	}
+
+	if cfg.CORS.MaxAge < 0 {
+		problems = append(problems, "cors.maxAge: must not be negative")
+	}
+
+	for i, w := range cfg.Freezes {
+		if !w.Start.Before(w.End) {
+			problems = append(problems, fmt.Sprintf("freezes[%d]: start must be before end", i))
+		}
+		for _, prefix := range w.Prefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				problems = append(problems, fmt.Sprintf("freezes[%d].prefixes: %q doesn't start with /", i, prefix))
+			}
+		}
+	}
+
+	return
+}
+
+// ValidateStartup runs validateSite, logging each problem, and keeps the
+// result for the health endpoint. Commands run it before serving.
+func ValidateStartup() {
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	problems := validateSite(ctx)
+	for _, p := range problems {
+		log.Printf("config problem: %s", p)
+	}
+
+	health.Lock()
+	health.problems, health.checked = problems, time.Now()
+	health.Unlock()
+}
+
+// healthStatus reports the problems found by the last validation. It
+// answers 503 while there are any so monitors notice.
+func healthStatus(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	health.Lock()
+	defer health.Unlock()
+
+	status := http.StatusOK
+	body := struct {
+		Status   string    `json:"status"`
+		Problems []string  `json:"problems"`
+		Checked  time.Time `json:"checked"`
+	}{"ok", health.problems, health.checked}
+
+	if body.Problems == nil {
+		body.Problems = []string{}
+	}
+	if len(body.Problems) > 0 {
+		status, body.Status = http.StatusServiceUnavailable, "misconfigured"
+	}
+
+	return jsonResponse(status, body), nil
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// variablesDocName holds site wide values, like the product name or
+// current version, as YAML:
+//
+//	product: Docstore
+//	version: 1.4.2
+//	supportEmail: help@example.com
+//
+// Docs use them with {{var "version"}}, so a fact is updated in one place.
+const variablesDocName = "_variables"
+
+var varRef = regexp.MustCompile(`\{\{\s*var\s+"([^"]*)"\s*\}\}`)
+
+// getVariables returns the site variables. Missing or malformed variables
+// mean none.
+func getVariables(ctx context.Context) map[string]string {
+	body, ok := getSystemDoc(ctx, variablesDocName)
+	if !ok {
+		return nil
+	}
+
+	vars := map[string]string{}
+	err := yaml.Unmarshal(body, &vars)
+	if err != nil {
+		log.Printf("%s error: %v", variablesDocName, err)
+		return nil
+	}
+	return vars
+}
+
+// substituteVariables replaces the variable references in doc. Unknown
+// variables are left as they are so the mistake shows on the page.
+func substituteVariables(ctx context.Context, docId string, doc []byte) []byte {
+	if !varRef.Match(doc) {
+		return doc
+	}
+
+	vars := getVariables(ctx)
+	return varRef.ReplaceAllFunc(doc, func(ref []byte) []byte {
+		key := string(varRef.FindSubmatch(ref)[1])
+		v, ok := vars[key]
+		if !ok {
+			log.Printf("unknown variable %q in %s", key, docId)
+			return ref
+		}
+		return []byte(v)
+	})
+}
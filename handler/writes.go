@@ -0,0 +1,400 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/docerr"
+	"github.com/drocamor/n22t.docstore/sensitive"
+	"github.com/drocamor/n22t.docstore/textdiff"
+	"gopkg.in/yaml.v2"
+)
+
+// writeResult reports the outcome of writing one doc, or with dryRun what
+// writing it would do.
+type writeResult struct {
+	DocId  string `json:"docId"`
+	DryRun bool   `json:"dryRun,omitempty"`
+
+	// Action is "create", "update" or "unchanged".
+	Action string `json:"action"`
+
+	// BaseVersion is the latest revision before the write, or 0 for a new
+	// doc, and Version the revision written. An unchanged doc isn't
+	// written again, so its Version is the existing revision.
+	BaseVersion int        `json:"baseVersion,omitempty"`
+	Version     int        `json:"version,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+
+	// Inserted and Deleted count the changed lines against BaseVersion.
+	Inserted int `json:"inserted"`
+	Deleted  int `json:"deleted"`
+
+	Problems []string `json:"problems,omitempty"`
+
+	// Findings are likely personal data or credentials in the doc. They
+	// are problems under the block policy and warnings otherwise.
+	Findings []sensitive.Finding `json:"findings,omitempty"`
+
+	// Warnings don't stop the write, like a page mostly repeating
+	// another or linking to a heading it lacks. See duplicateWarnings,
+	// anchorWarnings and checkFrontMatterSchema.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// SuggestedTags are tags for a page written without any, for the
+	// author to add. See suggestTags.
+	SuggestedTags []string `json:"suggestedTags,omitempty"`
+
+	// Error says why a doc written with others wasn't. See writeAll.
+	Error string `json:"error,omitempty"`
+
+	// oldSize is the size of the revision being replaced and oldTime
+	// when it was written.
+	oldSize int64
+	oldTime time.Time
+
+	// body is the content to write, once normalized.
+	body []byte
+}
+
+// dryRun reports whether request asks for its writes to be checked and
+// reported but not made.
+func dryRun(request events.APIGatewayProxyRequest) bool {
+	switch strings.ToLower(request.QueryStringParameters["dryRun"]) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// lintDoc checks body as the content of docId, returning any problems that
+// would make it break the site: config that doesn't parse, templates that
+// don't parse, and malformed front matter.
+func lintDoc(docId string, body []byte) []string {
+	switch {
+	case docId == configDocName || strings.HasPrefix(docId, configDocName+"."):
+		return lintConfig(body)
+	case docId == variablesDocName:
+		var vars map[string]string
+		if err := yaml.UnmarshalStrict(body, &vars); err != nil {
+			return []string{err.Error()}
+		}
+		return nil
+	case docId == statusDocName:
+		return lintStatus(body)
+	case isRedirectsDoc(docId):
+		return lintRedirects(docId, body)
+	case docId == policiesDocName:
+		return lintPolicies(body)
+	case isDefaultsDoc(docId):
+		return lintDefaults(body)
+	case isSchemaDoc(docId):
+		return lintSchema(body)
+	case strings.HasSuffix(docId, "-template.html"), strings.HasPrefix(docId, blocksDocPrefix):
+		return lintTemplate(docId, body)
+	case isPage(docId):
+		block, _, ok := frontMatterBlock(body)
+		if !ok {
+			return nil
+		}
+		var fm frontMatter
+		if err := yaml.UnmarshalStrict(block, &fm); err != nil {
+			return []string{"front matter: " + err.Error()}
+		}
+		return append(append(lintBlocks(fm), lintSource(fm)...), lintCanonical(fm)...)
+	}
+	return nil
+}
+
+// planWrite validates and lints writing body to docId and works out what
+// it would change, without writing anything.
+func planWrite(ctx context.Context, docId string, body []byte) (writeResult, error) {
+	res := writeResult{DocId: docId, Action: "create"}
+	op := "write " + docId
+
+	if docId == "" || docstore.ValidateDocId(docId) != nil {
+		return res, docerr.WithDetails(op, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"docId": docId})
+	}
+
+	err := requireAdministers(ctx, op, docId)
+	if err != nil {
+		return res, err
+	}
+
+	// Holds, labels, suggestions and drafts are only changed through their
+	// own endpoints, which audit every change.
+	if docId == holdsDocName {
+		return res, docerr.WithDetails(op, docerr.ErrForbidden, nil,
+			map[string]interface{}{"reason": "use the holds API"})
+	}
+	if docId == labelsDocName {
+		return res, docerr.WithDetails(op, docerr.ErrForbidden, nil,
+			map[string]interface{}{"reason": "use the labels API"})
+	}
+	if strings.HasPrefix(docId, suggestionPrefix) {
+		return res, docerr.WithDetails(op, docerr.ErrForbidden, nil,
+			map[string]interface{}{"reason": "use the suggestions API"})
+	}
+	if docId == draftsDocName || strings.HasPrefix(docId, draftPrefix) {
+		return res, docerr.WithDetails(op, docerr.ErrForbidden, nil,
+			map[string]interface{}{"reason": "use the drafts API"})
+	}
+
+	body = normalize(getConfig(ctx).Normalize, docId, body)
+	res.body = body
+	if int64(len(body)) > maxDocBytes {
+		return res, oversizeError(op, docId)
+	}
+
+	err = checkQuota(ctx, docId, len(body))
+	if err != nil {
+		return res, err
+	}
+
+	res.Problems = lintDoc(docId, body)
+	res.Problems = append(res.Problems, ownerProblems(ctx, docId, body)...)
+	res.Warnings = append(res.Warnings, anchorWarnings(docId, body)...)
+	res.Warnings = append(res.Warnings, templateWarnings(docId, body)...)
+	res.Warnings = append(res.Warnings, directiveWarnings(docId, body)...)
+	checkRawHTML(getConfig(ctx), docId, body, &res)
+	for _, p := range checkFrontMatterSchema(ctx, docId, body) {
+		if getConfig(ctx).FrontMatterSchema.Policy == "block" {
+			res.Problems = append(res.Problems, p)
+		} else {
+			res.Warnings = append(res.Warnings, p)
+		}
+	}
+
+	pii := getConfig(ctx).PII
+	res.Findings = scanSensitive(ctx, pii, body)
+	if pii.Policy == "block" {
+		for _, f := range res.Findings {
+			res.Problems = append(res.Problems, describeFinding(f))
+		}
+	}
+
+	latest, err := fetchDoc(ctx, docId)
+	switch {
+	case errors.Is(err, docerr.ErrNotFound):
+		_, res.Inserted = textdiff.Stats(textdiff.Lines("", string(body)))
+		checkRevisionMessage(ctx, &res)
+		res.Warnings = append(res.Warnings, duplicateWarnings(ctx, docId, body)...)
+		res.Warnings = append(res.Warnings, pinWarnings(ctx, docId)...)
+		res.SuggestedTags = suggestTags(ctx, docId, body)
+		return res, nil
+	case err != nil:
+		return res, err
+	}
+
+	res.BaseVersion, res.oldSize, res.oldTime = latest.meta.Id, int64(len(latest.body)), latest.meta.Timestamp
+	if bytes.Equal(latest.body, body) {
+		res.Action = "unchanged"
+		return res, nil
+	}
+
+	res.Action = "update"
+	checkSource(ctx, docId, latest, body, &res)
+	res.Deleted, res.Inserted = textdiff.Stats(textdiff.Lines(string(latest.body), string(body)))
+	checkRevisionMessage(ctx, &res)
+	res.Warnings = append(res.Warnings, duplicateWarnings(ctx, docId, body)...)
+	res.Warnings = append(res.Warnings, pinWarnings(ctx, docId)...)
+	res.SuggestedTags = suggestTags(ctx, docId, body)
+	return res, nil
+}
+
+// writeDoc writes body as a new revision of docId unless dryRun is set or
+// it has problems.
+func writeDoc(ctx context.Context, docId string, body []byte, dryRun bool) (writeResult, error) {
+	res, err := planWrite(ctx, docId, body)
+	if err != nil {
+		return res, err
+	}
+	body = res.body
+
+	freeze, err := checkFreeze(ctx, docId)
+	if err != nil {
+		return res, err
+	}
+	err = checkReview(ctx, docId, body)
+	if err != nil {
+		return res, err
+	}
+
+	res.DryRun = dryRun
+	if dryRun {
+		return res, nil
+	}
+
+	if len(res.Problems) > 0 {
+		return res, docerr.WithDetails("write "+docId, docerr.ErrBadRequest, nil,
+			map[string]interface{}{"docId": docId, "problems": res.Problems})
+	}
+
+	// Writing what's already there, as sync jobs often do, would only
+	// add an identical revision to the history.
+	if res.Action == "unchanged" {
+		res.Version, res.Timestamp = res.BaseVersion, &res.oldTime
+		return res, nil
+	}
+
+	err = checkMalware(ctx, docId, body)
+	if err != nil {
+		return res, err
+	}
+
+	rev, err := ds.PutRevision(docId, bytes.NewReader(body))
+	if err != nil {
+		return res, docerr.FromStore("PutRevision "+docId, err)
+	}
+	// A fetch of docId already in flight may have read the revision before
+	// this one; reads from now on start afresh.
+	flights.Forget("doc:" + docId)
+
+	recordTenantStorage(ctx, docId, int64(len(body))-res.oldSize)
+
+	meta := rev.Metadata()
+	res.Version, res.Timestamp = meta.Id, &meta.Timestamp
+	recordRevisionMeta(ctx, meta, body)
+	auditWrite(ctx, res)
+	if freeze != nil {
+		auditFreezeOverride(ctx, freeze, res)
+	}
+	noteWrite(docId, fetchedDoc{meta: meta, body: body})
+	noteAssetRefs(ctx, docId, body)
+	return res, nil
+}
+
+// docsPrefix is where docs are written outside the JSON API.
+const docsPrefix = "/docs/"
+
+// putDoc writes the request body as a new revision of the docId path
+// parameter.
+func putDoc(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	res, err := writeDoc(ctx, request.PathParameters["docId"], body, dryRun(request))
+	if err != nil {
+		return Response{}, err
+	}
+
+	status := 200
+	if res.Action == "create" && !res.DryRun {
+		status = 201
+	}
+	return jsonResponse(status, res), nil
+}
+
+// serveWrite answers PUT and POST /docs/{docId}, the plain write endpoint
+// for clients that don't use the JSON API, by writing the body as a new
+// revision of docId.
+func serveWrite(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	docId := strings.TrimPrefix(request.Path, docsPrefix)
+	if request.HTTPMethod != "PUT" && request.HTTPMethod != "POST" {
+		return Response{}, docerr.E(request.HTTPMethod+" "+request.Path, docerr.ErrMethodNotAllowed, nil)
+	}
+
+	role, err := requireWriter(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	request.PathParameters = map[string]string{"docId": docId}
+	return idempotent(putDoc)(withRole(ctx, role), request)
+}
+
+// bulkWrite is the body of a bulk write request.
+type bulkWrite struct {
+	Docs []struct {
+		DocId string `json:"docId"`
+		Body  string `json:"body"`
+	} `json:"docs"`
+}
+
+// bulkPutDocs writes several docs in one request; see writeAll.
+func bulkPutDocs(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	body, err := requestBody(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var bulk bulkWrite
+	err = json.Unmarshal(body, &bulk)
+	if err != nil {
+		return Response{}, docerr.E("bulk write", docerr.ErrBadRequest, err)
+	}
+
+	var docs []docWrite
+	for _, d := range bulk.Docs {
+		docs = append(docs, docWrite{d.DocId, []byte(d.Body)})
+	}
+	results, status, err := writeAll(ctx, docs, dryRun(request))
+	if err != nil {
+		return Response{}, err
+	}
+	return jsonResponse(status, struct {
+		Docs []writeResult `json:"docs"`
+	}{results}), nil
+}
+
+// docWrite is one of the docs writeAll writes.
+type docWrite struct {
+	docId string
+	body  []byte
+}
+
+// writeAll writes docs in order, returning their results and the status
+// to answer with. Every doc is checked before any is written, and if one
+// has problems none is, and the status is 400. A doc that fails to be
+// written stops the rest: the docs before it stay written, and its result
+// and theirs after it have an Error, with the status of its error. With
+// dryRun the docs are only checked.
+func writeAll(ctx context.Context, docs []docWrite, dryRun bool) ([]writeResult, int, error) {
+	var results []writeResult
+	failed := false
+	for _, d := range docs {
+		res, err := planWrite(ctx, d.docId, d.body)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := checkFreeze(ctx, d.docId); err != nil {
+			return nil, 0, err
+		}
+		if err := checkReview(ctx, d.docId, res.body); err != nil {
+			return nil, 0, err
+		}
+		res.DryRun = dryRun
+		failed = failed || len(res.Problems) > 0
+		results = append(results, res)
+	}
+	if failed {
+		return results, 400, nil
+	}
+	if dryRun {
+		return results, 200, nil
+	}
+
+	for i, d := range docs {
+		res, err := writeDoc(ctx, d.docId, d.body, false)
+		if err != nil {
+			log.Printf("bulk write %s: %v", d.docId, err)
+			results[i].Error = docerr.Message(err)
+			for j := i + 1; j < len(results); j++ {
+				results[j].Error = "not written: " + d.docId + " failed"
+			}
+			return results, docerr.Status(err), nil
+		}
+		results[i] = res
+	}
+	return results, 200, nil
+}
@@ -0,0 +1,104 @@
+// Command invalidate issues CloudFront invalidations for docs as new
+// revisions are written, so CDN-fronted deployments don't keep serving
+// stale pages. It is triggered by the revisions table's DynamoDB stream and
+// does nothing unless CLOUDFRONT_DISTRIBUTION_ID is set.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/drocamor/n22t.docstore/runtimeapi"
+)
+
+var distributionId = os.Getenv("CLOUDFRONT_DISTRIBUTION_ID")
+
+// paths are the canonical paths that may serve a cached copy of docId.
+// Pages are served at their own path. Anything else, like templates, the
+// site config, and assets whose revisioned URLs pages embed, can change
+// every page, so the whole distribution is invalidated.
+func paths(docId string) []string {
+	if strings.Contains(docId, ".") || strings.HasPrefix(docId, "_") {
+		return []string{"/*"}
+	}
+
+	if docId == "index" {
+		return []string{"/", "/index"}
+	}
+
+	return []string{"/" + docId}
+}
+
+// changedPaths collects the paths to invalidate for the revisions inserted
+// in event.
+func changedPaths(event events.DynamoDBEvent) []string {
+	seen := make(map[string]bool)
+	for _, record := range event.Records {
+		if record.EventName != "INSERT" {
+			continue
+		}
+
+		docId, ok := record.Change.Keys["DocId"]
+		if !ok {
+			continue
+		}
+
+		for _, p := range paths(docId.String()) {
+			if p == "/*" {
+				return []string{p}
+			}
+			seen[p] = true
+		}
+	}
+
+	var ps []string
+	for p := range seen {
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+
+	return ps
+}
+
+// Handler invalidates the paths of the docs with new revisions in event.
+func Handler(ctx context.Context, event events.DynamoDBEvent) error {
+	if distributionId == "" {
+		return nil
+	}
+
+	ps := changedPaths(event)
+	if len(ps) == 0 {
+		return nil
+	}
+
+	cf := cloudfront.New(session.Must(session.NewSession()))
+	_, err := cf.CreateInvalidationWithContext(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(distributionId),
+		InvalidationBatch: &cloudfront.InvalidationBatch{
+			CallerReference: aws.String(fmt.Sprintf("docstore-%d", time.Now().UnixNano())),
+			Paths: &cloudfront.Paths{
+				Quantity: aws.Int64(int64(len(ps))),
+				Items:    aws.StringSlice(ps),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalidate %v: %w", ps, err)
+	}
+
+	log.Printf("invalidated %v", ps)
+	return nil
+}
+
+func main() {
+	runtimeapi.Start(Handler)
+}
@@ -0,0 +1,335 @@
+// Package jsonschema validates values decoded from JSON or YAML against a
+// JSON Schema. It supports the keywords that describe metadata: type,
+// enum, const, properties, required, additionalProperties, items, the
+// length, size and range limits, pattern, uniqueItems and the date,
+// date-time, email and uri formats. Other keywords are ignored.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is a parsed JSON Schema.
+type Schema struct {
+	Type  types         `json:"type"`
+	Enum  []interface{} `json:"enum"`
+	Const interface{}   `json:"const"`
+
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+
+	Items       *Schema `json:"items"`
+	MinItems    *int    `json:"minItems"`
+	MaxItems    *int    `json:"maxItems"`
+	UniqueItems bool    `json:"uniqueItems"`
+
+	MinLength *int   `json:"minLength"`
+	MaxLength *int   `json:"maxLength"`
+	Pattern   string `json:"pattern"`
+	Format    string `json:"format"`
+
+	Minimum *float64 `json:"minimum"`
+	Maximum *float64 `json:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+// types is the type keyword, a single type or a list of them.
+type types []string
+
+func (t *types) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*t = types{one}
+		return nil
+	}
+	var many []string
+	err := json.Unmarshal(b, &many)
+	*t = many
+	return err
+}
+
+// additional is the additionalProperties keyword: false forbids
+// properties not in properties, and a schema validates them.
+type additional struct {
+	allowed bool
+	schema  *Schema
+}
+
+func (a *additional) UnmarshalJSON(b []byte) error {
+	if json.Unmarshal(b, &a.allowed) == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(b, &a.schema)
+}
+
+// Parse parses a schema from JSON.
+func Parse(b []byte) (*Schema, error) {
+	var s Schema
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, err
+	}
+	err = s.compile()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compile compiles the schema's patterns.
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	subs := []*Schema{s.Items}
+	for _, p := range s.Properties {
+		subs = append(subs, p)
+	}
+	if s.AdditionalProperties != nil {
+		subs = append(subs, s.AdditionalProperties.schema)
+	}
+	for _, sub := range subs {
+		if sub == nil {
+			continue
+		}
+		if err := sub.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Error is a way a value fails a schema. Path locates the failing part of
+// the value, like "tags[1]", and is empty for the value itself.
+type Error struct {
+	Path    string
+	Message string
+}
+
+func (e Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Validate returns the ways v fails s, or nil if it passes. v holds what
+// encoding/json or a YAML decoder decode into an interface{}; YAML maps'
+// keys are taken as strings.
+func (s *Schema) Validate(v interface{}) []Error {
+	var errs []Error
+	s.validate("", normalize(v), &errs)
+	return errs
+}
+
+// normalize converts YAML maps to JSON objects and numbers to float64.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = normalize(e)
+		}
+		return l
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return v
+}
+
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *Schema) validate(path string, v interface{}, errs *[]Error) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{path, fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 {
+		t, ok := typeOf(v), false
+		for _, want := range s.Type {
+			ok = ok || want == t || (want == "number" && t == "integer")
+		}
+		if !ok {
+			fail("is %s, want %s", t, strings.Join(s.Type, " or "))
+			return
+		}
+	}
+	if s.Enum != nil {
+		ok := false
+		for _, e := range s.Enum {
+			ok = ok || reflect.DeepEqual(normalize(e), v)
+		}
+		if !ok {
+			fail("must be one of %s", list(s.Enum))
+		}
+	}
+	if s.Const != nil && !reflect.DeepEqual(normalize(s.Const), v) {
+		fail("must be %v", s.Const)
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("longer than %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("doesn't match %s", s.Pattern)
+		}
+		if msg := checkFormat(s.Format, v); msg != "" {
+			fail("%s", msg)
+		}
+
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("less than %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("more than %v", *s.Maximum)
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("fewer than %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("more than %d items", *s.MaxItems)
+		}
+		for i, e := range v {
+			if s.UniqueItems {
+				for _, prev := range v[:i] {
+					if reflect.DeepEqual(prev, e) {
+						fail("has %v more than once", e)
+					}
+				}
+			}
+			if s.Items != nil {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e, errs)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if _, ok := v[k]; !ok {
+				fail("missing %s", k)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub := join(path, k)
+			if p, ok := s.Properties[k]; ok {
+				p.validate(sub, v[k], errs)
+				continue
+			}
+			if a := s.AdditionalProperties; a != nil {
+				if !a.allowed {
+					*errs = append(*errs, Error{sub, "unknown field"})
+				} else if a.schema != nil {
+					a.schema.validate(sub, v[k], errs)
+				}
+			}
+		}
+	}
+}
+
+// checkFormat returns why v isn't in format, or "" if it is or the format
+// isn't known.
+func checkFormat(format, v string) string {
+	var err error
+	switch format {
+	case "date":
+		_, err = time.Parse("2006-01-02", v)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, v)
+	case "email":
+		_, err = mail.ParseAddress(v)
+	case "uri":
+		var u *url.URL
+		u, err = url.Parse(v)
+		if err == nil && u.Scheme == "" {
+			err = fmt.Errorf("no scheme")
+		}
+	}
+	if err != nil {
+		return "not a " + format
+	}
+	return ""
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func list(values []interface{}) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
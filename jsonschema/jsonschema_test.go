@@ -0,0 +1,106 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string // YAML, as front matter is written
+		want   []string
+	}{
+		{"type", `{"type": "string"}`, `3`, []string{"is integer, want string"}},
+		{"type list", `{"type": ["string", "null"]}`, `~`, nil},
+		{"integer is a number", `{"type": "number"}`, `3`, nil},
+		{"number isn't an integer", `{"type": "integer"}`, `1.5`, []string{"is number, want integer"}},
+		{"enum", `{"enum": ["draft", "final"]}`, `done`, []string{"must be one of draft, final"}},
+		{"enum number", `{"enum": [1, 2]}`, `2`, nil},
+		{"const", `{"const": true}`, `false`, []string{"must be true"}},
+		{"length", `{"minLength": 2, "maxLength": 3}`, `é`, []string{"shorter than 2 characters"}},
+		{"length in characters", `{"maxLength": 2}`, `éé`, nil},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `Ops`, []string{"doesn't match ^[a-z]+$"}},
+		{"range", `{"minimum": 1, "maximum": 5}`, `7`, []string{"more than 5"}},
+		{"date", `{"format": "date"}`, `2024-02-30`, []string{"not a date"}},
+		{"yaml date", `{"type": "string", "format": "date"}`, `2024-02-03`, nil},
+		{"email", `{"format": "email"}`, `ops@example.com`, nil},
+		{"uri", `{"format": "uri"}`, `/relative`, []string{"not a uri"}},
+		{"unknown format", `{"format": "color"}`, `teal`, nil},
+		{"items", `{"items": {"type": "string"}, "maxItems": 2}`, `[a, 1, c]`,
+			[]string{"more than 2 items", "[1]: is integer, want string"}},
+		{"unique", `{"uniqueItems": true}`, `[a, b, a]`, []string{"has a more than once"}},
+		{"object", `{
+			"type": "object",
+			"required": ["title", "owner"],
+			"properties": {"title": {"type": "string"}, "tags": {"items": {"pattern": "^[a-z]+$"}}},
+			"additionalProperties": false
+		}`, `{title: 3, tags: [ok, Bad], extra: 1}`, []string{
+			"missing owner",
+			"extra: unknown field",
+			"tags[1]: doesn't match ^[a-z]+$",
+			"title: is integer, want string",
+		}},
+		{"additional schema", `{"additionalProperties": {"type": "boolean"}}`, `{draft: yes, pinned: true}`, nil},
+		{"nested path", `{"properties": {"review": {"properties": {"by": {"type": "string"}}}}}`, `{review: {by: [x]}}`,
+			[]string{"review.by: is array, want string"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var v interface{}
+			if err := yaml.Unmarshal([]byte(tt.value), &v); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range s.Validate(v) {
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate(%s) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	s, err := Parse([]byte(`{"properties": {"n": {"type": "integer", "maximum": 10}, "at": {"format": "date-time"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := json.NewDecoder(strings.NewReader(`{"n": 11}`))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if errs := s.Validate(v); len(errs) != 1 || errs[0].Error() != "n: more than 10" {
+		t.Errorf("Validate = %v, want n: more than 10", errs)
+	}
+	if errs := s.Validate(map[string]interface{}{"at": time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)}); errs != nil {
+		t.Errorf("Validate of a time = %v, want none", errs)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, schema := range []string{
+		`{"pattern": "("}`,
+		`{"properties": {"a": {"items": {"pattern": "["}}}}`,
+		`{"additionalProperties": {"pattern": "*"}}`,
+		`{"type": 3}`,
+		`not json`,
+	} {
+		if _, err := Parse([]byte(schema)); err == nil {
+			t.Errorf("Parse(%s) succeeded, want an error", schema)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Command local previews a site over plain HTTP from a directory of docs,
+// so rendering, templates and routing can be tried before deploying.
+//
+//	local [-addr host:port] dir
+//
+// Each file in dir is a doc, and a subdirectory of numbered files a doc
+// with history; see fsstore. Writes through the API land in dir too.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/n22t.docstore/fsstore"
+	"github.com/drocamor/n22t.docstore/handler"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to serve the preview on")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: local [-addr host:port] dir")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := flag.Arg(0)
+
+	handler.UseStore(fsstore.New(dir), func(where string) docstore.DocStore {
+		return fsstore.New(where)
+	})
+	handler.ValidateStartup()
+
+	log.Printf("serving %s on %s", dir, *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler.HTTPHandler()))
+}
@@ -0,0 +1,143 @@
+// Command maintenance does the store's housekeeping: it deletes the assets
+// uploaded to ASSET_BUCKET that no doc has linked to for ASSET_GC_GRACE,
+// which leaves time for a doc to link to an asset after it is uploaded and
+// for a link removed by mistake to be put back, and the uploads to
+// pre-signed URLs that were never completed. It runs on a schedule.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/blobstore"
+	"github.com/drocamor/n22t.docstore/runtimeapi"
+)
+
+var (
+	ds docstore.DocStore
+
+	// bucket holds the uploaded assets.
+	bucket = os.Getenv("ASSET_BUCKET")
+
+	// grace is how long an asset must go unreferenced to be deleted.
+	grace = envDuration("ASSET_GC_GRACE", 30*24*time.Hour)
+)
+
+// uploadExpiry is how long after an upload to a pre-signed URL it is
+// deleted if it hasn't been completed.
+const uploadExpiry = 24 * time.Hour
+
+func init() {
+	var opts []awsdocstore.AwsDocStoreOption
+	if t := os.Getenv("DOCS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithDocTable(t))
+	}
+	if t := os.Getenv("REVISIONS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithRevisionTable(t))
+	}
+	ds = awsdocstore.New(opts...)
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// getRefs reads the references to stored assets. There are none before
+// the first upload.
+func getRefs() (blobstore.Refs, error) {
+	rev, err := ds.GetDoc(blobstore.RefsDocName)
+	if err != nil && strings.Contains(err.Error(), "Doc not found.") {
+		return blobstore.Refs{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetDoc %s: %w", blobstore.RefsDocName, err)
+	}
+	body, err := ioutil.ReadAll(rev)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", blobstore.RefsDocName, err)
+	}
+	return blobstore.ParseRefs(body)
+}
+
+// Handler collects the unreferenced assets.
+func Handler(ctx context.Context) error {
+	if bucket == "" {
+		log.Print("no ASSET_BUCKET; nothing to collect")
+		return nil
+	}
+
+	store := blobstore.New(s3.New(session.Must(session.NewSession())), bucket)
+	n, err := store.ExpireUploads(ctx, time.Now().Add(-uploadExpiry))
+	log.Printf("deleted %d uploads never completed", n)
+	if err != nil {
+		return fmt.Errorf("expiring uploads: %w", err)
+	}
+
+	refs, err := getRefs()
+	if err != nil {
+		return err
+	}
+	before := make(map[string]bool, len(refs))
+	for hash := range refs {
+		before[hash] = true
+	}
+
+	deleted, err := store.Collect(ctx, refs, grace, time.Now())
+	log.Printf("deleted %d unreferenced assets", len(deleted))
+	for _, hash := range deleted {
+		log.Printf("deleted asset %s", hash)
+	}
+
+	// Docs may have been written while collecting, so what was collected
+	// is removed from the latest references, even if collecting stopped
+	// part way.
+	if len(refs) != len(before) {
+		perr := removeRefs(before, refs)
+		if perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+// removeRefs removes the references in before but not in after from the
+// latest references, unless a doc has linked to them since.
+func removeRefs(before map[string]bool, after blobstore.Refs) error {
+	latest, err := getRefs()
+	if err != nil {
+		return err
+	}
+	for hash := range before {
+		if _, kept := after[hash]; !kept && latest.Count(hash) == 0 {
+			delete(latest, hash)
+		}
+	}
+
+	b, err := latest.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = ds.PutRevision(blobstore.RefsDocName, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("PutRevision %s: %w", blobstore.RefsDocName, err)
+	}
+	return nil
+}
+
+func main() {
+	runtimeapi.Start(Handler)
+}
@@ -0,0 +1,95 @@
+// Package metrics emits CloudWatch metrics using the embedded metric format,
+// which CloudWatch extracts from the Lambda's log output.
+//
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Unit string
+
+const (
+	Count        Unit = "Count"
+	Milliseconds Unit = "Milliseconds"
+	Bytes        Unit = "Bytes"
+)
+
+var (
+	// Namespace is the CloudWatch namespace metrics are recorded in.
+	Namespace = "n22t-docstore"
+
+	// Output is where metric records are written.
+	Output io.Writer = os.Stdout
+
+	// Sink, if set, receives metrics instead of Output, for sending them
+	// somewhere other than CloudWatch.
+	Sink func(name string, value float64, unit Unit, dims map[string]string)
+
+	mu sync.Mutex
+)
+
+type metricDef struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit"`
+}
+
+type directive struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []metricDef `json:"Metrics"`
+}
+
+type awsMeta struct {
+	Timestamp         int64       `json:"Timestamp"`
+	CloudWatchMetrics []directive `json:"CloudWatchMetrics"`
+}
+
+// Emit records one value of the metric name, with the given dimensions.
+func Emit(name string, value float64, unit Unit, dims map[string]string) {
+	if Sink != nil {
+		Sink(name, value, unit, dims)
+		return
+	}
+
+	keys := make([]string, 0, len(dims))
+	record := map[string]interface{}{}
+	for k, v := range dims {
+		keys = append(keys, k)
+		record[k] = v
+	}
+	sort.Strings(keys)
+
+	record[name] = value
+	record["_aws"] = awsMeta{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []directive{{
+			Namespace:  Namespace,
+			Dimensions: [][]string{keys},
+			Metrics:    []metricDef{{Name: name, Unit: unit}},
+		}},
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("metric %s error: %v", name, err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	fmt.Fprintln(Output, string(b))
+}
+
+// Incr counts one occurrence of the metric name.
+func Incr(name string, dims map[string]string) {
+	Emit(name, 1, Count, dims)
+}
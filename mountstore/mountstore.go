@@ -0,0 +1,180 @@
+// Package mountstore presents several docstore.DocStores as one, so that
+// a site can show docs kept in separate stores. Each extra store is
+// mounted under a docId prefix: with a store mounted at "eng-", its doc
+// "guide" is the doc "eng-guide". Every other doc is in the root store,
+// which keeps the site's system docs.
+package mountstore
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/drocamor/docstore"
+)
+
+// The docstore's errors are only told apart by message.
+var errDocNotFound = fmt.Errorf("Doc not found.")
+
+type mount struct {
+	prefix string
+	ds     docstore.DocStore
+}
+
+type MountStore struct {
+	// mounts are the root store, under "", and then the mounted ones.
+	mounts []mount
+}
+
+type MountStoreOption func(*MountStore)
+
+// WithMount mounts ds under prefix, which should end with "-" so that its
+// docIds read as words.
+func WithMount(prefix string, ds docstore.DocStore) MountStoreOption {
+	return func(m *MountStore) {
+		m.mounts = append(m.mounts, mount{prefix, ds})
+	}
+}
+
+func New(root docstore.DocStore, opts ...MountStoreOption) *MountStore {
+	m := &MountStore{mounts: []mount{{"", root}}}
+
+	for _, o := range opts {
+		o(m)
+	}
+
+	return m
+}
+
+// resolve returns the store holding docId, and its docId there. A mounted
+// store's system docs, starting with "_", aren't reachable.
+func (m *MountStore) resolve(docId string) (int, string, error) {
+	for i, mt := range m.mounts[1:] {
+		if strings.HasPrefix(docId, mt.prefix) {
+			inner := strings.TrimPrefix(docId, mt.prefix)
+			if inner == "" || strings.HasPrefix(inner, "_") {
+				return 0, "", errDocNotFound
+			}
+			return i + 1, inner, nil
+		}
+	}
+	return 0, docId, nil
+}
+
+// shadowed reports whether the root store's doc docId is hidden by a
+// mount.
+func (m *MountStore) shadowed(docId string) bool {
+	for _, mt := range m.mounts[1:] {
+		if strings.HasPrefix(docId, mt.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// revision is a mounted store's revision under its docId in the
+// MountStore.
+type revision struct {
+	docstore.Revision
+	docId string
+}
+
+func (r *revision) Metadata() docstore.RevisionMetadata {
+	meta := r.Revision.Metadata()
+	meta.DocId = r.docId
+	return meta
+}
+
+func (m *MountStore) wrap(i int, rev docstore.Revision, err error) (docstore.Revision, error) {
+	if err != nil || i == 0 {
+		return rev, err
+	}
+	return &revision{rev, m.mounts[i].prefix + rev.Metadata().DocId}, nil
+}
+
+func (m *MountStore) GetDoc(docId string) (docstore.Revision, error) {
+	i, inner, err := m.resolve(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := m.mounts[i].ds.GetDoc(inner)
+	return m.wrap(i, rev, err)
+}
+
+func (m *MountStore) GetRevision(docId string, revisionId int) (docstore.Revision, error) {
+	i, inner, err := m.resolve(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := m.mounts[i].ds.GetRevision(inner, revisionId)
+	return m.wrap(i, rev, err)
+}
+
+func (m *MountStore) PutRevision(docId string, body io.Reader) (docstore.Revision, error) {
+	i, inner, err := m.resolve(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := m.mounts[i].ds.PutRevision(inner, body)
+	return m.wrap(i, rev, err)
+}
+
+// ListDocs lists the root store's docs and then each mounted store's in
+// turn. Its tokens are the index of the store being listed and that
+// store's own token, like "1:abc".
+func (m *MountStore) ListDocs(token string) (page docstore.DocPage, err error) {
+	i, inner := 0, ""
+	if token != "" {
+		parts := strings.SplitN(token, ":", 2)
+		i, err = strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || i < 0 || i >= len(m.mounts) {
+			return page, fmt.Errorf("mountstore: bad token %q", token)
+		}
+		inner = parts[1]
+	}
+
+	mt := m.mounts[i]
+	p, err := mt.ds.ListDocs(inner)
+	if err != nil {
+		return page, err
+	}
+
+	page.Docs = make([]docstore.Doc, 0, len(p.Docs))
+	for _, d := range p.Docs {
+		switch {
+		case i == 0 && m.shadowed(d.Id):
+		case i > 0 && strings.HasPrefix(d.Id, "_"):
+		default:
+			d.Id = mt.prefix + d.Id
+			page.Docs = append(page.Docs, d)
+		}
+	}
+
+	switch {
+	case p.More && p.NextToken != "":
+		page.NextToken, page.More = fmt.Sprintf("%d:%s", i, p.NextToken), true
+	case i+1 < len(m.mounts):
+		page.NextToken, page.More = fmt.Sprintf("%d:", i+1), true
+	}
+	return page, nil
+}
+
+func (m *MountStore) ListRevisions(docId string, token string) (docstore.RevisionPage, error) {
+	i, inner, err := m.resolve(docId)
+	if err != nil {
+		return docstore.RevisionPage{}, err
+	}
+
+	page, err := m.mounts[i].ds.ListRevisions(inner, token)
+	if err != nil {
+		return page, err
+	}
+	for j := range page.Revisions {
+		page.Revisions[j].DocId = docId
+	}
+	return page, nil
+}
@@ -0,0 +1,244 @@
+// Package otlp exports spans and metrics to an OpenTelemetry collector
+// using OTLP over HTTP with JSON encoding.
+//
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds and status codes, as numbered in the OTLP protocol.
+const (
+	spanKindServer = 2
+
+	statusOk    = 1
+	statusError = 2
+
+	temporalityDelta = 1
+)
+
+// ucum maps CloudWatch units to the UCUM codes OTLP expects.
+var ucum = map[string]string{
+	"Count":        "1",
+	"Milliseconds": "ms",
+	"Bytes":        "By",
+}
+
+// Span is one finished unit of work. Ids are lowercase hex, as in W3C
+// traceparent headers.
+type Span struct {
+	TraceId, SpanId, ParentSpanId string
+	Name                          string
+	Start, End                    time.Time
+	Attributes                    map[string]string
+	Error                         bool
+}
+
+// Exporter buffers spans and metrics and sends them to a collector when
+// flushed.
+type Exporter struct {
+	endpoint string
+	resource resource
+	client   *http.Client
+
+	mu      sync.Mutex
+	spans   []span
+	metrics []metric
+}
+
+// New returns an Exporter sending to the collector at endpoint, like
+// "http://localhost:4318", on behalf of service.
+func New(endpoint, service string) *Exporter {
+	return &Exporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		resource: resource{Attributes: attributes(map[string]string{"service.name": service})},
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// RecordSpan buffers s to be sent on the next Flush.
+func (e *Exporter) RecordSpan(s Span) {
+	status := status{Code: statusOk}
+	if s.Error {
+		status.Code = statusError
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span{
+		TraceId:      s.TraceId,
+		SpanId:       s.SpanId,
+		ParentSpanId: s.ParentSpanId,
+		Name:         s.Name,
+		Kind:         spanKindServer,
+		Start:        nanos(s.Start),
+		End:          nanos(s.End),
+		Attributes:   attributes(s.Attributes),
+		Status:       status,
+	})
+}
+
+// RecordMetric buffers one value of the metric name to be sent on the next
+// Flush. Counts are sent as deltas of a monotonic sum, and anything else as
+// a gauge.
+func (e *Exporter) RecordMetric(name string, value float64, unit string, dims map[string]string) {
+	m := metric{Name: name, Unit: ucum[unit]}
+	points := []dataPoint{{Value: value, Time: nanos(time.Now()), Attributes: attributes(dims)}}
+	if unit == "Count" {
+		m.Sum = &sum{DataPoints: points, Temporality: temporalityDelta, Monotonic: true}
+	} else {
+		m.Gauge = &gauge{DataPoints: points}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.metrics = append(e.metrics, m)
+}
+
+// Flush sends everything buffered to the collector. Anything that can't be
+// sent is dropped rather than kept to grow without bound.
+func (e *Exporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans, metrics := e.spans, e.metrics
+	e.spans, e.metrics = nil, nil
+	e.mu.Unlock()
+
+	scope := scope{Name: "n22t-docstore"}
+	var errs []string
+	if len(spans) > 0 {
+		err := e.post(ctx, "/v1/traces", map[string]interface{}{
+			"resourceSpans": []interface{}{map[string]interface{}{
+				"resource":   e.resource,
+				"scopeSpans": []interface{}{map[string]interface{}{"scope": scope, "spans": spans}},
+			}},
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(metrics) > 0 {
+		err := e.post(ctx, "/v1/metrics", map[string]interface{}{
+			"resourceMetrics": []interface{}{map[string]interface{}{
+				"resource":     e.resource,
+				"scopeMetrics": []interface{}{map[string]interface{}{"scope": scope, "metrics": metrics}},
+			}},
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("otlp: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (e *Exporter) post(ctx context.Context, path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+// nanos formats t as the decimal string OTLP JSON uses for 64 bit times.
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func attributes(m map[string]string) []attribute {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute, 0, len(m))
+	for _, k := range keys {
+		attrs = append(attrs, attribute{Key: k, Value: anyValue{String: m[k]}})
+	}
+	return attrs
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type attribute struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	String string `json:"stringValue"`
+}
+
+type status struct {
+	Code int `json:"code"`
+}
+
+type span struct {
+	TraceId      string      `json:"traceId"`
+	SpanId       string      `json:"spanId"`
+	ParentSpanId string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []attribute `json:"attributes"`
+	Status       status      `json:"status"`
+}
+
+type metric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	Sum   *sum   `json:"sum,omitempty"`
+	Gauge *gauge `json:"gauge,omitempty"`
+}
+
+type sum struct {
+	DataPoints  []dataPoint `json:"dataPoints"`
+	Temporality int         `json:"aggregationTemporality"`
+	Monotonic   bool        `json:"isMonotonic"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type dataPoint struct {
+	Value      float64     `json:"asDouble"`
+	Time       string      `json:"timeUnixNano"`
+	Attributes []attribute `json:"attributes"`
+}
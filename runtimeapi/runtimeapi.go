@@ -0,0 +1,205 @@
+// Package runtimeapi runs a Lambda handler on the provided.al2 runtime,
+// which speaks the Lambda Runtime API over HTTP rather than the RPC the
+// go1.x runtime used. The aws-lambda-go version this module is pinned to
+// only speaks RPC, and go1.x has no arm64 (Graviton) support, so Start
+// serves the Runtime API itself when it's there and falls back to
+// lambda.Start otherwise.
+package runtimeapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Start runs handler, which takes any of the forms lambda.Start takes,
+// and never returns.
+func Start(handler interface{}) {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		lambda.Start(handler)
+		return
+	}
+
+	invoke, err := newHandler(handler)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The next invocation is long polled, so the client has no timeout.
+	c := &client{base: "http://" + api + "/2018-06-01/runtime/", http: &http.Client{}}
+	for {
+		err := c.serveNext(invoke)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+type handlerFunc func(ctx context.Context, payload []byte) (interface{}, error)
+
+// newHandler checks handler's signature and returns a function calling
+// it with a JSON payload.
+func newHandler(handler interface{}) (handlerFunc, error) {
+	fn := reflect.ValueOf(handler)
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("handler kind %s is not func", t.Kind())
+	}
+	if t.NumIn() > 2 || t.NumIn() == 2 && !t.In(0).Implements(contextType) {
+		return nil, fmt.Errorf("handler takes %d arguments; want at most a context and an event", t.NumIn())
+	}
+	if t.NumOut() > 2 || t.NumOut() > 0 && !t.Out(t.NumOut()-1).Implements(errorType) {
+		return nil, fmt.Errorf("handler must return an error last")
+	}
+	takesContext := t.NumIn() > 0 && t.In(0).Implements(contextType)
+	takesEvent := t.NumIn() == 2 || t.NumIn() == 1 && !takesContext
+
+	return func(ctx context.Context, payload []byte) (interface{}, error) {
+		var args []reflect.Value
+		if takesContext {
+			args = append(args, reflect.ValueOf(ctx))
+		}
+		if takesEvent {
+			event := reflect.New(t.In(t.NumIn() - 1))
+			err := json.Unmarshal(payload, event.Interface())
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, event.Elem())
+		}
+
+		out := fn.Call(args)
+		var err error
+		if len(out) > 0 {
+			err, _ = out[len(out)-1].Interface().(error)
+		}
+		var v interface{}
+		if len(out) > 1 {
+			v = out[0].Interface()
+		}
+		return v, err
+	}, nil
+}
+
+type client struct {
+	base string
+	http *http.Client
+}
+
+// serveNext waits for the next invocation, runs it and posts its
+// response. Errors from the handler are reported to Lambda; errors
+// talking to Lambda are returned, as the runtime can't go on without it.
+func (c *client) serveNext(invoke handlerFunc) error {
+	resp, err := c.http.Get(c.base + "invocation/next")
+	if err != nil {
+		return err
+	}
+	payload, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("invocation/next: status %d", resp.StatusCode)
+	}
+
+	id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+	ms, _ := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Unix(ms/1000, ms%1000*int64(time.Millisecond)))
+	defer cancel()
+
+	lc := &lambdacontext.LambdaContext{
+		AwsRequestID:       id,
+		InvokedFunctionArn: resp.Header.Get("Lambda-Runtime-Invoked-Function-Arn"),
+	}
+	if cc := resp.Header.Get("Lambda-Runtime-Client-Context"); cc != "" {
+		json.Unmarshal([]byte(cc), &lc.ClientContext)
+	}
+	if ci := resp.Header.Get("Lambda-Runtime-Cognito-Identity"); ci != "" {
+		var identity struct {
+			ID     string `json:"cognitoIdentityId"`
+			PoolID string `json:"cognitoIdentityPoolId"`
+		}
+		json.Unmarshal([]byte(ci), &identity)
+		lc.Identity = lambdacontext.CognitoIdentity{CognitoIdentityID: identity.ID, CognitoIdentityPoolID: identity.PoolID}
+	}
+	ctx = lambdacontext.NewContext(ctx, lc)
+
+	// The X-Ray SDK and the go1.x runtime pass the trace header this way.
+	os.Setenv("_X_AMZN_TRACE_ID", resp.Header.Get("Lambda-Runtime-Trace-Id"))
+
+	v, err, panicked := call(ctx, invoke, payload)
+	if err == nil {
+		var out []byte
+		out, err = json.Marshal(v)
+		if err == nil {
+			return c.post("invocation/"+id+"/response", out, nil)
+		}
+	}
+
+	postErr := c.postError("invocation/"+id+"/error", err)
+	if panicked {
+		// As with the go1.x runtime, a panic ends the process so the next
+		// invocation starts from a clean one.
+		log.Fatalf("handler panicked: %v", err)
+	}
+	return postErr
+}
+
+// call runs invoke, turning a panic into an error.
+func call(ctx context.Context, invoke handlerFunc, payload []byte) (v interface{}, err error, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, panicked = fmt.Errorf("%v", r), true
+		}
+	}()
+	v, err = invoke(ctx, payload)
+	return
+}
+
+func (c *client) postError(path string, err error) error {
+	t := reflect.TypeOf(err)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	b, _ := json.Marshal(struct {
+		Message string `json:"errorMessage"`
+		Type    string `json:"errorType"`
+	}{err.Error(), t.Name()})
+	return c.post(path, b, map[string]string{"Lambda-Runtime-Function-Error-Type": t.Name()})
+}
+
+func (c *client) post(path string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		return fmt.Errorf("%s: status %d", path, resp.StatusCode)
+	}
+	return nil
+}
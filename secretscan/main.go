@@ -0,0 +1,130 @@
+// Command secretscan looks through every revision of every doc for
+// credentials and API keys, and writes what it finds to the _secret-scan
+// doc so they can be rotated. Old revisions are scanned too: removing a key
+// from a doc leaves it readable with ?rev. It runs on a schedule.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/runtimeapi"
+	"github.com/drocamor/n22t.docstore/sensitive"
+	"github.com/drocamor/n22t.docstore/storelist"
+)
+
+// reportDocName is the doc the findings are written to. Like other "_"
+// docs it isn't served to readers.
+const reportDocName = "_secret-scan"
+
+var ds docstore.DocStore
+
+func init() {
+	var opts []awsdocstore.AwsDocStoreOption
+	if t := os.Getenv("DOCS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithDocTable(t))
+	}
+	if t := os.Getenv("REVISIONS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithRevisionTable(t))
+	}
+	ds = awsdocstore.New(opts...)
+}
+
+// finding is a secret found in one revision of a doc.
+type finding struct {
+	docId string
+	rev   int
+	sensitive.Finding
+}
+
+// scanDoc scans every revision of docId.
+func scanDoc(ctx context.Context, docId string) ([]finding, error) {
+	revs, err := storelist.Revisions(ctx, ds, docId)
+	if err != nil {
+		return nil, fmt.Errorf("ListRevisions %s: %w", docId, err)
+	}
+
+	var findings []finding
+	for _, meta := range revs {
+		rev, err := ds.GetRevision(docId, meta.Id)
+		if err != nil {
+			return nil, fmt.Errorf("GetRevision %s@%d: %w", docId, meta.Id, err)
+		}
+		body, err := ioutil.ReadAll(rev)
+		if err != nil {
+			return nil, fmt.Errorf("read %s@%d: %w", docId, meta.Id, err)
+		}
+
+		for _, f := range sensitive.Scan(string(body), sensitive.Secrets) {
+			findings = append(findings, finding{docId: docId, rev: meta.Id, Finding: f})
+		}
+	}
+	return findings, nil
+}
+
+// report formats findings as a markdown doc.
+func report(findings []finding, scanned int, failed []string, at time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Secret scan\n\nScanned %d docs at %s.\n\n", scanned, at.UTC().Format(time.RFC3339))
+
+	if len(findings) == 0 {
+		b.WriteString("No secrets found.\n")
+	} else {
+		fmt.Fprintf(&b, "Found %d possible secrets. Rotate them, then remove them from the doc.\n\n", len(findings))
+		b.WriteString("| Doc | Revision | Line | Kind | Match |\n|---|---|---|---|---|\n")
+		for _, f := range findings {
+			fmt.Fprintf(&b, "| %s | %d | %d | %s | `%s` |\n", f.docId, f.rev, f.Line, f.Kind, f.Excerpt)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "\nThese docs couldn't be scanned: %s.\n", strings.Join(failed, ", "))
+	}
+	return b.Bytes()
+}
+
+// Handler scans the store and writes the report.
+func Handler(ctx context.Context) error {
+	docs, err := storelist.Docs(ctx, ds)
+	if err != nil {
+		return fmt.Errorf("ListDocs: %w", err)
+	}
+
+	var findings []finding
+	var failed []string
+	scanned := 0
+	for _, doc := range docs {
+		if doc.Id == reportDocName {
+			continue
+		}
+
+		found, err := scanDoc(ctx, doc.Id)
+		if err != nil {
+			log.Printf("scan error: %v", err)
+			failed = append(failed, doc.Id)
+			continue
+		}
+		findings = append(findings, found...)
+		scanned++
+	}
+
+	_, err = ds.PutRevision(reportDocName, bytes.NewReader(report(findings, scanned, failed, time.Now())))
+	if err != nil {
+		return fmt.Errorf("PutRevision %s: %w", reportDocName, err)
+	}
+
+	log.Printf("scanned %d docs, %d findings", scanned, len(findings))
+	return nil
+}
+
+func main() {
+	runtimeapi.Start(Handler)
+}
@@ -0,0 +1,107 @@
+// Package sensitive finds likely personal data and credentials in text.
+package sensitive
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Pattern detects one kind of sensitive data.
+type Pattern struct {
+	Kind string
+	Re   *regexp.Regexp
+
+	// Valid, if set, filters out matches that fit the pattern but can't be
+	// the real thing, like card numbers failing the Luhn check.
+	Valid func(match string) bool
+}
+
+// Finding is one likely piece of sensitive data. Offset is the byte offset
+// in the text and Line its 1-based line. Excerpt is the match masked, so
+// reports don't repeat the secret.
+type Finding struct {
+	Kind    string `json:"kind"`
+	Line    int    `json:"line"`
+	Offset  int    `json:"offset"`
+	Excerpt string `json:"excerpt"`
+}
+
+// PII are patterns for personal data.
+var PII = []Pattern{
+	{Kind: "email", Re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{Kind: "credit_card", Re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), Valid: luhn},
+	{Kind: "us_ssn", Re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+}
+
+// Secrets are patterns for credentials and API keys in common token
+// formats.
+var Secrets = []Pattern{
+	{Kind: "aws_access_key_id", Re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Kind: "aws_secret_access_key", Re: regexp.MustCompile(`(?i)aws_?secret_?access_?key\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{Kind: "private_key", Re: regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`)},
+	{Kind: "github_token", Re: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{Kind: "slack_token", Re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{Kind: "stripe_key", Re: regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}\b`)},
+	{Kind: "google_api_key", Re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{Kind: "jwt", Re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\b`)},
+}
+
+// Default is every built-in pattern.
+var Default = append(append([]Pattern{}, PII...), Secrets...)
+
+// Scan returns the matches of patterns in text, in order.
+func Scan(text string, patterns []Pattern) (findings []Finding) {
+	for _, p := range patterns {
+		for _, loc := range p.Re.FindAllStringIndex(text, -1) {
+			match := text[loc[0]:loc[1]]
+			if p.Valid != nil && !p.Valid(match) {
+				continue
+			}
+			findings = append(findings, Finding{
+				Kind:    p.Kind,
+				Line:    strings.Count(text[:loc[0]], "\n") + 1,
+				Offset:  loc[0],
+				Excerpt: Mask(match),
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Offset < findings[j].Offset })
+	return
+}
+
+// Mask hides all but the first and last few characters of s.
+func Mask(s string) string {
+	n := len(s) / 6
+	if n > 4 {
+		n = 4
+	}
+	if len(s) <= 2*n {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:n] + strings.Repeat("*", len(s)-2*n) + s[len(s)-n:]
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by card
+// numbers.
+func luhn(s string) bool {
+	sum, double, digits := 0, false, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	docstorev1 "github.com/drocamor/n22t.docstore/docstorev1"
+	"github.com/drocamor/n22t.docstore/handler"
+)
+
+// docService serves the doc API by calling the REST routes it mirrors
+// through handler.Handler, so both share auth, limits, validation, audit
+// and caching, and answers with their JSON decoded into the messages.
+type docService struct{}
+
+func (docService) GetDoc(ctx context.Context, req *connect.Request[docstorev1.GetDocRequest]) (*connect.Response[docstorev1.GetDocResponse], error) {
+	res := &docstorev1.GetDocResponse{}
+	if err := call(ctx, req, http.MethodGet, "/"+req.Msg.DocId, nil, "", req.Msg.DocId, res); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (docService) PutDoc(ctx context.Context, req *connect.Request[docstorev1.PutDocRequest]) (*connect.Response[docstorev1.PutDocResponse], error) {
+	query := url.Values{}
+	if req.Msg.DryRun {
+		query.Set("dryRun", "true")
+	}
+	res := &docstorev1.PutDocResponse{}
+	if err := call(ctx, req, http.MethodPut, "/api/v1/docs/"+req.Msg.DocId, query, req.Msg.Body, req.Msg.DocId, res); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (docService) ListRevisions(ctx context.Context, req *connect.Request[docstorev1.ListRevisionsRequest]) (*connect.Response[docstorev1.ListRevisionsResponse], error) {
+	res := &docstorev1.ListRevisionsResponse{}
+	if err := call(ctx, req, http.MethodGet, "/api/v1/docs/"+req.Msg.DocId+"/revisions", nil, "", req.Msg.DocId, res); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (docService) Search(ctx context.Context, req *connect.Request[docstorev1.SearchRequest]) (*connect.Response[docstorev1.SearchResponse], error) {
+	query := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	set("q", req.Msg.Q)
+	set("tag", strings.Join(req.Msg.Tag, ","))
+	set("owner", req.Msg.Owner)
+	set("prefix", req.Msg.Prefix)
+	if req.Msg.Limit > 0 {
+		set("limit", strconv.Itoa(int(req.Msg.Limit)))
+	}
+	res := &docstorev1.SearchResponse{}
+	if err := call(ctx, req, http.MethodGet, "/api/v1/search", query, "", "", res); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
+
+// forwardedHeader reports whether a client's header is passed on to the
+// REST route. The RPC protocol's own headers describe the RPC, not the
+// request made for it.
+func forwardedHeader(k string) bool {
+	k = http.CanonicalHeaderKey(k)
+	switch k {
+	case "Accept", "Content-Type", "Content-Length", "Te":
+		return false
+	}
+	return !strings.HasPrefix(k, "Connect-") && !strings.HasPrefix(k, "Grpc-")
+}
+
+// call makes the REST request for an RPC and decodes its JSON answer into
+// res. docId, if the route takes one, is checked first: the RPC can't
+// address a doc the route's path couldn't.
+func call(ctx context.Context, req connect.AnyRequest, method, path string, query url.Values, body, docId string, res proto.Message) error {
+	if path != "/api/v1/search" && (docId == "" || strings.Contains(docId, "/")) {
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid doc id %q", docId))
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, (&url.URL{Path: path, RawQuery: query.Encode()}).String(), strings.NewReader(body))
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	for k, v := range req.Header() {
+		if forwardedHeader(k) {
+			r.Header[k] = v
+		}
+	}
+	r.Header.Set("Accept", "application/json")
+	r.RemoteAddr = req.Peer().Addr
+
+	request, err := handler.Event(r)
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	resp, err := handler.Handler(ctx, request)
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	out := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		if out, err = base64.StdEncoding.DecodeString(resp.Body); err != nil {
+			return connect.NewError(connect.CodeInternal, err)
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		return restError(resp.StatusCode, out)
+	}
+	if !strings.HasPrefix(resp.Headers["Content-Type"], "application/json") {
+		// A doc that isn't a page, like a stylesheet, has no JSON form.
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s is not a page", docId))
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(out, res); err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	return nil
+}
+
+// restError returns the RPC error for a REST route's error response, with
+// the message of its error envelope if it has one.
+func restError(status int, body []byte) error {
+	var envelope struct {
+		Message string `json:"message"`
+	}
+	msg := http.StatusText(status)
+	if json.Unmarshal(body, &envelope) == nil && envelope.Message != "" {
+		msg = envelope.Message
+	} else if s := strings.TrimSpace(string(body)); s != "" && len(s) < 200 {
+		msg = s
+	}
+	return connect.NewError(statusCode(status), fmt.Errorf("%s", msg))
+}
+
+// statusCode maps an HTTP status to the RPC code for it, as the gRPC
+// HTTP mapping does in reverse.
+func statusCode(status int) connect.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return connect.CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return connect.CodeUnauthenticated
+	case http.StatusForbidden:
+		return connect.CodePermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return connect.CodeNotFound
+	case http.StatusConflict:
+		return connect.CodeAborted
+	case http.StatusMethodNotAllowed, http.StatusPreconditionFailed, http.StatusLocked, http.StatusUpgradeRequired:
+		return connect.CodeFailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return connect.CodeResourceExhausted
+	case http.StatusNotImplemented:
+		return connect.CodeUnimplemented
+	case http.StatusServiceUnavailable:
+		return connect.CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return connect.CodeDeadlineExceeded
+	}
+	return connect.CodeInternal
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	"github.com/drocamor/docstore"
+	docstorev1 "github.com/drocamor/n22t.docstore/docstorev1"
+	"github.com/drocamor/n22t.docstore/docstorev1/docstorev1connect"
+	"github.com/drocamor/n22t.docstore/fsstore"
+	"github.com/drocamor/n22t.docstore/handler"
+)
+
+// newClient serves a site of files on a test server and returns a client
+// for its doc API.
+func newClient(t *testing.T, files map[string]string) docstorev1connect.DocServiceClient {
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler.UseStore(fsstore.New(dir), func(where string) docstore.DocStore {
+		return fsstore.New(where)
+	})
+
+	srv := httptest.NewServer(newMux())
+	t.Cleanup(srv.Close)
+	return docstorev1connect.NewDocServiceClient(srv.Client(), srv.URL)
+}
+
+func TestDocService(t *testing.T) {
+	client := newClient(t, map[string]string{
+		"doc-template.html": "<html><body>{{.DocBody}}</body></html>",
+		"hello":             "# Hello\n\nHello, world.\n",
+	})
+	ctx := context.Background()
+
+	doc, err := client.GetDoc(ctx, connect.NewRequest(&docstorev1.GetDocRequest{DocId: "hello"}))
+	if err != nil {
+		t.Fatalf("GetDoc: %v", err)
+	}
+	if doc.Msg.DocId != "hello" || doc.Msg.Version != 1 || doc.Msg.Title != "# Hello" {
+		t.Errorf("GetDoc = %v, want hello version 1 titled # Hello", doc.Msg)
+	}
+	if doc.Msg.Markdown != "# Hello\n\nHello, world.\n" {
+		t.Errorf("GetDoc markdown = %q", doc.Msg.Markdown)
+	}
+	if doc.Msg.Timestamp == nil {
+		t.Error("GetDoc has no timestamp")
+	}
+
+	revs, err := client.ListRevisions(ctx, connect.NewRequest(&docstorev1.ListRevisionsRequest{DocId: "hello"}))
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	if len(revs.Msg.Revisions) != 1 || revs.Msg.Revisions[0].Version != 1 {
+		t.Errorf("ListRevisions = %v, want version 1", revs.Msg.Revisions)
+	}
+}
+
+func TestDocServiceErrors(t *testing.T) {
+	client := newClient(t, map[string]string{
+		"doc-template.html": "<html><body>{{.DocBody}}</body></html>",
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want connect.Code
+	}{
+		{"missing doc", func() error {
+			_, err := client.GetDoc(ctx, connect.NewRequest(&docstorev1.GetDocRequest{DocId: "nope"}))
+			return err
+		}, connect.CodeNotFound},
+		{"doc id with a slash", func() error {
+			_, err := client.GetDoc(ctx, connect.NewRequest(&docstorev1.GetDocRequest{DocId: "a/b"}))
+			return err
+		}, connect.CodeInvalidArgument},
+		{"write without a key", func() error {
+			_, err := client.PutDoc(ctx, connect.NewRequest(&docstorev1.PutDocRequest{DocId: "new", Body: "# New\n"}))
+			return err
+		}, connect.CodeUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cerr *connect.Error
+			if err := tt.call(); !errors.As(err, &cerr) || cerr.Code() != tt.want {
+				t.Errorf("error = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+}
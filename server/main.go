@@ -0,0 +1,48 @@
+// Command server runs the docs handler as a long-lived HTTP server, for
+// running the site in a container instead of on Lambda. It serves the site
+// and REST API as the deployed routes do, and the doc API for internal
+// services over Connect, gRPC and gRPC-Web; see docstorev1.
+//
+//	server [-addr host:port]
+//
+// The store is configured from the environment as for the Lambda function.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/drocamor/n22t.docstore/docstorev1/docstorev1connect"
+	"github.com/drocamor/n22t.docstore/handler"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to serve on")
+	flag.Parse()
+
+	handler.ValidateStartup()
+
+	log.Printf("serving on %s", *addr)
+	log.Fatal(newServer(*addr).ListenAndServe())
+}
+
+// newServer returns the server for addr. gRPC needs HTTP/2, which a
+// container behind a load balancer gets without TLS.
+func newServer(addr string) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{
+		Addr:      addr,
+		Handler:   newMux(),
+		Protocols: &protocols,
+	}
+}
+
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(docstorev1connect.NewDocServiceHandler(docService{}))
+	mux.Handle("/", handler.HTTPHandler())
+	return mux
+}
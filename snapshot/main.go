@@ -0,0 +1,198 @@
+// Command snapshot archives every revision of every doc to S3, in the
+// layout of "docctl export -all" packed in a gzipped tar, for long term
+// retention. Snapshots are written straight to an archive storage class
+// and the bucket's lifecycle rules expire them; "docctl restore" reads
+// them back. It runs on a schedule.
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/drocamor/docstore"
+	"github.com/drocamor/docstore/awsdocstore"
+	"github.com/drocamor/n22t.docstore/runtimeapi"
+	"github.com/drocamor/n22t.docstore/storelist"
+)
+
+var (
+	ds docstore.DocStore
+
+	// bucket is where snapshots are written, under snapshots/.
+	bucket = os.Getenv("SNAPSHOT_BUCKET")
+
+	// storageClass is the S3 storage class snapshots are written with.
+	storageClass = envDefault("SNAPSHOT_STORAGE_CLASS", "GLACIER")
+)
+
+func init() {
+	var opts []awsdocstore.AwsDocStoreOption
+	if t := os.Getenv("DOCS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithDocTable(t))
+	}
+	if t := os.Getenv("REVISIONS_TABLE"); t != "" {
+		opts = append(opts, awsdocstore.WithRevisionTable(t))
+	}
+	ds = awsdocstore.New(opts...)
+}
+
+func envDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// manifest lists the revisions in a snapshot, as docctl export's does.
+// Failed lists docs that couldn't be read, and so are missing.
+type manifest struct {
+	Exported time.Time       `json:"exported"`
+	Docs     []manifestEntry `json:"docs"`
+	Failed   []string        `json:"failed,omitempty"`
+}
+
+type manifestEntry struct {
+	DocId     string    `json:"docId"`
+	Revision  int       `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+	SHA256    string    `json:"sha256"`
+	Path      string    `json:"path"`
+}
+
+// readDoc reads every revision of docId, oldest first.
+func readDoc(ctx context.Context, docId string) (entries []manifestEntry, bodies [][]byte, err error) {
+	revs, err := storelist.Revisions(ctx, ds, docId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ListRevisions %s: %w", docId, err)
+	}
+
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Id < revs[j].Id })
+	for _, meta := range revs {
+		n := meta.Id
+		rev, err := ds.GetRevision(docId, n)
+		if err != nil {
+			return nil, nil, fmt.Errorf("GetRevision %s@%d: %w", docId, n, err)
+		}
+		body, err := ioutil.ReadAll(rev)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s@%d: %w", docId, n, err)
+		}
+
+		sum := sha256.Sum256(body)
+		entries = append(entries, manifestEntry{
+			DocId:     docId,
+			Revision:  n,
+			Timestamp: rev.Metadata().Timestamp.UTC(),
+			SHA256:    hex.EncodeToString(sum[:]),
+			Path:      docId + "/" + strconv.Itoa(n),
+		})
+		bodies = append(bodies, body)
+	}
+	return entries, bodies, nil
+}
+
+// writeSnapshot writes every doc to w as a gzipped tar, ending with the
+// manifest.
+func writeSnapshot(ctx context.Context, w io.Writer, docs []docstore.Doc) (manifest, error) {
+	m := manifest{Exported: time.Now().UTC()}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	add := func(path string, t time.Time, body []byte) error {
+		err := tw.WriteHeader(&tar.Header{Name: path, Mode: 0644, Size: int64(len(body)), ModTime: t})
+		if err == nil {
+			_, err = tw.Write(body)
+		}
+		return err
+	}
+
+	for _, doc := range docs {
+		entries, bodies, err := readDoc(ctx, doc.Id)
+		if err != nil {
+			log.Printf("snapshot error: %v", err)
+			m.Failed = append(m.Failed, doc.Id)
+			continue
+		}
+
+		for i, e := range entries {
+			err = add(e.Path, e.Timestamp, bodies[i])
+			if err != nil {
+				return m, err
+			}
+		}
+		m.Docs = append(m.Docs, entries...)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	err = add("manifest.json", m.Exported, b)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	return m, err
+}
+
+// Handler snapshots the store to bucket.
+func Handler(ctx context.Context) error {
+	if bucket == "" {
+		return fmt.Errorf("SNAPSHOT_BUCKET is not set")
+	}
+
+	docs, err := storelist.Docs(ctx, ds)
+	if err != nil {
+		return fmt.Errorf("ListDocs: %w", err)
+	}
+
+	key := "snapshots/" + time.Now().UTC().Format("2006-01-02T150405Z") + ".tar.gz"
+	pr, pw := io.Pipe()
+	var m manifest
+	go func() {
+		var err error
+		m, err = writeSnapshot(ctx, pw, docs)
+		pw.CloseWithError(err)
+	}()
+
+	uploader := s3manager.NewUploader(session.Must(session.NewSession()))
+	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		Body:         pr,
+		ContentType:  aws.String("application/gzip"),
+		StorageClass: aws.String(storageClass),
+	})
+	if err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("upload %s: %w", key, err)
+	}
+
+	log.Printf("snapshot %s: %d revisions of %d docs", key, len(m.Docs), len(docs)-len(m.Failed))
+	if len(m.Failed) > 0 {
+		return fmt.Errorf("snapshot %s is missing %s", key, strings.Join(m.Failed, ", "))
+	}
+	return nil
+}
+
+func main() {
+	runtimeapi.Start(Handler)
+}
@@ -0,0 +1,55 @@
+// Package storelist pages through a docstore's listings for the handlers
+// and scheduled jobs that need all of one rather than a page at a time.
+package storelist
+
+import (
+	"context"
+
+	"github.com/drocamor/docstore"
+)
+
+// Docs returns every doc in ds. It stops with ctx's error once ctx is
+// done.
+func Docs(ctx context.Context, ds docstore.DocStore) (docs []docstore.Doc, err error) {
+	token := ""
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var page docstore.DocPage
+		page, err = ds.ListDocs(token)
+		if err != nil {
+			return
+		}
+
+		docs = append(docs, page.Docs...)
+		if !page.More || page.NextToken == "" {
+			return
+		}
+		token = page.NextToken
+	}
+}
+
+// Revisions returns the metadata of every revision of docId in ds. It
+// stops with ctx's error once ctx is done.
+func Revisions(ctx context.Context, ds docstore.DocStore, docId string) (revs []docstore.RevisionMetadata, err error) {
+	token := ""
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var page docstore.RevisionPage
+		page, err = ds.ListRevisions(docId, token)
+		if err != nil {
+			return
+		}
+
+		revs = append(revs, page.Revisions...)
+		if !page.More || page.NextToken == "" {
+			return
+		}
+		token = page.NextToken
+	}
+}
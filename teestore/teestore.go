@@ -0,0 +1,134 @@
+// Package teestore wraps a docstore.DocStore so that the revisions written
+// through it are also written to a second store, like a staging site's,
+// in the background.
+//
+// Reads are served by the primary store alone, and a write succeeds or
+// fails with it. Copies are queued once the primary write succeeds and
+// written in order by a single goroutine, so a slow or failing secondary
+// never slows the writes of the primary. When the queue is full copies are
+// dropped, and copies still queued when the process exits are lost: the
+// secondary is a best effort copy, not a replica. Each copy is a new
+// revision of the secondary's doc, so revision numbers differ between the
+// stores.
+package teestore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/drocamor/docstore"
+)
+
+// ErrQueueFull is passed to the error handler for copies dropped because
+// the queue was full.
+var ErrQueueFull = errors.New("teestore: queue full")
+
+type TeeStore struct {
+	ds        docstore.DocStore
+	secondary docstore.DocStore
+	filter    func(docId string) bool
+	onError   func(docId string, err error)
+
+	queue chan copyJob
+}
+
+// copyJob is a revision waiting to be copied.
+type copyJob struct {
+	docId string
+	body  []byte
+}
+
+type TeeStoreOption func(*TeeStore)
+
+// WithQueue sets how many copies can wait to be written before more are
+// dropped. The default is 100.
+func WithQueue(n int) TeeStoreOption {
+	return func(t *TeeStore) {
+		t.queue = make(chan copyJob, n)
+	}
+}
+
+// WithFilter copies only the docs for which keep returns true.
+func WithFilter(keep func(docId string) bool) TeeStoreOption {
+	return func(t *TeeStore) {
+		t.filter = keep
+	}
+}
+
+// WithErrorHandler calls f with each copy that fails or is dropped, from
+// the copying goroutine or the writer.
+func WithErrorHandler(f func(docId string, err error)) TeeStoreOption {
+	return func(t *TeeStore) {
+		t.onError = f
+	}
+}
+
+// New returns a TeeStore over ds copying writes to secondary, and starts
+// the goroutine copying them.
+func New(ds, secondary docstore.DocStore, opts ...TeeStoreOption) *TeeStore {
+	t := &TeeStore{
+		ds:        ds,
+		secondary: secondary,
+		filter:    func(string) bool { return true },
+		onError:   func(string, error) {},
+		queue:     make(chan copyJob, 100),
+	}
+
+	for _, o := range opts {
+		o(t)
+	}
+
+	go t.copyAll()
+	return t
+}
+
+func (t *TeeStore) copyAll() {
+	for job := range t.queue {
+		_, err := t.secondary.PutRevision(job.docId, bytes.NewReader(job.body))
+		if err != nil {
+			t.onError(job.docId, err)
+		}
+	}
+}
+
+func (t *TeeStore) GetDoc(docId string) (docstore.Revision, error) {
+	return t.ds.GetDoc(docId)
+}
+
+func (t *TeeStore) GetRevision(docId string, revisionId int) (docstore.Revision, error) {
+	return t.ds.GetRevision(docId, revisionId)
+}
+
+// PutRevision writes to the primary store and, if that succeeds, queues
+// a copy for the secondary.
+func (t *TeeStore) PutRevision(docId string, body io.Reader) (docstore.Revision, error) {
+	if !t.filter(docId) {
+		return t.ds.PutRevision(docId, body)
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := t.ds.PutRevision(docId, bytes.NewReader(b))
+	if err != nil {
+		return rev, err
+	}
+
+	select {
+	case t.queue <- copyJob{docId, b}:
+	default:
+		t.onError(docId, ErrQueueFull)
+	}
+	return rev, nil
+}
+
+func (t *TeeStore) ListDocs(token string) (docstore.DocPage, error) {
+	return t.ds.ListDocs(token)
+}
+
+func (t *TeeStore) ListRevisions(docId string, token string) (docstore.RevisionPage, error) {
+	return t.ds.ListRevisions(docId, token)
+}
@@ -0,0 +1,127 @@
+package textdiff
+
+import "strings"
+
+// hunk replaces lines [start, end) of a base text with lines.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks returns the changes a diff makes to its old text.
+func hunks(diff []Line) []hunk {
+	var hs []hunk
+	var cur *hunk
+	i := 0
+	for _, l := range diff {
+		if l.Op == Equal {
+			if cur != nil {
+				hs = append(hs, *cur)
+				cur = nil
+			}
+			i++
+			continue
+		}
+
+		if cur == nil {
+			cur = &hunk{start: i, end: i}
+		}
+		if l.Op == Delete {
+			i++
+			cur.end = i
+		} else {
+			cur.lines = append(cur.lines, l.Text)
+		}
+	}
+	if cur != nil {
+		hs = append(hs, *cur)
+	}
+	return hs
+}
+
+// apply returns lines [s, e) of base with hs applied.
+func apply(base []string, s, e int, hs []hunk) []string {
+	var out []string
+	for _, h := range hs {
+		out = append(out, base[s:h.start]...)
+		out = append(out, h.lines...)
+		s = h.end
+	}
+	return append(out, base[s:e]...)
+}
+
+// Merge combines the changes ours and theirs each made to base. Where they
+// changed the same lines differently, both versions are kept between
+// conflict markers and counted in conflicts.
+func Merge(base, ours, theirs string) (merged string, conflicts int) {
+	bs := split(base)
+	a, b := hunks(Lines(base, ours)), hunks(Lines(base, theirs))
+
+	var out []string
+	pos := 0
+	for len(a) > 0 || len(b) > 0 {
+		// Start a region at the earliest change, and grow it while changes
+		// from either side overlap it.
+		var s int
+		switch {
+		case len(b) == 0 || len(a) > 0 && a[0].start <= b[0].start:
+			s = a[0].start
+		default:
+			s = b[0].start
+		}
+		e := s
+		var ga, gb []hunk
+		for {
+			if len(a) > 0 && (a[0].start < e || a[0].start == s) {
+				ga, a = append(ga, a[0]), a[1:]
+				if ga[len(ga)-1].end > e {
+					e = ga[len(ga)-1].end
+				}
+				continue
+			}
+			if len(b) > 0 && (b[0].start < e || b[0].start == s) {
+				gb, b = append(gb, b[0]), b[1:]
+				if gb[len(gb)-1].end > e {
+					e = gb[len(gb)-1].end
+				}
+				continue
+			}
+			break
+		}
+
+		out = append(out, bs[pos:s]...)
+		pos = e
+		ours, theirs := apply(bs, s, e, ga), apply(bs, s, e, gb)
+		switch {
+		case len(gb) == 0:
+			out = append(out, ours...)
+		case len(ga) == 0 || equal(ours, theirs):
+			out = append(out, theirs...)
+		default:
+			conflicts++
+			out = append(out, "<<<<<<< ours")
+			out = append(out, ours...)
+			out = append(out, "=======")
+			out = append(out, theirs...)
+			out = append(out, ">>>>>>> theirs")
+		}
+	}
+	out = append(out, bs[pos:]...)
+
+	if len(out) == 0 {
+		return "", conflicts
+	}
+	return strings.Join(out, "\n") + "\n", conflicts
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
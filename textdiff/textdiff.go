@@ -0,0 +1,133 @@
+// Package textdiff computes line based differences between two texts.
+package textdiff
+
+import "strings"
+
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is one line of a diff: a line common to both texts, a line only in
+// the old text or a line only in the new one.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// maxCells caps the size of the table used to align the changed middle of
+// two texts. Past it, the middle is reported as wholly replaced.
+const maxCells = 4000000
+
+// Lines returns the edits that turn a into b, line by line.
+func Lines(a, b string) []Line {
+	as, bs := split(a), split(b)
+
+	// Trim the common prefix and suffix, which is usually most of a doc.
+	pre := 0
+	for pre < len(as) && pre < len(bs) && as[pre] == bs[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(as)-pre && suf < len(bs)-pre && as[len(as)-1-suf] == bs[len(bs)-1-suf] {
+		suf++
+	}
+
+	var diff []Line
+	for _, l := range as[:pre] {
+		diff = append(diff, Line{Equal, l})
+	}
+	diff = append(diff, middle(as[pre:len(as)-suf], bs[pre:len(bs)-suf])...)
+	for _, l := range as[len(as)-suf:] {
+		diff = append(diff, Line{Equal, l})
+	}
+
+	return diff
+}
+
+// Changed reports whether a diff contains any edits.
+func Changed(diff []Line) bool {
+	for _, l := range diff {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats counts the lines deleted and inserted by a diff.
+func Stats(diff []Line) (deleted, inserted int) {
+	for _, l := range diff {
+		switch l.Op {
+		case Delete:
+			deleted++
+		case Insert:
+			inserted++
+		}
+	}
+	return
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// middle aligns a and b using their longest common subsequence.
+func middle(a, b []string) (diff []Line) {
+	if len(a)*len(b) > maxCells {
+		for _, l := range a {
+			diff = append(diff, Line{Delete, l})
+		}
+		for _, l := range b {
+			diff = append(diff, Line{Insert, l})
+		}
+		return
+	}
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, Line{Equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, Line{Delete, a[i]})
+			i++
+		default:
+			diff = append(diff, Line{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, Line{Delete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, Line{Insert, b[j]})
+	}
+
+	return
+}
@@ -0,0 +1,102 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+// format writes a diff one line per line, prefixed " ", "-" or "+".
+func format(diff []Line) string {
+	var b strings.Builder
+	for _, l := range diff {
+		b.WriteString([]string{" ", "-", "+"}[l.Op] + l.Text + "\n")
+	}
+	return b.String()
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", " a\n b\n"},
+		{"both empty", "", "", ""},
+		{"from empty", "", "a\nb\n", "+a\n+b\n"},
+		{"to empty", "a\n", "", "-a\n"},
+		{"changed middle", "a\nb\nc\n", "a\nx\nc\n", " a\n-b\n+x\n c\n"},
+		{"insert", "a\nc\n", "a\nb\nc\n", " a\n+b\n c\n"},
+		{"delete", "a\nb\nc\n", "a\nc\n", " a\n-b\n c\n"},
+		{"no final newline", "a\nb", "a\nb\n", " a\n b\n"},
+		{"moved", "a\nb\nc\nd\n", "b\nc\na\nd\n", "-a\n b\n c\n+a\n d\n"},
+		{"repeated lines", "x\nx\ny\n", "x\ny\nx\n", " x\n-x\n y\n+x\n"},
+		{"blank lines", "a\n\nb\n", "a\nb\n", " a\n-\n b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := Lines(tt.a, tt.b)
+			if got := format(diff); got != tt.want {
+				t.Errorf("Lines(%q, %q) =\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+			}
+			edited := strings.Contains("\n"+tt.want, "\n-") || strings.Contains("\n"+tt.want, "\n+")
+			if Changed(diff) != edited {
+				t.Errorf("Changed = %v, want %v", Changed(diff), edited)
+			}
+		})
+	}
+}
+
+func TestLinesTooLarge(t *testing.T) {
+	// Past maxCells the changed middle is replaced wholesale, keeping the
+	// common lines around it.
+	n := 2100
+	var a, b strings.Builder
+	a.WriteString("head\n")
+	b.WriteString("head\n")
+	for i := 0; i < n; i++ {
+		a.WriteString("a\n")
+		b.WriteString("b\n")
+	}
+	a.WriteString("tail\n")
+	b.WriteString("tail\n")
+
+	diff := Lines(a.String(), b.String())
+	if deleted, inserted := Stats(diff); deleted != n || inserted != n {
+		t.Errorf("Stats = %d, %d, want %d, %d", deleted, inserted, n, n)
+	}
+	if diff[0] != (Line{Equal, "head"}) || diff[len(diff)-1] != (Line{Equal, "tail"}) {
+		t.Errorf("diff starts %v and ends %v, want the common lines", diff[0], diff[len(diff)-1])
+	}
+}
+
+func TestStats(t *testing.T) {
+	deleted, inserted := Stats(Lines("a\nb\nc\n", "a\nx\ny\n"))
+	if deleted != 2 || inserted != 2 {
+		t.Errorf("Stats = %d, %d, want 2, 2", deleted, inserted)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name, base, ours, theirs, want string
+		conflicts                      int
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", 0},
+		{"ours only", "a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n", "a\nB\nc\n", 0},
+		{"theirs only", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nC\n", "a\nb\nC\n", 0},
+		{"both apart", "a\nb\nc\nd\n", "A\nb\nc\nd\n", "a\nb\nc\nD\n", "A\nb\nc\nD\n", 0},
+		{"same change", "a\nb\nc\n", "a\nX\nc\n", "a\nX\nc\n", "a\nX\nc\n", 0},
+		{"conflict", "a\nb\nc\n", "a\nours\nc\n", "a\ntheirs\nc\n",
+			"a\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\nc\n", 1},
+		{"both insert at one place", "a\nc\n", "a\nb\nc\n", "a\nB\nc\n",
+			"a\n<<<<<<< ours\nb\n=======\nB\n>>>>>>> theirs\nc\n", 1},
+		{"delete and edit elsewhere", "a\nb\nc\nd\n", "a\nc\nd\n", "a\nb\nc\nD\n", "a\nc\nD\n", 0},
+		{"all deleted", "a\n", "", "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := Merge(tt.base, tt.ours, tt.theirs)
+			if got != tt.want || conflicts != tt.conflicts {
+				t.Errorf("Merge = %q, %d conflicts, want %q, %d", got, conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
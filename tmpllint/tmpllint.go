@@ -0,0 +1,261 @@
+// Package tmpllint checks text/templates against the types of the data they
+// are executed with, so references to fields that don't exist are caught
+// when a template is saved rather than when a page using it fails.
+package tmpllint
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Report is what Check found.
+type Report struct {
+	// Undefined lists references to fields, methods and templates that
+	// don't exist, with where they are, like
+	// "docPage:3:14: undefined field .Auther".
+	Undefined []string
+
+	// Unused lists the exported fields of the data of the template
+	// checked that none of its templates use, like ".Footnotes".
+	Unused []string
+}
+
+// Check checks the templates of tmpl. shapes gives the type of the data
+// templates are executed with, by template name, and must include tmpl's
+// own. Dot is followed into the templates they invoke, and into range and
+// with where the type of the pipeline is known: fields, and calls of funcs,
+// which should be the template's own functions.
+func Check(tmpl *template.Template, shapes map[string]reflect.Type, funcs template.FuncMap) Report {
+	c := &checker{
+		tmpl:      tmpl,
+		funcs:     funcs,
+		used:      map[reflect.Type]map[string]bool{},
+		undefined: map[string]parse.Pos{},
+		visited:   map[string]bool{},
+	}
+	for name, shape := range shapes {
+		if t := tmpl.Lookup(name); t != nil && t.Tree != nil {
+			c.check(t, shape)
+		}
+	}
+
+	var r Report
+	for u := range c.undefined {
+		r.Undefined = append(r.Undefined, u)
+	}
+	sort.Slice(r.Undefined, func(i, j int) bool {
+		pi, pj := c.undefined[r.Undefined[i]], c.undefined[r.Undefined[j]]
+		if pi != pj {
+			return pi < pj
+		}
+		return r.Undefined[i] < r.Undefined[j]
+	})
+
+	if root := indirect(shapes[tmpl.Name()]); root != nil && root.Kind() == reflect.Struct && !c.all[root] {
+		for i := 0; i < root.NumField(); i++ {
+			f := root.Field(i)
+			if f.PkgPath == "" && !c.used[root][f.Name] {
+				r.Unused = append(r.Unused, "."+f.Name)
+			}
+		}
+	}
+	return r
+}
+
+type checker struct {
+	tmpl  *template.Template
+	funcs template.FuncMap
+
+	// used records the fields of each type referred to, and all the types
+	// passed whole, to functions or printed, whose fields all count as
+	// used.
+	used map[reflect.Type]map[string]bool
+	all  map[reflect.Type]bool
+
+	// undefined holds the problems found, by where they are in the text.
+	undefined map[string]parse.Pos
+
+	// visited records the templates checked, with the type of their dot,
+	// so recursive templates end.
+	visited map[string]bool
+}
+
+// check checks t executed with data of type dot.
+func (c *checker) check(t *template.Template, dot reflect.Type) {
+	key := fmt.Sprintf("%s %v", t.Name(), dot)
+	if c.visited[key] {
+		return
+	}
+	c.visited[key] = true
+	c.walk(t.Tree, t.Root, dot, dot)
+}
+
+// walk checks node, in which dot has type dot and $ type root. A nil type
+// is one that isn't known, whose fields aren't checked.
+func (c *checker) walk(tree *parse.Tree, node parse.Node, dot, root reflect.Type) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(tree, child, dot, root)
+		}
+	case *parse.ActionNode:
+		c.pipe(tree, n.Pipe, dot, root)
+	case *parse.IfNode:
+		c.pipe(tree, n.Pipe, dot, root)
+		c.walk(tree, n.List, dot, root)
+		c.walk(tree, n.ElseList, dot, root)
+	case *parse.WithNode:
+		t := c.pipe(tree, n.Pipe, dot, root)
+		c.walk(tree, n.List, t, root)
+		c.walk(tree, n.ElseList, dot, root)
+	case *parse.RangeNode:
+		t := c.pipe(tree, n.Pipe, dot, root)
+		c.walk(tree, n.List, elem(t), root)
+		c.walk(tree, n.ElseList, dot, root)
+	case *parse.TemplateNode:
+		var t reflect.Type
+		switch {
+		case isDot(n.Pipe):
+			// Passing dot on uses none of it yet.
+			t = dot
+		case n.Pipe != nil:
+			t = c.pipe(tree, n.Pipe, dot, root)
+		}
+		callee := c.tmpl.Lookup(n.Name)
+		if callee == nil || callee.Tree == nil {
+			c.report(tree, n, fmt.Sprintf("undefined template %q", n.Name))
+			return
+		}
+		c.check(callee, t)
+	}
+}
+
+// pipe checks the references in pipe and returns the type of its result,
+// or nil if it isn't known.
+func (c *checker) pipe(tree *parse.Tree, pipe *parse.PipeNode, dot, root reflect.Type) reflect.Type {
+	if pipe == nil {
+		return nil
+	}
+	var result reflect.Type
+	for _, cmd := range pipe.Cmds {
+		result = nil
+		for i, arg := range cmd.Args {
+			var t reflect.Type
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				t = c.resolve(tree, a, dot, a.Ident)
+			case *parse.VariableNode:
+				if a.Ident[0] == "$" {
+					t = c.resolve(tree, a, root, a.Ident[1:])
+				}
+			case *parse.DotNode:
+				c.useAll(dot)
+				t = dot
+			case *parse.PipeNode:
+				t = c.pipe(tree, a, dot, root)
+			case *parse.ChainNode:
+				if p, ok := a.Node.(*parse.PipeNode); ok {
+					c.pipe(tree, p, dot, root)
+				}
+			case *parse.IdentifierNode:
+				if fn, ok := c.funcs[a.Ident]; ok {
+					if ft := reflect.TypeOf(fn); ft.Kind() == reflect.Func && ft.NumOut() > 0 {
+						t = ft.Out(0)
+					}
+				}
+			}
+			if i == 0 {
+				result = t
+			}
+		}
+	}
+	return result
+}
+
+// resolve follows the chain of field and method names idents from t,
+// reporting the first that doesn't exist, and returns the type it ends
+// at.
+func (c *checker) resolve(tree *parse.Tree, node parse.Node, t reflect.Type, idents []string) reflect.Type {
+	for i, ident := range idents {
+		t = indirect(t)
+		if t == nil {
+			return nil
+		}
+		if m, ok := reflect.PtrTo(t).MethodByName(ident); ok {
+			if m.Type.NumOut() == 0 {
+				return nil
+			}
+			t = m.Type.Out(0)
+			continue
+		}
+		switch t.Kind() {
+		case reflect.Map, reflect.Interface:
+			// Keys and dynamic values aren't known.
+			return nil
+		case reflect.Struct:
+			if f, ok := t.FieldByName(ident); ok && f.PkgPath == "" {
+				if c.used[t] == nil {
+					c.used[t] = map[string]bool{}
+				}
+				c.used[t][ident] = true
+				t = f.Type
+				continue
+			}
+		}
+		c.report(tree, node, "undefined field ."+strings.Join(idents[:i+1], "."))
+		return nil
+	}
+	return t
+}
+
+// useAll records every field of t as used.
+func (c *checker) useAll(t reflect.Type) {
+	if t = indirect(t); t != nil {
+		if c.all == nil {
+			c.all = map[reflect.Type]bool{}
+		}
+		c.all[t] = true
+	}
+}
+
+func (c *checker) report(tree *parse.Tree, node parse.Node, problem string) {
+	location, _ := tree.ErrorContext(node)
+	c.undefined[location+": "+problem] = node.Position()
+}
+
+// isDot reports whether pipe is just dot.
+func isDot(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := pipe.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
+
+// indirect returns the type pointers of t point to.
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// elem returns the type of the elements of t, or nil if that isn't known.
+func elem(t reflect.Type) reflect.Type {
+	t = indirect(t)
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return t.Elem()
+	}
+	return nil
+}